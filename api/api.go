@@ -0,0 +1,283 @@
+// Package api serves the JSON API under /api/v1. It mirrors
+// the HTML controllers on top of the same models.Services, so
+// both follow the same rules for who may see and change what.
+//
+// Successful responses wrap their resource in {"data": ...},
+// lists add a "next_cursor" to pass back as ?cursor= while
+// there are more items. Errors use the envelope described
+// by Error, see WriteError
+package api
+
+import (
+	"net/http"
+
+	"../../photofriends/email"
+	"../../photofriends/models"
+	"../context"
+	"github.com/gorilla/mux"
+)
+
+// Prefix is where the routes of this version are mounted
+const Prefix = "/api/v1"
+
+// New creates the API on top of services. Friend
+// requests are notified by email through mailer,
+// linking to the friends page under baseURL
+func New(services *models.Services, mailer email.Mailer, baseURL string) *API {
+	return &API{
+		gs:           services.Gallery,
+		is:           services.Image,
+		fs:           services.Friend,
+		us:           services.User,
+		ups:          services.Upload,
+		mailer:       mailer,
+		baseURL:      baseURL,
+		requestEmail: email.NewTemplate("friend_request"),
+	}
+}
+
+type API struct {
+	gs           models.GalleryService
+	is           models.ImageService
+	fs           models.FriendService
+	us           models.UserService
+	ups          models.UploadService
+	mailer       email.Mailer
+	baseURL      string
+	requestEmail *email.Template
+}
+
+// scopeSession is never given to API tokens. Routes that
+// act in the name of the user towards others need a session
+const scopeSession = "session"
+
+// Route is a single endpoint of the API. Besides serving it,
+// the route describes itself for the OpenAPI document
+type Route struct {
+	// Name identifies the route in the OpenAPI document, and
+	// is the name of the method calling it in package client
+	Name   string
+	Method string
+
+	// Path is relative to Prefix, with mux variables
+	Path    string
+	Summary string
+
+	// Public routes can be used without logging in, everyone
+	// else gets a 401. Handlers still check the current user
+	// may see what they ask for
+	Public bool
+
+	// Scope is what API tokens need to use the route, see
+	// models.APITokenScopes. Any token may use routes
+	// without one
+	Scope string
+
+	// Query lists the query parameters the route reads,
+	// see queryParams. Paged routes also read the ones
+	// of pageParams and respond with a listEnvelope
+	Query []string
+	Paged bool
+
+	// Body is a value of the type of the JSON request body.
+	// Upload routes take a multipart form instead, see
+	// UploadImages
+	Body   interface{}
+	Upload bool
+
+	// Status and Response are those of a successful request,
+	// Response is a value of the type of the data it holds
+	Status   int
+	Response interface{}
+
+	Handler http.HandlerFunc
+}
+
+// Routes lists every endpoint of the API
+func (a *API) Routes() []Route {
+	return []Route{
+		{
+			Name: "GetMe", Method: "GET", Path: "/me",
+			Summary: "Show the current user",
+			Status:  http.StatusOK, Response: User{},
+			Handler: a.me,
+		},
+		{
+			Name: "GetUser", Method: "GET", Path: "/users/{id:[0-9]+}",
+			Summary: "Show a user",
+			Scope:   models.ScopeFriendsRead,
+			Status:  http.StatusOK, Response: User{},
+			Handler: a.user,
+		},
+		{
+			Name: "Unfriend", Method: "DELETE", Path: "/users/{id:[0-9]+}/friendship",
+			Summary: "Unfriend, cancel a request or unblock a user",
+			Scope:   scopeSession,
+			Status:  http.StatusNoContent,
+			Handler: a.unfriend,
+		},
+		{
+			Name: "BlockUser", Method: "POST", Path: "/users/{id:[0-9]+}/block",
+			Summary: "Block a user",
+			Scope:   scopeSession,
+			Status:  http.StatusNoContent,
+			Handler: a.block,
+		},
+
+		{
+			Name: "ListGalleries", Method: "GET", Path: "/galleries",
+			Summary: "List the galleries of the current user, newest first",
+			Scope:   models.ScopeGalleriesRead,
+			Paged:   true,
+			Status:  http.StatusOK, Response: []Gallery{},
+			Handler: a.listGalleries,
+		},
+		{
+			Name: "CreateGallery", Method: "POST", Path: "/galleries",
+			Summary: "Create a gallery",
+			Scope:   models.ScopeGalleriesWrite,
+			Body:    GalleryBody{},
+			Status:  http.StatusCreated, Response: Gallery{},
+			Handler: a.createGallery,
+		},
+		{
+			Name: "GetGallery", Method: "GET", Path: "/galleries/{id:[0-9]+}",
+			Summary: "Show a gallery",
+			Public:  true,
+			Scope:   models.ScopeGalleriesRead,
+			Query:   []string{"share"},
+			Status:  http.StatusOK, Response: Gallery{},
+			Handler: a.showGallery,
+		},
+		{
+			Name: "UpdateGallery", Method: "PATCH", Path: "/galleries/{id:[0-9]+}",
+			Summary: "Update the title or visibility of a gallery",
+			Scope:   models.ScopeGalleriesWrite,
+			Body:    GalleryPatch{},
+			Status:  http.StatusOK, Response: Gallery{},
+			Handler: a.updateGallery,
+		},
+		{
+			Name: "DeleteGallery", Method: "DELETE", Path: "/galleries/{id:[0-9]+}",
+			Summary: "Delete a gallery and its images",
+			Scope:   models.ScopeGalleriesWrite,
+			Status:  http.StatusNoContent,
+			Handler: a.deleteGallery,
+		},
+
+		{
+			Name: "ListImages", Method: "GET", Path: "/galleries/{id:[0-9]+}/images",
+			Summary: "List the images of a gallery, oldest first",
+			Public:  true,
+			Scope:   models.ScopeGalleriesRead,
+			Query:   []string{"share"},
+			Paged:   true,
+			Status:  http.StatusOK, Response: []Image{},
+			Handler: a.listImages,
+		},
+		{
+			Name: "UploadImages", Method: "POST", Path: "/galleries/{id:[0-9]+}/images",
+			Summary: "Upload images to a gallery",
+			Scope:   models.ScopeImagesUpload,
+			Upload:  true,
+			Status:  http.StatusCreated, Response: []Image{},
+			Handler: a.uploadImages,
+		},
+		{
+			Name: "GetImage", Method: "GET", Path: "/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}",
+			Summary: "Show an image",
+			Public:  true,
+			Scope:   models.ScopeGalleriesRead,
+			Query:   []string{"share"},
+			Status:  http.StatusOK, Response: Image{},
+			Handler: a.showImage,
+		},
+		{
+			Name: "DeleteImage", Method: "DELETE", Path: "/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}",
+			Summary: "Delete an image",
+			Scope:   models.ScopeGalleriesWrite,
+			Status:  http.StatusNoContent,
+			Handler: a.deleteImage,
+		},
+
+		{
+			Name: "ListFriendships", Method: "GET", Path: "/friendships",
+			Summary: "List the friendships of the current user, newest first",
+			Scope:   models.ScopeFriendsRead,
+			Paged:   true,
+			Status:  http.StatusOK, Response: []Friendship{},
+			Handler: a.listFriendships,
+		},
+		{
+			Name: "RequestFriend", Method: "POST", Path: "/friendships",
+			Summary: "Send a friend request, or accept the one the user sent",
+			Scope:   scopeSession,
+			Body:    FriendRequestBody{},
+			Status:  http.StatusCreated, Response: Friendship{},
+			Handler: a.requestFriend,
+		},
+		{
+			Name: "AcceptFriendship", Method: "POST", Path: "/friendships/{id:[0-9]+}/accept",
+			Summary: "Accept a friend request",
+			Scope:   scopeSession,
+			Status:  http.StatusNoContent,
+			Handler: a.accept,
+		},
+		{
+			Name: "DeclineFriendship", Method: "POST", Path: "/friendships/{id:[0-9]+}/decline",
+			Summary: "Decline a friend request",
+			Scope:   scopeSession,
+			Status:  http.StatusNoContent,
+			Handler: a.decline,
+		},
+	}
+}
+
+// Auth authenticates requests to the API, see middelware.APIToken
+type Auth interface {
+	// ApplyFn adds the current user to the request context
+	ApplyFn(next http.HandlerFunc) http.HandlerFunc
+
+	// RequireScope refuses requests made with an
+	// API token that was not given scope
+	RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc
+}
+
+// Register adds the routes to r under Prefix, behind auth,
+// along with the OpenAPI document describing them at
+// /openapi.json. Anything else under /api/ is answered
+// with a JSON 404. Resumable uploads are added under
+// UploadsPath, see registerUploads
+func (a *API) Register(r *mux.Router, auth Auth) {
+	sub := r.PathPrefix(Prefix).Subrouter()
+	for _, route := range a.Routes() {
+		handler := route.Handler
+		if route.Scope != "" {
+			handler = auth.RequireScope(route.Scope, handler)
+		}
+		if !route.Public {
+			handler = requireUser(handler)
+		}
+		sub.HandleFunc(route.Path, auth.ApplyFn(handler)).Methods(route.Method)
+	}
+
+	sub.HandleFunc("/openapi.json", a.openAPI).Methods("GET")
+	a.registerUploads(r, auth)
+	r.PathPrefix("/api/").HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		WriteError(res, errNotFound)
+	})
+}
+
+// requireUser responds with a 401 unless the request
+// is from a logged in user, instead of redirecting
+// to the login page like middelware.RequireUser
+func requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if context.User(req.Context()) == nil {
+			WriteError(res, errUnauthorized)
+			return
+		}
+
+		next(res, req)
+	}
+}
@@ -0,0 +1,352 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"../../photofriends/models"
+	"../../photofriends/ratelimit"
+	"../context"
+	"github.com/gorilla/mux"
+)
+
+// memGalleries is an in-memory GalleryService, where
+// only owners and public galleries can be seen
+type memGalleries struct {
+	galleries []models.Gallery
+}
+
+func (m *memGalleries) CanView(gallery *models.Gallery, user *models.User, shareToken string) (bool, error) {
+	owner := user != nil && user.ID == gallery.UserID
+	return owner || gallery.Visibility == models.VisibilityPublic, nil
+}
+
+func (m *memGalleries) ByID(id uint) (*models.Gallery, error) {
+	for _, gallery := range m.galleries {
+		if gallery.ID == id {
+			return &gallery, nil
+		}
+	}
+
+	return nil, models.ErrNotFound
+}
+
+func (m *memGalleries) ByUserID(userID uint) ([]models.Gallery, error) {
+	return m.ByUserIDPage(userID, models.Page{})
+}
+
+func (m *memGalleries) ByUserIDPage(userID uint, page models.Page) ([]models.Gallery, error) {
+	var all []models.Gallery
+	for i := len(m.galleries) - 1; i >= 0; i-- {
+		gallery := m.galleries[i]
+		if gallery.UserID != userID || (page.After > 0 && gallery.ID >= page.After) {
+			continue
+		}
+
+		if page.Limit > 0 && len(all) == page.Limit {
+			break
+		}
+		all = append(all, gallery)
+	}
+
+	return all, nil
+}
+
+func (m *memGalleries) Create(gallery *models.Gallery) error {
+	if gallery.Title == "" {
+		return models.FieldErrors{"title": models.ErrTitleRequired}
+	}
+
+	gallery.ID = uint(len(m.galleries) + 1)
+	gallery.ShareToken = "s3cret"
+	m.galleries = append(m.galleries, *gallery)
+	return nil
+}
+
+func (m *memGalleries) Update(gallery *models.Gallery) error {
+	m.galleries[gallery.ID-1] = *gallery
+	return nil
+}
+
+func (m *memGalleries) Delete(id, userID uint) error {
+	if m.galleries[id-1].UserID != userID {
+		return models.ErrNotOwner
+	}
+	m.galleries[id-1] = models.Gallery{}
+	return nil
+}
+
+// memImages is an ImageService for galleries without images
+type memImages struct {
+	models.ImageService
+}
+
+func (m *memImages) ByGalleryID(galleryID uint) ([]models.Image, error) {
+	return nil, nil
+}
+
+// contextAuth takes the user from the request context as is
+type contextAuth struct{}
+
+func (contextAuth) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return next
+}
+
+func (contextAuth) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return next
+}
+
+func testingAPI() (*API, *mux.Router, *memGalleries) {
+	gs := &memGalleries{}
+	a := &API{gs: gs, is: &memImages{}}
+	r := mux.NewRouter()
+	a.Register(r, contextAuth{})
+
+	return a, r, gs
+}
+
+// do makes a request as user, who may be nil, and decodes
+// the data or error envelope of the response into dst
+func do(t *testing.T, r *mux.Router, user *models.User, method, path, body string, dst interface{}) int {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != nil {
+		req = req.WithContext(context.WithUser(req.Context(), user))
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if dst != nil {
+		if err := json.NewDecoder(rec.Body).Decode(dst); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+
+	return rec.Code
+}
+
+func testingUser(id uint, verified bool) *models.User {
+	user := &models.User{Name: "Jane", Email: "jane@example.com"}
+	user.ID = id
+	if verified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	return user
+}
+
+func TestWriteError(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{models.ErrNotFound, http.StatusNotFound, "not_found"},
+		{models.ErrNotOwner, http.StatusForbidden, "forbidden"},
+		{models.ErrAlreadyFriends, http.StatusConflict, "conflict"},
+		{models.FieldErrors{"title": models.ErrTitleRequired}, http.StatusUnprocessableEntity, "invalid"},
+		{&ratelimit.LimitedError{RetryAfter: time.Minute}, http.StatusTooManyRequests, "rate_limited"},
+		{models.ErrEmailTaken, http.StatusBadRequest, "bad_request"},
+		{io.ErrUnexpectedEOF, http.StatusInternalServerError, "internal"},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		WriteError(rec, c.err)
+
+		var body errorEnvelope
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != c.status || body.Error.Code != c.code {
+			t.Errorf("%v: Expected %d %s. Recieved %d %s", c.err, c.status, c.code, rec.Code, body.Error.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	WriteError(rec, io.ErrUnexpectedEOF)
+	if strings.Contains(rec.Body.String(), "EOF") {
+		t.Errorf("Expected private errors to be hidden. Recieved %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	WriteError(rec, models.FieldErrors{"title": models.ErrTitleRequired})
+	if !strings.Contains(rec.Body.String(), `"fields":{"title":"Title is required"}`) {
+		t.Errorf("Expected the field errors. Recieved %s", rec.Body)
+	}
+}
+
+func TestUploadImagesTooLarge(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/v1/galleries/1/images", strings.NewReader("--x--"))
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	req.ContentLength = maxImagesBytes + 1
+
+	rec := httptest.NewRecorder()
+	_, err := UploadImages(&memImages{}, &models.Gallery{}, rec, req)
+	if err != errImagesTooLarge {
+		t.Fatalf("Expected errImagesTooLarge. Recieved %v", err)
+	}
+
+	WriteError(rec, err)
+	var body errorEnvelope
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusRequestEntityTooLarge || body.Error.Code != "too_large" {
+		t.Errorf("Expected 413 too_large. Recieved %d %s", rec.Code, body.Error.Code)
+	}
+}
+
+func TestWantsJSON(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", true},
+		{"application/json, text/plain, */*", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"text/html;q=0.5, application/json", true},
+		{"application/json;q=0.5, text/html", false},
+		{"application/json;q=0, */*", false},
+		{"*/*", false},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/galleries", nil)
+		req.Header.Set("Accept", c.accept)
+		if got := WantsJSON(req); got != c.want {
+			t.Errorf("WantsJSON(%q) = %v. Expected %v", c.accept, got, c.want)
+		}
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	req := httptest.NewRequest("GET", "/?cursor="+EncodeCursor(42)+"&limit=500", nil)
+	page, err := pageParams(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if page.After != 42 || page.Limit != maxLimit+1 {
+		t.Errorf("Expected the page after 42 with the max limit. Recieved %+v", page)
+	}
+
+	for _, query := range []string{"?cursor=bm9wZQ", "?cursor=!", "?limit=0", "?limit=ten"} {
+		if _, err := pageParams(httptest.NewRequest("GET", "/"+query, nil)); err == nil {
+			t.Errorf("Expected an error for %s", query)
+		}
+	}
+}
+
+func TestGalleriesPagination(t *testing.T) {
+	_, r, gs := testingAPI()
+	jane := testingUser(1, true)
+	for _, title := range []string{"One", "Two", "Three"} {
+		gs.Create(&models.Gallery{UserID: jane.ID, Title: title})
+	}
+	gs.Create(&models.Gallery{UserID: 2, Title: "Other"})
+
+	var titles []string
+	path := "/api/v1/galleries?limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("Expected the cursor to run out")
+		}
+
+		var body struct {
+			Data       []Gallery `json:"data"`
+			NextCursor string    `json:"next_cursor"`
+		}
+		if status := do(t, r, jane, "GET", path, "", &body); status != http.StatusOK {
+			t.Fatalf("Expected 200. Recieved %d", status)
+		}
+
+		for _, g := range body.Data {
+			titles = append(titles, g.Title)
+		}
+
+		path = ""
+		if body.NextCursor != "" {
+			path = "/api/v1/galleries?limit=2&cursor=" + body.NextCursor
+		}
+	}
+
+	if strings.Join(titles, ",") != "Three,Two,One" {
+		t.Errorf("Expected the galleries of jane newest first. Recieved %v", titles)
+	}
+}
+
+func TestGalleriesAPI(t *testing.T) {
+	_, r, gs := testingAPI()
+	jane := testingUser(1, false)
+	jon := testingUser(2, true)
+
+	var created struct{ Data Gallery }
+	status := do(t, r, jane, "POST", "/api/v1/galleries", `{"title": "Holiday", "visibility": "private"}`, &created)
+	if status != http.StatusCreated || created.Data.ID == 0 || created.Data.ShareToken == "" {
+		t.Fatalf("Expected the gallery to be created. Recieved %d %+v", status, created)
+	}
+
+	cases := []struct {
+		user   *models.User
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{nil, "GET", "/api/v1/galleries", "", http.StatusUnauthorized, "unauthorized"},
+		{jane, "POST", "/api/v1/galleries", `{"title": ""}`, http.StatusUnprocessableEntity, "invalid"},
+		{jane, "POST", "/api/v1/galleries", `{"title": "Public", "visibility": "public"}`, http.StatusForbidden, "forbidden"},
+		{jane, "POST", "/api/v1/galleries", `{"name": "Typo"}`, http.StatusBadRequest, "bad_request"},
+		{jon, "GET", "/api/v1/galleries/1", "", http.StatusNotFound, "not_found"},
+		{nil, "GET", "/api/v1/galleries/1", "", http.StatusNotFound, "not_found"},
+		{jon, "PATCH", "/api/v1/galleries/1", `{"title": "Mine"}`, http.StatusForbidden, "forbidden"},
+		{jon, "DELETE", "/api/v1/galleries/1", "", http.StatusForbidden, "forbidden"},
+		{jane, "GET", "/api/v1/galleries/9", "", http.StatusNotFound, "not_found"},
+		{jane, "GET", "/api/v2/galleries", "", http.StatusNotFound, "not_found"},
+	}
+
+	for _, c := range cases {
+		var body errorEnvelope
+		status := do(t, r, c.user, c.method, c.path, c.body, &body)
+		if status != c.status || body.Error.Code != c.code || body.Error.Message == "" {
+			t.Errorf("%s %s: Expected %d %s. Recieved %d %+v", c.method, c.path, c.status, c.code, status, body.Error)
+		}
+	}
+
+	var updated struct{ Data Gallery }
+	status = do(t, r, jane, "PATCH", "/api/v1/galleries/1", `{"title": "Summer"}`, &updated)
+	if status != http.StatusOK || updated.Data.Title != "Summer" || updated.Data.Visibility != "private" {
+		t.Errorf("Expected only the title to change. Recieved %d %+v", status, updated.Data)
+	}
+
+	// others see public galleries, but not the share token
+	gs.galleries[0].Visibility = models.VisibilityPublic
+	var shown struct{ Data Gallery }
+	if status := do(t, r, jon, "GET", "/api/v1/galleries/1", "", &shown); status != http.StatusOK || shown.Data.ShareToken != "" {
+		t.Errorf("Expected the gallery without its share token. Recieved %d %+v", status, shown.Data)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/galleries", strings.NewReader("title=Form"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req.WithContext(context.WithUser(req.Context(), jane)))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for a form body. Recieved %d", rec.Code)
+	}
+
+	if status := do(t, r, jane, "DELETE", "/api/v1/galleries/1", "", nil); status != http.StatusNoContent {
+		t.Errorf("Expected 204. Recieved %d", status)
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"../../photofriends/models"
+	"../../photofriends/ratelimit"
+	"../../photofriends/views"
+)
+
+var (
+	errUnauthorized = views.NewPublicError("Please log in first")
+	errNotFound     = views.NewPublicError("Resource not found")
+	errBadJSON      = views.NewPublicError("The request body is not valid JSON")
+	errMediaType    = views.NewPublicError("Send the request body as application/json")
+	errCursor       = views.NewPublicError("The cursor is not valid")
+	errLimit        = views.NewPublicError("The limit must be a positive number")
+	errNoImages     = views.NewPublicError("Please pick at least one image to upload")
+	errNoUser       = views.NewPublicError("Please send either a user_id or an email")
+
+	errImagesTooLarge = views.NewPublicError(fmt.Sprintf("Images must add up to at most %d MB per upload", maxImagesBytes>>20))
+
+	errUserNotFound    = views.NewPublicError("User not found")
+	errGalleryNotFound = views.NewPublicError("Gallery not found")
+	errImageNotFound   = views.NewPublicError("Image not found")
+	errRequestNotFound = views.NewPublicError("Friend request not found")
+	errUploadNotFound  = views.NewPublicError("Upload not found")
+
+	errTusVersion     = views.NewPublicError("Only version " + tusVersion + " of the tus protocol is supported")
+	errUploadLength   = views.NewPublicError("Upload-Length must be set to the size of the image")
+	errUploadOffset   = views.NewPublicError("Upload-Offset must be set to where the chunk starts")
+	errUploadMetadata = views.NewPublicError("Upload-Metadata is not valid")
+	errChunkType      = views.NewPublicError("Send chunks as " + chunkContentType)
+)
+
+// errorStatus is the status models errors are returned with.
+// Other errors that can be shown to users are a bad request,
+// and the rest an internal error
+var errorStatus = map[error]int{
+	models.ErrNotFound:             http.StatusNotFound,
+	models.ErrNotOwner:             http.StatusForbidden,
+	models.ErrEmailUnverified:      http.StatusForbidden,
+	models.ErrFriendBlocked:        http.StatusForbidden,
+	models.ErrFriendRequestExists:  http.StatusConflict,
+	models.ErrAlreadyFriends:       http.StatusConflict,
+	models.ErrFriendSelf:           http.StatusUnprocessableEntity,
+	models.ErrImageTypeInvalid:     http.StatusUnsupportedMediaType,
+	models.ErrAPITokenInvalid:      http.StatusUnauthorized,
+	models.ErrAPITokenExpired:      http.StatusUnauthorized,
+	models.ErrAPITokenScope:        http.StatusForbidden,
+	models.ErrUploadOffsetMismatch: http.StatusConflict,
+	models.ErrUploadTooLarge:       http.StatusRequestEntityTooLarge,
+	models.ErrChunkTooLarge:        http.StatusRequestEntityTooLarge,
+	models.ErrUploadExpired:        http.StatusGone,
+	errUnauthorized:                http.StatusUnauthorized,
+	errNotFound:                    http.StatusNotFound,
+	errUserNotFound:                http.StatusNotFound,
+	errGalleryNotFound:             http.StatusNotFound,
+	errImageNotFound:               http.StatusNotFound,
+	errRequestNotFound:             http.StatusNotFound,
+	errMediaType:                   http.StatusUnsupportedMediaType,
+	errImagesTooLarge:              http.StatusRequestEntityTooLarge,
+	errUploadNotFound:              http.StatusNotFound,
+	errTusVersion:                  http.StatusPreconditionFailed,
+	errChunkType:                   http.StatusUnsupportedMediaType,
+}
+
+// errorCodes are the machine readable codes of the
+// statuses, so clients need not parse messages
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "invalid",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal",
+}
+
+// Error is the body of every error response, eg:
+//
+//	{"error": {"code": "invalid", "message": "Please fix the errors below",
+//		"fields": {"title": "Title is required"}}}
+type Error struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error Error `json:"error"`
+}
+
+// ErrorStatus returns the status err is responded with
+func ErrorStatus(err error) int {
+	// FieldErrors is a map, which can not be looked up in one
+	switch err.(type) {
+	case models.FieldErrors:
+		return http.StatusUnprocessableEntity
+	case *ratelimit.LimitedError:
+		return http.StatusTooManyRequests
+	}
+
+	if status, ok := errorStatus[err]; ok {
+		return status
+	}
+
+	if _, ok := err.(views.PublicError); ok {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// WriteError responds with err in the error envelope,
+// with the status picked by ErrorStatus
+func WriteError(res http.ResponseWriter, err error) {
+	WriteErrorStatus(res, ErrorStatus(err), err)
+}
+
+// WriteErrorStatus responds with err in the error envelope.
+// Only messages that are safe to show to users are sent,
+// anything else is logged and reported as a generic error
+func WriteErrorStatus(res http.ResponseWriter, status int, err error) {
+	e := Error{
+		Code:    errorCodes[status],
+		Message: views.ErrorAlert(err).Message,
+	}
+	if e.Code == "" {
+		e.Code = errorCodes[status/100*100]
+	}
+
+	if fe, ok := err.(models.FieldErrors); ok {
+		e.Fields = fe.Fields()
+	}
+
+	if limited, ok := err.(*ratelimit.LimitedError); ok {
+		seconds := int(limited.RetryAfter.Seconds()) + 1
+		res.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	writeJSON(res, status, errorEnvelope{e})
+}
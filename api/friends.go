@@ -0,0 +1,191 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"../../photofriends/email"
+	"../../photofriends/models"
+	"../context"
+)
+
+// FriendRequestBody is the body to send a friend request
+// with. The user is picked by ID, or by email address
+type FriendRequestBody struct {
+	UserID uint   `json:"user_id,omitempty"`
+	Email  string `json:"email,omitempty"`
+}
+
+// listFriendships lists the friends, friend requests and
+// blocked users of the current user, newest first. Users
+// that blocked the current user are left out
+//
+// GET /api/v1/friendships
+func (a *API) listFriendships(res http.ResponseWriter, req *http.Request) {
+	page, err := pageParams(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	user := context.User(req.Context())
+	friendships, err := a.fs.ByUserIDPage(user.ID, page)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	n, next := nextCursor(len(friendships), page, func(i int) uint { return friendships[i].ID })
+	all := make([]Friendship, 0, n)
+	for _, friendship := range friendships[:n] {
+		if friendship.Status == models.FriendshipBlocked && friendship.UserID != user.ID {
+			continue
+		}
+
+		other, err := a.us.ByID(friendship.Other(user.ID))
+		if err != nil {
+			continue
+		}
+		all = append(all, NewFriendship(&friendship, other, user.ID))
+	}
+
+	writeList(res, all, next)
+}
+
+// requestFriend sends a friend request from the current
+// user, or accepts the request the other user sent
+//
+// POST /api/v1/friendships
+func (a *API) requestFriend(res http.ResponseWriter, req *http.Request) {
+	var body FriendRequestBody
+	if err := decodeJSON(res, req, &body); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	user := context.User(req.Context())
+	if !user.Verified() {
+		WriteError(res, models.ErrEmailUnverified)
+		return
+	}
+
+	var other *models.User
+	var err error
+	switch {
+	case body.UserID != 0:
+		other, err = a.us.ByID(body.UserID)
+	case body.Email != "":
+		other, err = a.us.ByEmail(body.Email)
+	default:
+		err = errNoUser
+	}
+	if err == models.ErrNotFound {
+		err = errUserNotFound
+	}
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	friendship, err := a.fs.Request(user.ID, other.ID)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	if friendship.Status == models.FriendshipPending {
+		// the request went through either way, so a failed
+		// notification is only logged
+		if err := a.notifyRequest(user, other); err != nil {
+			log.Printf("api: notifying user %d: %v", other.ID, err)
+		}
+	}
+
+	WriteData(res, http.StatusCreated, NewFriendship(friendship, other, user.ID))
+}
+
+// accept accepts a friend request sent to the current user
+//
+// POST /api/v1/friendships/:id/accept
+func (a *API) accept(res http.ResponseWriter, req *http.Request) {
+	a.answer(res, req, a.fs.Accept)
+}
+
+// decline declines a friend request sent to the current user
+//
+// POST /api/v1/friendships/:id/decline
+func (a *API) decline(res http.ResponseWriter, req *http.Request) {
+	a.answer(res, req, a.fs.Decline)
+}
+
+// unfriend ends a friendship, cancels a sent
+// request or unblocks the user with the given id
+//
+// DELETE /api/v1/users/:id/friendship
+func (a *API) unfriend(res http.ResponseWriter, req *http.Request) {
+	a.withOther(res, req, a.fs.Unfriend)
+}
+
+// block blocks the user with the given id
+//
+// POST /api/v1/users/:id/block
+func (a *API) block(res http.ResponseWriter, req *http.Request) {
+	a.withOther(res, req, a.fs.Block)
+}
+
+// answer runs fn for the friendship id in the route
+func (a *API) answer(res http.ResponseWriter, req *http.Request, fn func(userID, friendshipID uint) error) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		WriteError(res, errRequestNotFound)
+		return
+	}
+
+	user := context.User(req.Context())
+	if err := fn(user.ID, id); err != nil {
+		if err == models.ErrNotFound {
+			err = errRequestNotFound
+		}
+
+		WriteError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// withOther runs fn for the user id in the route
+func (a *API) withOther(res http.ResponseWriter, req *http.Request, fn func(userID, otherID uint) error) {
+	other, err := a.userByID(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	user := context.User(req.Context())
+	if err := fn(user.ID, other.ID); err != nil {
+		if err == models.ErrNotFound {
+			err = errUserNotFound
+		}
+
+		WriteError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// notifyRequest emails the user a friend request was sent
+// to, linking to the friends page like the HTML form does
+func (a *API) notifyRequest(from, to *models.User) error {
+	msg, err := a.requestEmail.Message(to.Email, struct {
+		Name string
+		From string
+		Link string
+	}{to.Name, from.Name, email.Link(a.baseURL, "/friends", nil)})
+	if err != nil {
+		return err
+	}
+
+	return a.mailer.Send(msg)
+}
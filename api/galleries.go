@@ -0,0 +1,211 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"../../photofriends/models"
+	"../context"
+)
+
+// GalleryBody is the body to create a gallery with
+type GalleryBody struct {
+	Title      string `json:"title"`
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// GalleryPatch is the body to update a gallery with,
+// fields that are left out are not changed
+type GalleryPatch struct {
+	Title      *string `json:"title"`
+	Visibility *string `json:"visibility"`
+}
+
+// listGalleries lists the galleries owned by
+// the current user, newest first
+//
+// GET /api/v1/galleries
+func (a *API) listGalleries(res http.ResponseWriter, req *http.Request) {
+	page, err := pageParams(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	user := context.User(req.Context())
+	galleries, err := a.gs.ByUserIDPage(user.ID, page)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	n, next := nextCursor(len(galleries), page, func(i int) uint { return galleries[i].ID })
+	writeList(res, NewGalleries(galleries[:n], user), next)
+}
+
+// createGallery creates a gallery owned by the current user
+//
+// POST /api/v1/galleries
+func (a *API) createGallery(res http.ResponseWriter, req *http.Request) {
+	var body GalleryBody
+	if err := decodeJSON(res, req, &body); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	user := context.User(req.Context())
+	if !models.CanPublish(user, body.Visibility) {
+		WriteError(res, models.ErrEmailUnverified)
+		return
+	}
+
+	gallery := models.Gallery{
+		Title:      body.Title,
+		Visibility: body.Visibility,
+		UserID:     user.ID,
+	}
+	if err := a.gs.Create(&gallery); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	res.Header().Set("Location", fmt.Sprintf("%s/galleries/%d", Prefix, gallery.ID))
+	WriteData(res, http.StatusCreated, NewGallery(&gallery, user))
+}
+
+// showGallery shows a gallery the current user, if any,
+// may see. Unlisted galleries need the ?share= token
+//
+// GET /api/v1/galleries/:id
+func (a *API) showGallery(res http.ResponseWriter, req *http.Request) {
+	gallery, err := a.viewableGallery(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	WriteData(res, http.StatusOK, NewGallery(gallery, context.User(req.Context())))
+}
+
+// updateGallery changes the title or visibility
+// of a gallery owned by the current user
+//
+// PATCH /api/v1/galleries/:id
+func (a *API) updateGallery(res http.ResponseWriter, req *http.Request) {
+	gallery, err := a.ownedGallery(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	var body GalleryPatch
+	if err := decodeJSON(res, req, &body); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	if body.Title != nil {
+		gallery.Title = *body.Title
+	}
+
+	// galleries made public before this rule existed may stay public
+	user := context.User(req.Context())
+	if body.Visibility != nil && *body.Visibility != gallery.Visibility {
+		if !models.CanPublish(user, *body.Visibility) {
+			WriteError(res, models.ErrEmailUnverified)
+			return
+		}
+		gallery.Visibility = *body.Visibility
+	}
+
+	if err := a.gs.Update(gallery); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	WriteData(res, http.StatusOK, NewGallery(gallery, user))
+}
+
+// deleteGallery removes a gallery owned by the
+// current user, along with all of its images
+//
+// DELETE /api/v1/galleries/:id
+func (a *API) deleteGallery(res http.ResponseWriter, req *http.Request) {
+	gallery, err := a.ownedGallery(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	images, err := a.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	for i := range images {
+		if err := a.is.Delete(&images[i]); err != nil {
+			WriteError(res, err)
+			return
+		}
+	}
+
+	if err := a.gs.Delete(gallery.ID, context.User(req.Context()).ID); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// galleryByID looks up the gallery in the "id" route variable
+func (a *API) galleryByID(req *http.Request) (*models.Gallery, error) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		return nil, errGalleryNotFound
+	}
+
+	gallery, err := a.gs.ByID(id)
+	if err == models.ErrNotFound {
+		return nil, errGalleryNotFound
+	}
+
+	return gallery, err
+}
+
+// viewableGallery works like galleryByID, but galleries the
+// current user may not see are reported as not found
+func (a *API) viewableGallery(req *http.Request) (*models.Gallery, error) {
+	gallery, err := a.galleryByID(req)
+	if err != nil {
+		return nil, err
+	}
+
+	user := context.User(req.Context())
+	ok, err := a.gs.CanView(gallery, user, req.URL.Query().Get("share"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, errGalleryNotFound
+	}
+
+	return gallery, nil
+}
+
+// ownedGallery works like galleryByID, but
+// only returns galleries of the current user
+func (a *API) ownedGallery(req *http.Request) (*models.Gallery, error) {
+	gallery, err := a.galleryByID(req)
+	if err != nil {
+		return nil, err
+	}
+
+	user := context.User(req.Context())
+	if user == nil || gallery.UserID != user.ID {
+		return nil, models.ErrNotOwner
+	}
+
+	return gallery, nil
+}
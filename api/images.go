@@ -0,0 +1,228 @@
+package api
+
+import (
+	"io"
+	"net/http"
+
+	"../../photofriends/models"
+	"../context"
+)
+
+const (
+	// maxMultipartMem is how much of an upload is kept
+	// in memory before the rest is spilled to temp files
+	maxMultipartMem = 1 << 20 // 1 megabyte
+
+	// maxImagesBytes limits the whole body of a form upload,
+	// so the temp files can not fill the disk. Larger images
+	// can be sent in chunks, see UploadsPath
+	maxImagesBytes = models.MaxUploadLength
+)
+
+// listImages lists the images of a gallery the
+// current user may see, oldest first
+//
+// GET /api/v1/galleries/:id/images
+func (a *API) listImages(res http.ResponseWriter, req *http.Request) {
+	gallery, err := a.viewableGallery(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	page, err := pageParams(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	images, err := a.is.ByGalleryIDPage(gallery.ID, page)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	n, next := nextCursor(len(images), page, func(i int) uint { return images[i].ID })
+	writeList(res, NewImages(images[:n]), next)
+}
+
+// uploadImages stores every image posted in the "images"
+// field of the multipart form in a gallery owned by the
+// current user, and responds with the images created
+//
+// POST /api/v1/galleries/:id/images
+func (a *API) uploadImages(res http.ResponseWriter, req *http.Request) {
+	gallery, err := a.ownedGallery(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	images, err := UploadImages(a.is, gallery, res, req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	WriteData(res, http.StatusCreated, NewImages(images))
+}
+
+// showImage shows an image of a gallery the current user may
+// see. Its owner also gets the images it looks like
+//
+// GET /api/v1/galleries/:id/images/:imageID
+func (a *API) showImage(res http.ResponseWriter, req *http.Request) {
+	gallery, err := a.viewableGallery(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	image, err := a.imageByID(req, gallery)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	if user := context.User(req.Context()); user != nil && user.ID == gallery.UserID {
+		if err := a.is.DuplicatesOf(image); err != nil {
+			WriteError(res, err)
+			return
+		}
+	}
+
+	WriteData(res, http.StatusOK, NewImage(image))
+}
+
+// deleteImage removes an image of a gallery owned by the current user
+//
+// DELETE /api/v1/galleries/:id/images/:imageID
+func (a *API) deleteImage(res http.ResponseWriter, req *http.Request) {
+	gallery, err := a.ownedGallery(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	image, err := a.imageByID(req, gallery)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	if err := a.is.Delete(image); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// imageByID looks up the image in the "imageID" route
+// variable, which has to belong to the gallery
+func (a *API) imageByID(req *http.Request, gallery *models.Gallery) (*models.Image, error) {
+	id, err := idVar(req, "imageID")
+	if err != nil {
+		return nil, errImageNotFound
+	}
+
+	image, err := a.is.ByID(id)
+	if err == models.ErrNotFound || (err == nil && image.GalleryID != gallery.ID) {
+		return nil, errImageNotFound
+	}
+
+	return image, err
+}
+
+// UploadImages stores every image posted in the "images"
+// field of the multipart form of req in the gallery. With
+// "strip_metadata" set to "on" private EXIF data is removed.
+// The images come with the Duplicates the user already has.
+// Bodies larger than maxImagesBytes are refused
+func UploadImages(is models.ImageService, gallery *models.Gallery, res http.ResponseWriter, req *http.Request) ([]models.Image, error) {
+	if req.ContentLength > maxImagesBytes {
+		return nil, errImagesTooLarge
+	}
+
+	body := &cappedBody{ReadCloser: http.MaxBytesReader(res, req.Body, maxImagesBytes)}
+	req.Body = body
+	if err := req.ParseMultipartForm(maxMultipartMem); err != nil {
+		if body.n >= maxImagesBytes {
+			return nil, errImagesTooLarge
+		}
+		return nil, errNoImages
+	}
+	defer req.MultipartForm.RemoveAll()
+
+	strip := req.FormValue("strip_metadata") == "on"
+	files := req.MultipartForm.File["images"]
+	if len(files) == 0 {
+		return nil, errNoImages
+	}
+
+	images := make([]models.Image, 0, len(files))
+	for _, fh := range files {
+		file, err := fh.Open()
+		if err != nil {
+			return images, err
+		}
+
+		image := models.Image{
+			GalleryID:     gallery.ID,
+			Filename:      fh.Filename,
+			Size:          fh.Size,
+			StripMetadata: strip,
+		}
+
+		image.ContentType, err = detectContentType(file)
+		if err == nil {
+			err = is.Create(&image, file)
+		}
+		file.Close()
+
+		if err != nil {
+			return images, err
+		}
+		images = append(images, image)
+	}
+
+	// every image is queued before waiting for any
+	// of them to be hashed, so they are done together
+	for i := range images {
+		if err := is.DuplicatesOf(&images[i]); err != nil {
+			return images, err
+		}
+	}
+
+	return images, nil
+}
+
+// cappedBody counts the bytes read from a body cut off by
+// http.MaxBytesReader, to tell when the limit was reached
+type cappedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (cb *cappedBody) Read(p []byte) (int, error) {
+	n, err := cb.ReadCloser.Read(p)
+	cb.n += int64(n)
+	return n, err
+}
+
+// detectContentType sniffs the content type from the first
+// bytes of the file rather than trusting the client provided
+// header, then rewinds the file so it can be read in full
+func detectContentType(file io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := file.Read(buf)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(buf[:n]), nil
+}
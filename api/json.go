@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"../../photofriends/models"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// maxBodyBytes limits JSON request bodies,
+	// uploads are limited by the storage instead
+	maxBodyBytes = 1 << 20 // 1 megabyte
+
+	// defaultLimit and maxLimit are the number of
+	// items in a page of a list, see pageParams
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// dataEnvelope wraps every successful response
+type dataEnvelope struct {
+	Data interface{} `json:"data"`
+}
+
+// listEnvelope wraps a page of a list. NextCursor is
+// empty on the last page
+type listEnvelope struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+// WriteData responds with v in the data envelope
+func WriteData(res http.ResponseWriter, status int, v interface{}) {
+	writeJSON(res, status, dataEnvelope{v})
+}
+
+// writeList responds with a page of a list, see pageParams
+func writeList(res http.ResponseWriter, v interface{}, next string) {
+	writeJSON(res, http.StatusOK, listEnvelope{Data: v, NextCursor: next})
+}
+
+// decodeJSON decodes the request body into dst. Fields dst
+// does not have are rejected, so typos are not ignored
+func decodeJSON(res http.ResponseWriter, req *http.Request, dst interface{}) error {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errMediaType
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return errBadJSON
+	}
+
+	return nil
+}
+
+// EncodeCursor returns the opaque cursor of the page
+// after the item with the given ID
+func EncodeCursor(id uint) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+// decodeCursor reverses EncodeCursor
+func decodeCursor(cursor string) (uint, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errCursor
+	}
+
+	id, err := strconv.ParseUint(string(b), 10, 32)
+	if err != nil || id == 0 {
+		return 0, errCursor
+	}
+
+	return uint(id), nil
+}
+
+// pageParams reads the page asked for from the cursor and
+// limit query parameters. The page returned has one more
+// item than asked for, so handlers can tell whether there
+// is a next page, see nextCursor
+func pageParams(req *http.Request) (models.Page, error) {
+	q := req.URL.Query()
+	page := models.Page{Limit: defaultLimit}
+	if cursor := q.Get("cursor"); cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+		page.After = after
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return page, errLimit
+		}
+		if n > maxLimit {
+			n = maxLimit
+		}
+		page.Limit = n
+	}
+
+	page.Limit++
+	return page, nil
+}
+
+// nextCursor returns how many of the n items fetched for
+// page belong in the response, and the cursor of the next
+// page if there is one. lastID returns the ID of an item
+func nextCursor(n int, page models.Page, lastID func(i int) uint) (int, string) {
+	limit := page.Limit - 1
+	if n <= limit {
+		return n, ""
+	}
+
+	return limit, EncodeCursor(lastID(limit - 1))
+}
+
+// idVar parses the named route variable as an ID
+func idVar(req *http.Request, name string) (uint, error) {
+	id, err := strconv.Atoi(mux.Vars(req)[name])
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(id), nil
+}
+
+// WantsJSON reports whether the client prefers JSON over
+// HTML, going by the q values of the Accept header. Browsers
+// accept */*, which is HTML unless JSON is asked for as well
+func WantsJSON(req *http.Request) bool {
+	jsonQ, htmlQ, anyQ := 0.0, 0.0, 0.0
+	for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/json":
+			jsonQ = maxQ(jsonQ, q)
+		case "text/html":
+			htmlQ = maxQ(htmlQ, q)
+		case "*/*":
+			anyQ = maxQ(anyQ, q)
+		}
+	}
+
+	return jsonQ > 0 && jsonQ > htmlQ && jsonQ >= anyQ
+}
+
+func maxQ(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
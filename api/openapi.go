@@ -0,0 +1,393 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"../../photofriends/models"
+)
+
+// openAPIVersion is the version of the OpenAPI
+// specification the document follows
+const openAPIVersion = "3.0.3"
+
+// Document is an OpenAPI document, only with
+// the parts needed to describe this API
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem holds the operations of a path by
+// lower case method, as OpenAPI wants them
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema. Ref points to one of
+// Components.Schemas, in which case nothing else is set
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// The security schemes of the document. Requests are made
+// either with an API token or the session cookie of the site
+const (
+	securityToken   = "apiToken"
+	securitySession = "session"
+)
+
+// schemaRefPrefix is where Schema.Ref points to
+const schemaRefPrefix = "#/components/schemas/"
+
+// queryParams describes the query parameters
+// routes can list in Route.Query
+var queryParams = map[string]Parameter{
+	"share": {
+		Name:        "share",
+		In:          "query",
+		Description: "The share token of an unlisted gallery",
+		Schema:      &Schema{Type: "string"},
+	},
+	"cursor": {
+		Name:        "cursor",
+		In:          "query",
+		Description: "The next_cursor of the previous page",
+		Schema:      &Schema{Type: "string"},
+	},
+	"limit": {
+		Name:        "limit",
+		In:          "query",
+		Description: "The number of items in the page",
+		Schema:      &Schema{Type: "integer", Minimum: intPtr(1), Maximum: intPtr(maxLimit), Default: defaultLimit},
+	},
+}
+
+// pathVar matches the mux variables of a path, along
+// with their pattern, such as {id:[0-9]+}
+var pathVar = regexp.MustCompile(`\{([a-zA-Z]+)(?::([^}]+))?\}`)
+
+// openAPI serves the OpenAPI document
+//
+// GET /api/v1/openapi.json
+func (a *API) openAPI(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, a.OpenAPI())
+}
+
+// OpenAPI describes the routes of the API and the
+// resources they take and return as an OpenAPI document
+func (a *API) OpenAPI() *Document {
+	doc := &Document{
+		OpenAPI: openAPIVersion,
+		Info: Info{
+			Title:       "PhotoFriends API",
+			Description: apiDescription(),
+			Version:     strings.TrimPrefix(Prefix, "/api/"),
+		},
+		Servers: []Server{{URL: Prefix}},
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+			SecuritySchemes: map[string]SecurityScheme{
+				securityToken: {
+					Type:         "http",
+					Scheme:       "bearer",
+					BearerFormat: "pf_...",
+					Description:  "A personal API token, see /tokens",
+				},
+				// see middelware.SessionCookie
+				securitySession: {
+					Type: "apiKey",
+					In:   "cookie",
+					Name: "session",
+				},
+			},
+		},
+		Security: []map[string][]string{{securityToken: {}}, {securitySession: {}}},
+	}
+
+	doc.schema(reflect.TypeOf(Error{}))
+	doc.Components.Schemas["ErrorEnvelope"] = &Schema{
+		Type:       "object",
+		Properties: map[string]*Schema{"error": {Ref: schemaRefPrefix + "Error"}},
+		Required:   []string{"error"},
+	}
+
+	for _, route := range a.Routes() {
+		path := pathVar.ReplaceAllString(route.Path, "{$1}")
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = doc.operation(route)
+	}
+
+	return doc
+}
+
+// apiDescription introduces the API and the
+// scopes API tokens can be given
+func apiDescription() string {
+	var sb strings.Builder
+	sb.WriteString("Successful responses wrap their resource in {\"data\": ...}, ")
+	sb.WriteString("lists add a next_cursor to pass back as ?cursor= while there are more items. ")
+	sb.WriteString("Errors are described by ErrorEnvelope.\n\nAPI tokens are given scopes:\n")
+	for _, scope := range models.APITokenScopes {
+		sb.WriteString("\n- " + scope.Name + ": " + scope.Description)
+	}
+
+	return sb.String()
+}
+
+// operation describes route
+func (doc *Document) operation(route Route) *Operation {
+	op := &Operation{
+		OperationID: route.Name,
+		Summary:     route.Summary,
+		Tags:        []string{strings.SplitN(strings.TrimPrefix(route.Path, "/"), "/", 2)[0]},
+		Responses: map[string]Response{
+			"default": {
+				Description: "An error",
+				Content:     jsonContent(&Schema{Ref: schemaRefPrefix + "ErrorEnvelope"}),
+			},
+		},
+	}
+
+	switch route.Scope {
+	case "":
+	case scopeSession:
+		op.Description = "Can not be used with an API token."
+		op.Security = []map[string][]string{{securitySession: {}}}
+	default:
+		op.Description = "API tokens need the " + route.Scope + " scope."
+	}
+	if route.Public {
+		// an empty requirement makes the others optional
+		security := op.Security
+		if security == nil {
+			security = []map[string][]string{{securityToken: {}}, {securitySession: {}}}
+		}
+		op.Security = append(security, map[string][]string{})
+	}
+
+	for _, match := range pathVar.FindAllStringSubmatch(route.Path, -1) {
+		schema := &Schema{Type: "string"}
+		if match[2] == "[0-9]+" {
+			schema = &Schema{Type: "integer", Minimum: intPtr(1)}
+		}
+		op.Parameters = append(op.Parameters, Parameter{Name: match[1], In: "path", Required: true, Schema: schema})
+	}
+
+	query := route.Query
+	if route.Paged {
+		query = append(query, "cursor", "limit")
+	}
+	for _, name := range query {
+		op.Parameters = append(op.Parameters, queryParams[name])
+	}
+
+	switch {
+	case route.Upload:
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				"multipart/form-data": {Schema: &Schema{
+					Type: "object",
+					Properties: map[string]*Schema{
+						"images": {Type: "array", Items: &Schema{Type: "string", Format: "binary"}},
+						"strip_metadata": {
+							Type:        "string",
+							Description: "Set to on to remove private EXIF data",
+						},
+					},
+					Required: []string{"images"},
+				}},
+			},
+		}
+	case route.Body != nil:
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  jsonContent(doc.schema(reflect.TypeOf(route.Body))),
+		}
+	}
+
+	success := Response{Description: http.StatusText(route.Status)}
+	if route.Response != nil {
+		envelope := &Schema{
+			Type:       "object",
+			Properties: map[string]*Schema{"data": doc.schema(reflect.TypeOf(route.Response))},
+			Required:   []string{"data"},
+		}
+		if route.Paged {
+			envelope.Properties["next_cursor"] = &Schema{
+				Type:        "string",
+				Description: "Set while there are more items",
+			}
+		}
+		success.Content = jsonContent(envelope)
+	}
+	op.Responses[strconv.Itoa(route.Status)] = success
+
+	return op
+}
+
+// schema returns the schema of values of type t as encoded
+// by encoding/json. Named structs are added to the components
+// and referred to, so they are described only once
+func (doc *Document) schema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct:
+		return doc.structSchema(t)
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Uint, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: doc.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: doc.schema(t.Elem())}
+	}
+
+	return &Schema{}
+}
+
+// structSchema describes the exported fields of struct type
+// t. Fields that may be left out, either omitempty or
+// pointers, are not required
+func (doc *Document) structSchema(t reflect.Type) *Schema {
+	ref := &Schema{Ref: schemaRefPrefix + t.Name()}
+	if _, ok := doc.Components.Schemas[t.Name()]; ok {
+		return ref
+	}
+
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	// added before the fields, so types referring
+	// to themselves do not recurse forever
+	doc.Components.Schemas[t.Name()] = s
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		s.Properties[name] = doc.schema(field.Type)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+
+	return ref
+}
+
+// jsonContent is the content of a JSON request or response
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: schema}}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
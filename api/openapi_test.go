@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// refs returns every $ref in the JSON encoding of v
+func refs(t *testing.T, v interface{}) []string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var all []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, child := range v {
+				if ref, ok := child.(string); ok && k == "$ref" {
+					all = append(all, ref)
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+
+	var decoded interface{}
+	json.Unmarshal(b, &decoded)
+	walk(decoded)
+	return all
+}
+
+func TestOpenAPIValid(t *testing.T) {
+	a, _, _ := testingAPI()
+	doc := a.OpenAPI()
+
+	names := make(map[string]bool)
+	for path, item := range doc.Paths {
+		declared := pathVar.FindAllStringSubmatch(path, -1)
+		for method, op := range item {
+			if names[op.OperationID] {
+				t.Errorf("%s %s: Expected a unique operationId. Recieved %s again", method, path, op.OperationID)
+			}
+			names[op.OperationID] = true
+
+			var params []string
+			for _, p := range op.Parameters {
+				if p.In == "path" {
+					params = append(params, p.Name)
+				}
+			}
+			if len(params) != len(declared) {
+				t.Errorf("%s %s: Expected %d path parameters. Recieved %v", method, path, len(declared), params)
+			}
+
+			if _, ok := op.Responses["default"]; !ok || len(op.Responses) != 2 {
+				t.Errorf("%s %s: Expected a success and an error response. Recieved %v", method, path, op.Responses)
+			}
+		}
+	}
+
+	for _, ref := range refs(t, doc) {
+		name := strings.TrimPrefix(ref, schemaRefPrefix)
+		if s, ok := doc.Components.Schemas[name]; !ok || s.Ref != "" {
+			t.Errorf("Expected %s to resolve to a schema", ref)
+		}
+	}
+
+	gallery := doc.Components.Schemas["Gallery"]
+	if gallery == nil || gallery.Properties["created_at"].Format != "date-time" {
+		t.Fatalf("Expected the Gallery schema with its timestamps. Recieved %+v", gallery)
+	}
+	for _, name := range gallery.Required {
+		if name == "share_token" || name == "images" {
+			t.Errorf("Expected omitempty fields not to be required. Recieved %v", gallery.Required)
+		}
+	}
+}
+
+// TestOpenAPIRoutes keeps the document in sync with what is
+// actually served, as routes could be added to the router
+// in Register without going through Routes
+func TestOpenAPIRoutes(t *testing.T) {
+	a, r, _ := testingAPI()
+	doc := a.OpenAPI()
+
+	served := make(map[string]bool)
+	r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		if err != nil || !strings.HasPrefix(path, Prefix+"/") || path == Prefix+"/openapi.json" {
+			return nil
+		}
+
+		path = pathVar.ReplaceAllString(strings.TrimPrefix(path, Prefix), "{$1}")
+		for _, method := range methods {
+			served[method+" "+path] = true
+			if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("Expected %s %s to be documented", method, path)
+			}
+		}
+		return nil
+	})
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			if !served[strings.ToUpper(method)+" "+path] {
+				t.Errorf("Expected %s %s to be served", method, path)
+			}
+		}
+	}
+
+	var spec struct {
+		OpenAPI string              `json:"openapi"`
+		Paths   map[string]PathItem `json:"paths"`
+	}
+	if status := do(t, r, nil, "GET", Prefix+"/openapi.json", "", &spec); status != http.StatusOK {
+		t.Fatalf("Expected 200. Recieved %d", status)
+	}
+	if spec.OpenAPI != openAPIVersion || len(spec.Paths) != len(doc.Paths) {
+		t.Errorf("Expected the document to be served. Recieved %+v", spec)
+	}
+}
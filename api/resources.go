@@ -0,0 +1,194 @@
+package api
+
+import (
+	"time"
+
+	"../../photofriends/models"
+)
+
+// User is a user as seen by others. The email address
+// and whether it is verified are only shown to the
+// user themselves
+type User struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Email    string `json:"email,omitempty"`
+	Verified *bool  `json:"verified,omitempty"`
+}
+
+// NewUser returns the resource of user, self
+// is set when it is the current user
+func NewUser(user *models.User, self bool) User {
+	u := User{ID: user.ID, Name: user.Name}
+	if self {
+		verified := user.Verified()
+		u.Email = user.Email
+		u.Verified = &verified
+	}
+
+	return u
+}
+
+// Gallery is a gallery along with its images, if they were
+// looked up. The API lists them on their own, see listImages.
+// The share token is only shown to the owner, who hands out
+// the share links
+type Gallery struct {
+	ID         uint      `json:"id"`
+	UserID     uint      `json:"user_id"`
+	Title      string    `json:"title"`
+	Visibility string    `json:"visibility"`
+	ShareToken string    `json:"share_token,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Images     []Image   `json:"images,omitempty"`
+}
+
+// NewGallery returns the resource of gallery
+// as seen by user, who may be nil
+func NewGallery(gallery *models.Gallery, user *models.User) Gallery {
+	g := Gallery{
+		ID:         gallery.ID,
+		UserID:     gallery.UserID,
+		Title:      gallery.Title,
+		Visibility: gallery.Visibility,
+		CreatedAt:  gallery.CreatedAt,
+		UpdatedAt:  gallery.UpdatedAt,
+	}
+	if len(gallery.Images) > 0 {
+		g.Images = NewImages(gallery.Images)
+	}
+	if user != nil && user.ID == gallery.UserID {
+		g.ShareToken = gallery.ShareToken
+	}
+
+	return g
+}
+
+// NewGalleries returns the resources of galleries, see NewGallery
+func NewGalleries(galleries []models.Gallery, user *models.User) []Gallery {
+	all := make([]Gallery, len(galleries))
+	for i := range galleries {
+		all[i] = NewGallery(&galleries[i], user)
+	}
+
+	return all
+}
+
+// Image is an uploaded image. Width and Height are
+// 0 until the derived sizes have been generated.
+// Duplicates are only listed right after an upload, and
+// for the owner of a single image
+type Image struct {
+	ID           uint           `json:"id"`
+	GalleryID    uint           `json:"gallery_id"`
+	Filename     string         `json:"filename"`
+	ContentType  string         `json:"content_type"`
+	Size         int64          `json:"size"`
+	Width        int            `json:"width"`
+	Height       int            `json:"height"`
+	URL          string         `json:"url"`
+	ThumbnailURL string         `json:"thumbnail_url"`
+	Variants     []ImageVariant `json:"variants"`
+	CreatedAt    time.Time      `json:"created_at"`
+	Duplicates   []Duplicate    `json:"duplicates,omitempty"`
+}
+
+// ImageVariant is one of the derived sizes of an Image
+type ImageVariant struct {
+	Name  string `json:"name"`
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+}
+
+// Duplicate is an image of the same user that looks like an
+// uploaded one. Distance is the number of bits their
+// perceptual hashes differ in, 0 when they look the same
+type Duplicate struct {
+	ImageID      uint   `json:"image_id"`
+	GalleryID    uint   `json:"gallery_id"`
+	Filename     string `json:"filename"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	Distance     int    `json:"distance"`
+}
+
+// NewImage returns the resource of image
+func NewImage(image *models.Image) Image {
+	i := Image{
+		ID:           image.ID,
+		GalleryID:    image.GalleryID,
+		Filename:     image.Filename,
+		ContentType:  image.ContentType,
+		Size:         image.Size,
+		Width:        image.Width,
+		Height:       image.Height,
+		URL:          image.URL,
+		ThumbnailURL: image.Thumbnail(),
+		Variants:     make([]ImageVariant, len(image.Variants)),
+		CreatedAt:    image.CreatedAt,
+	}
+	for n, v := range image.Variants {
+		i.Variants[n] = ImageVariant{Name: v.Name, Width: v.Width, URL: v.URL}
+	}
+	if len(image.Duplicates) > 0 {
+		i.Duplicates = NewDuplicates(image.Duplicates)
+	}
+
+	return i
+}
+
+// NewImages returns the resources of images
+func NewImages(images []models.Image) []Image {
+	all := make([]Image, len(images))
+	for i := range images {
+		all[i] = NewImage(&images[i])
+	}
+
+	return all
+}
+
+// NewDuplicates returns the resources of duplicates
+func NewDuplicates(duplicates []models.Duplicate) []Duplicate {
+	all := make([]Duplicate, len(duplicates))
+	for i := range duplicates {
+		d := &duplicates[i]
+		all[i] = Duplicate{
+			ImageID:      d.ID,
+			GalleryID:    d.GalleryID,
+			Filename:     d.Filename,
+			ThumbnailURL: d.Thumbnail(),
+			Distance:     d.Distance,
+		}
+	}
+
+	return all
+}
+
+// Friendship is a friendship as seen by one of its users.
+// User is the user on the other side, and Direction tells
+// whether the current user sent the request or did the
+// blocking, "outgoing", or the other user, "incoming"
+type Friendship struct {
+	ID        uint      `json:"id"`
+	User      User      `json:"user"`
+	Status    string    `json:"status"`
+	Direction string    `json:"direction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewFriendship returns the resource of friendship as seen
+// by the user with userID. other is the user on the other side
+func NewFriendship(friendship *models.Friendship, other *models.User, userID uint) Friendship {
+	f := Friendship{
+		ID:        friendship.ID,
+		User:      NewUser(other, false),
+		Status:    friendship.Status,
+		Direction: "incoming",
+		CreatedAt: friendship.CreatedAt,
+	}
+	if friendship.UserID == userID {
+		f.Direction = "outgoing"
+	}
+
+	return f
+}
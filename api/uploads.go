@@ -0,0 +1,320 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"../../photofriends/models"
+	"../context"
+	"github.com/gorilla/mux"
+)
+
+// Resumable uploads follow version 1.0.0 of the tus protocol,
+// see https://tus.io/protocols/resumable-upload. An upload is
+// created with POST /uploads, and its image sent in chunks
+// with PATCH /uploads/:id. When a connection drops the client
+// asks for the bytes received with HEAD /uploads/:id, and
+// resumes from there. Once complete, the image is added to
+// the gallery named in the Upload-Metadata. Chunks can be at
+// most Upload-Max-Chunk-Size bytes, which OPTIONS tells along
+// with the Tus-Max-Size of a whole upload
+const (
+	// UploadsPath is where uploads are created
+	UploadsPath = "/uploads"
+
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,termination,expiration"
+
+	// chunkContentType is what PATCH requests are sent as
+	chunkContentType = "application/offset+octet-stream"
+)
+
+// registerUploads adds the routes of resumable uploads to
+// r, behind auth. They need the images:upload scope
+func (a *API) registerUploads(r *mux.Router, auth Auth) {
+	protect := func(handler http.HandlerFunc) http.HandlerFunc {
+		handler = auth.RequireScope(models.ScopeImagesUpload, handler)
+		return tusResumable(auth.ApplyFn(requireUser(handler)))
+	}
+
+	upload := UploadsPath + "/{id:[0-9]+}"
+	r.HandleFunc(UploadsPath, tusResumable(tusOptions)).Methods("OPTIONS")
+	r.HandleFunc(upload, tusResumable(tusOptions)).Methods("OPTIONS")
+	r.HandleFunc(UploadsPath, protect(a.createUpload)).Methods("POST")
+	r.HandleFunc(upload, protect(a.uploadOffset)).Methods("HEAD")
+	r.HandleFunc(upload, protect(a.patchUpload)).Methods("PATCH")
+	r.HandleFunc(upload, protect(a.terminateUpload)).Methods("DELETE")
+}
+
+// tusResumable adds the version of the protocol to every
+// response, and refuses requests made with another one.
+// OPTIONS requests need not name one, they ask for it
+func tusResumable(next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Tus-Resumable", tusVersion)
+		if req.Method != "OPTIONS" && req.Header.Get("Tus-Resumable") != tusVersion {
+			res.Header().Set("Tus-Version", tusVersion)
+			WriteError(res, errTusVersion)
+			return
+		}
+
+		next(res, req)
+	}
+}
+
+// tusOptions tells clients what the server supports
+//
+// OPTIONS /uploads
+func tusOptions(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Tus-Version", tusVersion)
+	res.Header().Set("Tus-Extension", tusExtensions)
+	res.Header().Set("Tus-Max-Size", strconv.FormatInt(models.MaxUploadLength, 10))
+	res.Header().Set("Upload-Max-Chunk-Size", strconv.FormatInt(models.MaxChunkLength, 10))
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// createUpload creates an upload of Upload-Length bytes.
+// Upload-Metadata has to hold the gallery_id of a gallery
+// of the current user, and the filename of the image. With
+// strip_metadata set to "on" private EXIF data is removed.
+// The first chunk may be sent along, as with PATCH
+//
+// POST /uploads
+func (a *API) createUpload(res http.ResponseWriter, req *http.Request) {
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		WriteError(res, errUploadLength)
+		return
+	}
+	if length > models.MaxUploadLength {
+		WriteErrorStatus(res, http.StatusRequestEntityTooLarge, models.ErrUploadLengthInvalid)
+		return
+	}
+
+	meta, err := parseUploadMetadata(req.Header.Get("Upload-Metadata"))
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	galleryID, err := strconv.ParseUint(meta["gallery_id"], 10, 64)
+	if err != nil {
+		WriteError(res, errGalleryNotFound)
+		return
+	}
+
+	user := context.User(req.Context())
+	gallery, err := a.gs.ByID(uint(galleryID))
+	if err == models.ErrNotFound {
+		err = errGalleryNotFound
+	} else if err == nil && gallery.UserID != user.ID {
+		err = models.ErrNotOwner
+	}
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	upload := models.Upload{
+		UserID:        user.ID,
+		GalleryID:     gallery.ID,
+		Filename:      meta["filename"],
+		StripMetadata: meta["strip_metadata"] == "on",
+		Length:        length,
+	}
+	if err := a.ups.Create(&upload); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	if req.Header.Get("Content-Type") == chunkContentType {
+		// the upload exists either way, so other errors are
+		// left for the client to notice and resume from
+		err := a.ups.Write(&upload, 0, req.Body)
+		if err == models.ErrImageTypeInvalid || err == models.ErrUploadTooLarge || err == models.ErrChunkTooLarge {
+			a.ups.Terminate(&upload)
+			WriteError(res, err)
+			return
+		}
+	}
+
+	res.Header().Set("Location", fmt.Sprintf("%s/%d", UploadsPath, upload.ID))
+	writeUploadHeaders(res, &upload)
+	res.WriteHeader(http.StatusCreated)
+}
+
+// uploadOffset tells how many bytes of an upload were
+// received, and where the image is once complete
+//
+// HEAD /uploads/:id
+func (a *API) uploadOffset(res http.ResponseWriter, req *http.Request) {
+	upload, err := a.uploadByID(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	res.Header().Set("Upload-Metadata", encodeUploadMetadata(map[string]string{
+		"filename":   upload.Filename,
+		"gallery_id": strconv.FormatUint(uint64(upload.GalleryID), 10),
+	}))
+	writeUploadHeaders(res, upload)
+	res.WriteHeader(http.StatusOK)
+}
+
+// patchUpload stores a chunk of an upload starting at
+// Upload-Offset. The last chunk adds the image to the
+// gallery, and responds with its Upload-Image-ID
+//
+// PATCH /uploads/:id
+func (a *API) patchUpload(res http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Content-Type") != chunkContentType {
+		WriteError(res, errChunkType)
+		return
+	}
+
+	// chunks sent without a length are cut off
+	// at MaxChunkLength while they are read
+	if req.ContentLength > models.MaxChunkLength {
+		WriteError(res, models.ErrChunkTooLarge)
+		return
+	}
+
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		WriteError(res, errUploadOffset)
+		return
+	}
+
+	upload, err := a.uploadByID(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	// the gallery may have been deleted since
+	// the upload was created
+	if _, err := a.gs.ByID(upload.GalleryID); err == models.ErrNotFound {
+		a.ups.Terminate(upload)
+		WriteError(res, errGalleryNotFound)
+		return
+	}
+
+	err = a.ups.Write(upload, offset, req.Body)
+	if err == models.ErrImageTypeInvalid {
+		// no later chunk can fix the start of the file
+		a.ups.Terminate(upload)
+	}
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	writeUploadHeaders(res, upload)
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// terminateUpload deletes an upload and what was received
+//
+// DELETE /uploads/:id
+func (a *API) terminateUpload(res http.ResponseWriter, req *http.Request) {
+	upload, err := a.uploadByID(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	if err := a.ups.Terminate(upload); err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// uploadByID looks up the upload in the "id" route variable,
+// which has to be one of the current user
+func (a *API) uploadByID(req *http.Request) (*models.Upload, error) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		return nil, errUploadNotFound
+	}
+
+	upload, err := a.ups.ByID(id)
+	if err == models.ErrNotFound {
+		return nil, errUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user := context.User(req.Context()); user == nil || upload.UserID != user.ID {
+		return nil, errUploadNotFound
+	}
+
+	return upload, nil
+}
+
+// writeUploadHeaders sets the headers describing
+// the progress of upload
+func writeUploadHeaders(res http.ResponseWriter, upload *models.Upload) {
+	res.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	res.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.ImageID != 0 {
+		res.Header().Set("Upload-Image-ID", strconv.FormatUint(uint64(upload.ImageID), 10))
+	}
+}
+
+// parseUploadMetadata decodes an Upload-Metadata header, a
+// comma separated list of keys each followed by a space and
+// their base64 value, which may be left out for empty values
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, errUploadMetadata
+		}
+
+		key := fields[0]
+		if _, ok := meta[key]; ok {
+			return nil, errUploadMetadata
+		}
+
+		meta[key] = ""
+		if len(fields) == 2 {
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, errUploadMetadata
+			}
+			meta[key] = string(value)
+		}
+	}
+
+	return meta, nil
+}
+
+// encodeUploadMetadata reverses parseUploadMetadata
+func encodeUploadMetadata(meta map[string]string) string {
+	pairs := make([]string, 0, len(meta))
+	for key, value := range meta {
+		if value == "" {
+			pairs = append(pairs, key)
+			continue
+		}
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
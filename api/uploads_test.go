@@ -0,0 +1,221 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"../../photofriends/models"
+	"../context"
+	"github.com/gorilla/mux"
+)
+
+// memUploads is an in-memory UploadService, where
+// the image is created as soon as every byte arrived
+type memUploads struct {
+	models.UploadService
+	uploads map[uint]*models.Upload
+}
+
+func (m *memUploads) Create(upload *models.Upload) error {
+	upload.ID = uint(len(m.uploads) + 1)
+	upload.ExpiresAt = time.Now().Add(models.UploadLifetime)
+	m.uploads[upload.ID] = upload
+	return nil
+}
+
+func (m *memUploads) ByID(id uint) (*models.Upload, error) {
+	upload, ok := m.uploads[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+
+	return upload, nil
+}
+
+func (m *memUploads) Write(upload *models.Upload, offset int64, r io.Reader) error {
+	if offset != upload.Offset {
+		return models.ErrUploadOffsetMismatch
+	}
+
+	b, _ := ioutil.ReadAll(r)
+	upload.Offset += int64(len(b))
+	if upload.Offset > upload.Length {
+		upload.Offset -= int64(len(b))
+		return models.ErrUploadTooLarge
+	}
+	if upload.Complete() {
+		upload.ImageID = 7
+	}
+
+	return nil
+}
+
+func (m *memUploads) Terminate(upload *models.Upload) error {
+	delete(m.uploads, upload.ID)
+	return nil
+}
+
+func testingUploads() *mux.Router {
+	gs := &memGalleries{}
+	gs.Create(&models.Gallery{UserID: 1, Title: "Holiday"})
+
+	a := &API{gs: gs, ups: &memUploads{uploads: make(map[uint]*models.Upload)}}
+	r := mux.NewRouter()
+	a.Register(r, contextAuth{})
+	return r
+}
+
+// tus makes a request of the tus protocol as user, with
+// the headers given as pairs of name and value
+func tus(r *mux.Router, user *models.User, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for i := 0; i < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	if user != nil {
+		req = req.WithContext(context.WithUser(req.Context(), user))
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadsProtocol(t *testing.T) {
+	r := testingUploads()
+	jane := testingUser(1, true)
+	jon := testingUser(2, true)
+
+	rec := tus(r, nil, "OPTIONS", "/uploads", "")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Version") != tusVersion || !strings.Contains(rec.Header().Get("Tus-Extension"), "termination") {
+		t.Errorf("Expected the protocol to be described. Recieved %d %v", rec.Code, rec.Header())
+	}
+	if rec.Header().Get("Upload-Max-Chunk-Size") != strconv.Itoa(models.MaxChunkLength) {
+		t.Errorf("Expected the largest chunk to be advertised. Recieved %v", rec.Header())
+	}
+
+	// gallery_id 1, filename beach.jpg
+	meta := "gallery_id MQ==,filename YmVhY2guanBn"
+	rec = tus(r, jane, "POST", "/uploads", "", "Upload-Length", "10", "Upload-Metadata", meta)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Location") != "/uploads/1" || rec.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("Expected the upload to be created. Recieved %d %v", rec.Code, rec.Header())
+	}
+
+	chunk := []string{"Content-Type", chunkContentType, "Upload-Offset"}
+	cases := []struct {
+		user    *models.User
+		method  string
+		body    string
+		headers []string
+		status  int
+		offset  string
+	}{
+		{jane, "PATCH", "hello", append(chunk, "0"), http.StatusNoContent, "5"},
+		{jane, "HEAD", "", nil, http.StatusOK, "5"},
+		{jane, "PATCH", "again", append(chunk, "0"), http.StatusConflict, ""},
+		{jane, "PATCH", "world", []string{"Content-Type", "text/plain", "Upload-Offset", "5"}, http.StatusUnsupportedMediaType, ""},
+		{jon, "HEAD", "", nil, http.StatusNotFound, ""},
+		{jon, "PATCH", "world", append(chunk, "5"), http.StatusNotFound, ""},
+		{nil, "HEAD", "", nil, http.StatusUnauthorized, ""},
+		{jane, "PATCH", "world!", append(chunk, "5"), http.StatusRequestEntityTooLarge, ""},
+		{jane, "PATCH", "world", append(chunk, "5"), http.StatusNoContent, "10"},
+	}
+
+	for _, c := range cases {
+		rec := tus(r, c.user, c.method, "/uploads/1", c.body, c.headers...)
+		if rec.Code != c.status || rec.Header().Get("Upload-Offset") != c.offset {
+			t.Errorf("%s %v: Expected %d at offset %q. Recieved %d at %q", c.method, c.headers, c.status, c.offset, rec.Code, rec.Header().Get("Upload-Offset"))
+		}
+		if rec.Header().Get("Tus-Resumable") != tusVersion {
+			t.Errorf("%s %v: Expected the Tus-Resumable header", c.method, c.headers)
+		}
+	}
+
+	rec = tus(r, jane, "HEAD", "/uploads/1", "")
+	if rec.Header().Get("Upload-Image-ID") != "7" || rec.Header().Get("Upload-Length") != "10" {
+		t.Errorf("Expected the upload to be complete. Recieved %v", rec.Header())
+	}
+
+	if rec := tus(r, jane, "DELETE", "/uploads/1", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204. Recieved %d", rec.Code)
+	}
+	if rec := tus(r, jane, "HEAD", "/uploads/1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected the upload to be terminated. Recieved %d", rec.Code)
+	}
+}
+
+func TestUploadsChunkTooLarge(t *testing.T) {
+	r := testingUploads()
+	req := httptest.NewRequest("PATCH", "/uploads/1", strings.NewReader(""))
+	req.ContentLength = models.MaxChunkLength + 1
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", chunkContentType)
+	req.Header.Set("Upload-Offset", "0")
+	req = req.WithContext(context.WithUser(req.Context(), testingUser(1, true)))
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected %d. Recieved %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
+
+func TestUploadsCreate(t *testing.T) {
+	r := testingUploads()
+	jane := testingUser(1, true)
+	jon := testingUser(2, true)
+
+	cases := []struct {
+		user    *models.User
+		headers []string
+		status  int
+	}{
+		{jane, []string{"Upload-Metadata", "gallery_id MQ=="}, http.StatusBadRequest},
+		{jane, []string{"Upload-Length", "1073741824", "Upload-Metadata", "gallery_id MQ=="}, http.StatusRequestEntityTooLarge},
+		{jane, []string{"Upload-Length", "10", "Upload-Metadata", "gallery_id !!"}, http.StatusBadRequest},
+		{jane, []string{"Upload-Length", "10", "Upload-Metadata", "gallery_id OQ=="}, http.StatusNotFound},
+		{jon, []string{"Upload-Length", "10", "Upload-Metadata", "gallery_id MQ=="}, http.StatusForbidden},
+		{jane, []string{"Upload-Length", "10", "Upload-Metadata", "gallery_id MQ==", "Tus-Resumable", "0.2.2"}, http.StatusPreconditionFailed},
+	}
+
+	for _, c := range cases {
+		if rec := tus(r, c.user, "POST", "/uploads", "", c.headers...); rec.Code != c.status {
+			t.Errorf("%v: Expected %d. Recieved %d", c.headers, c.status, rec.Code)
+		}
+	}
+
+	// creation with upload
+	rec := tus(r, jane, "POST", "/uploads", "hello", "Upload-Length", "10",
+		"Upload-Metadata", "gallery_id MQ==,filename YS5qcGc=", "Content-Type", chunkContentType)
+	if rec.Code != http.StatusCreated || rec.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("Expected the first chunk to be stored. Recieved %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestUploadMetadata(t *testing.T) {
+	meta, err := parseUploadMetadata("filename YmVhY2guanBn, gallery_id MQ==,is_confidential")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta["filename"] != "beach.jpg" || meta["gallery_id"] != "1" || len(meta) != 3 {
+		t.Errorf("Expected the metadata to be decoded. Recieved %v", meta)
+	}
+
+	if encoded := encodeUploadMetadata(meta); encoded != "filename YmVhY2guanBn,gallery_id MQ==,is_confidential" {
+		t.Errorf("Expected the metadata to be encoded. Recieved %q", encoded)
+	}
+
+	for _, header := range []string{"a MQ==,a Mg==", "a b c", "a !!", "a MQ==,,b Mg=="} {
+		if _, err := parseUploadMetadata(header); err == nil {
+			t.Errorf("Expected an error for %q", header)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"../../photofriends/models"
+	"../context"
+)
+
+// me shows the current user
+//
+// GET /api/v1/me
+func (a *API) me(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	WriteData(res, http.StatusOK, NewUser(user, true))
+}
+
+// user shows the name of any user, the way it
+// is shown to their friends
+//
+// GET /api/v1/users/:id
+func (a *API) user(res http.ResponseWriter, req *http.Request) {
+	other, err := a.userByID(req)
+	if err != nil {
+		WriteError(res, err)
+		return
+	}
+
+	self := context.User(req.Context()).ID == other.ID
+	WriteData(res, http.StatusOK, NewUser(other, self))
+}
+
+// userByID looks up the user in the "id" route variable
+func (a *API) userByID(req *http.Request) (*models.User, error) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		return nil, errUserNotFound
+	}
+
+	user, err := a.us.ByID(id)
+	if err == models.ErrNotFound {
+		return nil, errUserNotFound
+	}
+
+	return user, err
+}
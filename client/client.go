@@ -0,0 +1,350 @@
+// Package client calls the PhotoFriends JSON API with a
+// personal API token. It has a method for every operation
+// of the OpenAPI document served at /api/v1/openapi.json,
+// named after its operationId.
+//
+// The resources are declared here again rather than taken
+// from package api, so programs using the client do not
+// pull in the models and their database drivers. Tests
+// keep both in sync with the document
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client calls the API at BaseURL, such as
+// https://photofriends.example.com/api/v1
+type Client struct {
+	BaseURL string
+	token   string
+	http    *http.Client
+}
+
+// New creates a client sending token with every request.
+// httpClient may be nil to use http.DefaultClient.
+//
+// Tokens can not be used to act towards other users, such
+// as RequestFriend or BlockUser. Those need an empty token
+// and a httpClient with a Jar holding a session cookie
+func New(baseURL, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		http:    httpClient,
+	}
+}
+
+// Error is an error responded by the API. Fields
+// holds the invalid fields of the request, if any
+type Error struct {
+	Status  int               `json:"-"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("photofriends: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// ListOptions picks a page of a list. Cursor is the next
+// cursor of the previous page, empty for the first page,
+// and Limit 0 uses the default of the API
+type ListOptions struct {
+	Cursor string
+	Limit  int
+}
+
+func (o ListOptions) query() url.Values {
+	q := url.Values{}
+	if o.Cursor != "" {
+		q.Set("cursor", o.Cursor)
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+
+	return q
+}
+
+// envelope is the body of successful responses,
+// NextCursor is only set on pages of lists
+type envelope struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+// do makes a request with body encoded as JSON, unless it
+// is nil, and decodes the data of the response into dst,
+// unless it is nil. It returns the cursor of the next page
+func (c *Client) do(method, path string, query url.Values, body, dst interface{}) (string, error) {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+		reader = bytes.NewReader(b)
+		contentType = "application/json"
+	}
+
+	return c.send(method, path, query, contentType, reader, dst)
+}
+
+// send makes a request with a body of contentType,
+// see do
+func (c *Client) send(method, path string, query url.Values, contentType string, body io.Reader, dst interface{}) (string, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		var e struct {
+			Error *Error `json:"error"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Error == nil {
+			return "", &Error{Status: res.StatusCode, Code: "unknown", Message: http.StatusText(res.StatusCode)}
+		}
+
+		e.Error.Status = res.StatusCode
+		return "", e.Error
+	}
+
+	if dst == nil || res.StatusCode == http.StatusNoContent {
+		return "", nil
+	}
+
+	env := envelope{Data: dst}
+	if err := json.NewDecoder(res.Body).Decode(&env); err != nil {
+		return "", err
+	}
+
+	return env.NextCursor, nil
+}
+
+// shareQuery passes the share token of an unlisted
+// gallery, if any
+func shareQuery(share string) url.Values {
+	if share == "" {
+		return nil
+	}
+
+	return url.Values{"share": {share}}
+}
+
+// GetMe returns the user the token belongs to
+func (c *Client) GetMe() (*User, error) {
+	var user User
+	if _, err := c.do("GET", "/me", nil, nil, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUser returns the user with id
+func (c *Client) GetUser(id uint) (*User, error) {
+	var user User
+	if _, err := c.do("GET", fmt.Sprintf("/users/%d", id), nil, nil, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Unfriend ends the friendship with the user with id,
+// cancels the request sent to them or unblocks them
+func (c *Client) Unfriend(id uint) error {
+	_, err := c.do("DELETE", fmt.Sprintf("/users/%d/friendship", id), nil, nil, nil)
+	return err
+}
+
+// BlockUser blocks the user with id
+func (c *Client) BlockUser(id uint) error {
+	_, err := c.do("POST", fmt.Sprintf("/users/%d/block", id), nil, nil, nil)
+	return err
+}
+
+// ListGalleries returns a page of the galleries of the
+// user, newest first, and the cursor of the next page
+func (c *Client) ListGalleries(opts ListOptions) ([]Gallery, string, error) {
+	var galleries []Gallery
+	next, err := c.do("GET", "/galleries", opts.query(), nil, &galleries)
+	return galleries, next, err
+}
+
+// CreateGallery creates a gallery
+func (c *Client) CreateGallery(body GalleryBody) (*Gallery, error) {
+	var gallery Gallery
+	if _, err := c.do("POST", "/galleries", nil, body, &gallery); err != nil {
+		return nil, err
+	}
+
+	return &gallery, nil
+}
+
+// GetGallery returns the gallery with id. share is the
+// share token of an unlisted gallery, or empty
+func (c *Client) GetGallery(id uint, share string) (*Gallery, error) {
+	var gallery Gallery
+	if _, err := c.do("GET", fmt.Sprintf("/galleries/%d", id), shareQuery(share), nil, &gallery); err != nil {
+		return nil, err
+	}
+
+	return &gallery, nil
+}
+
+// UpdateGallery changes the fields set in patch
+func (c *Client) UpdateGallery(id uint, patch GalleryPatch) (*Gallery, error) {
+	var gallery Gallery
+	if _, err := c.do("PATCH", fmt.Sprintf("/galleries/%d", id), nil, patch, &gallery); err != nil {
+		return nil, err
+	}
+
+	return &gallery, nil
+}
+
+// DeleteGallery deletes the gallery with id and its images
+func (c *Client) DeleteGallery(id uint) error {
+	_, err := c.do("DELETE", fmt.Sprintf("/galleries/%d", id), nil, nil, nil)
+	return err
+}
+
+// ListImages returns a page of the images of a gallery,
+// oldest first, and the cursor of the next page
+func (c *Client) ListImages(galleryID uint, share string, opts ListOptions) ([]Image, string, error) {
+	q := opts.query()
+	if share != "" {
+		q.Set("share", share)
+	}
+
+	var images []Image
+	next, err := c.do("GET", fmt.Sprintf("/galleries/%d/images", galleryID), q, nil, &images)
+	return images, next, err
+}
+
+// File is an image to upload
+type File struct {
+	Name    string
+	Content io.Reader
+}
+
+// UploadImages uploads files to a gallery. With strip
+// set private EXIF data is removed from them
+func (c *Client) UploadImages(galleryID uint, files []File, strip bool) ([]Image, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, f := range files {
+		part, err := mw.CreateFormFile("images", f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(part, f.Content); err != nil {
+			return nil, err
+		}
+	}
+	if strip {
+		mw.WriteField("strip_metadata", "on")
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var images []Image
+	_, err := c.send("POST", fmt.Sprintf("/galleries/%d/images", galleryID), nil, mw.FormDataContentType(), &body, &images)
+	return images, err
+}
+
+// UploadImage uploads a single image to a gallery
+func (c *Client) UploadImage(galleryID uint, filename string, content io.Reader) (*Image, error) {
+	images, err := c.UploadImages(galleryID, []File{{Name: filename, Content: content}}, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("photofriends: no image was uploaded")
+	}
+
+	return &images[0], nil
+}
+
+// GetImage returns an image of a gallery. share is the
+// share token of an unlisted gallery, or empty
+func (c *Client) GetImage(galleryID, imageID uint, share string) (*Image, error) {
+	var image Image
+	if _, err := c.do("GET", fmt.Sprintf("/galleries/%d/images/%d", galleryID, imageID), shareQuery(share), nil, &image); err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+// DeleteImage deletes an image of a gallery
+func (c *Client) DeleteImage(galleryID, imageID uint) error {
+	_, err := c.do("DELETE", fmt.Sprintf("/galleries/%d/images/%d", galleryID, imageID), nil, nil, nil)
+	return err
+}
+
+// ListFriendships returns a page of the friendships of
+// the user, newest first, and the cursor of the next page
+func (c *Client) ListFriendships(opts ListOptions) ([]Friendship, string, error) {
+	var friendships []Friendship
+	next, err := c.do("GET", "/friendships", opts.query(), nil, &friendships)
+	return friendships, next, err
+}
+
+// RequestFriend sends a friend request, or accepts
+// the one the other user sent. It needs a session
+func (c *Client) RequestFriend(body FriendRequestBody) (*Friendship, error) {
+	var friendship Friendship
+	if _, err := c.do("POST", "/friendships", nil, body, &friendship); err != nil {
+		return nil, err
+	}
+
+	return &friendship, nil
+}
+
+// AcceptFriendship accepts the friend request with id
+func (c *Client) AcceptFriendship(id uint) error {
+	_, err := c.do("POST", fmt.Sprintf("/friendships/%d/accept", id), nil, nil, nil)
+	return err
+}
+
+// DeclineFriendship declines the friend request with id
+func (c *Client) DeclineFriendship(id uint) error {
+	_, err := c.do("POST", fmt.Sprintf("/friendships/%d/decline", id), nil, nil, nil)
+	return err
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"../../photofriends/api"
+)
+
+// recorder answers every request with an empty data
+// envelope, and keeps the last one
+type recorder struct {
+	method string
+	path   string
+}
+
+func (r *recorder) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	r.method, r.path = req.Method, req.URL.Path
+	res.Header().Set("Content-Type", "application/json")
+	io.WriteString(res, `{"data": null}`)
+}
+
+// TestOperations calls every method named after an operation
+// of the OpenAPI document, and checks it requests its path
+func TestOperations(t *testing.T) {
+	doc := (&api.API{}).OpenAPI()
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	c := New(srv.URL+api.Prefix, "pf_test", nil)
+	cv := reflect.ValueOf(c)
+	for path, item := range doc.Paths {
+		pattern := regexp.MustCompile("^" + api.Prefix + regexp.MustCompile(`\{[a-zA-Z]+\}`).ReplaceAllString(path, "[0-9]+") + "$")
+		for method, op := range item {
+			m := cv.MethodByName(op.OperationID)
+			if !m.IsValid() {
+				t.Errorf("Expected a Client method for %s %s. Recieved none named %s", method, path, op.OperationID)
+				continue
+			}
+
+			// uploads need an image, and the others
+			// do fine with zero values
+			args := make([]reflect.Value, m.Type().NumIn())
+			for i := range args {
+				args[i] = reflect.Zero(m.Type().In(i))
+			}
+			if op.OperationID == "UploadImages" {
+				args[1] = reflect.ValueOf([]File{{Name: "a.png", Content: strings.NewReader("png")}})
+			}
+
+			rec.method, rec.path = "", ""
+			out := m.Call(args)
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				t.Errorf("%s: %v", op.OperationID, err)
+			}
+
+			if rec.method != strings.ToUpper(method) || !pattern.MatchString(rec.path) {
+				t.Errorf("%s: Expected %s %s. Recieved %s %s", op.OperationID, strings.ToUpper(method), path, rec.method, rec.path)
+			}
+		}
+	}
+}
+
+// fields returns the JSON names of the fields of struct
+// type t, and the ones that are always encoded
+func fields(t reflect.Type) (names, required []string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		names = append(names, name)
+		if !strings.Contains(tag, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(required)
+
+	return names, required
+}
+
+// TestSchemas checks the resources of the client match the
+// schemas of the OpenAPI document of the same name
+func TestSchemas(t *testing.T) {
+	doc := (&api.API{}).OpenAPI()
+	types := []interface{}{
+		User{}, Gallery{}, Image{}, ImageVariant{}, Duplicate{}, Friendship{}, Error{},
+		GalleryBody{}, GalleryPatch{}, FriendRequestBody{},
+	}
+
+	seen := map[string]bool{"ErrorEnvelope": true}
+	for _, v := range types {
+		typ := reflect.TypeOf(v)
+		seen[typ.Name()] = true
+		schema, ok := doc.Components.Schemas[typ.Name()]
+		if !ok {
+			t.Errorf("Expected a schema named %s", typ.Name())
+			continue
+		}
+
+		var names []string
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		gotNames, gotRequired := fields(typ)
+		if !reflect.DeepEqual(gotNames, names) || strings.Join(gotRequired, ",") != strings.Join(schema.Required, ",") {
+			t.Errorf("%s: Expected fields %v, %v required. Recieved %v, %v required", typ.Name(), names, schema.Required, gotNames, gotRequired)
+		}
+	}
+
+	for name := range doc.Components.Schemas {
+		if !seen[name] {
+			t.Errorf("Expected a client type for the %s schema", name)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	var auth, contentType string
+	var posted map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		auth, contentType = req.Header.Get("Authorization"), req.Header.Get("Content-Type")
+		res.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/api/v1/galleries":
+			if req.Method == "POST" {
+				posted = nil
+				json.NewDecoder(req.Body).Decode(&posted)
+				res.WriteHeader(http.StatusUnprocessableEntity)
+				io.WriteString(res, `{"error": {"code": "invalid", "message": "Invalid", "fields": {"title": "Title is required"}}}`)
+				return
+			}
+
+			io.WriteString(res, `{"data": [{"id": 2, "title": "Two"}], "next_cursor": "Mg"}`)
+		case "/api/v1/galleries/2/images":
+			req.ParseMultipartForm(1 << 20)
+			if len(req.MultipartForm.File["images"]) != 1 {
+				res.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			res.WriteHeader(http.StatusCreated)
+			io.WriteString(res, `{"data": [{"id": 7, "filename": "`+req.MultipartForm.File["images"][0].Filename+`"}]}`)
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL+"/api/v1/", "pf_test", nil)
+	galleries, next, err := c.ListGalleries(ListOptions{Limit: 1})
+	if err != nil || len(galleries) != 1 || galleries[0].Title != "Two" || next != "Mg" {
+		t.Errorf("Expected a page of galleries. Recieved %+v %q %v", galleries, next, err)
+	}
+	if auth != "Bearer pf_test" {
+		t.Errorf("Expected the token to be sent. Recieved %q", auth)
+	}
+
+	_, err = c.CreateGallery(GalleryBody{Title: ""})
+	e, ok := err.(*Error)
+	if !ok || e.Status != http.StatusUnprocessableEntity || e.Fields["title"] == "" {
+		t.Errorf("Expected the field errors. Recieved %#v", err)
+	}
+	if _, ok := posted["title"]; !ok || contentType != "application/json" {
+		t.Errorf("Expected the body to be sent as JSON. Recieved %q %v", contentType, posted)
+	}
+
+	image, err := c.UploadImage(2, "beach.jpg", strings.NewReader("jpeg"))
+	if err != nil || image.ID != 7 || image.Filename != "beach.jpg" {
+		t.Errorf("Expected the image to be uploaded. Recieved %+v %v", image, err)
+	}
+
+	if _, err := c.GetGallery(9, ""); err == nil || err.(*Error).Status != http.StatusNotFound {
+		t.Errorf("Expected an error for a response without an envelope. Recieved %v", err)
+	}
+}
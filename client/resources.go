@@ -0,0 +1,93 @@
+package client
+
+import "time"
+
+// User is a user. Email and Verified are
+// only set for the user the token belongs to
+type User struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Email    string `json:"email,omitempty"`
+	Verified *bool  `json:"verified,omitempty"`
+}
+
+// Gallery is a gallery. ShareToken is only
+// set for galleries of the user
+type Gallery struct {
+	ID         uint      `json:"id"`
+	UserID     uint      `json:"user_id"`
+	Title      string    `json:"title"`
+	Visibility string    `json:"visibility"`
+	ShareToken string    `json:"share_token,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Images     []Image   `json:"images,omitempty"`
+}
+
+// Image is an uploaded image. Width and Height are
+// 0 until the derived sizes have been generated.
+// Duplicates are only listed right after an upload, and
+// for the owner of a single image
+type Image struct {
+	ID           uint           `json:"id"`
+	GalleryID    uint           `json:"gallery_id"`
+	Filename     string         `json:"filename"`
+	ContentType  string         `json:"content_type"`
+	Size         int64          `json:"size"`
+	Width        int            `json:"width"`
+	Height       int            `json:"height"`
+	URL          string         `json:"url"`
+	ThumbnailURL string         `json:"thumbnail_url"`
+	Variants     []ImageVariant `json:"variants"`
+	CreatedAt    time.Time      `json:"created_at"`
+	Duplicates   []Duplicate    `json:"duplicates,omitempty"`
+}
+
+// ImageVariant is one of the derived sizes of an Image
+type ImageVariant struct {
+	Name  string `json:"name"`
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+}
+
+// Duplicate is an image of the same user that looks like an
+// uploaded one. Distance is the number of bits their
+// perceptual hashes differ in, 0 when they look the same
+type Duplicate struct {
+	ImageID      uint   `json:"image_id"`
+	GalleryID    uint   `json:"gallery_id"`
+	Filename     string `json:"filename"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	Distance     int    `json:"distance"`
+}
+
+// Friendship is a friendship with User. Direction is
+// "outgoing" when the user of the token sent the request
+// or did the blocking, "incoming" otherwise
+type Friendship struct {
+	ID        uint      `json:"id"`
+	User      User      `json:"user"`
+	Status    string    `json:"status"`
+	Direction string    `json:"direction"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GalleryBody creates a gallery. Visibility is one of
+// "private", "friends", "unlisted" or "public"
+type GalleryBody struct {
+	Title      string `json:"title"`
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// GalleryPatch updates the fields of a gallery that are set
+type GalleryPatch struct {
+	Title      *string `json:"title,omitempty"`
+	Visibility *string `json:"visibility,omitempty"`
+}
+
+// FriendRequestBody sends a friend request
+// to a user by either their ID or email
+type FriendRequestBody struct {
+	UserID uint   `json:"user_id,omitempty"`
+	Email  string `json:"email,omitempty"`
+}
@@ -0,0 +1,432 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"../../photofriends/email"
+	"../../photofriends/oidc"
+	"../../photofriends/ratelimit"
+	"../../photofriends/storage"
+	"../../photofriends/webauthn"
+	"github.com/BurntSushi/toml"
+)
+
+// Profiles the app can run with. Dev is the default
+const (
+	EnvDev  = "dev"
+	EnvProd = "prod"
+)
+
+// Default secrets. They are fine on a laptop, but anyone
+// who has read the source knows them, so prod refuses them
+const (
+	DefaultPepper  = "secret-random-string"
+	DefaultHMACKey = "secrey-hmac-key"
+)
+
+// PostgresConfig describes how to connect to the database
+type PostgresConfig struct {
+	Host     string `json:"host" toml:"host"`
+	Port     int    `json:"port" toml:"port"`
+	User     string `json:"user" toml:"user"`
+	Password string `json:"password" toml:"password"`
+	Name     string `json:"name" toml:"name"`
+	SSLMode  string `json:"sslmode" toml:"sslmode"`
+
+	// LogSQL prints every query that is run
+	LogSQL bool `json:"log_sql" toml:"log_sql"`
+}
+
+// ConnectionInfo returns the connection string for lib/pq
+func (c PostgresConfig) ConnectionInfo() string {
+	info := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s",
+		c.Host, c.Port, c.User, c.Name, c.SSLMode)
+	if c.Password != "" {
+		info += " password=" + c.Password
+	}
+
+	return info
+}
+
+// Config is everything main needs to start the app
+type Config struct {
+	// Env is the profile, either EnvDev or EnvProd. It picks
+	// the defaults, so it can only be set with the -env flag
+	// or the PHOTOFRIENDS_ENV variable, not in the file
+	Env string `json:"-" toml:"-"`
+
+	// Port is the HTTP port to listen on
+	Port int `json:"port" toml:"port"`
+
+	// BaseURL is where users reach the app, eg:
+	// "https://photofriends.example". Links sent by email
+	// are built from it, never from the Host header of the
+	// request, which is up to the client
+	BaseURL string `json:"base_url" toml:"base_url"`
+
+	// Pepper is appended to passwords before hashing, and
+	// HMACKey is used to hash tokens. Changing the pepper
+	// locks every user out until they reset their password
+	Pepper  string `json:"pepper" toml:"pepper"`
+	HMACKey string `json:"hmac_key" toml:"hmac_key"`
+
+	Database PostgresConfig `json:"database" toml:"database"`
+	Storage  storage.Config `json:"storage" toml:"storage"`
+	Email    email.Config   `json:"email" toml:"email"`
+
+	// RateLimit picks where failed logins are counted
+	RateLimit ratelimit.Config `json:"rate_limit" toml:"rate_limit"`
+
+	// WebAuthn is the site passkeys are bound to. Passkeys
+	// stop working when the RP ID is changed
+	WebAuthn webauthn.Config `json:"webauthn" toml:"webauthn"`
+
+	// OIDC lists the OpenID Connect providers users can sign
+	// in with. None are configured by default
+	OIDC []oidc.Config `json:"oidc" toml:"oidc"`
+
+	// ResetDB rolls back every migration on start, wiping the
+	// data. It can only be set with the -reset-db flag, and
+	// only in dev
+	ResetDB bool `json:"-" toml:"-"`
+
+	// Command holds the arguments left after the flags. When
+	// empty the server is started, otherwise it names a
+	// subcommand like "migrate up"
+	Command []string `json:"-" toml:"-"`
+}
+
+// IsProd reports whether the prod profile is in use
+func (c Config) IsProd() bool {
+	return c.Env == EnvProd
+}
+
+// Default returns the defaults of the env profile
+func Default(env string) (Config, error) {
+	cfg := Config{
+		Env:     env,
+		Port:    3000,
+		BaseURL: "http://localhost:3000",
+		Pepper:  DefaultPepper,
+		HMACKey: DefaultHMACKey,
+		Database: PostgresConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "postgres",
+			Password: "postgres",
+			Name:     "photofriends_dev",
+			SSLMode:  "disable",
+			LogSQL:   true,
+		},
+		Storage:   storage.DefaultConfig(),
+		Email:     email.DefaultConfig(),
+		RateLimit: ratelimit.DefaultConfig(),
+		WebAuthn:  webauthn.DefaultConfig(),
+	}
+
+	switch env {
+	case EnvDev:
+	case EnvProd:
+		cfg.Database.Name = "photofriends"
+		cfg.Database.SSLMode = "require"
+		cfg.Database.LogSQL = false
+		cfg.Email.Backend = "smtp"
+		cfg.Email.SMTP = email.SMTPConfig{Host: "localhost", Port: 25}
+		cfg.RateLimit.Backend = "postgres"
+	default:
+		return Config{}, fmt.Errorf("config: unknown env %q, use %q or %q", env, EnvDev, EnvProd)
+	}
+
+	return cfg, nil
+}
+
+// Load builds the config from the command line arguments
+// (without the program name). Later sources win:
+//
+//	1 - the defaults of the profile picked by -env or PHOTOFRIENDS_ENV
+//	2 - the JSON or TOML file given by -config or PHOTOFRIENDS_CONFIG
+//	3 - environment variables, see envVars
+//	4 - flags
+//
+// The result is validated, so in prod it is an error
+// to still use any of the default secrets
+func Load(args []string) (Config, error) {
+	return load(args, os.LookupEnv)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (Config, error) {
+	fs := flag.NewFlagSet("photofriends", flag.ContinueOnError)
+	env := fs.String("env", "", "profile to run with, dev or prod (env PHOTOFRIENDS_ENV)")
+	file := fs.String("config", "", "JSON or TOML config file (env PHOTOFRIENDS_CONFIG)")
+	resetDB := fs.Bool("reset-db", false, "roll back every migration and migrate up again on start, dev only")
+	for _, f := range flagVars {
+		fs.String(f.name, "", f.usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if *env == "" {
+		*env, _ = lookupEnv("PHOTOFRIENDS_ENV")
+	}
+	if *env == "" {
+		*env = EnvDev
+	}
+
+	cfg, err := Default(*env)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if *file == "" {
+		*file, _ = lookupEnv("PHOTOFRIENDS_CONFIG")
+	}
+	if *file != "" {
+		if err := loadFile(*file, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, v := range envVars {
+		raw, ok := lookupEnv(v.name)
+		if !ok {
+			continue
+		}
+
+		if err := setValue(v.dst(&cfg), raw); err != nil {
+			return Config{}, fmt.Errorf("config: %s: %v", v.name, err)
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, v := range flagVars {
+			if v.name == f.Name && err == nil {
+				if err = setValue(v.dst(&cfg), f.Value.String()); err != nil {
+					err = fmt.Errorf("config: -%s: %v", f.Name, err)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg.ResetDB = *resetDB
+	cfg.Command = fs.Args()
+	return cfg, cfg.Validate()
+}
+
+// loadFile decodes the file over cfg, so keys missing in
+// the file keep their current value. Unknown keys are an
+// error, as they are most likely typos
+func loadFile(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), cfg)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown keys %v", md.Undecoded())
+		}
+	default:
+		err = errors.New("the file must end in .json or .toml")
+	}
+
+	if err != nil {
+		return fmt.Errorf("config: %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks the config makes sense, and in prod
+// that none of the secrets are left at their defaults
+func (c Config) Validate() error {
+	var problems []string
+	if c.Port <= 0 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port %d is out of range", c.Port))
+	}
+
+	if u, err := url.Parse(c.BaseURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		problems = append(problems, fmt.Sprintf("base_url %q is not a URL", c.BaseURL))
+	}
+
+	if c.Pepper == "" {
+		problems = append(problems, "pepper is required")
+	}
+
+	if c.HMACKey == "" {
+		problems = append(problems, "hmac_key is required")
+	}
+
+	if err := validateWebAuthn(c.WebAuthn); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	problems = append(problems, validateOIDC(c.OIDC, c.IsProd())...)
+
+	if c.IsProd() {
+		if c.Pepper == DefaultPepper {
+			problems = append(problems, "pepper is the default, set PHOTOFRIENDS_PEPPER")
+		}
+
+		if c.HMACKey == DefaultHMACKey {
+			problems = append(problems, "hmac_key is the default, set PHOTOFRIENDS_HMAC_KEY")
+		}
+
+		if c.Storage.Backend != "s3" && c.Storage.Secret == storage.DefaultConfig().Secret {
+			problems = append(problems, "storage.secret is the default, set STORAGE_SECRET")
+		}
+
+		if c.ResetDB {
+			problems = append(problems, "-reset-db can not be used in prod")
+		}
+
+		if !strings.HasPrefix(c.BaseURL, "https://") {
+			problems = append(problems, "base_url must use https, set PHOTOFRIENDS_BASE_URL")
+		}
+
+		if !strings.HasPrefix(c.WebAuthn.Origin, "https://") {
+			problems = append(problems, "webauthn.origin must use https, set WEBAUTHN_ORIGIN")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("config: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// validateWebAuthn checks the origin is on the domain of
+// the RP ID, as browsers refuse to create passkeys otherwise
+func validateWebAuthn(c webauthn.Config) error {
+	u, err := url.Parse(c.Origin)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("webauthn.origin %q is not a URL", c.Origin)
+	}
+
+	host := u.Hostname()
+	if c.RPID == "" || (host != c.RPID && !strings.HasSuffix(host, "."+c.RPID)) {
+		return fmt.Errorf("webauthn.rp_id %q does not match the host of webauthn.origin", c.RPID)
+	}
+
+	return nil
+}
+
+// validateOIDC checks every provider has what the flow
+// needs, and a name of its own to use in URLs
+func validateOIDC(providers []oidc.Config, prod bool) []string {
+	var problems []string
+	names := make(map[string]bool)
+	for i, p := range providers {
+		switch {
+		case p.Name == "":
+			problems = append(problems, fmt.Sprintf("oidc[%d].name is required", i))
+		case p.Name != url.PathEscape(p.Name):
+			problems = append(problems, fmt.Sprintf("oidc[%d].name %q can not be used in URLs", i, p.Name))
+		case names[p.Name]:
+			problems = append(problems, fmt.Sprintf("oidc[%d].name %q is used twice", i, p.Name))
+		}
+		names[p.Name] = true
+
+		if p.Issuer == "" || p.ClientID == "" {
+			problems = append(problems, fmt.Sprintf("oidc[%d] needs an issuer and client_id", i))
+		}
+
+		u, err := url.Parse(p.RedirectURL)
+		if err != nil || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("oidc[%d].redirect_url %q is not a URL", i, p.RedirectURL))
+		} else if prod && u.Scheme != "https" {
+			problems = append(problems, fmt.Sprintf("oidc[%d].redirect_url must use https", i))
+		}
+	}
+
+	return problems
+}
+
+// setting is a config value that can be
+// overridden by an environment variable or flag
+type setting struct {
+	name  string
+	usage string
+	dst   func(cfg *Config) interface{}
+}
+
+// envVars are the environment variables Load reads
+var envVars = []setting{
+	{name: "PORT", dst: func(c *Config) interface{} { return &c.Port }},
+	{name: "PHOTOFRIENDS_BASE_URL", dst: func(c *Config) interface{} { return &c.BaseURL }},
+	{name: "PHOTOFRIENDS_PEPPER", dst: func(c *Config) interface{} { return &c.Pepper }},
+	{name: "PHOTOFRIENDS_HMAC_KEY", dst: func(c *Config) interface{} { return &c.HMACKey }},
+	{name: "DATABASE_HOST", dst: func(c *Config) interface{} { return &c.Database.Host }},
+	{name: "DATABASE_PORT", dst: func(c *Config) interface{} { return &c.Database.Port }},
+	{name: "DATABASE_USER", dst: func(c *Config) interface{} { return &c.Database.User }},
+	{name: "DATABASE_PASSWORD", dst: func(c *Config) interface{} { return &c.Database.Password }},
+	{name: "DATABASE_NAME", dst: func(c *Config) interface{} { return &c.Database.Name }},
+	{name: "DATABASE_SSLMODE", dst: func(c *Config) interface{} { return &c.Database.SSLMode }},
+	{name: "STORAGE_BACKEND", dst: func(c *Config) interface{} { return &c.Storage.Backend }},
+	{name: "STORAGE_DIR", dst: func(c *Config) interface{} { return &c.Storage.Dir }},
+	{name: "STORAGE_SECRET", dst: func(c *Config) interface{} { return &c.Storage.Secret }},
+	{name: "S3_ENDPOINT", dst: func(c *Config) interface{} { return &c.Storage.S3.Endpoint }},
+	{name: "S3_REGION", dst: func(c *Config) interface{} { return &c.Storage.S3.Region }},
+	{name: "S3_BUCKET", dst: func(c *Config) interface{} { return &c.Storage.S3.Bucket }},
+	{name: "S3_ACCESS_KEY", dst: func(c *Config) interface{} { return &c.Storage.S3.AccessKey }},
+	{name: "S3_SECRET_KEY", dst: func(c *Config) interface{} { return &c.Storage.S3.SecretKey }},
+	{name: "MAIL_BACKEND", dst: func(c *Config) interface{} { return &c.Email.Backend }},
+	{name: "MAIL_FROM", dst: func(c *Config) interface{} { return &c.Email.From }},
+	{name: "SMTP_HOST", dst: func(c *Config) interface{} { return &c.Email.SMTP.Host }},
+	{name: "SMTP_PORT", dst: func(c *Config) interface{} { return &c.Email.SMTP.Port }},
+	{name: "SMTP_USERNAME", dst: func(c *Config) interface{} { return &c.Email.SMTP.Username }},
+	{name: "SMTP_PASSWORD", dst: func(c *Config) interface{} { return &c.Email.SMTP.Password }},
+	{name: "RATELIMIT_BACKEND", dst: func(c *Config) interface{} { return &c.RateLimit.Backend }},
+	{name: "WEBAUTHN_RP_ID", dst: func(c *Config) interface{} { return &c.WebAuthn.RPID }},
+	{name: "WEBAUTHN_ORIGIN", dst: func(c *Config) interface{} { return &c.WebAuthn.Origin }},
+}
+
+// flagVars are the flags Load accepts besides -env, -config and -reset-db
+var flagVars = []setting{
+	{name: "port", usage: "HTTP port to listen on", dst: func(c *Config) interface{} { return &c.Port }},
+	{name: "db-host", usage: "postgres host", dst: func(c *Config) interface{} { return &c.Database.Host }},
+	{name: "db-port", usage: "postgres port", dst: func(c *Config) interface{} { return &c.Database.Port }},
+	{name: "db-user", usage: "postgres user", dst: func(c *Config) interface{} { return &c.Database.User }},
+	{name: "db-password", usage: "postgres password", dst: func(c *Config) interface{} { return &c.Database.Password }},
+	{name: "db-name", usage: "postgres database name", dst: func(c *Config) interface{} { return &c.Database.Name }},
+}
+
+// setValue parses raw into dst, which is a *string or *int
+func setValue(dst interface{}, raw string) error {
+	switch dst := dst.(type) {
+	case *string:
+		*dst = raw
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		*dst = n
+	default:
+		return fmt.Errorf("unsupported type %T", dst)
+	}
+
+	return nil
+}
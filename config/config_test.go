@@ -0,0 +1,198 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// env returns a lookup func backed by the map
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "photofriends-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Env != EnvDev || cfg.Port != 3000 || cfg.Database.Name != "photofriends_dev" || cfg.ResetDB {
+		t.Errorf("Unexpected dev defaults %+v", cfg)
+	}
+
+	want := "host=localhost port=5432 user=postgres dbname=photofriends_dev sslmode=disable password=postgres"
+	if info := cfg.Database.ConnectionInfo(); info != want {
+		t.Errorf("Expected connection info %q. Recieved %q", want, info)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+port = 4000
+pepper = "file-pepper"
+
+[database]
+name = "from_file"
+host = "db.internal"
+
+[storage.s3]
+bucket = "photos"
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	cfg, err := load([]string{"-config", path, "-db-name", "from_flag"}, env(map[string]string{
+		"PORT":          "5000",
+		"DATABASE_NAME": "from_env",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != 5000 {
+		t.Errorf("Expected the env to override the file port. Recieved %d", cfg.Port)
+	}
+
+	if cfg.Database.Name != "from_flag" {
+		t.Errorf("Expected the flag to override the env. Recieved %q", cfg.Database.Name)
+	}
+
+	if cfg.Database.Host != "db.internal" || cfg.Pepper != "file-pepper" || cfg.Storage.S3.Bucket != "photos" {
+		t.Errorf("Expected values from the file. Recieved %+v", cfg)
+	}
+
+	if cfg.Database.User != "postgres" || cfg.HMACKey != DefaultHMACKey {
+		t.Errorf("Expected keys missing from the file to keep their defaults. Recieved %+v", cfg)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	path := writeFile(t, "config.json", `{"port": 8080, "email": {"backend": "smtp", "smtp": {"host": "mail", "port": 587}}}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	cfg, err := load([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != 8080 || cfg.Email.Backend != "smtp" || cfg.Email.SMTP.Port != 587 {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	for name, contents := range map[string]string{
+		"config.json": `{"prot": 8080}`,
+		"config.toml": "prot = 8080",
+	} {
+		path := writeFile(t, name, contents)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		if _, err := load([]string{"-config", path}, env(nil)); err == nil {
+			t.Errorf("%s: Expected an error for an unknown key", name)
+		}
+	}
+}
+
+func TestLoadProd(t *testing.T) {
+	_, err := load([]string{"-env", "prod"}, env(nil))
+	if err == nil {
+		t.Fatal("Expected prod to refuse the default secrets")
+	}
+
+	for _, key := range []string{"pepper", "hmac_key", "storage.secret", "webauthn.origin", "base_url"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Expected the error to mention %s. Recieved %v", key, err)
+		}
+	}
+
+	secrets := map[string]string{
+		"PHOTOFRIENDS_ENV":      "prod",
+		"PHOTOFRIENDS_PEPPER":   "a",
+		"PHOTOFRIENDS_HMAC_KEY": "b",
+		"STORAGE_SECRET":        "c",
+		"WEBAUTHN_RP_ID":        "photofriends.example",
+		"WEBAUTHN_ORIGIN":       "https://www.photofriends.example",
+		"PHOTOFRIENDS_BASE_URL": "https://www.photofriends.example",
+	}
+
+	cfg, err := load(nil, env(secrets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cfg.IsProd() || cfg.Database.SSLMode != "require" || cfg.Database.LogSQL || cfg.RateLimit.Backend != "postgres" {
+		t.Errorf("Unexpected prod defaults %+v", cfg)
+	}
+
+	if _, err := load([]string{"-reset-db"}, env(secrets)); err == nil {
+		t.Error("Expected -reset-db to be refused in prod")
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := load([]string{"-env", "staging"}, env(nil)); err == nil {
+		t.Error("Expected an error for an unknown env")
+	}
+
+	if _, err := load(nil, env(map[string]string{"PORT": "abc"})); err == nil {
+		t.Error("Expected an error for a port that is not a number")
+	}
+
+	if _, err := load(nil, env(map[string]string{"WEBAUTHN_RP_ID": "photofriends.example"})); err == nil {
+		t.Error("Expected an error for an origin outside the RP ID")
+	}
+
+	if _, err := load(nil, env(map[string]string{"PHOTOFRIENDS_BASE_URL": "photofriends.example"})); err == nil {
+		t.Error("Expected an error for a base URL without a scheme")
+	}
+}
+
+func TestLoadOIDC(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+[[oidc]]
+name = "google"
+issuer = "https://accounts.google.com"
+client_id = "client"
+redirect_url = "http://localhost:3000/auth/google/callback"
+
+[[oidc]]
+name = "google"
+issuer = "https://login.example"
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := load([]string{"-config", path}, env(nil))
+	if err == nil {
+		t.Fatal("Expected an error for providers missing settings")
+	}
+
+	for _, problem := range []string{`oidc[1].name "google" is used twice`, "oidc[1] needs an issuer and client_id", "oidc[1].redirect_url"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q in %v", problem, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "oidc[0]") {
+		t.Errorf("Expected the first provider to be valid. Recieved %v", err)
+	}
+}
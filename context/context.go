@@ -7,13 +7,16 @@ import (
 )
 
 const (
-	userKey privateKey = "user"
+	userKey    privateKey = "user"
+	sessionKey privateKey = "session"
+	csrfKey    privateKey = "csrf"
+	tokenKey   privateKey = "api_token"
 )
 
 type privateKey string
 
 func WithUser(ctx context.Context, user *models.User) context.Context {
-	return context.WithValue(ctx, user, user)
+	return context.WithValue(ctx, userKey, user)
 }
 
 func User(ctx context.Context) *models.User {
@@ -25,3 +28,53 @@ func User(ctx context.Context) *models.User {
 
 	return nil
 }
+
+// WithSession stores the session the current
+// request was authenticated with
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+// Session returns the session of the current request, or
+// nil if the request is not from a logged in user
+func Session(ctx context.Context) *models.Session {
+	if temp := ctx.Value(sessionKey); temp != nil {
+		if session, ok := temp.(*models.Session); ok {
+			return session
+		}
+	}
+
+	return nil
+}
+
+// WithCSRFToken stores the masked CSRF token
+// forms rendered for the request should post back
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfKey, token)
+}
+
+// CSRFToken returns the masked CSRF token of the request,
+// or an empty string if the CSRF middleware did not run
+func CSRFToken(ctx context.Context) string {
+	if token, ok := ctx.Value(csrfKey).(string); ok {
+		return token
+	}
+
+	return ""
+}
+
+// WithAPIToken stores the API token the current
+// request was authenticated with
+func WithAPIToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+// APIToken returns the API token of the current request, or
+// nil if the request was not made with a bearer token
+func APIToken(ctx context.Context) *models.APIToken {
+	if token, ok := ctx.Value(tokenKey).(*models.APIToken); ok {
+		return token
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"../../photofriends/models"
+	"../../photofriends/views"
+	"../context"
+)
+
+var errAPITokenNotFound = views.NewPublicError("API token not found")
+
+// apiTokenExpiries are the lifetimes in days users
+// can pick for a token, 0 never expires
+var apiTokenExpiries = []int{7, 30, 90, 365, 0}
+
+// NewAPITokens creates the controller to manage personal
+// API tokens. It panics if the templates can not be
+// parsed, so it should only be used on start
+func NewAPITokens(ts models.APITokenService) *APITokens {
+	return &APITokens{
+		IndexView: views.NewView("layout", "users/api_tokens"),
+		ts:        ts,
+	}
+}
+
+type APITokens struct {
+	IndexView *views.View
+	ts        models.APITokenService
+}
+
+// APITokenForm creates a token. ExpiresIn is the
+// lifetime in days, 0 for a token that never expires
+type APITokenForm struct {
+	Name      string   `schema:"name"`
+	Scopes    []string `schema:"scopes"`
+	ExpiresIn int      `schema:"expires_in"`
+}
+
+// apiTokensData is used to render the API tokens page.
+// Created is the token that was just created, the only
+// time its raw value can be shown
+type apiTokensData struct {
+	Tokens   []models.APIToken
+	Scopes   []models.APITokenScope
+	Expiries []int
+	Form     APITokenForm
+	Created  *models.APIToken
+}
+
+// Checked reports whether the form has scope picked
+func (d apiTokensData) Checked(scope string) bool {
+	for _, s := range d.Form.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Index lists the API tokens of the current user,
+// with the form to create another one
+//
+// GET /tokens
+func (t *APITokens) Index(res http.ResponseWriter, req *http.Request) {
+	t.renderIndex(res, req, nil, APITokenForm{ExpiresIn: 30}, nil)
+}
+
+// Create creates a token and shows it once
+//
+// POST /tokens
+func (t *APITokens) Create(res http.ResponseWriter, req *http.Request) {
+	var form APITokenForm
+	if err := parseForm(req, &form); err != nil {
+		t.renderIndex(res, req, err, form, nil)
+		return
+	}
+
+	var expiresAt *time.Time
+	if form.ExpiresIn > 0 {
+		at := time.Now().AddDate(0, 0, form.ExpiresIn)
+		expiresAt = &at
+	}
+
+	user := context.User(req.Context())
+	token, err := t.ts.Issue(user, form.Name, form.Scopes, expiresAt)
+	if err != nil {
+		t.renderIndex(res, req, err, form, nil)
+		return
+	}
+
+	// rendered rather than redirected to, as the
+	// raw token can not be looked up again
+	t.renderIndex(res, req, nil, APITokenForm{ExpiresIn: 30}, token)
+}
+
+// Revoke deletes a token of the current user
+//
+// POST /tokens/:id/revoke
+func (t *APITokens) Revoke(res http.ResponseWriter, req *http.Request) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		views.RedirectError(res, req, "/tokens", errAPITokenNotFound)
+		return
+	}
+
+	user := context.User(req.Context())
+	if err := t.ts.Revoke(user.ID, id); err != nil {
+		if err == models.ErrNotFound {
+			err = errAPITokenNotFound
+		}
+
+		views.RedirectError(res, req, "/tokens", err)
+		return
+	}
+
+	views.RedirectAlert(res, req, "/tokens", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The API token has been revoked",
+	})
+}
+
+// renderIndex renders the API tokens page showing err, if
+// any, with the form filled in as it was posted
+func (t *APITokens) renderIndex(res http.ResponseWriter, req *http.Request, err error, form APITokenForm, created *models.APIToken) {
+	user := context.User(req.Context())
+	tokens, listErr := t.ts.ByUserID(user.ID)
+	if listErr != nil {
+		views.Error(res, req, http.StatusInternalServerError, listErr)
+		return
+	}
+
+	vd := views.Data{Yield: &views.Form{Values: apiTokensData{
+		Tokens:   tokens,
+		Scopes:   models.APITokenScopes,
+		Expiries: apiTokenExpiries,
+		Form:     form,
+		Created:  created,
+	}}}
+	if err != nil {
+		vd.SetAlert(err)
+	}
+
+	t.IndexView.Render(res, req, vd)
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"../../photofriends/email"
+	"../../photofriends/models"
+	"../../photofriends/views"
+	"../context"
+)
+
+var (
+	errUserNotFound    = views.NewPublicError("User not found")
+	errRequestNotFound = views.NewPublicError("Friend request not found")
+	errNoUserByEmail   = views.NewPublicError("Nobody with that email address uses photofriends yet")
+)
+
+// NewFriends is used to create a new Friends controller.
+// Friend request emails link to the friends page under baseURL
+func NewFriends(fs models.FriendService, us models.UserService, mailer email.Mailer, baseURL string) *Friends {
+	return &Friends{
+		IndexView:    views.NewView("layout", "friends/index"),
+		requestEmail: email.NewTemplate("friend_request"),
+		fs:           fs,
+		us:           us,
+		mailer:       mailer,
+		baseURL:      baseURL,
+	}
+}
+
+type Friends struct {
+	IndexView    *views.View
+	requestEmail *email.Template
+	fs           models.FriendService
+	us           models.UserService
+	mailer       email.Mailer
+	baseURL      string
+}
+
+// friendRow is a friendship along with the user
+// on the other side of it, used by the friends view
+type friendRow struct {
+	Friendship models.Friendship
+	User       *models.User
+}
+
+// friendsData groups the friendships of the current
+// user the way they are shown on the friends page
+type friendsData struct {
+	Friends  []friendRow
+	Incoming []friendRow
+	Outgoing []friendRow
+	Blocked  []friendRow
+}
+
+type AddFriendForm struct {
+	Email string `schema:"email"`
+}
+
+// Index lists the friends and friend requests of the current user
+//
+// GET /friends
+func (f *Friends) Index(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	friendships, err := f.fs.ByUserID(user.ID)
+	if err != nil {
+		views.Error(res, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	var data friendsData
+	for _, friendship := range friendships {
+		other, err := f.us.ByID(friendship.Other(user.ID))
+		if err != nil {
+			continue
+		}
+
+		row := friendRow{Friendship: friendship, User: other}
+		switch {
+		case friendship.Status == models.FriendshipAccepted:
+			data.Friends = append(data.Friends, row)
+		case friendship.Status == models.FriendshipPending && friendship.FriendID == user.ID:
+			data.Incoming = append(data.Incoming, row)
+		case friendship.Status == models.FriendshipPending:
+			data.Outgoing = append(data.Outgoing, row)
+		case friendship.Status == models.FriendshipBlocked && friendship.UserID == user.ID:
+			data.Blocked = append(data.Blocked, row)
+		}
+	}
+
+	f.IndexView.Render(res, req, data)
+}
+
+// Add sends a friend request to the user with the posted email
+//
+// POST /friends
+func (f *Friends) Add(res http.ResponseWriter, req *http.Request) {
+	var form AddFriendForm
+	if err := parseForm(req, &form); err != nil {
+		views.RedirectError(res, req, "/friends", err)
+		return
+	}
+
+	other, err := f.us.ByEmail(form.Email)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			views.RedirectError(res, req, "/friends", errNoUserByEmail)
+		default:
+			views.RedirectError(res, req, "/friends", err)
+		}
+		return
+	}
+
+	f.request(res, req, other.ID)
+}
+
+// Request sends a friend request to the user with the given id
+//
+// POST /users/:id/friend
+func (f *Friends) Request(res http.ResponseWriter, req *http.Request) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		views.Error(res, req, http.StatusNotFound, errUserNotFound)
+		return
+	}
+
+	if _, err := f.us.ByID(id); err != nil {
+		views.Error(res, req, http.StatusNotFound, errUserNotFound)
+		return
+	}
+
+	f.request(res, req, id)
+}
+
+// Accept accepts a pending friend request
+//
+// POST /friends/:id/accept
+func (f *Friends) Accept(res http.ResponseWriter, req *http.Request) {
+	f.answer(res, req, f.fs.Accept)
+}
+
+// Decline declines a pending friend request
+//
+// POST /friends/:id/decline
+func (f *Friends) Decline(res http.ResponseWriter, req *http.Request) {
+	f.answer(res, req, f.fs.Decline)
+}
+
+// Unfriend ends a friendship, cancels a sent
+// request or unblocks the user with the given id
+//
+// POST /users/:id/unfriend
+func (f *Friends) Unfriend(res http.ResponseWriter, req *http.Request) {
+	f.withOther(res, req, f.fs.Unfriend)
+}
+
+// Block blocks the user with the given id
+//
+// POST /users/:id/block
+func (f *Friends) Block(res http.ResponseWriter, req *http.Request) {
+	f.withOther(res, req, f.fs.Block)
+}
+
+func (f *Friends) request(res http.ResponseWriter, req *http.Request, otherID uint) {
+	user := context.User(req.Context())
+	if !user.Verified() {
+		views.RedirectError(res, req, "/friends", models.ErrEmailUnverified)
+		return
+	}
+
+	friendship, err := f.fs.Request(user.ID, otherID)
+	if err != nil {
+		views.RedirectError(res, req, "/friends", err)
+		return
+	}
+
+	if friendship.Status == models.FriendshipPending {
+		// the request went through either way, so a failed
+		// notification is only logged
+		if err := f.notifyRequest(user, otherID); err != nil {
+			log.Printf("friends: notifying user %d: %v", otherID, err)
+		}
+	}
+
+	views.RedirectAlert(res, req, "/friends", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Friend request sent",
+	})
+}
+
+// notifyRequest emails the user a friend request was sent to
+func (f *Friends) notifyRequest(from *models.User, toID uint) error {
+	to, err := f.us.ByID(toID)
+	if err != nil {
+		return err
+	}
+
+	msg, err := f.requestEmail.Message(to.Email, emailData{
+		Name: to.Name,
+		From: from.Name,
+		Link: email.Link(f.baseURL, "/friends", nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	return f.mailer.Send(msg)
+}
+
+// answer runs fn for the friendship id in the route
+func (f *Friends) answer(res http.ResponseWriter, req *http.Request, fn func(userID, friendshipID uint) error) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		views.RedirectError(res, req, "/friends", errRequestNotFound)
+		return
+	}
+
+	user := context.User(req.Context())
+	if err := fn(user.ID, id); err != nil {
+		if err == models.ErrNotFound {
+			err = errRequestNotFound
+		}
+
+		views.RedirectError(res, req, "/friends", err)
+		return
+	}
+
+	http.Redirect(res, req, "/friends", http.StatusFound)
+}
+
+// withOther runs fn for the user id in the route
+func (f *Friends) withOther(res http.ResponseWriter, req *http.Request, fn func(userID, otherID uint) error) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		views.RedirectError(res, req, "/friends", errUserNotFound)
+		return
+	}
+
+	user := context.User(req.Context())
+	if err := fn(user.ID, id); err != nil {
+		if err == models.ErrNotFound {
+			err = errUserNotFound
+		}
+
+		views.RedirectError(res, req, "/friends", err)
+		return
+	}
+
+	http.Redirect(res, req, "/friends", http.StatusFound)
+}
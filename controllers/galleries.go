@@ -3,38 +3,129 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"../../photofriends/api"
 	"../../photofriends/models"
 	"../../photofriends/views"
 	"../context"
-	"github.com/gorilla/schema"
+	"github.com/gorilla/mux"
 )
 
-func NewGalleries(gs models.GalleryService) *Galleries {
+const (
+	ShowGallery = "show_gallery"
+	EditGallery = "edit_gallery"
+	ShowImage   = "show_image"
+)
+
+var (
+	errGalleryNotFound = views.NewPublicError("Gallery not found")
+	errImageNotFound   = views.NewPublicError("Image not found")
+)
+
+// NewGalleries is used to create a new Galleries controller.
+// The router is needed so handlers can build URLs for
+// named routes like ShowGallery and EditGallery.
+//
+// Clients that send "Accept: application/json" get the
+// resources of package api instead of pages, and errors
+// in its envelope, see api.WantsJSON
+func NewGalleries(gs models.GalleryService, is models.ImageService, r *mux.Router) *Galleries {
 	return &Galleries{
-		New: views.NewView("layout", "galleries/new"),
-		gs:  gs,
+		New:       views.NewView("layout", "galleries/new"),
+		ShowView:  views.NewView("layout", "galleries/show"),
+		EditView:  views.NewView("layout", "galleries/edit"),
+		IndexView: views.NewView("layout", "galleries/index"),
+		ImageView: views.NewView("layout", "galleries/image"),
+		DupsView:  views.NewView("layout", "galleries/duplicates"),
+		gs:        gs,
+		is:        is,
+		r:         r,
 	}
 }
 
 type Galleries struct {
-	New *views.View
-	gs  models.GalleryService
+	New       *views.View
+	ShowView  *views.View
+	EditView  *views.View
+	IndexView *views.View
+	ImageView *views.View
+	DupsView  *views.View
+	gs        models.GalleryService
+	is        models.ImageService
+	r         *mux.Router
 }
 
 type GalleryForm struct {
-	Title string `schema:"title"`
+	Title      string `schema:"title"`
+	Visibility string `schema:"visibility"`
 }
 
-// POST /galleries
-func (g *Galleries) Create(res http.ResponseWriter, req *http.Request) {
-	if err := req.ParseForm(); err != nil {
-		panic(err)
+// galleryView is what the gallery templates are rendered
+// with. Share is the token the gallery was opened with, so
+// links to images of unlisted galleries keep working
+type galleryView struct {
+	*models.Gallery
+	Share string
+}
+
+// Index lists all of the galleries owned by the current user
+//
+// GET /galleries
+func (g *Galleries) Index(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	galleries, err := g.gs.ByUserID(user.ID)
+	if err != nil {
+		renderError(res, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	if api.WantsJSON(req) {
+		api.WriteData(res, http.StatusOK, api.NewGalleries(galleries, user))
+		return
+	}
+
+	g.IndexView.Render(res, req, galleries)
+}
+
+// Show displays a single gallery
+//
+// GET /galleries/:id
+func (g *Galleries) Show(res http.ResponseWriter, req *http.Request) {
+	gallery, err := g.viewableGalleryByID(res, req)
+	if err != nil {
+		// viewableGalleryByID already renders the error for us
+		return
+	}
+
+	if api.WantsJSON(req) {
+		api.WriteData(res, http.StatusOK, api.NewGallery(gallery, context.User(req.Context())))
+		return
+	}
+
+	g.ShowView.Render(res, req, galleryView{gallery, req.URL.Query().Get("share")})
+}
+
+// Edit displays the edit form for a gallery owned by the current user
+//
+// GET /galleries/:id/edit
+func (g *Galleries) Edit(res http.ResponseWriter, req *http.Request) {
+	gallery, err := g.ownedGalleryByID(res, req)
+	if err != nil {
+		return
 	}
 
-	dec := schema.NewDecoder()
+	g.EditView.Render(res, req, &views.Form{Values: gallery})
+}
+
+// POST /galleries
+func (g *Galleries) Create(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
 	var form GalleryForm
-	if err := dec.Decode(&form, req.PostForm); err != nil {
+	vd.Yield = &views.Form{Values: &form}
+	if err := parseForm(req, &form); err != nil {
+		renderForm(res, req, g.New, vd, err)
 		return
 	}
 
@@ -44,15 +135,351 @@ func (g *Galleries) Create(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !models.CanPublish(user, form.Visibility) {
+		renderForm(res, req, g.New, vd, models.ErrEmailUnverified)
+		return
+	}
+
 	gallery := models.Gallery{
-		Title:  form.Title,
-		UserID: user.ID,
+		Title:      form.Title,
+		Visibility: form.Visibility,
+		UserID:     user.ID,
 	}
 
 	if err := g.gs.Create(&gallery); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		renderForm(res, req, g.New, vd, err)
+		return
+	}
+
+	if api.WantsJSON(req) {
+		res.Header().Set("Location", g.path(ShowGallery, gallery.ID))
+		api.WriteData(res, http.StatusCreated, api.NewGallery(&gallery, user))
+		return
+	}
+
+	g.redirectTo(res, req, EditGallery, gallery.ID, "")
+}
+
+// Update processes the edit form for a gallery
+//
+// POST /galleries/:id/update
+func (g *Galleries) Update(res http.ResponseWriter, req *http.Request) {
+	gallery, err := g.ownedGalleryByID(res, req)
+	if err != nil {
+		return
+	}
+
+	vd := views.Data{Yield: &views.Form{Values: gallery}}
+	var form GalleryForm
+	if err := parseForm(req, &form); err != nil {
+		renderForm(res, req, g.EditView, vd, err)
+		return
+	}
+
+	// galleries made public before this rule existed may stay public
+	user := context.User(req.Context())
+	if form.Visibility != gallery.Visibility && !models.CanPublish(user, form.Visibility) {
+		renderForm(res, req, g.EditView, vd, models.ErrEmailUnverified)
+		return
+	}
+
+	gallery.Title = form.Title
+	gallery.Visibility = form.Visibility
+	if err := g.gs.Update(gallery); err != nil {
+		renderForm(res, req, g.EditView, vd, err)
+		return
+	}
+
+	if api.WantsJSON(req) {
+		api.WriteData(res, http.StatusOK, api.NewGallery(gallery, user))
+		return
+	}
+
+	g.redirectTo(res, req, ShowGallery, gallery.ID, "Gallery saved")
+}
+
+// Delete removes a gallery owned by the current user
+//
+// POST /galleries/:id/delete
+func (g *Galleries) Delete(res http.ResponseWriter, req *http.Request) {
+	gallery, err := g.ownedGalleryByID(res, req)
+	if err != nil {
+		return
+	}
+
+	vd := views.Data{Yield: &views.Form{Values: gallery}}
+	for i := range gallery.Images {
+		if err := g.is.Delete(&gallery.Images[i]); err != nil {
+			renderForm(res, req, g.EditView, vd, err)
+			return
+		}
+	}
+
+	if err := g.gs.Delete(gallery.ID, context.User(req.Context()).ID); err != nil {
+		renderForm(res, req, g.EditView, vd, err)
+		return
+	}
+
+	if api.WantsJSON(req) {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	views.RedirectAlert(res, req, "/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Gallery deleted",
+	})
+}
+
+// ImageUpload stores every image posted in the "images"
+// field of the multipart form in the gallery
+//
+// POST /galleries/:id/images
+func (g *Galleries) ImageUpload(res http.ResponseWriter, req *http.Request) {
+	gallery, err := g.ownedGalleryByID(res, req)
+	if err != nil {
+		return
+	}
+
+	images, err := api.UploadImages(g.is, gallery, res, req)
+	if err != nil {
+		renderForm(res, req, g.EditView, views.Data{Yield: &views.Form{Values: gallery}}, err)
+		return
+	}
+
+	if api.WantsJSON(req) {
+		api.WriteData(res, http.StatusCreated, api.NewImages(images))
+		return
+	}
+
+	views.RedirectAlert(res, req, g.path(EditGallery, gallery.ID), http.StatusFound, uploadedAlert(images))
+}
+
+// uploadedAlert tells the images were uploaded, and
+// warns about the ones that look like images the
+// user already has
+func uploadedAlert(images []models.Image) views.Alert {
+	var names []string
+	for _, image := range images {
+		if len(image.Duplicates) > 0 {
+			names = append(names, image.Filename)
+		}
+	}
+
+	switch len(names) {
+	case 0:
+		return views.Alert{Level: views.AlertLvlSuccess, Message: "Images uploaded"}
+	case 1:
+		return views.Alert{
+			Level:   views.AlertLvlWarning,
+			Message: fmt.Sprintf("Images uploaded, but %s looks like an image you already have", names[0]),
+		}
+	default:
+		return views.Alert{
+			Level:   views.AlertLvlWarning,
+			Message: fmt.Sprintf("Images uploaded, but %s look like images you already have", strings.Join(names, ", ")),
+		}
+	}
+}
+
+// Duplicates groups the images in every gallery of the
+// current user that look the same or much alike
+//
+// GET /galleries/duplicates
+func (g *Galleries) Duplicates(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	groups, err := g.is.Duplicates(user.ID)
+	if err != nil {
+		renderError(res, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	if api.WantsJSON(req) {
+		all := make([][]api.Duplicate, len(groups))
+		for i, group := range groups {
+			all[i] = api.NewDuplicates(group)
+		}
+		api.WriteData(res, http.StatusOK, all)
+		return
+	}
+
+	galleries, err := g.gs.ByUserID(user.ID)
+	if err != nil {
+		renderError(res, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	titles := make(map[uint]string, len(galleries))
+	for _, gallery := range galleries {
+		titles[gallery.ID] = gallery.Title
+	}
+
+	g.DupsView.Render(res, req, struct {
+		Groups [][]models.Duplicate
+		Titles map[uint]string
+	}{groups, titles})
+}
+
+// ShowImage displays a single image of a gallery along with
+// the EXIF metadata read from it, and for its owner the
+// images it looks like
+//
+// GET /galleries/:id/images/:imageID
+func (g *Galleries) ShowImage(res http.ResponseWriter, req *http.Request) {
+	gallery, err := g.viewableGalleryByID(res, req)
+	if err != nil {
+		return
+	}
+
+	imageID, err := idVar(req, "imageID")
+	if err != nil {
+		renderError(res, req, http.StatusNotFound, errImageNotFound)
+		return
+	}
+
+	image, err := g.is.ByID(imageID)
+	if err != nil || image.GalleryID != gallery.ID {
+		renderError(res, req, http.StatusNotFound, errImageNotFound)
+		return
+	}
+
+	// only the owner is told about the images
+	// this one looks like, in any gallery
+	if user := context.User(req.Context()); user != nil && user.ID == gallery.UserID {
+		if err := g.is.DuplicatesOf(image); err != nil {
+			renderError(res, req, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	if api.WantsJSON(req) {
+		api.WriteData(res, http.StatusOK, api.NewImage(image))
+		return
+	}
+
+	g.ImageView.Render(res, req, struct {
+		Gallery galleryView
+		Image   *models.Image
+	}{galleryView{gallery, req.URL.Query().Get("share")}, image})
+}
+
+// galleryByID looks up the gallery using the "id" route
+// variable. If anything goes wrong the error is written
+// to the response and returned so the caller can stop
+func (g *Galleries) galleryByID(res http.ResponseWriter, req *http.Request) (*models.Gallery, error) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		renderError(res, req, http.StatusNotFound, errGalleryNotFound)
+		return nil, err
+	}
+
+	gallery, err := g.gs.ByID(id)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			renderError(res, req, http.StatusNotFound, errGalleryNotFound)
+		default:
+			renderError(res, req, http.StatusInternalServerError, err)
+		}
+		return nil, err
+	}
+
+	images, err := g.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		renderError(res, req, http.StatusInternalServerError, err)
+		return nil, err
+	}
+
+	gallery.Images = images
+	return gallery, nil
+}
+
+// viewableGalleryByID works like galleryByID, but will also
+// make sure the current user, if any, may see the gallery.
+// Galleries that can not be seen are reported as not found
+func (g *Galleries) viewableGalleryByID(res http.ResponseWriter, req *http.Request) (*models.Gallery, error) {
+	gallery, err := g.galleryByID(res, req)
+	if err != nil {
+		return nil, err
+	}
+
+	user := context.User(req.Context())
+	ok, err := g.gs.CanView(gallery, user, req.URL.Query().Get("share"))
+	if err != nil {
+		renderError(res, req, http.StatusInternalServerError, err)
+		return nil, err
+	}
+
+	if !ok {
+		renderError(res, req, http.StatusNotFound, errGalleryNotFound)
+		return nil, models.ErrNotFound
+	}
+
+	return gallery, nil
+}
+
+// ownedGalleryByID works like galleryByID, but will
+// also make sure the current user owns the gallery
+func (g *Galleries) ownedGalleryByID(res http.ResponseWriter, req *http.Request) (*models.Gallery, error) {
+	gallery, err := g.galleryByID(res, req)
+	if err != nil {
+		return nil, err
+	}
+
+	user := context.User(req.Context())
+	if user == nil || gallery.UserID != user.ID {
+		renderError(res, req, http.StatusForbidden, models.ErrNotOwner)
+		return nil, models.ErrNotOwner
+	}
+
+	return gallery, nil
+}
+
+// redirectTo redirects to the named gallery route for the
+// given id, and flashes msg as a success alert if it is set
+func (g *Galleries) redirectTo(res http.ResponseWriter, req *http.Request, name string, id uint, msg string) {
+	path := g.path(name, id)
+	if msg == "" {
+		http.Redirect(res, req, path, http.StatusFound)
+		return
+	}
+
+	views.RedirectAlert(res, req, path, http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: msg,
+	})
+}
+
+// path returns the path of the named gallery route
+// for the given id, falling back to the galleries
+func (g *Galleries) path(name string, id uint) string {
+	if url, err := g.r.Get(name).URL("id", strconv.Itoa(int(id))); err == nil {
+		return url.Path
+	}
+
+	return "/galleries"
+}
+
+// renderError renders the error page for err, or responds
+// with it as JSON to clients that asked for that
+func renderError(res http.ResponseWriter, req *http.Request, status int, err error) {
+	if api.WantsJSON(req) {
+		api.WriteErrorStatus(res, status, err)
+		return
+	}
+
+	views.Error(res, req, status, err)
+}
+
+// renderForm renders view with err shown above the form,
+// or responds with err as JSON to clients that asked for
+// that, with the status picked by api.WriteError
+func renderForm(res http.ResponseWriter, req *http.Request, view *views.View, vd views.Data, err error) {
+	if api.WantsJSON(req) {
+		api.WriteError(res, err)
 		return
 	}
 
-	fmt.Fprintln(res, gallery)
+	vd.SetAlert(err)
+	view.Render(res, req, vd)
 }
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"../../photofriends/models"
+	"../../photofriends/phash"
+	"../context"
+	"github.com/gorilla/mux"
+)
+
+// memGalleries is a GalleryService with a single gallery
+type memGalleries struct {
+	models.GalleryService
+	gallery models.Gallery
+}
+
+func (m *memGalleries) ByID(id uint) (*models.Gallery, error) {
+	if id != m.gallery.ID {
+		return nil, models.ErrNotFound
+	}
+
+	gallery := m.gallery
+	return &gallery, nil
+}
+
+// memImages is an ImageService that keeps the perceptual
+// hash of every image created, to find their duplicates
+type memImages struct {
+	models.ImageService
+	images []models.Image
+	hashes []phash.Hash
+}
+
+func (m *memImages) ByGalleryID(galleryID uint) ([]models.Image, error) {
+	return nil, nil
+}
+
+func (m *memImages) Create(img *models.Image, r io.Reader) error {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return err
+	}
+
+	img.ID = uint(len(m.images) + 1)
+	m.images = append(m.images, *img)
+	m.hashes = append(m.hashes, phash.DHash(src))
+	return nil
+}
+
+func (m *memImages) DuplicatesOf(img *models.Image) error {
+	h := m.hashes[img.ID-1]
+	for i, other := range m.hashes {
+		d := phash.Distance(h, other)
+		if m.images[i].ID != img.ID && d <= models.NearDuplicateDistance {
+			img.Duplicates = append(img.Duplicates, models.Duplicate{Image: m.images[i], Distance: d})
+		}
+	}
+
+	return nil
+}
+
+// testingPicture is a PNG of a few stripes, width pixels
+// wide. Pictures of the same seed look alike at any width
+func testingPicture(t *testing.T, seed, width int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, width*3/4))
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < width; x++ {
+			stripe := uint8((x*(seed+2)/width)*60 + (y*(seed+1)*4/3/width)*40)
+			img.Set(x, y, color.RGBA{stripe, 255 - stripe, uint8(x * 255 / width), 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// uploadAlert uploads files to the gallery, and
+// returns the alert message flashed for the upload
+func uploadAlert(t *testing.T, g *Galleries, user *models.User, files map[string][]byte) string {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, content := range files {
+		fw, err := mw.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(content)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/galleries/1/images", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req = req.WithContext(context.WithUser(req.Context(), user))
+
+	rec := httptest.NewRecorder()
+	g.r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/galleries/1/edit" {
+		t.Fatalf("Expected a redirect to the gallery. Recieved %d %v", rec.Code, rec.Header())
+	}
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "alert_message" {
+			msg, _ := base64.RawURLEncoding.DecodeString(c.Value)
+			return string(msg)
+		}
+	}
+
+	return ""
+}
+
+func TestImageUploadWarnsAboutDuplicates(t *testing.T) {
+	user := &models.User{Email: "jon@example.com"}
+	user.ID = 1
+	gallery := models.Gallery{UserID: user.ID, Title: "Holidays"}
+	gallery.ID = 1
+
+	r := mux.NewRouter()
+	g := &Galleries{gs: &memGalleries{gallery: gallery}, is: &memImages{}, r: r}
+	r.HandleFunc("/galleries/{id:[0-9]+}/edit", nil).Name(EditGallery)
+	r.HandleFunc("/galleries/{id:[0-9]+}/images", g.ImageUpload).Methods("POST")
+
+	msg := uploadAlert(t, g, user, map[string][]byte{"beach.png": testingPicture(t, 1, 320)})
+	if msg != "Images uploaded" {
+		t.Errorf("Expected the images to be uploaded. Recieved %q", msg)
+	}
+
+	// a smaller copy of the same picture, along with another one
+	msg = uploadAlert(t, g, user, map[string][]byte{
+		"beach-small.png": testingPicture(t, 1, 160),
+		"forest.png":      testingPicture(t, 5, 320),
+	})
+	if !strings.Contains(msg, "beach-small.png looks like an image you already have") || strings.Contains(msg, "forest.png") {
+		t.Errorf("Expected a warning about beach-small.png. Recieved %q", msg)
+	}
+}
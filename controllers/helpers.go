@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"../../photofriends/views"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+)
+
+// parseForm parses the posted form of the request
+// and decodes it into the provided destination. Fields
+// without a destination, like the CSRF token, are ignored
+func parseForm(req *http.Request, dst interface{}) error {
+	if err := req.ParseForm(); err != nil {
+		return err
+	}
+
+	dec := schema.NewDecoder()
+	dec.IgnoreUnknownKeys(true)
+	return dec.Decode(dst, req.PostForm)
+}
+
+// idVar parses the named route variable as an ID
+func idVar(req *http.Request, name string) (uint, error) {
+	id, err := strconv.Atoi(mux.Vars(req)[name])
+	if err != nil {
+		return 0, err
+	}
+
+	return uint(id), nil
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+// jsonError responds with the message users would see
+// for err, as {"error": "..."}
+func jsonError(res http.ResponseWriter, status int, err error) {
+	writeJSON(res, status, map[string]string{"error": views.ErrorAlert(err).Message})
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"../../photofriends/models"
+	"../../photofriends/views"
+	"../context"
+	"github.com/gorilla/mux"
+)
+
+// identityCookie holds the state of the sign in at a
+// provider in progress, to check the callback comes
+// back to the browser that started it
+const identityCookie = "oidc"
+
+var (
+	errIdentityNotFound = views.NewPublicError("Linked account not found")
+	errIdentityDenied   = views.NewPublicError("Signing in with the provider was cancelled")
+)
+
+// NewIdentities creates the controller to sign in with
+// providers and link accounts there. It panics if the
+// templates can not be parsed, so it should only be
+// used on start
+func NewIdentities(is models.IdentityService, ss models.SessionService) *Identities {
+	return &Identities{
+		IndexView: views.NewView("layout", "users/identities"),
+		is:        is,
+		ss:        ss,
+	}
+}
+
+type Identities struct {
+	IndexView *views.View
+	is        models.IdentityService
+	ss        models.SessionService
+}
+
+// identitiesData is used to render the linked accounts page
+type identitiesData struct {
+	Identities []models.Identity
+	Providers  []models.IdentityProvider
+
+	// Names maps provider names to display names
+	Names map[string]string
+}
+
+// Index lists the linked accounts of the current user,
+// and the providers more can be linked at
+//
+// GET /identities
+func (i *Identities) Index(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	identities, err := i.is.ByUserID(user.ID)
+	if err != nil {
+		views.Error(res, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	data := identitiesData{
+		Identities: identities,
+		Providers:  i.is.Providers(),
+		Names:      make(map[string]string),
+	}
+	for _, p := range data.Providers {
+		data.Names[p.Name] = p.DisplayName
+	}
+
+	i.IndexView.Render(res, req, views.Data{Yield: data})
+}
+
+// Link sends the current user to the provider, to
+// link their account there
+//
+// POST /identities/:provider/link
+func (i *Identities) Link(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	i.begin(res, req, user, "/identities")
+}
+
+// Login sends the visitor to the provider to log in,
+// or sign up if their account is not linked yet
+//
+// POST /auth/:provider
+func (i *Identities) Login(res http.ResponseWriter, req *http.Request) {
+	i.begin(res, req, nil, "/login")
+}
+
+func (i *Identities) begin(res http.ResponseWriter, req *http.Request, user *models.User, back string) {
+	state, authURL, err := i.is.Begin(mux.Vars(req)["provider"], user)
+	if err != nil {
+		views.RedirectError(res, req, back, err)
+		return
+	}
+
+	setIdentityCookie(res, state)
+	http.Redirect(res, req, authURL, http.StatusFound)
+}
+
+// Callback is where the provider sends users back to. Users
+// that linked an account return to their linked accounts,
+// everyone else is logged in, asking for their second factor
+// like a password login would
+//
+// GET /auth/:provider/callback
+func (i *Identities) Callback(res http.ResponseWriter, req *http.Request) {
+	back := "/login"
+	if context.User(req.Context()) != nil {
+		back = "/identities"
+	}
+
+	state := takeIdentityCookie(res, req)
+	q := req.URL.Query()
+	if q.Get("error") != "" {
+		views.RedirectError(res, req, back, errIdentityDenied)
+		return
+	}
+
+	// a state the browser did not start could log the
+	// visitor in as someone else, see RFC 6749 section 10.12
+	if state == "" || q.Get("state") != state {
+		views.RedirectError(res, req, back, models.ErrIdentityExpired)
+		return
+	}
+
+	user, linked, err := i.is.Complete(state, q.Get("code"), models.LoginClient{
+		IP:        clientIP(req),
+		UserAgent: req.UserAgent(),
+	})
+	if err != nil {
+		views.RedirectError(res, req, back, err)
+		return
+	}
+
+	if linked {
+		views.RedirectAlert(res, req, "/identities", http.StatusFound, views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: "Your account has been linked. You can now use it to log in",
+		})
+		return
+	}
+
+	next, err := logIn(res, req, i.ss, user)
+	if err != nil {
+		views.RedirectError(res, req, "/login", err)
+		return
+	}
+
+	http.Redirect(res, req, next, http.StatusFound)
+}
+
+// Unlink removes a linked account of the current user
+//
+// POST /identities/:id/unlink
+func (i *Identities) Unlink(res http.ResponseWriter, req *http.Request) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		views.RedirectError(res, req, "/identities", errIdentityNotFound)
+		return
+	}
+
+	user := context.User(req.Context())
+	if err := i.is.Unlink(user, id); err != nil {
+		if err == models.ErrNotFound {
+			err = errIdentityNotFound
+		}
+
+		views.RedirectError(res, req, "/identities", err)
+		return
+	}
+
+	views.RedirectAlert(res, req, "/identities", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The account has been unlinked",
+	})
+}
+
+// setIdentityCookie keeps the state while the user signs in
+// at the provider. It has to be Lax, as the callback is a
+// navigation from the site of the provider
+func setIdentityCookie(res http.ResponseWriter, state string) {
+	cookie := http.Cookie{
+		Name:     identityCookie,
+		Value:    state,
+		Path:     "/auth",
+		MaxAge:   int(models.IdentityFlowTimeout / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(res, &cookie)
+}
+
+// takeIdentityCookie returns the state and clears the
+// cookie, as every sign in can only be completed once
+func takeIdentityCookie(res http.ResponseWriter, req *http.Request) string {
+	cookie, err := req.Cookie(identityCookie)
+	if err != nil {
+		return ""
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     identityCookie,
+		Value:    "",
+		Path:     "/auth",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return cookie.Value
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"../../photofriends/models"
+	"../../photofriends/views"
+	"../../photofriends/webauthn"
+	"../context"
+)
+
+// ceremonyCookie holds the token of the passkey
+// ceremony in progress, between begin and finish
+const ceremonyCookie = "webauthn"
+
+var (
+	errPasskeyNotFound = views.NewPublicError("Passkey not found")
+	errPasskeyMissing  = views.NewPublicError("Your browser did not return a passkey. Please try again")
+)
+
+// NewPasskeys creates the controller to add passkeys
+// and log in with them. It panics if the templates
+// can not be parsed, so it should only be used on start
+func NewPasskeys(ps models.PasskeyService, ss models.SessionService) *Passkeys {
+	return &Passkeys{
+		IndexView: views.NewView("layout", "users/passkeys"),
+		ps:        ps,
+		ss:        ss,
+	}
+}
+
+type Passkeys struct {
+	IndexView *views.View
+	ps        models.PasskeyService
+	ss        models.SessionService
+}
+
+// PasskeyForm is posted once the browser created or used
+// a passkey. Credential is the PublicKeyCredential as JSON
+type PasskeyForm struct {
+	Name       string `schema:"name"`
+	Credential string `schema:"credential"`
+}
+
+// passkeysData is used to render the passkeys page
+type passkeysData struct {
+	Passkeys []models.Passkey
+	Name     string
+}
+
+// Index lists the passkeys of the current user,
+// with the form to add another one
+//
+// GET /passkeys
+func (p *Passkeys) Index(res http.ResponseWriter, req *http.Request) {
+	p.renderIndex(res, req, nil, "")
+}
+
+// BeginRegistration returns the options for
+// navigator.credentials.create as JSON
+//
+// POST /passkeys/register/begin
+func (p *Passkeys) BeginRegistration(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	token, opts, err := p.ps.BeginRegistration(user)
+	if err != nil {
+		jsonError(res, http.StatusInternalServerError, err)
+		return
+	}
+
+	setCeremonyCookie(res, token)
+	writeJSON(res, http.StatusOK, map[string]interface{}{"publicKey": opts})
+}
+
+// Create stores the passkey the browser created
+//
+// POST /passkeys
+func (p *Passkeys) Create(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	var form PasskeyForm
+	if err := parseForm(req, &form); err != nil {
+		p.renderIndex(res, req, err, form.Name)
+		return
+	}
+
+	var resp webauthn.RegistrationResponse
+	if err := json.Unmarshal([]byte(form.Credential), &resp); err != nil {
+		p.renderIndex(res, req, errPasskeyMissing, form.Name)
+		return
+	}
+
+	token := takeCeremonyCookie(res, req)
+	if _, err := p.ps.FinishRegistration(user, token, form.Name, &resp); err != nil {
+		p.renderIndex(res, req, err, form.Name)
+		return
+	}
+
+	views.RedirectAlert(res, req, "/passkeys", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your passkey has been added. You can now use it to log in",
+	})
+}
+
+// Delete removes a passkey of the current user
+//
+// POST /passkeys/:id/delete
+func (p *Passkeys) Delete(res http.ResponseWriter, req *http.Request) {
+	id, err := idVar(req, "id")
+	if err != nil {
+		views.RedirectError(res, req, "/passkeys", errPasskeyNotFound)
+		return
+	}
+
+	user := context.User(req.Context())
+	if err := p.ps.Remove(user.ID, id); err != nil {
+		if err == models.ErrNotFound {
+			err = errPasskeyNotFound
+		}
+
+		views.RedirectError(res, req, "/passkeys", err)
+		return
+	}
+
+	views.RedirectAlert(res, req, "/passkeys", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The passkey has been removed",
+	})
+}
+
+// BeginLogin returns the options for
+// navigator.credentials.get as JSON
+//
+// POST /login/passkey/begin
+func (p *Passkeys) BeginLogin(res http.ResponseWriter, req *http.Request) {
+	token, opts, err := p.ps.BeginLogin()
+	if err != nil {
+		jsonError(res, http.StatusInternalServerError, err)
+		return
+	}
+
+	setCeremonyCookie(res, token)
+	writeJSON(res, http.StatusOK, map[string]interface{}{"publicKey": opts})
+}
+
+// Login logs in the owner of the passkey the browser
+// used. No password or second factor is asked for
+//
+// POST /login/passkey
+func (p *Passkeys) Login(res http.ResponseWriter, req *http.Request) {
+	var form PasskeyForm
+	if err := parseForm(req, &form); err != nil {
+		views.RedirectError(res, req, "/login", err)
+		return
+	}
+
+	var resp webauthn.AssertionResponse
+	if err := json.Unmarshal([]byte(form.Credential), &resp); err != nil {
+		views.RedirectError(res, req, "/login", errPasskeyMissing)
+		return
+	}
+
+	token := takeCeremonyCookie(res, req)
+	user, err := p.ps.FinishLogin(token, &resp, models.LoginClient{
+		IP:        clientIP(req),
+		UserAgent: req.UserAgent(),
+	})
+	if err != nil {
+		views.RedirectError(res, req, "/login", err)
+		return
+	}
+
+	if err := signIn(res, req, p.ss, user); err != nil {
+		views.RedirectError(res, req, "/login", err)
+		return
+	}
+
+	http.Redirect(res, req, "/galleries", http.StatusFound)
+}
+
+// renderIndex renders the passkeys page showing err, if
+// any, and the name that was typed for a passkey that failed
+func (p *Passkeys) renderIndex(res http.ResponseWriter, req *http.Request, err error, name string) {
+	user := context.User(req.Context())
+	passkeys, listErr := p.ps.ByUserID(user.ID)
+	if listErr != nil {
+		views.Error(res, req, http.StatusInternalServerError, listErr)
+		return
+	}
+
+	vd := views.Data{Yield: &views.Form{Values: passkeysData{Passkeys: passkeys, Name: name}}}
+	if err != nil {
+		vd.SetAlert(err)
+	}
+
+	p.IndexView.Render(res, req, vd)
+}
+
+// setCeremonyCookie keeps the ceremony token for as
+// long as the browser waits for the authenticator
+func setCeremonyCookie(res http.ResponseWriter, token string) {
+	cookie := http.Cookie{
+		Name:     ceremonyCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(webauthn.Timeout / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(res, &cookie)
+}
+
+// takeCeremonyCookie returns the ceremony token and clears
+// the cookie, as every ceremony can only be finished once
+func takeCeremonyCookie(res http.ResponseWriter, req *http.Request) string {
+	cookie, err := req.Cookie(ceremonyCookie)
+	if err != nil {
+		return ""
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     ceremonyCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return cookie.Value
+}
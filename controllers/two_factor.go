@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+
+	"../../photofriends/models"
+	"../../photofriends/totp"
+	"../../photofriends/views"
+	"../context"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// totpIssuer is the name authenticator apps show for the code
+const totpIssuer = "photofriends"
+
+type TwoFactorForm struct {
+	Code string `schema:"code"`
+}
+
+// twoFactorData is used to render the two factor settings
+type twoFactorData struct {
+	Enabled           bool
+	Secret            string
+	QRCode            template.URL
+	RecoveryCodesLeft int
+
+	// RecoveryCodes are only set right after enabling
+	RecoveryCodes []string
+}
+
+// TwoFactor shows the QR code to set up two factor auth, or
+// how many recovery codes are left once it is turned on
+//
+// GET /2fa
+func (u *Users) TwoFactor(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	data, err := u.twoFactorData(user)
+	if err != nil {
+		views.Error(res, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	u.TwoFactorView.Render(res, req, &views.Form{Values: data})
+}
+
+// EnableTwoFactor turns on two factor auth once the user
+// entered a code from their app, and shows the recovery codes
+//
+// POST /2fa/enable
+func (u *Users) EnableTwoFactor(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	var vd views.Data
+	var form TwoFactorForm
+	if err := parseForm(req, &form); err != nil {
+		views.RedirectError(res, req, "/2fa", err)
+		return
+	}
+
+	codes, err := u.us.EnableTOTP(user, form.Code)
+	if err != nil {
+		data, dataErr := u.twoFactorData(user)
+		if dataErr != nil {
+			views.Error(res, req, http.StatusInternalServerError, dataErr)
+			return
+		}
+
+		vd.Yield = &views.Form{Values: data}
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(res, req, vd)
+		return
+	}
+
+	vd.Yield = &views.Form{Values: twoFactorData{Enabled: true, RecoveryCodes: codes}}
+	u.TwoFactorView.Render(res, req, vd)
+}
+
+// DisableTwoFactor turns off two factor auth after
+// checking a code, so a stolen session is not enough
+//
+// POST /2fa/disable
+func (u *Users) DisableTwoFactor(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	var vd views.Data
+	var form TwoFactorForm
+	if err := parseForm(req, &form); err != nil {
+		views.RedirectError(res, req, "/2fa", err)
+		return
+	}
+
+	if err := u.us.DisableTOTP(user, form.Code); err != nil {
+		data, dataErr := u.twoFactorData(user)
+		if dataErr != nil {
+			views.Error(res, req, http.StatusInternalServerError, dataErr)
+			return
+		}
+
+		vd.Yield = &views.Form{Values: data}
+		renderAuthError(res, req, u.TwoFactorView, vd, err)
+		return
+	}
+
+	views.RedirectAlert(res, req, "/2fa", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two factor authentication is turned off",
+	})
+}
+
+// TwoFactorChallenge asks for the second factor of a
+// user who just entered their password
+//
+// GET /login/2fa
+func (u *Users) TwoFactorChallenge(res http.ResponseWriter, req *http.Request) {
+	if pendingSession(req) == nil {
+		http.Redirect(res, req, "/login", http.StatusFound)
+		return
+	}
+
+	u.ChallengeView.Render(res, req, &views.Form{Values: &TwoFactorForm{}})
+}
+
+// CompleteTwoFactor checks the code, and replaces the pending
+// session with a real one, which logs the user in
+//
+// POST /login/2fa
+func (u *Users) CompleteTwoFactor(res http.ResponseWriter, req *http.Request) {
+	pending := pendingSession(req)
+	if pending == nil {
+		http.Redirect(res, req, "/login", http.StatusFound)
+		return
+	}
+
+	var vd views.Data
+	var form TwoFactorForm
+	vd.Yield = &views.Form{Values: &form}
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		u.ChallengeView.Render(res, req, vd)
+		return
+	}
+
+	user, err := u.us.ByID(pending.UserID)
+	if err != nil {
+		views.Error(res, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := u.us.VerifyTOTP(user, form.Code); err != nil {
+		renderAuthError(res, req, u.ChallengeView, vd, err)
+		return
+	}
+
+	u.ss.Delete(pending.ID)
+	if err := signIn(res, req, u.ss, user); err != nil {
+		vd.SetAlert(err)
+		u.ChallengeView.Render(res, req, vd)
+		return
+	}
+
+	http.Redirect(res, req, "/galleries", http.StatusFound)
+}
+
+// twoFactorData returns what the settings page shows for
+// the user, starting the setup if two factor auth is off
+func (u *Users) twoFactorData(user *models.User) (twoFactorData, error) {
+	if user.TOTPEnabled() {
+		left, err := u.us.RecoveryCodesLeft(user)
+		return twoFactorData{Enabled: true, RecoveryCodesLeft: left}, err
+	}
+
+	secret, err := u.us.SetupTOTP(user)
+	if err != nil {
+		return twoFactorData{}, err
+	}
+
+	png, err := qrcode.Encode(totp.URL(totpIssuer, user.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		return twoFactorData{}, err
+	}
+
+	return twoFactorData{
+		Secret: secret,
+		QRCode: template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+	}, nil
+}
+
+// pendingSession returns the session of a user
+// who still has to enter their second factor
+func pendingSession(req *http.Request) *models.Session {
+	session := context.Session(req.Context())
+	if session == nil || !session.Pending {
+		return nil
+	}
+
+	return session
+}
@@ -1,31 +1,69 @@
 package controllers
 
 import (
-	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"../../photofriends/email"
+	"../../photofriends/middelware"
 	"../../photofriends/models"
-	"../../photofriends/rand"
+	"../../photofriends/ratelimit"
 	"../../photofriends/views"
-	"github.com/gorilla/schema"
+	"../context"
+)
+
+var (
+	errSessionNotFound = views.NewPublicError("Session not found")
+	errEmailUnknown    = views.NewPublicError("No account exists for that email address")
 )
 
 // NewUsers is uused to create a new Users controller
 // this function will panic if the templates are not
 // passed correctly, and should only be used during
-// initial setup
-func NewUsers(us models.UserService) *Users {
+// initial setup. The login page offers to sign in
+// with providers, and emailed links point to baseURL
+func NewUsers(us models.UserService, ss models.SessionService, providers []models.IdentityProvider, mailer email.Mailer, baseURL string) *Users {
 	return &Users{
-		NewView:   views.NewView("layout", "users/new"),
-		LoginView: views.NewView("layout", "users/login"),
-		us:        us,
+		NewView:       views.NewView("layout", "users/new"),
+		LoginView:     views.NewView("layout", "users/login"),
+		SessionsView:  views.NewView("layout", "users/sessions"),
+		LoginsView:    views.NewView("layout", "users/logins"),
+		TwoFactorView: views.NewView("layout", "users/two_factor"),
+		ChallengeView: views.NewView("layout", "users/two_factor_challenge"),
+		ForgotView:    views.NewView("layout", "users/forgot"),
+		ResetView:     views.NewView("layout", "users/reset"),
+		VerifyView:    views.NewView("layout", "users/verify"),
+		resetEmail:    email.NewTemplate("reset"),
+		verifyEmail:   email.NewTemplate("verify"),
+		us:            us,
+		ss:            ss,
+		providers:     providers,
+		mailer:        mailer,
+		baseURL:       baseURL,
 	}
 }
 
 type Users struct {
-	NewView   *views.View
-	LoginView *views.View
-	us        models.UserService
+	NewView       *views.View
+	LoginView     *views.View
+	SessionsView  *views.View
+	LoginsView    *views.View
+	TwoFactorView *views.View
+	ChallengeView *views.View
+	ForgotView    *views.View
+	ResetView     *views.View
+	VerifyView    *views.View
+	resetEmail    *email.Template
+	verifyEmail   *email.Template
+	us            models.UserService
+	ss            models.SessionService
+	providers     []models.IdentityProvider
+	mailer        email.Mailer
+	baseURL       string
 }
 
 type SignupForm struct {
@@ -39,14 +77,13 @@ type SignupForm struct {
 //
 // POST /signup
 func (u *Users) Create(res http.ResponseWriter, req *http.Request) {
-	if err := req.ParseForm(); err != nil {
-		panic(err)
-	}
-
-	dec := schema.NewDecoder()
+	var vd views.Data
 	var form SignupForm
-	if err := dec.Decode(&form, req.PostForm); err != nil {
-		panic(err)
+	vd.Yield = &views.Form{Values: &form}
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		u.NewView.Render(res, req, vd)
+		return
 	}
 
 	user := models.User{
@@ -56,21 +93,43 @@ func (u *Users) Create(res http.ResponseWriter, req *http.Request) {
 	}
 
 	if err := u.us.Create(&user); err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		vd.SetAlert(err)
+		u.NewView.Render(res, req, vd)
 		return
 	}
 
-	err := u.signIn(res, &user)
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+	// the account exists either way, and the email can be
+	// sent again from the verify page, so this is only logged
+	if err := u.sendVerification(req, &user); err != nil {
+		log.Printf("users: sending verification to user %d: %v", user.ID, err)
+	}
+
+	if err := signIn(res, req, u.ss, &user); err != nil {
+		// the account was created, so let them log in by hand
+		http.Redirect(res, req, "/login", http.StatusFound)
 		return
 	}
-	http.Redirect(res, req, "/cookietest", http.StatusFound)
+
+	views.RedirectAlert(res, req, "/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Welcome to photofriends! We have sent you an email to verify your address.",
+	})
 }
 
 type LoginForm struct {
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
+
+	// Providers are offered to sign in with instead
+	Providers []models.IdentityProvider `schema:"-"`
+}
+
+// LoginForm renders the login page
+//
+// GET /login
+func (u *Users) LoginForm(res http.ResponseWriter, req *http.Request) {
+	vd := views.Data{Yield: &views.Form{Values: &LoginForm{Providers: u.providers}}}
+	u.LoginView.Render(res, req, vd)
 }
 
 // Login is used to verify the provided email address and password
@@ -78,69 +137,384 @@ type LoginForm struct {
 //
 // POST /login
 func (u *Users) Login(res http.ResponseWriter, req *http.Request) {
-	if err := req.ParseForm(); err != nil {
-		panic(err)
-	}
-
-	dec := schema.NewDecoder()
-	var form LoginForm
-	if err := dec.Decode(&form, req.PostForm); err != nil {
-		panic(err)
+	var vd views.Data
+	form := LoginForm{Providers: u.providers}
+	vd.Yield = &views.Form{Values: &form}
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(res, req, vd)
+		return
 	}
 
-	user, err := u.us.Authenticate(form.Email, form.Password)
+	user, err := u.us.Authenticate(form.Email, form.Password, models.LoginClient{
+		IP:        clientIP(req),
+		UserAgent: req.UserAgent(),
+	})
 	if err != nil {
 		switch err {
 		case models.ErrNotFound:
-			fmt.Fprintln(res, "Invalid email address.")
-		case models.ErrPasswordIncorrect:
-			fmt.Fprintln(res, "Invalid password provided.")
-		default:
-			http.Error(res, err.Error(), http.StatusInternalServerError)
+			err = models.FieldErrors{"email": errEmailUnknown}
+		case models.ErrEmailRequired, models.ErrEmailInvalid:
+			err = models.FieldErrors{"email": err}
+		case models.ErrPasswordIncorrect, models.ErrPasswordNotSet:
+			err = models.FieldErrors{"password": err}
 		}
+		renderAuthError(res, req, u.LoginView, vd, err)
+		return
 	}
 
-	err = u.signIn(res, user)
+	next, err := logIn(res, req, u.ss, user)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		vd.SetAlert(err)
+		u.LoginView.Render(res, req, vd)
 		return
 	}
-	http.Redirect(res, req, "/cookietest", http.StatusFound)
+
+	http.Redirect(res, req, next, http.StatusFound)
+}
+
+// renderAuthError renders view with err. When the visitor is
+// being rate limited it responds 429 with a Retry-After header
+func renderAuthError(res http.ResponseWriter, req *http.Request, view *views.View, vd views.Data, err error) {
+	vd.SetAlert(err)
+	if limited, ok := err.(*ratelimit.LimitedError); ok {
+		seconds := int(limited.RetryAfter.Seconds()) + 1
+		res.Header().Set("Retry-After", strconv.Itoa(seconds))
+		view.RenderStatus(res, req, http.StatusTooManyRequests, vd)
+		return
+	}
+
+	view.Render(res, req, vd)
+}
+
+type ForgotForm struct {
+	Email string `schema:"email"`
+}
+
+// forgotData is used to render the forgot password page
+type forgotData struct {
+	Sent bool
 }
 
-// signIn is used to sign in the given user in via cookies
-func (u *Users) signIn(res http.ResponseWriter, user *models.User) error {
-	if user.Remember == "" {
-		token, err := rand.RememberToken()
+// Forgot emails a password reset link to the posted email
+// address. The same page is shown whether or not an account
+// exists, so it can not be used to find out who is signed up
+//
+// POST /forgot
+func (u *Users) Forgot(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	var form ForgotForm
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		u.ForgotView.Render(res, req, vd)
+		return
+	}
+
+	token, user, err := u.us.InitiateReset(form.Email)
+	switch err {
+	case nil:
+		err = u.sendEmail(u.resetEmail, user.Email, emailData{
+			Name: user.Name,
+			Link: email.Link(u.baseURL, "/reset", url.Values{"token": {token}}),
+		})
 		if err != nil {
-			return err
+			vd.SetAlert(err)
+			u.ForgotView.Render(res, req, vd)
+			return
+		}
+	case models.ErrNotFound, models.ErrEmailInvalid, models.ErrEmailRequired:
+		// pretend we sent it
+	default:
+		vd.SetAlert(err)
+		u.ForgotView.Render(res, req, vd)
+		return
+	}
+
+	vd.Yield = forgotData{Sent: true}
+	u.ForgotView.Render(res, req, vd)
+}
+
+type ResetForm struct {
+	Token    string `schema:"token"`
+	Password string `schema:"password"`
+}
+
+// ResetForm shows the form to pick a new password
+//
+// GET /reset?token=...
+func (u *Users) ResetForm(res http.ResponseWriter, req *http.Request) {
+	u.ResetView.Render(res, req, &views.Form{
+		Values: ResetForm{Token: req.URL.Query().Get("token")},
+	})
+}
+
+// Reset sets the new password, then signs the user in
+// with a fresh session, as every other one was revoked
+//
+// POST /reset
+func (u *Users) Reset(res http.ResponseWriter, req *http.Request) {
+	var vd views.Data
+	var form ResetForm
+	vd.Yield = &views.Form{Values: &form}
+	if err := parseForm(req, &form); err != nil {
+		vd.SetAlert(err)
+		u.ResetView.Render(res, req, vd)
+		return
+	}
+
+	user, err := u.us.CompleteReset(form.Token, form.Password)
+	if err != nil {
+		vd.SetAlert(err)
+		u.ResetView.Render(res, req, vd)
+		return
+	}
+
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your password has been changed, and every other device has been logged out.",
+	}
+	next, err := logIn(res, req, u.ss, user)
+	if err != nil {
+		views.RedirectAlert(res, req, "/login", http.StatusFound, alert)
+		return
+	}
+
+	views.RedirectAlert(res, req, next, http.StatusFound, alert)
+}
+
+// verifyData is used to render the verify page
+type verifyData struct {
+	Email string
+	Sent  bool
+}
+
+// Verify marks the email address of the user the token in
+// the link was sent to as verified. Without a token it shows
+// the page to request a new link
+//
+// GET /verify?token=...
+func (u *Users) Verify(res http.ResponseWriter, req *http.Request) {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		user := context.User(req.Context())
+		if user == nil {
+			http.Redirect(res, req, "/login", http.StatusFound)
+			return
+		}
+
+		if user.Verified() {
+			http.Redirect(res, req, "/galleries", http.StatusFound)
+			return
 		}
 
-		user.Remember = token
-		err = u.us.Update(user)
+		u.VerifyView.Render(res, req, verifyData{Email: user.Email})
+		return
+	}
+
+	if _, err := u.us.CompleteVerification(token); err != nil {
+		views.Error(res, req, http.StatusBadRequest, err)
+		return
+	}
+
+	views.RedirectAlert(res, req, "/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Thanks, your email address is verified.",
+	})
+}
+
+// ResendVerification emails the current user a new
+// verification link, the earlier one stops working
+//
+// POST /verify/resend
+func (u *Users) ResendVerification(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	vd := views.Data{Yield: verifyData{Email: user.Email}}
+	if err := u.sendVerification(req, user); err != nil {
+		if err == models.ErrEmailVerified {
+			http.Redirect(res, req, "/galleries", http.StatusFound)
+			return
+		}
+
+		vd.SetAlert(err)
+		u.VerifyView.Render(res, req, vd)
+		return
+	}
+
+	vd.Yield = verifyData{Email: user.Email, Sent: true}
+	u.VerifyView.Render(res, req, vd)
+}
+
+// sendVerification emails the user a link to verify their address
+func (u *Users) sendVerification(req *http.Request, user *models.User) error {
+	token, err := u.us.InitiateVerification(user)
+	if err != nil {
+		return err
+	}
+
+	return u.sendEmail(u.verifyEmail, user.Email, emailData{
+		Name: user.Name,
+		Link: email.Link(u.baseURL, "/verify", url.Values{"token": {token}}),
+	})
+}
+
+// Logout ends the session of the current device
+//
+// POST /logout
+func (u *Users) Logout(res http.ResponseWriter, req *http.Request) {
+	if session := context.Session(req.Context()); session != nil {
+		u.ss.Delete(session.ID)
 	}
 
 	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    user.Remember,
+		Name:     middelware.SessionCookie,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
 		HttpOnly: true,
 	}
 	http.SetCookie(res, &cookie)
-	return nil
+	http.Redirect(res, req, "/", http.StatusFound)
+}
+
+// sessionRow is a session as shown on the sessions page
+type sessionRow struct {
+	models.Session
+	Current bool
+}
+
+// Sessions lists every device the current user is logged in on
+//
+// GET /sessions
+func (u *Users) Sessions(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	current := context.Session(req.Context())
+
+	sessions, err := u.ss.ByUserID(user.ID)
+	if err != nil {
+		views.Error(res, req, http.StatusInternalServerError, err)
+		return
+	}
+
+	rows := make([]sessionRow, len(sessions))
+	for i, session := range sessions {
+		rows[i] = sessionRow{
+			Session: session,
+			Current: current != nil && current.ID == session.ID,
+		}
+	}
+
+	u.SessionsView.Render(res, req, rows)
 }
 
-// CookieTest is used to display cookies on the current user
-func (u *Users) CookieTest(res http.ResponseWriter, req *http.Request) {
-	cookie, err := req.Cookie("remember_token")
+// LoginAttempts lists the latest attempts to log in as the
+// current user, so they can spot someone guessing their password
+//
+// GET /logins
+func (u *Users) LoginAttempts(res http.ResponseWriter, req *http.Request) {
+	user := context.User(req.Context())
+	attempts, err := u.us.LoginAttempts(user.ID)
 	if err != nil {
-		http.Redirect(res, req, "/login", http.StatusFound)
+		views.Error(res, req, http.StatusInternalServerError, err)
 		return
 	}
 
-	user, err := u.us.ByRemember(cookie.Value)
+	u.LoginsView.Render(res, req, attempts)
+}
+
+// RevokeSession logs out one of the devices of the current user
+//
+// POST /sessions/:id/revoke
+func (u *Users) RevokeSession(res http.ResponseWriter, req *http.Request) {
+	id, err := idVar(req, "id")
 	if err != nil {
-		http.Redirect(res, req, "/login", http.StatusFound)
+		views.RedirectError(res, req, "/sessions", errSessionNotFound)
 		return
 	}
-	fmt.Fprintln(res, user)
+
+	user := context.User(req.Context())
+	if err := u.ss.Revoke(user.ID, id); err != nil {
+		if err == models.ErrNotFound {
+			err = errSessionNotFound
+		}
+
+		views.RedirectError(res, req, "/sessions", err)
+		return
+	}
+
+	views.RedirectAlert(res, req, "/sessions", http.StatusFound, views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The device has been logged out",
+	})
+}
+
+// signIn starts a new session for the user on this
+// device and stores its token in the session cookie
+func signIn(res http.ResponseWriter, req *http.Request, ss models.SessionService, user *models.User) error {
+	session, err := ss.Start(user, req.UserAgent(), clientIP(req))
+	if err != nil {
+		return err
+	}
+
+	setSessionCookie(res, session)
+	return nil
+}
+
+// logIn is used once the password of the user was checked,
+// or they signed in with a provider. Users with two factor
+// auth get a pending session and still have to enter a code,
+// everyone else is signed in. The page to continue on is
+// returned
+func logIn(res http.ResponseWriter, req *http.Request, ss models.SessionService, user *models.User) (string, error) {
+	if !user.TOTPEnabled() {
+		return "/galleries", signIn(res, req, ss, user)
+	}
+
+	session, err := ss.StartPending(user, req.UserAgent(), clientIP(req))
+	if err != nil {
+		return "", err
+	}
+
+	setSessionCookie(res, session)
+	return "/login/2fa", nil
+}
+
+// setSessionCookie stores the token of the session in the
+// session cookie, which expires together with the session
+func setSessionCookie(res http.ResponseWriter, session *models.Session) {
+	cookie := http.Cookie{
+		Name:     middelware.SessionCookie,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+	}
+	http.SetCookie(res, &cookie)
+}
+
+// emailData is what the email templates are rendered with
+type emailData struct {
+	Name string
+	From string
+	Link string
+}
+
+// sendEmail renders tpl for the recipient and sends it
+func (u *Users) sendEmail(tpl *email.Template, to string, data emailData) error {
+	msg, err := tpl.Message(to, data)
+	if err != nil {
+		return err
+	}
+
+	return u.mailer.Send(msg)
+}
+
+// clientIP returns the IP address the request came from
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
 }
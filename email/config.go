@@ -0,0 +1,41 @@
+package email
+
+import "fmt"
+
+// Config selects and configures the Mailer backend
+type Config struct {
+	// Backend is one of "smtp", "file" or "memory"
+	Backend string `json:"backend" toml:"backend"`
+
+	// From is used for messages that do not set one
+	From string `json:"from" toml:"from"`
+
+	// Dir is where the file backend writes .eml files
+	Dir string `json:"dir" toml:"dir"`
+
+	SMTP SMTPConfig `json:"smtp" toml:"smtp"`
+}
+
+// DefaultConfig writes emails to ./tmp/mail/
+// rather than sending them anywhere
+func DefaultConfig() Config {
+	return Config{
+		Backend: "file",
+		From:    "photofriends <support@photofriends.com>",
+		Dir:     "tmp/mail",
+	}
+}
+
+// New creates the Mailer described by cfg
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Backend {
+	case "", "file":
+		return NewFile(cfg.Dir, cfg.From), nil
+	case "memory":
+		return NewOutbox(cfg.From), nil
+	case "smtp":
+		return NewSMTP(cfg.SMTP, cfg.From), nil
+	default:
+		return nil, fmt.Errorf("email: unknown backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,110 @@
+package email
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// NewFile creates a Mailer that writes every message as
+// a .eml file into dir instead of sending it. Most mail
+// clients can open these files, which makes it easy to
+// check what emails look like while developing
+func NewFile(dir, from string) *File {
+	return &File{
+		dir:  dir,
+		from: from,
+	}
+}
+
+// ensure interface is matching
+var _ Mailer = &File{}
+
+// File is a Mailer that saves messages to disk
+type File struct {
+	dir  string
+	from string
+}
+
+func (f *File) Send(msg Message) error {
+	if msg.From == "" {
+		msg.From = f.from
+	}
+
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), sanitize(msg.To))
+	return ioutil.WriteFile(filepath.Join(f.dir, name), b, 0644)
+}
+
+// NewOutbox creates a Mailer that keeps messages in
+// memory, so tests can check what would have been sent
+func NewOutbox(from string) *Outbox {
+	return &Outbox{from: from}
+}
+
+// ensure interface is matching
+var _ Mailer = &Outbox{}
+
+// Outbox is an in-memory Mailer for tests
+type Outbox struct {
+	mu       sync.Mutex
+	from     string
+	messages []Message
+}
+
+func (o *Outbox) Send(msg Message) error {
+	if msg.From == "" {
+		msg.From = o.from
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// Messages returns every message sent so far, oldest first
+func (o *Outbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
+
+// Last returns the most recently sent message
+func (o *Outbox) Last() (Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.messages) == 0 {
+		return Message{}, false
+	}
+
+	return o.messages[len(o.messages)-1], true
+}
+
+// sanitize keeps the characters of s that are
+// safe to use in a file name
+func sanitize(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '.', c == '-', c == '_', c == '@':
+		default:
+			b[i] = '_'
+		}
+	}
+
+	return string(b)
+}
@@ -0,0 +1,129 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"../../photofriends/rand"
+)
+
+// Message is a single email sent by the app. Messages with
+// both a Text and an HTML body are sent as multipart, so
+// mail clients can pick the version they prefer
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Link builds a full URL to path under baseURL, the
+// configured address of the app, for use in emails.
+// Links are never built from the Host header of a
+// request, so nobody can make them point elsewhere
+func Link(baseURL, path string, query url.Values) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		u = &url.URL{}
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Bytes renders the message in the RFC 5322 format
+// that is sent over SMTP or saved as a .eml file
+func (m Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.write(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (m Message) write(w *bytes.Buffer) error {
+	id, err := rand.String(16)
+	if err != nil {
+		return err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("From", m.From)
+	header.Set("To", m.To)
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("Message-ID", fmt.Sprintf("<%s@photofriends>", id))
+	header.Set("MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		writeHeader(w, header)
+		return writeQP(w, m.Text)
+	}
+
+	mw := multipart.NewWriter(w)
+	header.Set("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
+	writeHeader(w, header)
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := writeQP(pw, p.body); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
+
+// writeHeader writes the header fields in a stable
+// order followed by the blank line ending the header
+func writeHeader(w *bytes.Buffer, header textproto.MIMEHeader) {
+	order := []string{"From", "To", "Subject", "Date", "Message-Id", "Mime-Version",
+		"Content-Type", "Content-Transfer-Encoding"}
+
+	for _, name := range order {
+		if v := header.Get(name); v != "" {
+			fmt.Fprintf(w, "%s: %s\r\n", name, v)
+		}
+	}
+
+	w.WriteString("\r\n")
+}
+
+func writeQP(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+
+	return qp.Close()
+}
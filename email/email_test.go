@@ -0,0 +1,240 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parts parses a rendered message and returns its
+// bodies keyed by content type
+func parts(t *testing.T, raw []byte) (*mail.Message, map[string]string) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := make(map[string]string)
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		b, _ := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+		bodies[mediaType] = string(b)
+		return msg, bodies
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+
+		ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		b, _ := ioutil.ReadAll(p)
+		bodies[ct] = string(b)
+	}
+
+	return msg, bodies
+}
+
+func TestMessageMultipart(t *testing.T) {
+	raw, err := Message{
+		From:    "support@photofriends.com",
+		To:      "michael@dundermifflin.com",
+		Subject: "Hej på deg",
+		Text:    "Plain body",
+		HTML:    "<p>HTML body</p>",
+	}.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, bodies := parts(t, raw)
+
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if subject != "Hej på deg" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+
+	if bodies["text/plain"] != "Plain body" || bodies["text/html"] != "<p>HTML body</p>" {
+		t.Errorf("Unexpected bodies %q", bodies)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photofriends-email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "layouts"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "layouts", "email.gohtml"),
+		[]byte(`{{define "email"}}<body>{{template "html" .}}</body>{{end}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "hello.gohtml"), []byte(
+		`{{define "subject"}}Hello {{.}}{{end}}`+
+			`{{define "text"}}Hi {{.}} & co{{end}}`+
+			`{{define "html"}}<p>Hi {{.}} & co</p>{{end}}`), 0644)
+
+	templateDir, layoutDir = dir+"/", dir+"/layouts/"
+	defer func() { templateDir, layoutDir = "views/emails/", "views/emails/layouts/" }()
+
+	msg, err := NewTemplate("hello").Message("a@b.com", "<Jim>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Subject != "Hello <Jim>" || msg.Text != "Hi <Jim> & co\n" {
+		t.Errorf("Unexpected subject or text %q %q", msg.Subject, msg.Text)
+	}
+
+	if msg.HTML != "<body><p>Hi &lt;Jim&gt; & co</p></body>" {
+		t.Errorf("Expected the HTML body to be escaped. Recieved %q", msg.HTML)
+	}
+}
+
+func TestLink(t *testing.T) {
+	cases := []struct {
+		base, path string
+		query      url.Values
+		want       string
+	}{
+		{"https://photofriends.example", "/friends", nil, "https://photofriends.example/friends"},
+		{"https://photofriends.example/", "/reset", url.Values{"token": {"a b"}}, "https://photofriends.example/reset?token=a+b"},
+		{"https://example.com/photos", "/verify", nil, "https://example.com/photos/verify"},
+	}
+
+	for _, c := range cases {
+		if got := Link(c.base, c.path, c.query); got != c.want {
+			t.Errorf("Link(%q, %q): Expected %s. Recieved %s", c.base, c.path, c.want, got)
+		}
+	}
+}
+
+func TestOutboxAndFile(t *testing.T) {
+	outbox := NewOutbox("support@photofriends.com")
+	outbox.Send(Message{To: "a@b.com", Subject: "One"})
+	outbox.Send(Message{To: "a@b.com", Subject: "Two"})
+
+	last, ok := outbox.Last()
+	if !ok || last.Subject != "Two" || last.From != "support@photofriends.com" || len(outbox.Messages()) != 2 {
+		t.Errorf("Unexpected outbox %v", outbox.Messages())
+	}
+
+	dir, err := ioutil.TempDir("", "photofriends-email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := NewFile(dir, "support@photofriends.com").Send(Message{To: "a@b.com", Text: "Body"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one .eml file. Recieved %v", files)
+	}
+
+	raw, _ := ioutil.ReadFile(files[0])
+	if _, bodies := parts(t, raw); bodies["text/plain"] != "Body" {
+		t.Errorf("Unexpected file contents %q", raw)
+	}
+}
+
+// fakeSMTP accepts a single message and returns it on the channel
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 fake ESMTP")
+
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 fake")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return l.Addr().String(), received
+}
+
+func TestSMTP(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewSMTP(SMTPConfig{Host: host, Port: p}, "support@photofriends.com").Send(Message{
+		To:      "a@b.com",
+		Subject: "Hi",
+		Text:    "Plain",
+		HTML:    "<p>HTML</p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, bodies := parts(t, []byte(<-received))
+	if msg.Header.Get("From") != "support@photofriends.com" || bodies["text/html"] != "<p>HTML</p>" {
+		t.Errorf("Unexpected message %v %q", msg.Header, bodies)
+	}
+}
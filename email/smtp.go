@@ -0,0 +1,52 @@
+package email
+
+import (
+	"fmt"
+	"net/smtp"
+)
+
+// SMTPConfig is used to connect to an SMTP server
+type SMTPConfig struct {
+	Host     string `json:"host" toml:"host"`
+	Port     int    `json:"port" toml:"port"`
+	Username string `json:"username" toml:"username"`
+	Password string `json:"password" toml:"password"`
+}
+
+// NewSMTP creates a Mailer that delivers messages through
+// an SMTP server. Messages without a From use from
+func NewSMTP(cfg SMTPConfig, from string) *SMTP {
+	s := &SMTP{
+		addr: fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		from: from,
+	}
+
+	if cfg.Username != "" {
+		s.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	return s
+}
+
+// ensure interface is matching
+var _ Mailer = &SMTP{}
+
+// SMTP is a Mailer sending messages to an SMTP server
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func (s *SMTP) Send(msg Message) error {
+	if msg.From == "" {
+		msg.From = s.from
+	}
+
+	b, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(s.addr, s.auth, msg.From, []string{msg.To}, b)
+}
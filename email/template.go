@@ -0,0 +1,81 @@
+package email
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+var (
+	templateDir = "views/emails/"
+	layoutDir   = "views/emails/layouts/"
+	templateExt = ".gohtml"
+)
+
+// NewTemplate loads the email template with the given name,
+// eg: "reset" loads views/emails/reset.gohtml along with the
+// email layouts. A template defines a "subject", a "text"
+// and an "html" block. Just like views.NewView, this will
+// panic if the template can not be parsed, so it should
+// only be used during initial setup
+func NewTemplate(name string) *Template {
+	files := append([]string{templateDir + name + templateExt}, layoutFiles()...)
+
+	text, err := texttemplate.ParseFiles(files...)
+	if err != nil {
+		panic(err)
+	}
+
+	html, err := htmltemplate.ParseFiles(files...)
+	if err != nil {
+		panic(err)
+	}
+
+	return &Template{
+		text: text,
+		html: html,
+	}
+}
+
+// Template renders the subject and bodies of a message.
+// The text body is rendered without HTML escaping, while
+// the HTML body is escaped like any other view
+type Template struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Message renders the template with data into a message to to
+func (t *Template) Message(to string, data interface{}) (Message, error) {
+	var subject, text, html bytes.Buffer
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+
+	if err := t.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return Message{}, err
+	}
+
+	if err := t.html.ExecuteTemplate(&html, "email", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+// layoutFiles returns the email layouts using globbing
+func layoutFiles() []string {
+	files, err := filepath.Glob(layoutDir + "*" + templateExt)
+	if err != nil {
+		panic(err)
+	}
+
+	return files
+}
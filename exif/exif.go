@@ -0,0 +1,270 @@
+package exif
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNoExif is returned when a JPEG does
+	// not contain an EXIF segment
+	ErrNoExif = errors.New("exif: no exif data found")
+
+	// ErrNotJPEG is returned when the data
+	// does not start with a JPEG marker
+	ErrNotJPEG = errors.New("exif: not a jpeg")
+
+	// ErrMalformed is returned when the EXIF
+	// segment is truncated or otherwise broken
+	ErrMalformed = errors.New("exif: malformed exif data")
+)
+
+// JPEG markers we care about
+const (
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerAPP1 = 0xE1
+)
+
+// TIFF tags read from IFD0, the EXIF IFD and the GPS IFD
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagLensMake         = 0xA433
+	tagLensModel        = 0xA434
+
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+)
+
+// exifHeader starts the APP1 segment holding EXIF data
+var exifHeader = []byte("Exif\x00\x00")
+
+// Exif holds the fields we read from a photo
+type Exif struct {
+	Make      string
+	Model     string
+	LensMake  string
+	LensModel string
+
+	ExposureTime Rational
+	FNumber      float64
+	ISO          int
+	FocalLength  float64
+
+	// CapturedAt is the zero time if the
+	// camera did not record a capture time
+	CapturedAt time.Time
+
+	// Orientation is the TIFF orientation tag, 1 through 8.
+	// It is 1 when the image is already stored upright
+	Orientation int
+
+	HasGPS    bool
+	Latitude  float64
+	Longitude float64
+}
+
+// Rational is an unsigned TIFF fraction, eg: 1/250
+type Rational struct {
+	Num uint32
+	Den uint32
+}
+
+// Float returns the rational as a float, or 0 if it is undefined
+func (r Rational) Float() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String formats exposure times the way photographers
+// expect them, eg: "1/250" for short and "2" for long ones
+func (r Rational) String() string {
+	if r.Num == 0 || r.Den == 0 {
+		return ""
+	}
+
+	if r.Num < r.Den {
+		return fmt.Sprintf("1/%d", int(math.Round(float64(r.Den)/float64(r.Num))))
+	}
+
+	return fmt.Sprintf("%g", r.Float())
+}
+
+// Decode reads the EXIF data from a JPEG. It stops reading at
+// the EXIF segment, so only the start of r is ever consumed
+func Decode(r io.Reader) (*Exif, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return nil, ErrNotJPEG
+	}
+
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return nil, err
+		}
+
+		if marker == markerSOS || marker == markerEOI {
+			return nil, ErrNoExif
+		}
+
+		payload, err := readSegment(br)
+		if err != nil {
+			return nil, err
+		}
+
+		if marker == markerAPP1 && strings.HasPrefix(string(payload), string(exifHeader)) {
+			return parseTIFF(payload[len(exifHeader):])
+		}
+	}
+}
+
+// nextMarker reads the next marker, skipping any fill bytes
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, ErrMalformed
+	}
+
+	if b != 0xFF {
+		return 0, ErrMalformed
+	}
+
+	for b == 0xFF {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, ErrMalformed
+		}
+	}
+
+	return b, nil
+}
+
+// readSegment reads the length prefixed payload of a segment
+func readSegment(br *bufio.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(br, length[:]); err != nil {
+		return nil, ErrMalformed
+	}
+
+	n := int(binary.BigEndian.Uint16(length[:])) - 2
+	if n < 0 {
+		return nil, ErrMalformed
+	}
+
+	payload, err := ioutil.ReadAll(io.LimitReader(br, int64(n)))
+	if err != nil || len(payload) != n {
+		return nil, ErrMalformed
+	}
+
+	return payload, nil
+}
+
+// parseTIFF reads the fields we care about from the TIFF
+// structure that makes up the body of the EXIF segment
+func parseTIFF(b []byte) (*Exif, error) {
+	if len(b) < 8 {
+		return nil, ErrMalformed
+	}
+
+	t := tiff{data: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, ErrMalformed
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(b[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Exif{
+		Make:        ifd0.string(tagMake),
+		Model:       ifd0.string(tagModel),
+		Orientation: ifd0.int(tagOrientation),
+		CapturedAt:  parseDateTime(ifd0.string(tagDateTime)),
+	}
+
+	if x.Orientation < 1 || x.Orientation > 8 {
+		x.Orientation = 1
+	}
+
+	if off, ok := ifd0.uint32(tagExifIFD); ok {
+		sub, err := t.ifd(off)
+		if err != nil {
+			return nil, err
+		}
+
+		x.LensMake = sub.string(tagLensMake)
+		x.LensModel = sub.string(tagLensModel)
+		x.ExposureTime = sub.rational(tagExposureTime, 0)
+		x.FNumber = sub.rational(tagFNumber, 0).Float()
+		x.ISO = sub.int(tagISO)
+		x.FocalLength = sub.rational(tagFocalLength, 0).Float()
+		if captured := parseDateTime(sub.string(tagDateTimeOriginal)); !captured.IsZero() {
+			x.CapturedAt = captured
+		}
+	}
+
+	if off, ok := ifd0.uint32(tagGPSIFD); ok {
+		gps, err := t.ifd(off)
+		if err != nil {
+			return nil, err
+		}
+
+		lat, latOK := gps.degrees(tagGPSLatitude)
+		lng, lngOK := gps.degrees(tagGPSLongitude)
+		if latOK && lngOK {
+			if gps.string(tagGPSLatitudeRef) == "S" {
+				lat = -lat
+			}
+			if gps.string(tagGPSLongitudeRef) == "W" {
+				lng = -lng
+			}
+
+			x.HasGPS = true
+			x.Latitude = lat
+			x.Longitude = lng
+		}
+	}
+
+	return x, nil
+}
+
+// parseDateTime parses the EXIF "2006:01:02 15:04:05" format.
+// EXIF times carry no zone, so they are read as UTC
+func parseDateTime(s string) time.Time {
+	t, err := time.Parse("2006:01:02 15:04:05", s)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
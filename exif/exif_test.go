@@ -0,0 +1,202 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+// testEntry is an IFD entry used to build test EXIF data
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// buildTIFF lays out a little endian TIFF with IFD0, an EXIF
+// IFD and a GPS IFD, placing values that do not fit in an
+// entry after each directory
+func buildTIFF(ifd0, exifIFD, gpsIFD []testEntry) []byte {
+	le := binary.LittleEndian
+	buf := []byte{'I', 'I', 42, 0, 8, 0, 0, 0}
+
+	writeIFD := func(entries []testEntry) uint32 {
+		start := uint32(len(buf))
+		dataOff := start + 2 + uint32(len(entries))*12 + 4
+		dir := make([]byte, 2, dataOff-start)
+		le.PutUint16(dir, uint16(len(entries)))
+
+		var data []byte
+		for _, e := range entries {
+			entry := make([]byte, 12)
+			le.PutUint16(entry[0:], e.tag)
+			le.PutUint16(entry[2:], e.typ)
+			le.PutUint32(entry[4:], e.count)
+			if len(e.value) <= 4 {
+				copy(entry[8:], e.value)
+			} else {
+				le.PutUint32(entry[8:], dataOff+uint32(len(data)))
+				data = append(data, e.value...)
+			}
+			dir = append(dir, entry...)
+		}
+
+		dir = append(dir, 0, 0, 0, 0)
+		buf = append(buf, dir...)
+		buf = append(buf, data...)
+		return start
+	}
+
+	// IFD0 is written last so the sub IFD offsets are known
+	exifOff := writeIFD(exifIFD)
+	gpsOff := writeIFD(gpsIFD)
+	ifd0 = append(ifd0,
+		testEntry{tagExifIFD, typeLong, 1, u32(exifOff)},
+		testEntry{tagGPSIFD, typeLong, 1, u32(gpsOff)})
+	le.PutUint32(buf[4:], writeIFD(ifd0))
+
+	return buf
+}
+
+func ascii(s string) testEntry {
+	return testEntry{typ: typeASCII, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func withTag(tag uint16, e testEntry) testEntry {
+	e.tag = tag
+	return e
+}
+
+func u16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func rationals(vals ...uint32) []byte {
+	var b []byte
+	for _, v := range vals {
+		b = append(b, u32(v)...)
+	}
+	return b
+}
+
+// testJPEG encodes a small image and inserts an APP1
+// segment holding tiffData right after the SOI marker
+func testJPEG(t *testing.T, tiffData []byte) []byte {
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), tiffData...)
+	app1 := []byte{0xFF, markerAPP1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(payload)+2))
+
+	out := append([]byte{}, img.Bytes()[:2]...)
+	out = append(out, app1...)
+	out = append(out, payload...)
+	return append(out, img.Bytes()[2:]...)
+}
+
+func testExifJPEG(t *testing.T) []byte {
+	tiffData := buildTIFF(
+		[]testEntry{
+			withTag(tagMake, ascii("Canon")),
+			withTag(tagModel, ascii("Canon EOS 80D")),
+			{tagOrientation, typeShort, 1, u16(6)},
+		},
+		[]testEntry{
+			{tagExposureTime, typeRational, 1, rationals(1, 250)},
+			{tagFNumber, typeRational, 1, rationals(28, 10)},
+			{tagISO, typeShort, 1, u16(400)},
+			withTag(tagDateTimeOriginal, ascii("2019:04:20 13:37:00")),
+			{tagFocalLength, typeRational, 1, rationals(50, 1)},
+			withTag(tagLensModel, ascii("EF50mm f/1.8 STM")),
+		},
+		[]testEntry{
+			withTag(tagGPSLatitudeRef, ascii("N")),
+			{tagGPSLatitude, typeRational, 3, rationals(59, 1, 54, 1, 36, 1)},
+			withTag(tagGPSLongitudeRef, ascii("E")),
+			{tagGPSLongitude, typeRational, 3, rationals(10, 1, 45, 1, 0, 1)},
+		},
+	)
+
+	return testJPEG(t, tiffData)
+}
+
+func TestDecode(t *testing.T) {
+	x, err := Decode(bytes.NewReader(testExifJPEG(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x.Make != "Canon" || x.Model != "Canon EOS 80D" || x.LensModel != "EF50mm f/1.8 STM" {
+		t.Errorf("Unexpected camera %q %q %q", x.Make, x.Model, x.LensModel)
+	}
+
+	if x.ExposureTime.String() != "1/250" || x.FNumber != 2.8 || x.ISO != 400 || x.FocalLength != 50 {
+		t.Errorf("Unexpected exposure %s f/%g ISO %d %gmm", x.ExposureTime, x.FNumber, x.ISO, x.FocalLength)
+	}
+
+	if want := time.Date(2019, 4, 20, 13, 37, 0, 0, time.UTC); !x.CapturedAt.Equal(want) {
+		t.Errorf("Expected CapturedAt %s. Recieved %s", want, x.CapturedAt)
+	}
+
+	if x.Orientation != 6 {
+		t.Errorf("Expected orientation 6. Recieved %d", x.Orientation)
+	}
+
+	if !x.HasGPS || x.Latitude < 59.909 || x.Latitude > 59.911 || x.Longitude != 10.75 {
+		t.Errorf("Unexpected GPS %v %f,%f", x.HasGPS, x.Latitude, x.Longitude)
+	}
+}
+
+func TestDecodeWithoutExif(t *testing.T) {
+	var img bytes.Buffer
+	jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 1, 1)), nil)
+
+	if _, err := Decode(&img); err != ErrNoExif {
+		t.Errorf("Expected ErrNoExif. Recieved %v", err)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	var out bytes.Buffer
+	if err := Strip(&out, bytes.NewReader(testExifJPEG(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(bytes.NewReader(out.Bytes())); err != ErrNoExif {
+		t.Errorf("Expected ErrNoExif after stripping. Recieved %v", err)
+	}
+
+	if _, err := jpeg.Decode(&out); err != nil {
+		t.Errorf("Stripped image no longer decodes: %v", err)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	img.Pix[3] = 0xFF // alpha of the top left pixel
+
+	rotated := Orient(img, 6)
+	if b := rotated.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("Expected 2x4. Recieved %dx%d", b.Dx(), b.Dy())
+	}
+
+	// rotating clockwise moves the top left pixel to the top right
+	if _, _, _, a := rotated.At(1, 0).RGBA(); a == 0 {
+		t.Error("Expected the top left pixel to end up top right")
+	}
+}
@@ -0,0 +1,71 @@
+package exif
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// Strip copies the JPEG read from r to w, leaving out every
+// APP1 (EXIF and XMP) and APP13 (IPTC) segment. These hold
+// GPS positions, serial numbers and other private data. The
+// image data itself is copied as is, so nothing is re-encoded
+func Strip(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return ErrNotJPEG
+	}
+
+	if _, err := w.Write(soi[:]); err != nil {
+		return err
+	}
+
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return err
+		}
+
+		if marker == markerSOS || marker == markerEOI {
+			// everything from here on is image data
+			if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+				return err
+			}
+
+			_, err := io.Copy(w, br)
+			return err
+		}
+
+		payload, err := readSegment(br)
+		if err != nil {
+			return err
+		}
+
+		if marker == markerAPP1 || marker == 0xED {
+			continue
+		}
+
+		header := []byte{0xFF, marker, 0, 0}
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+	}
+}
+
+// Stripped returns a reader of the JPEG read from r with
+// its private segments left out, see Strip. The reader
+// must be closed once the caller is done with it
+func Stripped(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(Strip(pw, r))
+	}()
+
+	return pr
+}
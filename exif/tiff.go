@@ -0,0 +1,147 @@
+package exif
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// TIFF field types
+const (
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+)
+
+// typeSizes is the size in bytes of a single value of each type
+var typeSizes = map[uint16]uint32{
+	typeByte:      1,
+	typeASCII:     1,
+	typeShort:     2,
+	typeLong:      4,
+	typeRational:  8,
+	typeUndefined: 1,
+}
+
+// tiff is the TIFF structure inside an EXIF segment.
+// Every offset in it is relative to the start of data
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// field is a single IFD entry with its raw value bytes
+type field struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// ifd maps tags to the fields of one image file directory
+type ifd struct {
+	fields map[uint16]field
+	order  binary.ByteOrder
+}
+
+// ifd reads the directory starting at offset. Fields of
+// unknown types or pointing outside the data are skipped
+func (t tiff) ifd(offset uint32) (ifd, error) {
+	dir := ifd{fields: make(map[uint16]field), order: t.order}
+	if uint64(offset)+2 > uint64(len(t.data)) {
+		return dir, ErrMalformed
+	}
+
+	n := uint32(t.order.Uint16(t.data[offset:]))
+	start := offset + 2
+	if uint64(start)+uint64(n)*12 > uint64(len(t.data)) {
+		return dir, ErrMalformed
+	}
+
+	for i := uint32(0); i < n; i++ {
+		entry := t.data[start+i*12 : start+i*12+12]
+		tag := t.order.Uint16(entry[0:])
+		typ := t.order.Uint16(entry[2:])
+		count := t.order.Uint32(entry[4:])
+
+		size, ok := typeSizes[typ]
+		if !ok || count > uint32(len(t.data)) {
+			continue
+		}
+
+		total := uint64(size) * uint64(count)
+		value := entry[8:12]
+		if total > 4 {
+			off := uint64(t.order.Uint32(entry[8:]))
+			if off+total > uint64(len(t.data)) {
+				continue
+			}
+			value = t.data[off : off+total]
+		}
+
+		dir.fields[tag] = field{typ: typ, count: count, value: value[:total]}
+	}
+
+	return dir, nil
+}
+
+// string returns an ASCII field without its trailing NULs
+func (d ifd) string(tag uint16) string {
+	f, ok := d.fields[tag]
+	if !ok || (f.typ != typeASCII && f.typ != typeUndefined) {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(f.value), "\x00"))
+}
+
+// uint32 returns the first value of a SHORT or LONG field
+func (d ifd) uint32(tag uint16) (uint32, bool) {
+	f, ok := d.fields[tag]
+	if !ok || f.count == 0 {
+		return 0, false
+	}
+
+	switch f.typ {
+	case typeShort:
+		return uint32(d.order.Uint16(f.value)), true
+	case typeLong:
+		return d.order.Uint32(f.value), true
+	default:
+		return 0, false
+	}
+}
+
+// int is like uint32, but returns 0 for missing fields
+func (d ifd) int(tag uint16) int {
+	v, _ := d.uint32(tag)
+	return int(v)
+}
+
+// rational returns the i-th value of a RATIONAL field
+func (d ifd) rational(tag uint16, i uint32) Rational {
+	f, ok := d.fields[tag]
+	if !ok || f.typ != typeRational || i >= f.count {
+		return Rational{}
+	}
+
+	return Rational{
+		Num: d.order.Uint32(f.value[i*8:]),
+		Den: d.order.Uint32(f.value[i*8+4:]),
+	}
+}
+
+// degrees converts a GPS degrees, minutes, seconds
+// field into decimal degrees
+func (d ifd) degrees(tag uint16) (float64, bool) {
+	f, ok := d.fields[tag]
+	if !ok || f.typ != typeRational || f.count < 3 {
+		return 0, false
+	}
+
+	deg := d.rational(tag, 0).Float()
+	min := d.rational(tag, 1).Float()
+	sec := d.rational(tag, 2).Float()
+	return deg + min/60 + sec/3600, true
+}
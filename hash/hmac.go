@@ -2,6 +2,7 @@ package hash
 
 import (
 	"hash"
+	"sync"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -12,18 +13,27 @@ func NewHMAC(key string) HMAC {
 	h := hmac.New(sha256.New, []byte(key))
 	return HMAC {
 		hmac: h,
+		mu: &sync.Mutex{},
 	}
 }
 
 // HMAC is a wrapper around the crypto/hmac
 // package and make it easier to use in our code
+//
+// The underlying hash is shared by every copy of the HMAC,
+// so it is guarded by a mutex to make Hash safe to call
+// from concurrent requests
 type HMAC struct {
 	hmac hash.Hash
+	mu *sync.Mutex
 }
 
 // Hash will hash the provided input string using HMAC
 // with the secret key provided when the HMAC object was created
 func (h HMAC) Hash(input string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
 	h.hmac.Reset()
 	h.hmac.Write([]byte(input))
 	b := h.hmac.Sum(nil)
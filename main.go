@@ -1,59 +1,193 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"time"
 
+	"../photofriends/api"
+	"../photofriends/config"
 	"../photofriends/controllers"
+	"../photofriends/email"
 	"../photofriends/middelware"
 	"../photofriends/models"
+	"../photofriends/storage"
 
 	"github.com/gorilla/mux"
 )
 
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "postgres"
-	password = "postgres"
-	dbname   = "photofriends_dev"
-)
+// uploadCleanupInterval is how often expired
+// resumable uploads are deleted
+const uploadCleanupInterval = time.Hour
 
 func main() {
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(cfg.Command) > 0 {
+		if cfg.Command[0] != "migrate" {
+			log.Fatalf("unknown command %q, try migrate", cfg.Command[0])
+		}
+
+		if err := runMigrate(cfg, cfg.Command[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	must(migrateOnStart(cfg))
 
-	services, err := models.NewServices(psqlInfo)
+	imageStore, err := storage.New(cfg.Storage)
+	must(err)
+
+	services, err := models.NewServices(models.ServicesConfig{
+		ConnectionInfo: cfg.Database.ConnectionInfo(),
+		LogSQL:         cfg.Database.LogSQL,
+		Pepper:         cfg.Pepper,
+		HMACKey:        cfg.HMACKey,
+		RateLimit:      cfg.RateLimit,
+		WebAuthn:       cfg.WebAuthn,
+		OIDC:           cfg.OIDC,
+	}, imageStore)
 	must(err)
 
 	defer services.Close()
-	services.DestructiveReset()
-	services.AutoMigrate()
 
-	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User)
-	galleriesC := controllers.NewGalleries(services.Gallery)
-	requireUserMw := middelware.RequireUser{
-		UserService: services.User,
-	}
+	// expired partial uploads are looked for at startup
+	// and then regularly, rather than on every new upload
+	go func() {
+		for {
+			if err := services.Upload.DeleteExpired(); err != nil {
+				log.Printf("uploads: deleting expired uploads: %v", err)
+			}
+			time.Sleep(uploadCleanupInterval)
+		}
+	}()
+
+	// images uploaded before perceptual hashes were kept are
+	// hashed in the background, so they show up as duplicates
+	go func() {
+		if err := services.Image.HashMissing(); err != nil {
+			log.Printf("images: hashing missing images: %v", err)
+		}
+	}()
 
 	// router & path config
 	// note the "Methods", it specify that
 	// only the sat requests types are allowed
 	router := mux.NewRouter() // router
-	router.Handle("/", staticC.Home).Methods("GET")
-	router.Handle("/contact", staticC.Contact).Methods("GET")
-	router.Handle("/signup", usersC.NewView).Methods("GET")
-	router.HandleFunc("/signup", usersC.Create).Methods("POST")
-	router.Handle("/login", usersC.LoginView).Methods("GET")
-	router.HandleFunc("/login", usersC.Login).Methods("POST")
-	router.HandleFunc("/cookietest", usersC.CookieTest).Methods("GET")
+
+	staticC := controllers.NewStatic()
+	mailer, err := email.New(cfg.Email)
+	must(err)
+
+	usersC := controllers.NewUsers(services.User, services.Session, services.Identity.Providers(), mailer, cfg.BaseURL)
+	passkeysC := controllers.NewPasskeys(services.Passkey, services.Session)
+	identitiesC := controllers.NewIdentities(services.Identity, services.Session)
+	apiTokensC := controllers.NewAPITokens(services.APIToken)
+	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, router)
+	friendsC := controllers.NewFriends(services.Friend, services.User, mailer, cfg.BaseURL)
+	userMw := middelware.User{
+		UserService:    services.User,
+		SessionService: services.Session,
+	}
+	requireUserMw := middelware.RequireUser{
+		User: userMw,
+	}
+	apiTokenMw := middelware.APIToken{
+		APITokenService: services.APIToken,
+		User:            userMw,
+	}
+	csrfMw := middelware.CSRF{
+		Secure: cfg.IsProd(),
+	}
+
+	router.Handle("/", userMw.Apply(staticC.Home)).Methods("GET")
+	router.Handle("/contact", userMw.Apply(staticC.Contact)).Methods("GET")
+	router.Handle("/signup", userMw.Apply(usersC.NewView)).Methods("GET")
+	router.HandleFunc("/signup", userMw.ApplyFn(usersC.Create)).Methods("POST")
+	router.HandleFunc("/login", userMw.ApplyFn(usersC.LoginForm)).Methods("GET")
+	router.HandleFunc("/login", userMw.ApplyFn(usersC.Login)).Methods("POST")
+	router.Handle("/forgot", userMw.Apply(usersC.ForgotView)).Methods("GET")
+	router.HandleFunc("/forgot", userMw.ApplyFn(usersC.Forgot)).Methods("POST")
+	router.HandleFunc("/reset", userMw.ApplyFn(usersC.ResetForm)).Methods("GET")
+	router.HandleFunc("/reset", userMw.ApplyFn(usersC.Reset)).Methods("POST")
+	router.HandleFunc("/verify", userMw.ApplyFn(usersC.Verify)).Methods("GET")
+	router.HandleFunc("/verify/resend", requireUserMw.ApplyFn(usersC.ResendVerification)).Methods("POST")
+	router.HandleFunc("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST")
+	router.HandleFunc("/sessions", requireUserMw.ApplyFn(usersC.Sessions)).Methods("GET")
+	router.HandleFunc("/sessions/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(usersC.RevokeSession)).Methods("POST")
+	router.HandleFunc("/logins", requireUserMw.ApplyFn(usersC.LoginAttempts)).Methods("GET")
+	router.HandleFunc("/login/2fa", userMw.ApplyFn(usersC.TwoFactorChallenge)).Methods("GET")
+	router.HandleFunc("/login/2fa", userMw.ApplyFn(usersC.CompleteTwoFactor)).Methods("POST")
+	router.HandleFunc("/2fa", requireUserMw.ApplyFn(usersC.TwoFactor)).Methods("GET")
+	router.HandleFunc("/2fa/enable", requireUserMw.ApplyFn(usersC.EnableTwoFactor)).Methods("POST")
+	router.HandleFunc("/2fa/disable", requireUserMw.ApplyFn(usersC.DisableTwoFactor)).Methods("POST")
+
+	// passkey routes
+	router.HandleFunc("/passkeys", requireUserMw.ApplyFn(passkeysC.Index)).Methods("GET")
+	router.HandleFunc("/passkeys", requireUserMw.ApplyFn(passkeysC.Create)).Methods("POST")
+	router.HandleFunc("/passkeys/register/begin", requireUserMw.ApplyFn(passkeysC.BeginRegistration)).Methods("POST")
+	router.HandleFunc("/passkeys/{id:[0-9]+}/delete", requireUserMw.ApplyFn(passkeysC.Delete)).Methods("POST")
+	router.HandleFunc("/login/passkey/begin", userMw.ApplyFn(passkeysC.BeginLogin)).Methods("POST")
+	router.HandleFunc("/login/passkey", userMw.ApplyFn(passkeysC.Login)).Methods("POST")
+
+	// linked account routes
+	router.HandleFunc("/identities", requireUserMw.ApplyFn(identitiesC.Index)).Methods("GET")
+	router.HandleFunc("/identities/{provider}/link", requireUserMw.ApplyFn(identitiesC.Link)).Methods("POST")
+	router.HandleFunc("/identities/{id:[0-9]+}/unlink", requireUserMw.ApplyFn(identitiesC.Unlink)).Methods("POST")
+	router.HandleFunc("/auth/{provider}", userMw.ApplyFn(identitiesC.Login)).Methods("POST")
+	router.HandleFunc("/auth/{provider}/callback", userMw.ApplyFn(identitiesC.Callback)).Methods("GET")
+
+	// API token routes
+	router.HandleFunc("/tokens", requireUserMw.ApplyFn(apiTokensC.Index)).Methods("GET")
+	router.HandleFunc("/tokens", requireUserMw.ApplyFn(apiTokensC.Create)).Methods("POST")
+	router.HandleFunc("/tokens/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(apiTokensC.Revoke)).Methods("POST")
 
 	// gallery routes
 	router.Handle("/galleries/new", requireUserMw.Apply(galleriesC.New)).Methods("GET")
+	router.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Index)).Methods("GET")
 	router.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Create)).Methods("POST")
+	router.HandleFunc("/galleries/duplicates", requireUserMw.ApplyFn(galleriesC.Duplicates)).Methods("GET")
+	router.HandleFunc("/galleries/{id:[0-9]+}", userMw.ApplyFn(galleriesC.Show)).
+		Methods("GET").Name(controllers.ShowGallery)
+	router.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).
+		Methods("GET").Name(controllers.EditGallery)
+	router.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST")
+	router.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST")
+	router.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
+	router.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}", userMw.ApplyFn(galleriesC.ShowImage)).
+		Methods("GET").Name(controllers.ShowImage)
+
+	// friend routes
+	router.HandleFunc("/friends", requireUserMw.ApplyFn(friendsC.Index)).Methods("GET")
+	router.HandleFunc("/friends", requireUserMw.ApplyFn(friendsC.Add)).Methods("POST")
+	router.HandleFunc("/friends/{id:[0-9]+}/accept", requireUserMw.ApplyFn(friendsC.Accept)).Methods("POST")
+	router.HandleFunc("/friends/{id:[0-9]+}/decline", requireUserMw.ApplyFn(friendsC.Decline)).Methods("POST")
+	router.HandleFunc("/users/{id:[0-9]+}/friend", requireUserMw.ApplyFn(friendsC.Request)).Methods("POST")
+	router.HandleFunc("/users/{id:[0-9]+}/unfriend", requireUserMw.ApplyFn(friendsC.Unfriend)).Methods("POST")
+	router.HandleFunc("/users/{id:[0-9]+}/block", requireUserMw.ApplyFn(friendsC.Block)).Methods("POST")
+
+	// JSON API and resumable uploads, see package api.
+	// Scripts use API tokens instead of the session cookie
+	api.New(services, mailer, cfg.BaseURL).Register(router, &apiTokenMw)
+
+	// uploaded images stored on local disk are served through
+	// signed URLs, other backends hand out their own URLs
+	if local, ok := imageStore.(*storage.Local); ok {
+		router.PathPrefix(cfg.Storage.URLPrefix).Handler(local.Handler())
+	}
 
-	http.ListenAndServe(":3000", router) // port to serve (nil = NULLPOINTER)
+	log.Printf("Starting the %s server on :%d", cfg.Env, cfg.Port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), csrfMw.Apply(router)))
 }
 
 // panic if ANY error is present
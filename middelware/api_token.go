@@ -0,0 +1,87 @@
+package middelware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"../api"
+	"../context"
+	"../models"
+)
+
+// APIToken authenticates requests with a personal API token
+// sent as "Authorization: Bearer <token>", and adds the token
+// and its user to the request context. Requests without a
+// bearer token are handed to User, so the same routes work
+// for the browser.
+//
+// Requests with a bearer token never fall back to the session
+// cookie, as they are exempt from CSRF checks. A token that
+// is not valid is answered with a 401 right away
+type APIToken struct {
+	models.APITokenService
+	User
+}
+
+func (mw *APIToken) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *APIToken) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	withSession := mw.User.ApplyFn(next)
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if !bearerAuth(req) {
+			withSession(res, req)
+			return
+		}
+
+		raw := strings.TrimSpace(req.Header.Get("Authorization")[len("Bearer "):])
+		token, err := mw.APITokenService.ByToken(raw)
+		if err != nil {
+			unauthorized(res, err)
+			return
+		}
+
+		user, err := mw.UserService.ByID(token.UserID)
+		if err != nil {
+			unauthorized(res, models.ErrAPITokenInvalid)
+			return
+		}
+
+		mw.APITokenService.Touch(token)
+
+		ctx := req.Context()
+		ctx = context.WithUser(ctx, user)
+		ctx = context.WithAPIToken(ctx, token)
+		req = req.WithContext(ctx)
+
+		next(res, req)
+	})
+}
+
+// RequireScope refuses requests made with an API token
+// that was not given scope with a 403. Requests made
+// with a session may do anything the user can. It
+// expects APIToken to have run first
+func (mw *APIToken) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		token := context.APIToken(req.Context())
+		if token != nil && !token.HasScope(scope) {
+			// see RFC 6750 section 3.1
+			res.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+			api.WriteErrorStatus(res, http.StatusForbidden, models.ErrAPITokenScope)
+			return
+		}
+
+		next(res, req)
+	}
+}
+
+// unauthorized answers a request made with a token
+// that is not valid, see RFC 6750 section 3.1
+func unauthorized(res http.ResponseWriter, err error) {
+	res.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	api.WriteErrorStatus(res, http.StatusUnauthorized, err)
+}
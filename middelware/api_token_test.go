@@ -0,0 +1,146 @@
+package middelware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"../context"
+	"../models"
+)
+
+// fakeTokens knows a single token, "pf_good"
+type fakeTokens struct {
+	models.APITokenService
+}
+
+func (f *fakeTokens) ByToken(token string) (*models.APIToken, error) {
+	if token != "pf_good" {
+		return nil, models.ErrAPITokenInvalid
+	}
+
+	return &models.APIToken{ID: 1, UserID: 1, Scopes: models.ScopeGalleriesRead}, nil
+}
+
+func (f *fakeTokens) Touch(token *models.APIToken) error {
+	return nil
+}
+
+// fakeUsers knows user 1, and user 2 through the "good" session
+type fakeUsers struct {
+	models.UserService
+}
+
+func (f *fakeUsers) ByID(id uint) (*models.User, error) {
+	user := &models.User{}
+	user.ID = id
+	return user, nil
+}
+
+type fakeSessions struct {
+	models.SessionService
+}
+
+func (f *fakeSessions) ByToken(token string) (*models.Session, error) {
+	if token != "good" {
+		return nil, models.ErrNotFound
+	}
+
+	return &models.Session{UserID: 2}, nil
+}
+
+func (f *fakeSessions) Touch(session *models.Session) error {
+	return nil
+}
+
+func testingAPIToken() *APIToken {
+	return &APIToken{
+		APITokenService: &fakeTokens{},
+		User: User{
+			UserService:    &fakeUsers{},
+			SessionService: &fakeSessions{},
+		},
+	}
+}
+
+// tokenGet runs handler behind mw, and returns the response
+// and the user the handler saw, 0 if it was not called
+func tokenGet(handler http.HandlerFunc, auth, session string) (*httptest.ResponseRecorder, uint) {
+	var userID uint
+	next := func(res http.ResponseWriter, req *http.Request) {
+		userID = 100
+		if user := context.User(req.Context()); user != nil {
+			userID = user.ID
+		}
+		handler(res, req)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/galleries", nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: SessionCookie, Value: session})
+	}
+
+	rec := httptest.NewRecorder()
+	testingAPIToken().ApplyFn(next)(rec, req)
+	return rec, userID
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	ok := func(res http.ResponseWriter, req *http.Request) {}
+
+	cases := []struct {
+		name    string
+		auth    string
+		session string
+		status  int
+		userID  uint
+	}{
+		{"bearer token", "Bearer pf_good", "", http.StatusOK, 1},
+		{"lowercase scheme", "bearer pf_good", "", http.StatusOK, 1},
+		{"session cookie", "", "good", http.StatusOK, 2},
+		{"visitor", "", "", http.StatusOK, 100},
+		{"invalid token", "Bearer pf_bad", "", http.StatusUnauthorized, 0},
+
+		// a bad token must not fall back to the cookie, as
+		// bearer requests skip the CSRF checks
+		{"invalid token with session", "Bearer pf_bad", "good", http.StatusUnauthorized, 0},
+	}
+
+	for _, c := range cases {
+		rec, userID := tokenGet(ok, c.auth, c.session)
+		if rec.Code != c.status || userID != c.userID {
+			t.Errorf("%s: Expected %d for user %d. Recieved %d for user %d", c.name, c.status, c.userID, rec.Code, userID)
+		}
+
+		if c.status == http.StatusUnauthorized && !strings.Contains(rec.Header().Get("WWW-Authenticate"), "invalid_token") {
+			t.Errorf("%s: Expected a WWW-Authenticate header. Recieved %q", c.name, rec.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestAPITokenRequireScope(t *testing.T) {
+	mw := testingAPIToken()
+	ok := func(res http.ResponseWriter, req *http.Request) {}
+
+	cases := []struct {
+		auth    string
+		session string
+		scope   string
+		status  int
+	}{
+		{"Bearer pf_good", "", models.ScopeGalleriesRead, http.StatusOK},
+		{"Bearer pf_good", "", models.ScopeGalleriesWrite, http.StatusForbidden},
+		{"", "good", models.ScopeGalleriesWrite, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		rec, _ := tokenGet(mw.RequireScope(c.scope, ok), c.auth, c.session)
+		if rec.Code != c.status {
+			t.Errorf("%s %s for %s: Expected %d. Recieved %d", c.auth, c.session, c.scope, c.status, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package middelware
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"../context"
+	"../rand"
+	"../views"
+)
+
+const (
+	// CSRFCookie holds the raw CSRF token of the browser
+	CSRFCookie = "csrf"
+
+	// CSRFHeader can carry the token instead of the
+	// form field, for requests made from scripts
+	CSRFHeader = "X-CSRF-Token"
+
+	csrfTokenBytes     = 32
+	csrfCookieLifetime = 30 * 24 * time.Hour
+)
+
+var errCSRFInvalid = views.NewPublicError("Your form has expired or was sent from another site. " +
+	"Please go back, reload the page and try again.")
+
+// CSRF protects every state changing request against cross
+// site form posts with a double submit token. The browser
+// gets a random token in a cookie, and forms have to post
+// it back in the field rendered by {{csrfField}}, or in
+// the CSRFHeader. Another site can make the browser send
+// the cookie, but can not read it to fill in the form.
+//
+// The token in forms is masked with a one time pad, so it
+// is different on every page and can not be recovered
+// through compression side channels like BREACH.
+//
+// Requests with a bearer token in the Authorization header
+// are exempt, browsers never add that header on their own.
+// Handlers behind bearer auth must therefore never fall
+// back to the session cookie
+type CSRF struct {
+	// Secure marks the cookie as HTTPS only
+	Secure bool
+}
+
+func (mw *CSRF) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *CSRF) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if bearerAuth(req) {
+			next(res, req)
+			return
+		}
+
+		token := csrfCookieToken(req)
+		if token == nil {
+			var err error
+			if token, err = rand.Bytes(csrfTokenBytes); err != nil {
+				log.Println(err)
+				http.Error(res, views.AlertMsgGeneric, http.StatusInternalServerError)
+				return
+			}
+
+			http.SetCookie(res, &http.Cookie{
+				Name:     CSRFCookie,
+				Value:    base64.RawURLEncoding.EncodeToString(token),
+				Path:     "/",
+				Expires:  time.Now().Add(csrfCookieLifetime),
+				HttpOnly: true,
+				Secure:   mw.Secure,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		masked, err := maskCSRFToken(token)
+		if err != nil {
+			log.Println(err)
+			http.Error(res, views.AlertMsgGeneric, http.StatusInternalServerError)
+			return
+		}
+
+		ctx := context.WithCSRFToken(req.Context(), masked)
+		req = req.WithContext(ctx)
+
+		if !safeMethod(req.Method) {
+			sent := req.Header.Get(CSRFHeader)
+			if sent == "" {
+				sent = req.PostFormValue(views.CSRFFieldName)
+			}
+
+			if !validCSRFToken(token, sent) {
+				views.Error(res, req, http.StatusForbidden, errCSRFInvalid)
+				return
+			}
+		}
+
+		next(res, req)
+	})
+}
+
+// safeMethod reports whether requests with method
+// only read, and therefore need no token
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// bearerAuth reports whether req authenticates
+// with a bearer token instead of cookies
+func bearerAuth(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	return len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ")
+}
+
+// csrfCookieToken returns the raw token from the cookie,
+// or nil if the cookie is missing or malformed
+func csrfCookieToken(req *http.Request) []byte {
+	cookie, err := req.Cookie(CSRFCookie)
+	if err != nil {
+		return nil
+	}
+
+	token, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(token) != csrfTokenBytes {
+		return nil
+	}
+
+	return token
+}
+
+// maskCSRFToken returns a random pad followed by
+// the token XORed with it, base64 encoded
+func maskCSRFToken(token []byte) (string, error) {
+	pad, err := rand.Bytes(len(token))
+	if err != nil {
+		return "", err
+	}
+
+	masked := make([]byte, 2*len(token))
+	copy(masked, pad)
+	for i := range token {
+		masked[len(token)+i] = token[i] ^ pad[i]
+	}
+
+	return base64.RawURLEncoding.EncodeToString(masked), nil
+}
+
+// validCSRFToken unmasks sent and compares
+// it to the token in constant time
+func validCSRFToken(token []byte, sent string) bool {
+	masked, err := base64.RawURLEncoding.DecodeString(sent)
+	if err != nil || len(masked) != 2*len(token) {
+		return false
+	}
+
+	unmasked := make([]byte, len(token))
+	for i := range token {
+		unmasked[i] = masked[i] ^ masked[len(token)+i]
+	}
+
+	return subtle.ConstantTimeCompare(token, unmasked) == 1
+}
@@ -0,0 +1,117 @@
+package middelware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"../context"
+	"../views"
+)
+
+func TestMain(m *testing.M) {
+	// the error page is rendered from views/
+	// relative to the root of the repo
+	os.Chdir("..")
+	os.Exit(m.Run())
+}
+
+// csrfGet makes a GET request through mw and returns the
+// cookies it set and the masked token handed to the views
+func csrfGet(t *testing.T, mw *CSRF) ([]*http.Cookie, string) {
+	var token string
+	handler := mw.ApplyFn(func(res http.ResponseWriter, req *http.Request) {
+		token = context.CSRFToken(req.Context())
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/galleries/new", nil))
+	if token == "" {
+		t.Fatal("Expected a token in the request context")
+	}
+
+	return rec.Result().Cookies(), token
+}
+
+func csrfPost(mw *CSRF, cookies []*http.Cookie, form url.Values, header http.Header) (*httptest.ResponseRecorder, bool) {
+	called := false
+	handler := mw.ApplyFn(func(res http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+
+	req := httptest.NewRequest("POST", "/galleries", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec, called
+}
+
+func TestCSRF(t *testing.T) {
+	mw := &CSRF{}
+	cookies, token := csrfGet(t, mw)
+	if len(cookies) != 1 || cookies[0].Name != CSRFCookie || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an http only %s cookie. Recieved %v", CSRFCookie, cookies)
+	}
+
+	if _, other := csrfGet(t, mw); other == token {
+		t.Error("Expected every request to get a differently masked token")
+	}
+
+	form := url.Values{"title": {"Summer"}, views.CSRFFieldName: {token}}
+	if rec, called := csrfPost(mw, cookies, form, nil); !called {
+		t.Errorf("Expected the form token to be accepted. Recieved %d", rec.Code)
+	}
+
+	header := http.Header{CSRFHeader: {token}}
+	if rec, called := csrfPost(mw, cookies, url.Values{}, header); !called {
+		t.Errorf("Expected the header token to be accepted. Recieved %d", rec.Code)
+	}
+
+	// a token masked for another browser's cookie
+	otherCookies, otherToken := csrfGet(t, mw)
+	cases := map[string]struct {
+		cookies []*http.Cookie
+		token   string
+	}{
+		"no token":     {cookies, ""},
+		"no cookie":    {nil, token},
+		"other cookie": {otherCookies, token},
+		"other token":  {cookies, otherToken},
+		"garbage":      {cookies, "not-a-token"},
+	}
+	for name, c := range cases {
+		form := url.Values{views.CSRFFieldName: {c.token}}
+		rec, called := csrfPost(mw, c.cookies, form, nil)
+		if called || rec.Code != http.StatusForbidden {
+			t.Errorf("%s: expected the post to be rejected. Recieved %d", name, rec.Code)
+		}
+
+		if !strings.Contains(rec.Body.String(), "<html") {
+			t.Errorf("%s: expected a rendered error page", name)
+		}
+	}
+}
+
+func TestCSRFBearerExempt(t *testing.T) {
+	header := http.Header{"Authorization": {"Bearer some-api-token"}}
+	if rec, called := csrfPost(&CSRF{}, nil, url.Values{}, header); !called {
+		t.Errorf("Expected bearer requests to skip the check. Recieved %d", rec.Code)
+	}
+
+	header = http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}}
+	if _, called := csrfPost(&CSRF{}, nil, url.Values{}, header); called {
+		t.Error("Expected other authorization schemes to be checked")
+	}
+}
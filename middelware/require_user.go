@@ -4,11 +4,13 @@ import (
 	"net/http"
 
 	"../context"
-	"../models"
 )
 
+// RequireUser redirects to the login page unless the
+// request has a valid session. It uses User to do
+// the lookup, so the user is in the context after it
 type RequireUser struct {
-	models.UserService
+	User
 }
 
 func (mw *RequireUser) Apply(next http.Handler) http.HandlerFunc {
@@ -16,24 +18,12 @@ func (mw *RequireUser) Apply(next http.Handler) http.HandlerFunc {
 }
 
 func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		cookie, err := req.Cookie("remember_token")
-		if err != nil {
+	return mw.User.ApplyFn(func(res http.ResponseWriter, req *http.Request) {
+		if context.User(req.Context()) == nil {
 			http.Redirect(res, req, "/login", http.StatusFound)
 			return
 		}
 
-		user, err := mw.UserService.ByRemember(cookie.Value)
-		if err != nil {
-			http.Redirect(res, req, "/login", http.StatusFound)
-			return
-		}
-
-		// apply user to request context
-		ctx := req.Context()
-		ctx = context.WithUser(ctx, user)
-		req = req.WithContext(ctx)
-
 		next(res, req)
 	})
 }
@@ -0,0 +1,66 @@
+package middelware
+
+import (
+	"net/http"
+
+	"../context"
+	"../models"
+)
+
+// SessionCookie is the name of the cookie
+// holding the raw session token
+const SessionCookie = "session"
+
+// User looks up the session from the session cookie and
+// adds it and its user to the request context. Unlike
+// RequireUser it never redirects, visitors simply have
+// no user set
+type User struct {
+	models.UserService
+	SessionService models.SessionService
+}
+
+func (mw *User) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		cookie, err := req.Cookie(SessionCookie)
+		if err != nil {
+			next(res, req)
+			return
+		}
+
+		session, err := mw.SessionService.ByToken(cookie.Value)
+		if err != nil {
+			next(res, req)
+			return
+		}
+
+		// a pending session only gets as far as the two factor
+		// challenge, which finds it in the context. There is
+		// no user until the second factor was entered
+		if session.Pending {
+			req = req.WithContext(context.WithSession(req.Context(), session))
+			next(res, req)
+			return
+		}
+
+		user, err := mw.UserService.ByID(session.UserID)
+		if err != nil {
+			next(res, req)
+			return
+		}
+
+		mw.SessionService.Touch(session)
+
+		// apply user and session to request context
+		ctx := req.Context()
+		ctx = context.WithUser(ctx, user)
+		ctx = context.WithSession(ctx, session)
+		req = req.WithContext(ctx)
+
+		next(res, req)
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"../photofriends/config"
+	"../photofriends/migrate"
+	_ "github.com/lib/pq"
+)
+
+// migrationsDir holds the numbered SQL migrations
+const migrationsDir = "migrations"
+
+const migrateUsage = `usage: photofriends [flags] migrate <command>
+
+commands:
+  up [n]          apply all or the next n pending migrations
+  down [n|all]    roll back the last n migrations, 1 by default
+  status          list every migration and when it was applied
+  new <name>      create empty up and down files for a migration`
+
+// newMigrator opens the database and loads the migrations.
+// The returned func closes the database
+func newMigrator(cfg config.Config) (*migrate.Migrator, func() error, error) {
+	migrations, err := migrate.LoadDir(migrationsDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := sql.Open("postgres", cfg.Database.ConnectionInfo())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := migrate.New(migrate.NewPostgres(db), migrations)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return m, db.Close, nil
+}
+
+// migrateOnStart brings the schema up to date before the server
+// starts. With -reset-db every migration is rolled back first
+func migrateOnStart(cfg config.Config) error {
+	m, closeDB, err := newMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	if cfg.ResetDB {
+		if _, err := m.Down(0); err != nil {
+			return err
+		}
+	}
+
+	applied, err := m.Up(0)
+	for _, mig := range applied {
+		fmt.Println("migrated up", mig)
+	}
+
+	return err
+}
+
+// runMigrate runs the migrate subcommand with args,
+// the arguments after "migrate"
+func runMigrate(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	if args[0] == "new" {
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+
+		paths, err := migrate.Create(migrationsDir, args[1])
+		for _, path := range paths {
+			fmt.Println("created", path)
+		}
+		return err
+	}
+
+	m, closeDB, err := newMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	switch args[0] {
+	case "up":
+		n, err := migrateCount(args[1:], 0)
+		if err != nil {
+			return err
+		}
+
+		applied, err := m.Up(n)
+		for _, mig := range applied {
+			fmt.Println("migrated up", mig)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		n, err := migrateCount(args[1:], 1)
+		if err != nil {
+			return err
+		}
+
+		if cfg.IsProd() && n != 1 {
+			return errors.New("migrate: in prod migrations can only be rolled back one at a time")
+		}
+
+		rolledBack, err := m.Down(n)
+		for _, mig := range rolledBack {
+			fmt.Println("migrated down", mig)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%-24s  %s\n", applied, s.Migration)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// migrateCount parses the optional count argument of up and
+// down. "all" means every migration and is returned as 0
+func migrateCount(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+
+	if len(args) > 1 {
+		return 0, errors.New(migrateUsage)
+	}
+
+	if args[0] == "all" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("migrate: %q is not a positive number", args[0])
+	}
+
+	return n, nil
+}
@@ -0,0 +1,106 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fileRegex matches migration files, like 0001_initial_schema.up.sql
+var fileRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// LoadDir reads the SQL migrations in dir. Every version
+// needs an up file, the down file is optional
+func LoadDir(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".sql" {
+			continue
+		}
+
+		match := fileRegex.FindStringSubmatch(f.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrate: %s: name must look like 0001_name.up.sql", f.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		}
+
+		if mig.Name != match[2] {
+			return nil, fmt.Errorf("migrate: %s: %v", f.Name(), ErrDuplicateVersion)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			mig.UpSQL = string(data)
+		} else {
+			mig.DownSQL = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.UpSQL == "" {
+			return nil, fmt.Errorf("migrate: %s: the up file is missing or empty", mig)
+		}
+		migrations = append(migrations, *mig)
+	}
+
+	return migrations, nil
+}
+
+// Create writes empty up and down files for a new migration
+// in dir, numbered one after the highest existing version.
+// The paths of the new files are returned
+func Create(dir, name string) ([]string, error) {
+	name = strings.Trim(regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return nil, fmt.Errorf("migrate: a name is required")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	existing, err := LoadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	next := 1
+	for _, mig := range existing {
+		if mig.Version >= next {
+			next = mig.Version + 1
+		}
+	}
+
+	mig := Migration{Version: next, Name: name}
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%s.%s.sql", mig, direction))
+		body := fmt.Sprintf("-- %s: %s\n", mig, direction)
+		if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
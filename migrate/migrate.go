@@ -0,0 +1,249 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrNoDown is returned when rolling back a
+	// migration that has no down step
+	ErrNoDown = errors.New("Migration can not be rolled back")
+
+	// ErrDuplicateVersion is returned when two
+	// migrations share the same version number
+	ErrDuplicateVersion = errors.New("Migration version is used more than once")
+)
+
+// Tx is what migrations run their statements
+// on, *sql.Tx implements it
+type Tx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Migration is a single numbered schema change. Each
+// direction is either plain SQL, or a Go func for changes
+// SQL can not express easily, like backfilling data
+type Migration struct {
+	Version int
+	Name    string
+
+	UpSQL   string
+	DownSQL string
+
+	UpFunc   func(tx Tx) error
+	DownFunc func(tx Tx) error
+}
+
+// String returns the migration as shown in
+// file names, like 0001_initial_schema
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// HasDown reports whether the migration can be rolled back
+func (m Migration) HasDown() bool {
+	return m.DownSQL != "" || m.DownFunc != nil
+}
+
+// Run runs one direction of the migration on tx. Stores
+// call it inside the transaction that records the change
+func (m Migration) Run(tx Tx, up bool) error {
+	fn, query := m.UpFunc, m.UpSQL
+	if !up {
+		fn, query = m.DownFunc, m.DownSQL
+	}
+
+	if fn != nil {
+		return fn(tx)
+	}
+
+	if query == "" {
+		if up {
+			return nil
+		}
+		return ErrNoDown
+	}
+
+	_, err := tx.Exec(query)
+	return err
+}
+
+// Store keeps track of which migrations are applied
+//
+// Lock and Unlock must keep any other Store for the same
+// database from migrating in between, Apply must run the
+// migration and record it in a single transaction
+type Store interface {
+	Lock() error
+	Unlock() error
+	Applied() (map[int]time.Time, error)
+	Apply(m Migration, up bool) error
+}
+
+// Status is a migration and when it was applied,
+// AppliedAt is nil for pending migrations
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies and rolls back migrations
+type Migrator struct {
+	store      Store
+	migrations []Migration
+}
+
+// New creates a Migrator for the migrations, which
+// do not need to be sorted but must have unique versions
+func New(store Store, migrations []Migration) (*Migrator, error) {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	for i := range sorted {
+		if sorted[i].Version <= 0 {
+			return nil, fmt.Errorf("migrate: %s: version must be positive", sorted[i])
+		}
+
+		if i > 0 && sorted[i].Version == sorted[i-1].Version {
+			return nil, fmt.Errorf("migrate: %s: %v", sorted[i], ErrDuplicateVersion)
+		}
+	}
+
+	return &Migrator{
+		store:      store,
+		migrations: sorted,
+	}, nil
+}
+
+// Up applies up to n pending migrations, oldest first,
+// or every pending migration when n <= 0. The migrations
+// that were applied are returned, even on error
+func (m *Migrator) Up(n int) ([]Migration, error) {
+	if err := m.store.Lock(); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+
+	applied, err := m.store.Applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range m.migrations {
+		if n > 0 && len(done) == n {
+			break
+		}
+
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+
+		if err := m.store.Apply(mig, true); err != nil {
+			return done, fmt.Errorf("migrate: %s: %v", mig, err)
+		}
+		done = append(done, mig)
+	}
+
+	return done, nil
+}
+
+// Down rolls back up to n applied migrations, newest
+// first, or every applied migration when n <= 0. The
+// migrations that were rolled back are returned, even
+// on error
+func (m *Migrator) Down(n int) ([]Migration, error) {
+	if err := m.store.Lock(); err != nil {
+		return nil, err
+	}
+	defer m.store.Unlock()
+
+	applied, err := m.store.Applied()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	var done []Migration
+	for _, v := range versions {
+		if n > 0 && len(done) == n {
+			break
+		}
+
+		mig, ok := m.byVersion(v)
+		if !ok {
+			return done, fmt.Errorf("migrate: version %d is applied but has no migration", v)
+		}
+
+		if !mig.HasDown() {
+			return done, fmt.Errorf("migrate: %s: %v", mig, ErrNoDown)
+		}
+
+		if err := m.store.Apply(mig, false); err != nil {
+			return done, fmt.Errorf("migrate: %s: %v", mig, err)
+		}
+		done = append(done, mig)
+	}
+
+	return done, nil
+}
+
+// Status returns every known migration, oldest first,
+// with the time it was applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.store.Applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		statuses[i].Migration = mig
+		if at, ok := applied[mig.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+
+	return statuses, nil
+}
+
+// Pending returns how many migrations are not applied yet
+func (m *Migrator) Pending() (int, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
+
+func (m *Migrator) byVersion(version int) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+
+	return Migration{}, false
+}
@@ -0,0 +1,227 @@
+package migrate
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memStore keeps applied versions in memory and records
+// the order migrations ran in
+type memStore struct {
+	applied map[int]time.Time
+	ran     []string
+	locked  bool
+	locks   int
+}
+
+func newMemStore() *memStore {
+	return &memStore{applied: make(map[int]time.Time)}
+}
+
+func (ms *memStore) Lock() error {
+	if ms.locked {
+		return errors.New("already locked")
+	}
+
+	ms.locked = true
+	ms.locks++
+	return nil
+}
+
+func (ms *memStore) Unlock() error {
+	ms.locked = false
+	return nil
+}
+
+func (ms *memStore) Applied() (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	for v, at := range ms.applied {
+		applied[v] = at
+	}
+
+	return applied, nil
+}
+
+func (ms *memStore) Apply(m Migration, up bool) error {
+	if !ms.locked {
+		return errors.New("not locked")
+	}
+
+	if err := m.Run(nil, up); err != nil {
+		return err
+	}
+
+	if up {
+		ms.applied[m.Version] = time.Now()
+		ms.ran = append(ms.ran, "up "+m.String())
+	} else {
+		delete(ms.applied, m.Version)
+		ms.ran = append(ms.ran, "down "+m.String())
+	}
+
+	return nil
+}
+
+func noop(tx Tx) error { return nil }
+
+func testingMigrations() []Migration {
+	return []Migration{
+		{Version: 3, Name: "three", UpFunc: noop, DownFunc: noop},
+		{Version: 1, Name: "one", UpFunc: noop, DownFunc: noop},
+		{Version: 2, Name: "two", UpFunc: noop, DownFunc: noop},
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	store := newMemStore()
+	m, err := New(store, testingMigrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied, _ := m.Up(0); len(applied) != 0 {
+		t.Errorf("Expected nothing left to apply. Recieved %v", applied)
+	}
+
+	if _, err := m.Down(2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"up 0001_one", "up 0002_two", "up 0003_three", "down 0003_three", "down 0002_two"}
+	if !reflect.DeepEqual(store.ran, want) {
+		t.Errorf("Expected migrations to run as %v. Recieved %v", want, store.ran)
+	}
+
+	if store.locked || store.locks != 4 {
+		t.Errorf("Expected every run to lock and unlock. Recieved %d locks, locked %v", store.locks, store.locked)
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(statuses) != 3 || statuses[0].AppliedAt == nil || statuses[1].AppliedAt != nil || statuses[2].AppliedAt != nil {
+		t.Errorf("Unexpected statuses %+v", statuses)
+	}
+
+	if pending, _ := m.Pending(); pending != 2 {
+		t.Errorf("Expected 2 pending migrations. Recieved %d", pending)
+	}
+}
+
+func TestUpStopsOnError(t *testing.T) {
+	store := newMemStore()
+	failing := errors.New("boom")
+	m, _ := New(store, []Migration{
+		{Version: 1, Name: "one", UpFunc: noop},
+		{Version: 2, Name: "two", UpFunc: func(tx Tx) error { return failing }},
+		{Version: 3, Name: "three", UpFunc: noop},
+	})
+
+	applied, err := m.Up(0)
+	if err == nil || len(applied) != 1 {
+		t.Fatalf("Expected the second migration to fail. Recieved %v, %v", applied, err)
+	}
+
+	if _, ok := store.applied[2]; ok {
+		t.Error("Expected the failed migration not to be recorded")
+	}
+
+	if _, ok := store.applied[3]; ok {
+		t.Error("Expected migrations after the failed one not to run")
+	}
+}
+
+func TestDownWithoutDown(t *testing.T) {
+	store := newMemStore()
+	m, _ := New(store, []Migration{{Version: 1, Name: "one", UpSQL: "SELECT 1"}})
+	store.applied[1] = time.Now()
+
+	if _, err := m.Down(1); err == nil {
+		t.Error("Expected an error rolling back a migration without a down step")
+	}
+
+	store.applied[7] = time.Now()
+	if _, err := m.Down(1); err == nil {
+		t.Error("Expected an error rolling back a version that has no migration")
+	}
+}
+
+func TestNewDuplicateVersion(t *testing.T) {
+	_, err := New(newMemStore(), []Migration{{Version: 1, Name: "a"}, {Version: 1, Name: "b"}})
+	if err == nil {
+		t.Error("Expected an error for duplicate versions")
+	}
+}
+
+func TestCreateAndLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photofriends-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Create(dir, "Add users"); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := Create(dir, "add-galleries")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "0002_add_galleries.up.sql"),
+		filepath.Join(dir, "0002_add_galleries.down.sql"),
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected %v. Recieved %v", want, paths)
+	}
+
+	migrations, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) != 2 {
+		t.Fatalf("Expected 2 migrations. Recieved %v", migrations)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "3_oops.sql"), nil, 0644)
+	if _, err := LoadDir(dir); err == nil {
+		t.Error("Expected an error for a badly named file")
+	}
+}
+
+// TestMigrationsDir makes sure the migrations
+// shipped with the app load and can be rolled back
+func TestMigrationsDir(t *testing.T) {
+	migrations, err := LoadDir("../migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(newMemStore(), migrations); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range migrations {
+		if !m.HasDown() {
+			t.Errorf("%s has no down migration", m)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/crc32"
+	"time"
+)
+
+// lockKey identifies the advisory lock taken while
+// migrating. Every app sharing a database uses the same one
+var lockKey = int64(crc32.ChecksumIEEE([]byte("photofriends schema_migrations")))
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamp with time zone NOT NULL DEFAULT now()
+)`
+
+// conn is implemented by both *sql.DB and *sql.Conn
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// ensure interface is matching
+var _ Store = &Postgres{}
+
+// Postgres stores applied migrations in the schema_migrations
+// table. Lock takes a session level advisory lock, so two
+// deploys starting at once migrate one after the other
+type Postgres struct {
+	db     *sql.DB
+	locked *sql.Conn
+}
+
+// NewPostgres creates a Store for the database
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Lock blocks until no other migrator holds the lock. The
+// lock belongs to a single connection, which is kept and
+// used for every statement until Unlock
+func (p *Postgres) Lock() error {
+	if p.locked != nil {
+		return errors.New("migrate: already locked")
+	}
+
+	ctx := context.Background()
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		c.Close()
+		return err
+	}
+
+	p.locked = c
+	return nil
+}
+
+// Unlock releases the lock and its connection
+func (p *Postgres) Unlock() error {
+	if p.locked == nil {
+		return nil
+	}
+
+	_, err := p.locked.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+	p.locked.Close()
+	p.locked = nil
+	return err
+}
+
+// Applied creates the schema_migrations table if needed and
+// returns the applied versions with the time they were applied
+func (p *Postgres) Applied() (map[int]time.Time, error) {
+	ctx := context.Background()
+	c := p.conn()
+	if _, err := c.ExecContext(ctx, createTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+// Apply runs the migration and records it in one transaction,
+// so a failing migration leaves neither schema nor record behind
+func (p *Postgres) Apply(m Migration, up bool) error {
+	tx, err := p.conn().BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Run(tx, up); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// conn returns the locked connection if there is one
+func (p *Postgres) conn() conn {
+	if p.locked != nil {
+		return p.locked
+	}
+
+	return p.db
+}
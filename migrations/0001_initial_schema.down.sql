@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS email_verifications;
+DROP TABLE IF EXISTS pw_resets;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS friendships;
+DROP TABLE IF EXISTS image_metadata;
+DROP TABLE IF EXISTS images;
+DROP TABLE IF EXISTS galleries;
+DROP TABLE IF EXISTS users;
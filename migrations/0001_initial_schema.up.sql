@@ -0,0 +1,116 @@
+-- The tables as gorm AutoMigrate created them, so databases
+-- set up before migrations existed can adopt this version
+
+CREATE TABLE IF NOT EXISTS users (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	name varchar(255),
+	email varchar(255) NOT NULL,
+	password_hash varchar(255) NOT NULL,
+	remember_hash varchar(255) NOT NULL,
+	email_verified_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_email ON users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_remember_hash ON users (remember_hash);
+
+CREATE TABLE IF NOT EXISTS galleries (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	user_id integer NOT NULL,
+	title varchar(255) NOT NULL,
+	visibility varchar(255) NOT NULL DEFAULT 'private',
+	share_token varchar(255) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_galleries_deleted_at ON galleries (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_galleries_user_id ON galleries (user_id);
+
+CREATE TABLE IF NOT EXISTS images (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	gallery_id integer NOT NULL,
+	filename varchar(255) NOT NULL,
+	content_type varchar(255) NOT NULL,
+	size bigint,
+	strip_metadata boolean,
+	width integer,
+	height integer
+);
+CREATE INDEX IF NOT EXISTS idx_images_deleted_at ON images (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_images_gallery_id ON images (gallery_id);
+
+CREATE TABLE IF NOT EXISTS image_metadata (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	image_id integer NOT NULL,
+	camera_make varchar(255),
+	camera_model varchar(255),
+	lens_model varchar(255),
+	exposure_time varchar(255),
+	f_number numeric,
+	iso integer,
+	focal_length numeric,
+	captured_at timestamp with time zone,
+	orientation integer,
+	latitude numeric,
+	longitude numeric
+);
+CREATE INDEX IF NOT EXISTS idx_image_metadata_deleted_at ON image_metadata (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_image_metadata_image_id ON image_metadata (image_id);
+
+CREATE TABLE IF NOT EXISTS friendships (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	user_id integer NOT NULL,
+	friend_id integer NOT NULL,
+	status varchar(255) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_friendships_deleted_at ON friendships (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_friendships_friend_id ON friendships (friend_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_friendship_pair ON friendships (user_id, friend_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id serial PRIMARY KEY,
+	created_at timestamp with time zone,
+	updated_at timestamp with time zone,
+	deleted_at timestamp with time zone,
+	user_id integer NOT NULL,
+	token_hash varchar(255) NOT NULL,
+	user_agent varchar(255),
+	ip varchar(255),
+	last_seen_at timestamp with time zone,
+	expires_at timestamp with time zone NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_deleted_at ON sessions (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_sessions_token_hash ON sessions (token_hash);
+
+CREATE TABLE IF NOT EXISTS pw_resets (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	token_hash varchar(255) NOT NULL,
+	expires_at timestamp with time zone NOT NULL,
+	created_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_pw_resets_user_id ON pw_resets (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_pw_resets_token_hash ON pw_resets (token_hash);
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	token_hash varchar(255) NOT NULL,
+	expires_at timestamp with time zone NOT NULL,
+	created_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_email_verifications_user_id ON email_verifications (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_email_verifications_token_hash ON email_verifications (token_hash);
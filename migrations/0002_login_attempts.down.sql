@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS rate_limit_locks;
+DROP TABLE IF EXISTS rate_limit_failures;
+DROP TABLE IF EXISTS login_attempts;
@@ -0,0 +1,27 @@
+-- Every attempt to log in with a password, so users can
+-- see them, and the failures and lockouts of the postgres
+-- rate limit store shared by every instance of the app
+
+CREATE TABLE login_attempts (
+	id serial PRIMARY KEY,
+	user_id integer,
+	email varchar(255) NOT NULL,
+	ip varchar(255),
+	user_agent varchar(255),
+	result varchar(255) NOT NULL,
+	created_at timestamp with time zone
+);
+CREATE INDEX idx_login_attempts_user_id ON login_attempts (user_id, created_at);
+
+CREATE TABLE rate_limit_failures (
+	key text NOT NULL,
+	failed_at timestamp with time zone NOT NULL
+);
+CREATE INDEX idx_rate_limit_failures_key ON rate_limit_failures (key, failed_at);
+CREATE INDEX idx_rate_limit_failures_failed_at ON rate_limit_failures (failed_at);
+
+CREATE TABLE rate_limit_locks (
+	key text PRIMARY KEY,
+	locked_until timestamp with time zone NOT NULL,
+	lockouts integer NOT NULL
+);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS recovery_codes;
+
+ALTER TABLE sessions
+	DROP COLUMN IF EXISTS pending;
+
+ALTER TABLE users
+	DROP COLUMN IF EXISTS totp_last_step,
+	DROP COLUMN IF EXISTS totp_enabled_at,
+	DROP COLUMN IF EXISTS totp_secret;
@@ -0,0 +1,19 @@
+-- Optional TOTP two factor auth. Sessions of users who
+-- entered their password but not yet their code are pending
+
+ALTER TABLE users
+	ADD COLUMN totp_secret varchar(255),
+	ADD COLUMN totp_enabled_at timestamp with time zone,
+	ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE sessions
+	ADD COLUMN pending boolean NOT NULL DEFAULT false;
+
+CREATE TABLE recovery_codes (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	code_hash varchar(255) NOT NULL,
+	used_at timestamp with time zone,
+	created_at timestamp with time zone
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
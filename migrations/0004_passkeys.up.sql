@@ -0,0 +1,26 @@
+-- WebAuthn passkeys users can log in with instead of a
+-- password, and the challenges of ceremonies in progress
+
+CREATE TABLE passkeys (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	name varchar(255) NOT NULL,
+	credential_id bytea NOT NULL,
+	public_key bytea NOT NULL,
+	sign_count bigint NOT NULL DEFAULT 0,
+	last_used_at timestamp with time zone,
+	created_at timestamp with time zone
+);
+CREATE UNIQUE INDEX uix_passkeys_credential_id ON passkeys (credential_id);
+CREATE INDEX idx_passkeys_user_id ON passkeys (user_id);
+
+CREATE TABLE passkey_challenges (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL DEFAULT 0,
+	token_hash varchar(255) NOT NULL,
+	challenge bytea NOT NULL,
+	expires_at timestamp with time zone NOT NULL,
+	created_at timestamp with time zone
+);
+CREATE UNIQUE INDEX uix_passkey_challenges_token_hash ON passkey_challenges (token_hash);
+CREATE INDEX idx_passkey_challenges_expires_at ON passkey_challenges (expires_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS identity_flows;
+DROP TABLE IF EXISTS identities;
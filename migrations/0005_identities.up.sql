@@ -0,0 +1,26 @@
+-- Accounts at OpenID Connect providers linked to users,
+-- and the sign ins at a provider in progress
+
+CREATE TABLE identities (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	provider varchar(255) NOT NULL,
+	subject varchar(255) NOT NULL,
+	email varchar(255),
+	created_at timestamp with time zone
+);
+CREATE UNIQUE INDEX idx_identities_provider_subject ON identities (provider, subject);
+CREATE INDEX idx_identities_user_id ON identities (user_id);
+
+CREATE TABLE identity_flows (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL DEFAULT 0,
+	provider varchar(255) NOT NULL,
+	token_hash varchar(255) NOT NULL,
+	nonce varchar(255) NOT NULL,
+	verifier varchar(255) NOT NULL,
+	expires_at timestamp with time zone NOT NULL,
+	created_at timestamp with time zone
+);
+CREATE UNIQUE INDEX uix_identity_flows_token_hash ON identity_flows (token_hash);
+CREATE INDEX idx_identity_flows_expires_at ON identity_flows (expires_at);
@@ -0,0 +1,15 @@
+-- Personal access tokens scripts use instead of the
+-- session cookie, stored as HMACs like sessions
+
+CREATE TABLE api_tokens (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	name varchar(255) NOT NULL,
+	token_hash varchar(255) NOT NULL,
+	scopes varchar(255) NOT NULL,
+	expires_at timestamp with time zone,
+	last_used_at timestamp with time zone,
+	created_at timestamp with time zone
+);
+CREATE UNIQUE INDEX uix_api_tokens_token_hash ON api_tokens (token_hash);
+CREATE INDEX idx_api_tokens_user_id ON api_tokens (user_id);
@@ -0,0 +1,20 @@
+-- Images uploaded in chunks with the tus protocol. The
+-- chunks themselves are kept in the image storage until
+-- the upload is complete
+
+CREATE TABLE uploads (
+	id serial PRIMARY KEY,
+	user_id integer NOT NULL,
+	gallery_id integer NOT NULL,
+	filename varchar(255) NOT NULL,
+	strip_metadata boolean,
+	content_type varchar(255),
+	length bigint NOT NULL,
+	"offset" bigint NOT NULL,
+	parts text,
+	image_id integer,
+	expires_at timestamp with time zone NOT NULL,
+	created_at timestamp with time zone
+);
+CREATE INDEX idx_uploads_user_id ON uploads (user_id);
+CREATE INDEX idx_uploads_expires_at ON uploads (expires_at);
@@ -0,0 +1,20 @@
+-- Perceptual hashes of images, to warn about duplicates. The
+-- 64 bit hash is also split into four parts of 16 bits which
+-- are indexed on their own, so the hashes near another are
+-- found by looking up its parts and the values close to them
+
+CREATE TABLE image_hashes (
+	id serial PRIMARY KEY,
+	image_id integer NOT NULL,
+	hash bigint NOT NULL,
+	part0 integer NOT NULL,
+	part1 integer NOT NULL,
+	part2 integer NOT NULL,
+	part3 integer NOT NULL,
+	created_at timestamp with time zone
+);
+CREATE UNIQUE INDEX uix_image_hashes_image_id ON image_hashes (image_id);
+CREATE INDEX idx_image_hashes_part0 ON image_hashes (part0);
+CREATE INDEX idx_image_hashes_part1 ON image_hashes (part1);
+CREATE INDEX idx_image_hashes_part2 ON image_hashes (part2);
+CREATE INDEX idx_image_hashes_part3 ON image_hashes (part3);
@@ -0,0 +1,425 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"../../photofriends/hash"
+	"../../photofriends/rand"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrAPITokenInvalid is returned when a request is made
+	// with an API token that is unknown or was revoked
+	ErrAPITokenInvalid = modelError("The API token is not valid")
+
+	// ErrAPITokenExpired is returned when a request is made
+	// with an API token that is past its expiry time
+	ErrAPITokenExpired = modelError("The API token has expired")
+
+	// ErrAPITokenScope is returned when a request is made with
+	// an API token that was not given the scope it needs
+	ErrAPITokenScope = modelError("The API token does not have the scope needed for this request")
+
+	// ErrAPITokenNameRequired is returned when a token is created without a name
+	ErrAPITokenNameRequired = modelError("Name is required")
+
+	// ErrAPITokenNameTooLong is returned when a token is given a name that is too long
+	ErrAPITokenNameTooLong = modelError("Name must be at most 64 characters long")
+
+	// ErrAPITokenScopesRequired is returned when a token is created without scopes
+	ErrAPITokenScopesRequired = modelError("Please pick at least one scope")
+
+	// ErrAPITokenScopeInvalid is returned when a token is given
+	// a scope other than the ones listed in APITokenScopes
+	ErrAPITokenScopeInvalid = modelError("Scope is not valid")
+
+	// ErrAPITokenExpiryInvalid is returned when a token
+	// is created with an expiry time in the past
+	ErrAPITokenExpiryInvalid = modelError("Expiry must be in the future")
+)
+
+// API token scopes, the parts of the API a token may use
+const (
+	ScopeGalleriesRead  = "galleries:read"
+	ScopeGalleriesWrite = "galleries:write"
+	ScopeImagesUpload   = "images:upload"
+	ScopeFriendsRead    = "friends:read"
+)
+
+// APITokenScope describes a scope to the user picking it
+type APITokenScope struct {
+	Name        string
+	Description string
+}
+
+// APITokenScopes are the scopes tokens can be given
+var APITokenScopes = []APITokenScope{
+	{ScopeGalleriesRead, "See your galleries and their images"},
+	{ScopeGalleriesWrite, "Create, change and delete your galleries and images"},
+	{ScopeImagesUpload, "Upload images to your galleries"},
+	{ScopeFriendsRead, "See your friends and friend requests"},
+}
+
+const (
+	// apiTokenPrefix starts every token, so they are easy to
+	// recognize, eg: by secret scanners when they leak
+	apiTokenPrefix = "pf_"
+
+	// apiTokenNameMaxLength is the longest name a token can have
+	apiTokenNameMaxLength = 64
+
+	// apiTokenTouchInterval limits how often LastUsedAt is
+	// written, so not every request results in an update
+	apiTokenTouchInterval = time.Minute
+)
+
+// APIToken is a personal access token, used by scripts
+// instead of the session cookie. The raw token is only
+// shown to the user once, we store its HMAC
+type APIToken struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not_null;index"`
+	Name      string `gorm:"not_null"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not_null;unique_index"`
+
+	// Scopes are the scope names separated by spaces
+	Scopes     string `gorm:"not_null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// ScopeList returns the scopes of the token
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// HasScope reports whether the token was given scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired reports whether the token is past its expiry
+// time. Tokens without one never expire
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// APITokenService is used to manage personal access
+// tokens and authenticate requests made with them
+type APITokenService interface {
+	// Issue creates a token for the user. The raw token is
+	// set on the returned token, and can not be seen again
+	Issue(user *User, name string, scopes []string, expiresAt *time.Time) (*APIToken, error)
+
+	// ByToken looks up the token for a raw token. Expired
+	// tokens return ErrAPITokenExpired, anything else
+	// that is not found ErrAPITokenInvalid
+	ByToken(token string) (*APIToken, error)
+
+	// Touch records that the token was just used
+	Touch(token *APIToken) error
+
+	// Revoke deletes a token of the user
+	Revoke(userID, tokenID uint) error
+
+	APITokenDB
+}
+
+// APITokenDB is used to interact with the api tokens database
+type APITokenDB interface {
+	ByID(id uint) (*APIToken, error)
+	ByTokenHash(tokenHash string) (*APIToken, error)
+
+	// ByUserID returns the tokens of a user, newest first
+	ByUserID(userID uint) ([]APIToken, error)
+	Create(token *APIToken) error
+	Update(token *APIToken) error
+	Delete(id uint) error
+}
+
+func NewAPITokenService(db *gorm.DB, hmac hash.HMAC) APITokenService {
+	return &apiTokenService{
+		APITokenDB: &apiTokenValidator{
+			APITokenDB: &apiTokenGorm{db},
+			hmac:       hmac,
+		},
+	}
+}
+
+// ensure interface is matching
+var _ APITokenService = &apiTokenService{}
+
+type apiTokenService struct {
+	APITokenDB
+}
+
+func (ts *apiTokenService) Issue(user *User, name string, scopes []string, expiresAt *time.Time) (*APIToken, error) {
+	token := APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+
+	if err := ts.Create(&token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (ts *apiTokenService) ByToken(token string) (*APIToken, error) {
+	t, err := ts.ByTokenHash(token)
+	if err == ErrNotFound || err == ErrTokenRequired {
+		return nil, ErrAPITokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Expired() {
+		return nil, ErrAPITokenExpired
+	}
+
+	return t, nil
+}
+
+func (ts *apiTokenService) Touch(token *APIToken) error {
+	if token.LastUsedAt != nil && time.Since(*token.LastUsedAt) < apiTokenTouchInterval {
+		return nil
+	}
+
+	now := time.Now()
+	token.LastUsedAt = &now
+	return ts.Update(token)
+}
+
+func (ts *apiTokenService) Revoke(userID, tokenID uint) error {
+	token, err := ts.ByID(tokenID)
+	if err != nil {
+		return err
+	}
+
+	if token.UserID != userID {
+		return ErrNotFound
+	}
+
+	return ts.Delete(token.ID)
+}
+
+/******************* VALIDATORS **************************/
+
+type apiTokenValidator struct {
+	APITokenDB
+	hmac hash.HMAC
+}
+
+// ByTokenHash expects the raw token and will
+// hash it before looking up the token
+func (tv *apiTokenValidator) ByTokenHash(token string) (*APIToken, error) {
+	t := APIToken{Token: token}
+	if err := runAPITokenValFuncs(&t, tv.hmacToken); err != nil {
+		return nil, err
+	}
+
+	return tv.APITokenDB.ByTokenHash(t.TokenHash)
+}
+
+func (tv *apiTokenValidator) Create(token *APIToken) error {
+	if err := tv.fields(token); err != nil {
+		return err
+	}
+
+	err := runAPITokenValFuncs(token,
+		tv.userIDRequired,
+		tv.setTokenIfUnset,
+		tv.hmacToken)
+
+	if err != nil {
+		return err
+	}
+
+	return tv.APITokenDB.Create(token)
+}
+
+func (tv *apiTokenValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return tv.APITokenDB.Delete(id)
+}
+
+// fields validates the fields of the token form,
+// returning FieldErrors for all that are invalid
+func (tv *apiTokenValidator) fields(token *APIToken) error {
+	fe := FieldErrors{}
+	fe.add("name", runAPITokenValFuncs(token, tv.trimName, tv.nameRequired, tv.nameMaxLength))
+	fe.add("scopes", runAPITokenValFuncs(token, tv.scopesRequired, tv.scopesValid))
+	fe.add("expires_at", runAPITokenValFuncs(token, tv.expiryInFuture))
+	return fe.err()
+}
+
+func (tv *apiTokenValidator) userIDRequired(t *APIToken) error {
+	if t.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (tv *apiTokenValidator) trimName(t *APIToken) error {
+	t.Name = strings.TrimSpace(t.Name)
+	return nil
+}
+
+func (tv *apiTokenValidator) nameRequired(t *APIToken) error {
+	if t.Name == "" {
+		return ErrAPITokenNameRequired
+	}
+
+	return nil
+}
+
+func (tv *apiTokenValidator) nameMaxLength(t *APIToken) error {
+	if utf8.RuneCountInString(t.Name) > apiTokenNameMaxLength {
+		return ErrAPITokenNameTooLong
+	}
+
+	return nil
+}
+
+func (tv *apiTokenValidator) scopesRequired(t *APIToken) error {
+	if len(t.ScopeList()) == 0 {
+		return ErrAPITokenScopesRequired
+	}
+
+	return nil
+}
+
+func (tv *apiTokenValidator) scopesValid(t *APIToken) error {
+	for _, scope := range t.ScopeList() {
+		valid := false
+		for _, s := range APITokenScopes {
+			valid = valid || s.Name == scope
+		}
+
+		if !valid {
+			return ErrAPITokenScopeInvalid
+		}
+	}
+
+	return nil
+}
+
+func (tv *apiTokenValidator) expiryInFuture(t *APIToken) error {
+	if t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now()) {
+		return ErrAPITokenExpiryInvalid
+	}
+
+	return nil
+}
+
+// setTokenIfUnset creates the raw token, prefixed
+// with apiTokenPrefix
+func (tv *apiTokenValidator) setTokenIfUnset(t *APIToken) error {
+	if t.Token != "" {
+		return nil
+	}
+
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	t.Token = apiTokenPrefix + token
+	return nil
+}
+
+func (tv *apiTokenValidator) hmacToken(t *APIToken) error {
+	if t.Token == "" {
+		return ErrTokenRequired
+	}
+
+	t.TokenHash = tv.hmac.Hash(t.Token)
+	return nil
+}
+
+type apiTokenValFunc func(*APIToken) error
+
+func runAPITokenValFuncs(token *APIToken, fns ...apiTokenValFunc) error {
+	for _, fn := range fns {
+		if err := fn(token); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/************************************************************/
+
+// ensure interface is matching
+var _ APITokenDB = &apiTokenGorm{}
+
+type apiTokenGorm struct {
+	db *gorm.DB
+}
+
+func (tg *apiTokenGorm) ByID(id uint) (*APIToken, error) {
+	var token APIToken
+	if err := first(tg.db.Where("id = ?", id), &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// ByTokenHash looks up a token by the already hashed token
+func (tg *apiTokenGorm) ByTokenHash(tokenHash string) (*APIToken, error) {
+	var token APIToken
+	if err := first(tg.db.Where("token_hash = ?", tokenHash), &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (tg *apiTokenGorm) ByUserID(userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := tg.db.
+		Where("user_id = ?", userID).
+		Order("id desc").
+		Find(&tokens).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (tg *apiTokenGorm) Create(token *APIToken) error {
+	return tg.db.Create(token).Error
+}
+
+func (tg *apiTokenGorm) Update(token *APIToken) error {
+	return tg.db.Save(token).Error
+}
+
+// Delete removes the token for good, revoked
+// tokens have no reason to stick around
+func (tg *apiTokenGorm) Delete(id uint) error {
+	return tg.db.Delete(&APIToken{ID: id}).Error
+}
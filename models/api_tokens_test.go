@@ -0,0 +1,160 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"../../photofriends/hash"
+)
+
+// memAPITokenDB is an in-memory APITokenDB
+type memAPITokenDB struct {
+	nextID uint
+	tokens map[uint]APIToken
+}
+
+func (m *memAPITokenDB) ByID(id uint) (*APIToken, error) {
+	t, ok := m.tokens[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &t, nil
+}
+
+func (m *memAPITokenDB) ByTokenHash(tokenHash string) (*APIToken, error) {
+	for _, t := range m.tokens {
+		if t.TokenHash == tokenHash {
+			return &t, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memAPITokenDB) ByUserID(userID uint) ([]APIToken, error) {
+	var all []APIToken
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			all = append(all, t)
+		}
+	}
+
+	return all, nil
+}
+
+func (m *memAPITokenDB) Create(t *APIToken) error {
+	m.nextID++
+	t.ID = m.nextID
+	return m.Update(t)
+}
+
+func (m *memAPITokenDB) Update(t *APIToken) error {
+	stored := *t
+	stored.Token = ""
+	m.tokens[t.ID] = stored
+	return nil
+}
+
+func (m *memAPITokenDB) Delete(id uint) error {
+	delete(m.tokens, id)
+	return nil
+}
+
+func testingAPITokenService() (APITokenService, *memAPITokenDB) {
+	db := &memAPITokenDB{tokens: make(map[uint]APIToken)}
+	return &apiTokenService{
+		APITokenDB: &apiTokenValidator{APITokenDB: db, hmac: hash.NewHMAC("test-key")},
+	}, db
+}
+
+func TestAPITokenIssue(t *testing.T) {
+	ts, db := testingAPITokenService()
+	user := &User{}
+	user.ID = 1
+
+	token, err := ts.Issue(user, " Backup ", []string{ScopeGalleriesRead, ScopeImagesUpload}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(token.Token, apiTokenPrefix) || token.Name != "Backup" {
+		t.Errorf("Expected a prefixed raw token named Backup. Recieved %+v", token)
+	}
+
+	stored := db.tokens[token.ID]
+	if stored.Token != "" || stored.TokenHash == "" || stored.TokenHash == token.Token {
+		t.Errorf("Expected only the HMAC of the token to be stored. Recieved %+v", stored)
+	}
+
+	found, err := ts.ByToken(token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found.HasScope(ScopeImagesUpload) || found.HasScope(ScopeGalleriesWrite) {
+		t.Errorf("Expected the scopes to be kept. Recieved %q", found.Scopes)
+	}
+
+	if _, err := ts.ByToken(token.Token + "x"); err != ErrAPITokenInvalid {
+		t.Errorf("Expected ErrAPITokenInvalid for an unknown token. Recieved %v", err)
+	}
+
+	if _, err := ts.ByToken(""); err != ErrAPITokenInvalid {
+		t.Errorf("Expected ErrAPITokenInvalid for an empty token. Recieved %v", err)
+	}
+}
+
+func TestAPITokenFieldErrors(t *testing.T) {
+	ts, _ := testingAPITokenService()
+	user := &User{}
+	user.ID = 1
+
+	past := time.Now().Add(-time.Hour)
+	_, err := ts.Issue(user, "", []string{"galleries:admin"}, &past)
+	fe, ok := err.(FieldErrors)
+	if !ok {
+		t.Fatalf("Expected FieldErrors. Recieved %v", err)
+	}
+
+	if fe["name"] != ErrAPITokenNameRequired || fe["scopes"] != ErrAPITokenScopeInvalid || fe["expires_at"] != ErrAPITokenExpiryInvalid {
+		t.Errorf("Expected every field to be invalid. Recieved %v", fe)
+	}
+
+	if _, err := ts.Issue(user, "Script", nil, nil); err.(FieldErrors)["scopes"] != ErrAPITokenScopesRequired {
+		t.Errorf("Expected ErrAPITokenScopesRequired. Recieved %v", err)
+	}
+}
+
+func TestAPITokenExpiryAndRevoke(t *testing.T) {
+	ts, db := testingAPITokenService()
+	user := &User{}
+	user.ID = 1
+
+	soon := time.Now().Add(time.Hour)
+	token, err := ts.Issue(user, "Script", []string{ScopeFriendsRead}, &soon)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := db.tokens[token.ID]
+	past := time.Now().Add(-time.Minute)
+	stored.ExpiresAt = &past
+	db.tokens[token.ID] = stored
+	if _, err := ts.ByToken(token.Token); err != ErrAPITokenExpired {
+		t.Errorf("Expected ErrAPITokenExpired. Recieved %v", err)
+	}
+
+	if err := ts.Revoke(2, token.ID); err != ErrNotFound {
+		t.Errorf("Expected users not to revoke tokens of others. Recieved %v", err)
+	}
+
+	if err := ts.Revoke(user.ID, token.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ts.ByToken(token.Token); err != ErrAPITokenInvalid {
+		t.Errorf("Expected a revoked token to be invalid. Recieved %v", err)
+	}
+}
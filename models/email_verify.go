@@ -0,0 +1,168 @@
+package models
+
+import (
+	"time"
+
+	"../../photofriends/hash"
+	"../../photofriends/rand"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrVerificationInvalid is returned when an email verification
+	// token does not exist, was already used or expired
+	ErrVerificationInvalid = modelError("This verification link is invalid or has expired")
+
+	// ErrEmailVerified is returned when a verification email is
+	// requested for a user that has already verified their address
+	ErrEmailVerified = modelError("Email address is already verified")
+
+	// ErrEmailUnverified is returned when a user tries to use a
+	// feature that requires a verified email address
+	ErrEmailUnverified = modelError("Please verify your email address first")
+)
+
+// emailVerificationLifetime is how long a verification link can be used
+const emailVerificationLifetime = 24 * time.Hour
+
+// emailVerification is a single use token proving the user
+// can read mail sent to their address. Only the HMAC of the
+// token is stored
+type emailVerification struct {
+	ID        uint      `gorm:"primary_key"`
+	UserID    uint      `gorm:"not_null;index"`
+	Token     string    `gorm:"-"`
+	TokenHash string    `gorm:"not_null;unique_index"`
+	ExpiresAt time.Time `gorm:"not_null"`
+	CreatedAt time.Time
+}
+
+type emailVerificationDB interface {
+	ByToken(token string) (*emailVerification, error)
+	Create(ev *emailVerification) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+func newEmailVerificationValidator(db emailVerificationDB, hmac hash.HMAC) *emailVerificationValidator {
+	return &emailVerificationValidator{
+		emailVerificationDB: db,
+		hmac:                hmac,
+	}
+}
+
+type emailVerificationValidator struct {
+	emailVerificationDB
+	hmac hash.HMAC
+}
+
+// ByToken expects the raw token and will hash
+// it before looking up the verification
+func (evv *emailVerificationValidator) ByToken(token string) (*emailVerification, error) {
+	ev := emailVerification{Token: token}
+	if err := runEmailVerificationValFuncs(&ev, evv.hmacToken); err != nil {
+		return nil, err
+	}
+
+	return evv.emailVerificationDB.ByToken(ev.TokenHash)
+}
+
+func (evv *emailVerificationValidator) Create(ev *emailVerification) error {
+	err := runEmailVerificationValFuncs(ev,
+		evv.requireUserID,
+		evv.setTokenIfUnset,
+		evv.hmacToken,
+		evv.setExpiry)
+
+	if err != nil {
+		return err
+	}
+
+	return evv.emailVerificationDB.Create(ev)
+}
+
+func (evv *emailVerificationValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return evv.emailVerificationDB.Delete(id)
+}
+
+func (evv *emailVerificationValidator) requireUserID(ev *emailVerification) error {
+	if ev.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (evv *emailVerificationValidator) setTokenIfUnset(ev *emailVerification) error {
+	if ev.Token != "" {
+		return nil
+	}
+
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	ev.Token = token
+	return nil
+}
+
+func (evv *emailVerificationValidator) hmacToken(ev *emailVerification) error {
+	if ev.Token == "" {
+		return ErrVerificationInvalid
+	}
+
+	ev.TokenHash = evv.hmac.Hash(ev.Token)
+	return nil
+}
+
+func (evv *emailVerificationValidator) setExpiry(ev *emailVerification) error {
+	ev.ExpiresAt = time.Now().Add(emailVerificationLifetime)
+	return nil
+}
+
+type emailVerificationValFunc func(*emailVerification) error
+
+func runEmailVerificationValFuncs(ev *emailVerification, fns ...emailVerificationValFunc) error {
+	for _, fn := range fns {
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensure interface is matching
+var _ emailVerificationDB = &emailVerificationGorm{}
+
+type emailVerificationGorm struct {
+	db *gorm.DB
+}
+
+// ByToken looks up a verification by the already hashed token
+func (evg *emailVerificationGorm) ByToken(tokenHash string) (*emailVerification, error) {
+	var ev emailVerification
+	if err := first(evg.db.Where("token_hash = ?", tokenHash), &ev); err != nil {
+		return nil, err
+	}
+
+	return &ev, nil
+}
+
+func (evg *emailVerificationGorm) Create(ev *emailVerification) error {
+	return evg.db.Create(ev).Error
+}
+
+func (evg *emailVerificationGorm) Delete(id uint) error {
+	ev := emailVerification{ID: id}
+	return evg.db.Delete(&ev).Error
+}
+
+func (evg *emailVerificationGorm) DeleteByUserID(userID uint) error {
+	return evg.db.Where("user_id = ?", userID).Delete(&emailVerification{}).Error
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"sort"
+	"strings"
+)
+
+// modelError is an error with a message that is safe to
+// show to users. Errors about programming mistakes, like a
+// missing ID, stay plain errors so they are never shown
+type modelError string
+
+func (e modelError) Error() string {
+	return string(e)
+}
+
+// Public returns the message shown to users
+func (e modelError) Public() string {
+	return string(e)
+}
+
+// FieldErrors is returned when one or more fields are not
+// valid. It maps form field names to the first error found
+// for each of them, so every problem can be shown at once
+type FieldErrors map[string]error
+
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for field := range fe {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, len(fields))
+	for i, field := range fields {
+		msgs[i] = field + ": " + fe[field].Error()
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Public returns the message shown above the form, the
+// errors themselves are shown next to each field
+func (fe FieldErrors) Public() string {
+	return "Please fix the errors below"
+}
+
+// Fields returns the message for every invalid field
+func (fe FieldErrors) Fields() map[string]string {
+	msgs := make(map[string]string, len(fe))
+	for field, err := range fe {
+		msgs[field] = err.Error()
+	}
+
+	return msgs
+}
+
+// add records err for the field, unless it is nil
+func (fe FieldErrors) add(field string, err error) {
+	if err != nil {
+		fe[field] = err
+	}
+}
+
+// err returns nil if every field is valid. When one of
+// the errors is not about the input, like a failed query,
+// it is returned on its own so it is not shown to users
+func (fe FieldErrors) err() error {
+	if len(fe) == 0 {
+		return nil
+	}
+
+	for _, err := range fe {
+		if _, ok := err.(interface{ Public() string }); !ok {
+			return err
+		}
+	}
+
+	return fe
+}
@@ -0,0 +1,378 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrFriendSelf is returned when a user tries
+	// to befriend or block themselves
+	ErrFriendSelf = modelError("You can not befriend yourself")
+
+	// ErrFriendRequestExists is returned when a friend
+	// request is sent twice to the same user
+	ErrFriendRequestExists = modelError("Friend request already sent")
+
+	// ErrAlreadyFriends is returned when a friend request
+	// is sent to someone who is already a friend
+	ErrAlreadyFriends = modelError("You are already friends")
+
+	// ErrFriendBlocked is returned when a friend request is
+	// sent between users where one has blocked the other
+	ErrFriendBlocked = modelError("You can not send a friend request to this user")
+
+	// ErrFriendStatusInvalid is returned when a friendship is
+	// saved with a status other than the Friendship* constants
+	ErrFriendStatusInvalid = errors.New("Friendship status is not valid")
+)
+
+// Friendship statuses
+const (
+	// FriendshipPending is a friend request from
+	// UserID to FriendID that is not yet answered
+	FriendshipPending = "pending"
+
+	// FriendshipAccepted means both users are friends
+	FriendshipAccepted = "accepted"
+
+	// FriendshipBlocked means UserID has blocked FriendID
+	FriendshipBlocked = "blocked"
+)
+
+// Friendship is the relationship between two users. There is
+// at most one friendship for every pair of users, UserID is
+// always the user that sent the request or did the blocking
+type Friendship struct {
+	gorm.Model
+	UserID   uint   `gorm:"not_null;unique_index:idx_friendship_pair"`
+	FriendID uint   `gorm:"not_null;unique_index:idx_friendship_pair;index"`
+	Status   string `gorm:"not_null"`
+}
+
+// Other returns the ID of the user on the other side
+// of the friendship than the user with the given id
+func (f *Friendship) Other(userID uint) uint {
+	if f.UserID == userID {
+		return f.FriendID
+	}
+
+	return f.UserID
+}
+
+// FriendService is used to manage friend requests
+// and the relationships between users
+type FriendService interface {
+	// Request sends a friend request from one user to
+	// another. If the other user already sent a request
+	// to us, that request is accepted instead
+	Request(fromID, toID uint) (*Friendship, error)
+
+	// Accept and Decline answer a pending request. Only the
+	// user the request was sent to can answer it
+	Accept(userID, friendshipID uint) error
+	Decline(userID, friendshipID uint) error
+
+	// Unfriend removes the friendship between the users,
+	// cancels a pending request or lifts a block
+	Unfriend(userID, otherID uint) error
+
+	// Block blocks otherID, ending any friendship and
+	// preventing any further friend requests
+	Block(userID, otherID uint) error
+
+	// AreFriends reports whether the users are friends
+	AreFriends(a, b uint) (bool, error)
+
+	FriendDB
+}
+
+// FriendDB is used to interact with the friendships database
+type FriendDB interface {
+	ByID(id uint) (*Friendship, error)
+
+	// Between looks up the friendship between two users,
+	// no matter which of them created it
+	Between(a, b uint) (*Friendship, error)
+
+	// ByUserID returns every friendship the user is part of
+	ByUserID(userID uint) ([]Friendship, error)
+
+	// ByUserIDPage returns a page of the friendships
+	// the user is part of, newest first
+	ByUserIDPage(userID uint, page Page) ([]Friendship, error)
+
+	Create(friendship *Friendship) error
+	Update(friendship *Friendship) error
+	Delete(id uint) error
+}
+
+func NewFriendService(db *gorm.DB) FriendService {
+	return &friendService{
+		FriendDB: &friendValidator{&friendGorm{db}},
+	}
+}
+
+// ensure interface is matching
+var _ FriendService = &friendService{}
+
+type friendService struct {
+	FriendDB
+}
+
+func (fs *friendService) Request(fromID, toID uint) (*Friendship, error) {
+	existing, err := fs.Between(fromID, toID)
+	if err == ErrNotFound {
+		friendship := Friendship{
+			UserID:   fromID,
+			FriendID: toID,
+			Status:   FriendshipPending,
+		}
+
+		return &friendship, fs.Create(&friendship)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch existing.Status {
+	case FriendshipAccepted:
+		return nil, ErrAlreadyFriends
+	case FriendshipBlocked:
+		return nil, ErrFriendBlocked
+	}
+
+	if existing.UserID == fromID {
+		return nil, ErrFriendRequestExists
+	}
+
+	// they already asked us, so this is an accept
+	existing.Status = FriendshipAccepted
+	return existing, fs.Update(existing)
+}
+
+func (fs *friendService) Accept(userID, friendshipID uint) error {
+	friendship, err := fs.pendingFor(userID, friendshipID)
+	if err != nil {
+		return err
+	}
+
+	friendship.Status = FriendshipAccepted
+	return fs.Update(friendship)
+}
+
+func (fs *friendService) Decline(userID, friendshipID uint) error {
+	friendship, err := fs.pendingFor(userID, friendshipID)
+	if err != nil {
+		return err
+	}
+
+	return fs.Delete(friendship.ID)
+}
+
+func (fs *friendService) Unfriend(userID, otherID uint) error {
+	friendship, err := fs.Between(userID, otherID)
+	if err != nil {
+		return err
+	}
+
+	// only the user that did the blocking can lift it
+	if friendship.Status == FriendshipBlocked && friendship.UserID != userID {
+		return ErrNotFound
+	}
+
+	return fs.Delete(friendship.ID)
+}
+
+func (fs *friendService) Block(userID, otherID uint) error {
+	friendship, err := fs.Between(userID, otherID)
+	if err == ErrNotFound {
+		return fs.Create(&Friendship{
+			UserID:   userID,
+			FriendID: otherID,
+			Status:   FriendshipBlocked,
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	friendship.UserID = userID
+	friendship.FriendID = otherID
+	friendship.Status = FriendshipBlocked
+	return fs.Update(friendship)
+}
+
+func (fs *friendService) AreFriends(a, b uint) (bool, error) {
+	friendship, err := fs.Between(a, b)
+	if err == ErrNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return friendship.Status == FriendshipAccepted, nil
+}
+
+// pendingFor looks up a pending request sent to userID
+func (fs *friendService) pendingFor(userID, friendshipID uint) (*Friendship, error) {
+	friendship, err := fs.ByID(friendshipID)
+	if err != nil {
+		return nil, err
+	}
+
+	if friendship.Status != FriendshipPending || friendship.FriendID != userID {
+		return nil, ErrNotFound
+	}
+
+	return friendship, nil
+}
+
+/******************* VALIDATORS **************************/
+
+type friendValidator struct {
+	FriendDB
+}
+
+func (fv *friendValidator) Create(friendship *Friendship) error {
+	err := runFriendValFuncs(friendship,
+		fv.userIDsRequired,
+		fv.notSelf,
+		fv.statusValid)
+
+	if err != nil {
+		return err
+	}
+
+	return fv.FriendDB.Create(friendship)
+}
+
+func (fv *friendValidator) Update(friendship *Friendship) error {
+	err := runFriendValFuncs(friendship,
+		fv.userIDsRequired,
+		fv.notSelf,
+		fv.statusValid)
+
+	if err != nil {
+		return err
+	}
+
+	return fv.FriendDB.Update(friendship)
+}
+
+func (fv *friendValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return fv.FriendDB.Delete(id)
+}
+
+func (fv *friendValidator) userIDsRequired(f *Friendship) error {
+	if f.UserID <= 0 || f.FriendID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (fv *friendValidator) notSelf(f *Friendship) error {
+	if f.UserID == f.FriendID {
+		return ErrFriendSelf
+	}
+
+	return nil
+}
+
+func (fv *friendValidator) statusValid(f *Friendship) error {
+	switch f.Status {
+	case FriendshipPending, FriendshipAccepted, FriendshipBlocked:
+		return nil
+	default:
+		return ErrFriendStatusInvalid
+	}
+}
+
+type friendValFunc func(*Friendship) error
+
+func runFriendValFuncs(friendship *Friendship, fns ...friendValFunc) error {
+	for _, fn := range fns {
+		if err := fn(friendship); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/************************************************************/
+
+// ensure interface is matching
+var _ FriendDB = &friendGorm{}
+
+type friendGorm struct {
+	db *gorm.DB
+}
+
+func (fg *friendGorm) ByID(id uint) (*Friendship, error) {
+	var friendship Friendship
+	if err := first(fg.db.Where("id = ?", id), &friendship); err != nil {
+		return nil, err
+	}
+
+	return &friendship, nil
+}
+
+func (fg *friendGorm) Between(a, b uint) (*Friendship, error) {
+	var friendship Friendship
+	db := fg.db.Where("(user_id = ? AND friend_id = ?) OR (user_id = ? AND friend_id = ?)", a, b, b, a)
+	if err := first(db, &friendship); err != nil {
+		return nil, err
+	}
+
+	return &friendship, nil
+}
+
+func (fg *friendGorm) ByUserID(userID uint) ([]Friendship, error) {
+	var friendships []Friendship
+	err := fg.db.
+		Where("user_id = ? OR friend_id = ?", userID, userID).
+		Order("updated_at desc").
+		Find(&friendships).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return friendships, nil
+}
+
+func (fg *friendGorm) ByUserIDPage(userID uint, page Page) ([]Friendship, error) {
+	var friendships []Friendship
+	db := page.apply(fg.db.Where("user_id = ? OR friend_id = ?", userID, userID), true)
+	if err := db.Find(&friendships).Error; err != nil {
+		return nil, err
+	}
+
+	return friendships, nil
+}
+
+func (fg *friendGorm) Create(friendship *Friendship) error {
+	return fg.db.Create(friendship).Error
+}
+
+func (fg *friendGorm) Update(friendship *Friendship) error {
+	return fg.db.Save(friendship).Error
+}
+
+// Delete removes the friendship for good rather than soft
+// deleting it, so the pair can become friends again later
+func (fg *friendGorm) Delete(id uint) error {
+	friendship := Friendship{Model: gorm.Model{ID: id}}
+	return fg.db.Unscoped().Delete(&friendship).Error
+}
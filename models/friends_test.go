@@ -0,0 +1,157 @@
+package models
+
+import "testing"
+
+// memFriendDB is an in-memory FriendDB so the friend
+// request rules can be tested without a database
+type memFriendDB struct {
+	nextID      uint
+	friendships map[uint]*Friendship
+}
+
+func newMemFriendDB() *memFriendDB {
+	return &memFriendDB{friendships: make(map[uint]*Friendship)}
+}
+
+func (m *memFriendDB) ByID(id uint) (*Friendship, error) {
+	f, ok := m.friendships[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copy := *f
+	return &copy, nil
+}
+
+func (m *memFriendDB) Between(a, b uint) (*Friendship, error) {
+	for _, f := range m.friendships {
+		if (f.UserID == a && f.FriendID == b) || (f.UserID == b && f.FriendID == a) {
+			return m.ByID(f.ID)
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memFriendDB) ByUserID(userID uint) ([]Friendship, error) {
+	var all []Friendship
+	for _, f := range m.friendships {
+		if f.UserID == userID || f.FriendID == userID {
+			all = append(all, *f)
+		}
+	}
+
+	return all, nil
+}
+
+func (m *memFriendDB) ByUserIDPage(userID uint, page Page) ([]Friendship, error) {
+	var all []Friendship
+	for id := m.nextID; id > 0; id-- {
+		f, ok := m.friendships[id]
+		if !ok || (page.After > 0 && id >= page.After) || (f.UserID != userID && f.FriendID != userID) {
+			continue
+		}
+
+		if page.Limit > 0 && len(all) == page.Limit {
+			break
+		}
+		all = append(all, *f)
+	}
+
+	return all, nil
+}
+
+func (m *memFriendDB) Create(f *Friendship) error {
+	m.nextID++
+	f.ID = m.nextID
+	return m.Update(f)
+}
+
+func (m *memFriendDB) Update(f *Friendship) error {
+	copy := *f
+	m.friendships[f.ID] = &copy
+	return nil
+}
+
+func (m *memFriendDB) Delete(id uint) error {
+	delete(m.friendships, id)
+	return nil
+}
+
+func testingFriendService() FriendService {
+	return &friendService{
+		FriendDB: &friendValidator{newMemFriendDB()},
+	}
+}
+
+func TestFriendRequestAccept(t *testing.T) {
+	fs := testingFriendService()
+
+	req, err := fs.Request(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Request(1, 2); err != ErrFriendRequestExists {
+		t.Errorf("Expected ErrFriendRequestExists. Recieved %v", err)
+	}
+
+	// only the user the request was sent to can accept it
+	if err := fs.Accept(1, req.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound. Recieved %v", err)
+	}
+
+	if err := fs.Accept(2, req.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := fs.AreFriends(2, 1); !ok {
+		t.Error("Expected users to be friends")
+	}
+}
+
+func TestFriendRequestBothWays(t *testing.T) {
+	fs := testingFriendService()
+
+	if _, err := fs.Request(1, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// asking back counts as accepting
+	if _, err := fs.Request(2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := fs.AreFriends(1, 2); !ok {
+		t.Error("Expected users to be friends")
+	}
+}
+
+func TestFriendBlock(t *testing.T) {
+	fs := testingFriendService()
+
+	if _, err := fs.Request(1, 1); err != ErrFriendSelf {
+		t.Errorf("Expected ErrFriendSelf. Recieved %v", err)
+	}
+
+	if err := fs.Block(2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Request(1, 2); err != ErrFriendBlocked {
+		t.Errorf("Expected ErrFriendBlocked. Recieved %v", err)
+	}
+
+	// the blocked user can not lift the block
+	if err := fs.Unfriend(1, 2); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound. Recieved %v", err)
+	}
+
+	if err := fs.Unfriend(2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fs.Request(1, 2); err != nil {
+		t.Errorf("Expected request to succeed after unblocking. Recieved %v", err)
+	}
+}
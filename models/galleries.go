@@ -1,41 +1,132 @@
 package models
 
 import (
+	"crypto/subtle"
 	"errors"
 
+	"../../photofriends/rand"
 	"github.com/jinzhu/gorm"
 )
 
 var (
 	ErrUserIDRequired = errors.New("User ID is required")
 
-	ErrTitleRequired = errors.New("Title is required")
+	ErrTitleRequired = modelError("Title is required")
+
+	// ErrVisibilityInvalid is returned when a gallery is saved
+	// with a visibility other than the Visibility* constants
+	ErrVisibilityInvalid = modelError("Visibility must be private, friends, unlisted or public")
+
+	// ErrNotOwner is returned when an update is attempted on
+	// a gallery by someone other than the user that owns it
+	ErrNotOwner = modelError("You do not have permission to edit this gallery")
+)
+
+// Gallery visibility levels
+const (
+	// VisibilityPrivate galleries are only visible to the owner
+	VisibilityPrivate = "private"
+
+	// VisibilityFriends galleries are visible to the
+	// owner and to users with an accepted friendship
+	VisibilityFriends = "friends"
+
+	// VisibilityUnlisted galleries are visible to anyone
+	// with the share link, which includes the ShareToken
+	VisibilityUnlisted = "unlisted"
+
+	// VisibilityPublic galleries are visible to anyone
+	VisibilityPublic = "public"
 )
 
+// shareTokenBytes is the size of the random token
+// used in share links of unlisted galleries
+const shareTokenBytes = 16
+
 // Gallery is our image container resource
 // that visitors will view
 type Gallery struct {
 	gorm.Model
-	UserID uint   `gorm:"not_null;index"`
-	Title  string `gorm:"not_null"`
+	UserID     uint    `gorm:"not_null;index"`
+	Title      string  `gorm:"not_null"`
+	Visibility string  `gorm:"not_null;default:'private'"`
+	ShareToken string  `gorm:"not_null"`
+	Images     []Image `gorm:"-"`
+}
+
+// CanPublish reports whether the user may give a gallery the
+// visibility. Only users with a verified email address can make
+// galleries public, so unverified accounts can not spam listings
+func CanPublish(user *User, visibility string) bool {
+	return visibility != VisibilityPublic || user.Verified()
 }
 
 type GalleryService interface {
+	// CanView reports whether user may see the gallery and
+	// its images. user is nil for visitors that are not
+	// logged in, and shareToken is the token from the link
+	// the gallery was opened with, if any
+	CanView(gallery *Gallery, user *User, shareToken string) (bool, error)
 	GalleryDB
 }
 
+// GalleryDB is used to interact with the galleries database
+//
+// For all single gallery queries:
+// 1 - gallery, nil 	- Gallery found
+// 2 - nil, ErrNotFound	- Gallery not found
+// 3 - nil, otherError  - Database error
 type GalleryDB interface {
+	ByID(id uint) (*Gallery, error)
+	ByUserID(userID uint) ([]Gallery, error)
+
+	// ByUserIDPage returns a page of the galleries
+	// owned by the user, newest first
+	ByUserIDPage(userID uint, page Page) ([]Gallery, error)
 	Create(gallery *Gallery) error
+	Update(gallery *Gallery) error
+
+	// Delete deletes the gallery with the provided ID, which
+	// has to be owned by the user with userID
+	Delete(id, userID uint) error
 }
 
-func NewGalleryService(db *gorm.DB) GalleryDB {
+// NewGalleryService creates a GalleryService. Friendships
+// are looked up in fs for friends only galleries
+func NewGalleryService(db *gorm.DB, fs FriendService) GalleryService {
 	return &galleryService{
 		GalleryDB: &galleryValidator{&galleryGorm{db}},
+		friends:   fs,
 	}
 }
 
+// ensure interface is matching
+var _ GalleryService = &galleryService{}
+
 type galleryService struct {
 	GalleryDB
+	friends FriendService
+}
+
+func (gs *galleryService) CanView(gallery *Gallery, user *User, shareToken string) (bool, error) {
+	if user != nil && user.ID == gallery.UserID {
+		return true, nil
+	}
+
+	switch gallery.Visibility {
+	case VisibilityPublic:
+		return true, nil
+	case VisibilityUnlisted:
+		match := subtle.ConstantTimeCompare([]byte(shareToken), []byte(gallery.ShareToken))
+		return shareToken != "" && match == 1, nil
+	case VisibilityFriends:
+		if user == nil {
+			return false, nil
+		}
+		return gs.friends.AreFriends(user.ID, gallery.UserID)
+	default:
+		return false, nil
+	}
 }
 
 type galleryValidator struct {
@@ -43,9 +134,13 @@ type galleryValidator struct {
 }
 
 func (gv *galleryValidator) Create(gallery *Gallery) error {
+	if err := gv.fields(gallery); err != nil {
+		return err
+	}
+
 	err := runGalleryValFuncs(gallery,
 		gv.userIDRequired,
-		gv.titleRequired)
+		gv.setShareTokenIfUnset)
 
 	if err != nil {
 		return err
@@ -54,6 +149,53 @@ func (gv *galleryValidator) Create(gallery *Gallery) error {
 	return gv.GalleryDB.Create(gallery)
 }
 
+// Update will make sure the gallery still belongs to
+// the same user before persisting any changes
+func (gv *galleryValidator) Update(gallery *Gallery) error {
+	if err := gv.fields(gallery); err != nil {
+		return err
+	}
+
+	err := runGalleryValFuncs(gallery,
+		gv.userIDRequired,
+		gv.setShareTokenIfUnset,
+		gv.ownerUnchanged)
+
+	if err != nil {
+		return err
+	}
+
+	return gv.GalleryDB.Update(gallery)
+}
+
+// Delete will make sure the gallery belongs to
+// the user before deleting it
+func (gv *galleryValidator) Delete(id, userID uint) error {
+	var gallery Gallery
+	gallery.ID = id
+	gallery.UserID = userID
+
+	err := runGalleryValFuncs(&gallery,
+		gv.idGreaterThan(0),
+		gv.userIDRequired,
+		gv.ownerUnchanged)
+
+	if err != nil {
+		return err
+	}
+
+	return gv.GalleryDB.Delete(id, userID)
+}
+
+// fields validates the fields of the gallery form,
+// returning FieldErrors for all that are invalid
+func (gv *galleryValidator) fields(gallery *Gallery) error {
+	fe := FieldErrors{}
+	fe.add("title", runGalleryValFuncs(gallery, gv.titleRequired))
+	fe.add("visibility", runGalleryValFuncs(gallery, gv.defaultVisibility, gv.visibilityValid))
+	return fe.err()
+}
+
 func (gv *galleryValidator) userIDRequired(g *Gallery) error {
 	if g.UserID <= 0 {
 		return ErrUserIDRequired
@@ -70,6 +212,68 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+// defaultVisibility makes galleries private unless
+// the owner picked something else
+func (gv *galleryValidator) defaultVisibility(g *Gallery) error {
+	if g.Visibility == "" {
+		g.Visibility = VisibilityPrivate
+	}
+
+	return nil
+}
+
+func (gv *galleryValidator) visibilityValid(g *Gallery) error {
+	switch g.Visibility {
+	case VisibilityPrivate, VisibilityFriends, VisibilityUnlisted, VisibilityPublic:
+		return nil
+	default:
+		return ErrVisibilityInvalid
+	}
+}
+
+// setShareTokenIfUnset gives every gallery a random token
+// to use in the share link once it becomes unlisted
+func (gv *galleryValidator) setShareTokenIfUnset(g *Gallery) error {
+	if g.ShareToken != "" {
+		return nil
+	}
+
+	token, err := rand.String(shareTokenBytes)
+	if err != nil {
+		return err
+	}
+
+	g.ShareToken = token
+	return nil
+}
+
+func (gv *galleryValidator) idGreaterThan(n uint) galleryValFunc {
+	return galleryValFunc(func(g *Gallery) error {
+		if g.ID <= n {
+			return ErrIDInvalid
+		}
+
+		return nil
+	})
+}
+
+// ownerUnchanged looks up the stored gallery and makes sure
+// the UserID on the provided gallery matches the owner, so a
+// gallery can never be updated or deleted by, or moved to,
+// another user
+func (gv *galleryValidator) ownerUnchanged(g *Gallery) error {
+	existing, err := gv.GalleryDB.ByID(g.ID)
+	if err != nil {
+		return err
+	}
+
+	if existing.UserID != g.UserID {
+		return ErrNotOwner
+	}
+
+	return nil
+}
+
 // ensure interface is valid
 var _ GalleryDB = &galleryGorm{}
 
@@ -77,10 +281,60 @@ type galleryGorm struct {
 	db *gorm.DB
 }
 
+// ByID will look up a gallery by the id provided
+func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
+	var gallery Gallery
+	db := gg.db.Where("id = ?", id)
+	if err := first(db, &gallery); err != nil {
+		return nil, err
+	}
+
+	return &gallery, nil
+}
+
+// ByUserID will return all galleries owned by the
+// user with the provided id, newest first
+func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gg.db.
+		Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&galleries).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return galleries, nil
+}
+
+func (gg *galleryGorm) ByUserIDPage(userID uint, page Page) ([]Gallery, error) {
+	var galleries []Gallery
+	db := page.apply(gg.db.Where("user_id = ?", userID), true)
+	if err := db.Find(&galleries).Error; err != nil {
+		return nil, err
+	}
+
+	return galleries, nil
+}
+
 func (gg *galleryGorm) Create(gallery *Gallery) error {
 	return gg.db.Create(gallery).Error
 }
 
+// Update will update the provided gallery with all of the
+// data in the provided gallery object
+func (gg *galleryGorm) Update(gallery *Gallery) error {
+	return gg.db.Save(gallery).Error
+}
+
+// Delete will delete the gallery with the provided ID,
+// only when it is owned by the user with userID
+func (gg *galleryGorm) Delete(id, userID uint) error {
+	gallery := Gallery{Model: gorm.Model{ID: id}}
+	return gg.db.Where("user_id = ?", userID).Delete(&gallery).Error
+}
+
 type galleryValFunc func(*Gallery) error
 
 func runGalleryValFuncs(gallery *Gallery, fns ...galleryValFunc) error {
@@ -0,0 +1,139 @@
+package models
+
+import "testing"
+
+func TestGalleryCanView(t *testing.T) {
+	fs := testingFriendService()
+	gs := &galleryService{friends: fs}
+
+	owner := &User{}
+	owner.ID = 1
+	friend := &User{}
+	friend.ID = 2
+	stranger := &User{}
+	stranger.ID = 3
+
+	req, _ := fs.Request(owner.ID, friend.ID)
+	fs.Accept(friend.ID, req.ID)
+
+	cases := []struct {
+		visibility string
+		user       *User
+		share      string
+		want       bool
+	}{
+		{VisibilityPrivate, owner, "", true},
+		{VisibilityPrivate, friend, "", false},
+		{VisibilityFriends, friend, "", true},
+		{VisibilityFriends, stranger, "", false},
+		{VisibilityFriends, nil, "", false},
+		{VisibilityUnlisted, nil, "s3cret", true},
+		{VisibilityUnlisted, nil, "guess", false},
+		{VisibilityUnlisted, stranger, "", false},
+		{VisibilityPublic, nil, "", true},
+	}
+
+	for _, c := range cases {
+		gallery := &Gallery{UserID: owner.ID, Visibility: c.visibility, ShareToken: "s3cret"}
+		got, err := gs.CanView(gallery, c.user, c.share)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != c.want {
+			t.Errorf("CanView(%s, %v, %q) = %v. Expected %v", c.visibility, c.user, c.share, got, c.want)
+		}
+	}
+}
+
+func TestGalleryValidatorFieldErrors(t *testing.T) {
+	gv := &galleryValidator{}
+	err := gv.Create(&Gallery{UserID: 1, Visibility: "everyone"})
+
+	fe, ok := err.(FieldErrors)
+	if !ok {
+		t.Fatalf("Expected FieldErrors. Recieved %v", err)
+	}
+
+	if fe["title"] != ErrTitleRequired || fe["visibility"] != ErrVisibilityInvalid {
+		t.Errorf("Expected both the title and visibility to be reported. Recieved %v", fe)
+	}
+
+	fields := fe.Fields()
+	if fields["title"] != ErrTitleRequired.Error() || len(fields) != 2 {
+		t.Errorf("Unexpected field messages %v", fields)
+	}
+}
+
+func TestFieldErrorsHidePrivateErrors(t *testing.T) {
+	fe := FieldErrors{}
+	fe.add("title", ErrTitleRequired)
+	fe.add("email", nil)
+	if err := fe.err(); err == nil || len(fe) != 1 {
+		t.Fatalf("Expected only the title error. Recieved %v", err)
+	}
+
+	fe.add("user", ErrUserIDRequired)
+	if err := fe.err(); err != ErrUserIDRequired {
+		t.Errorf("Expected the private error on its own. Recieved %v", err)
+	}
+
+	if err := (FieldErrors{}).err(); err != nil {
+		t.Errorf("Expected no error. Recieved %v", err)
+	}
+}
+
+// memGalleryDB is an in-memory GalleryDB
+type memGalleryDB struct {
+	GalleryDB
+	galleries map[uint]Gallery
+}
+
+func (m *memGalleryDB) ByID(id uint) (*Gallery, error) {
+	gallery, ok := m.galleries[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &gallery, nil
+}
+
+func (m *memGalleryDB) Delete(id, userID uint) error {
+	delete(m.galleries, id)
+	return nil
+}
+
+func TestGalleryValidatorDelete(t *testing.T) {
+	db := &memGalleryDB{galleries: make(map[uint]Gallery)}
+	gallery := Gallery{UserID: 1, Title: "Holiday"}
+	gallery.ID = 7
+	db.galleries[gallery.ID] = gallery
+	gv := &galleryValidator{db}
+
+	cases := []struct {
+		id, userID uint
+		want       error
+	}{
+		{0, 1, ErrIDInvalid},
+		{7, 0, ErrUserIDRequired},
+		{7, 2, ErrNotOwner},
+		{8, 1, ErrNotFound},
+	}
+
+	for _, c := range cases {
+		if err := gv.Delete(c.id, c.userID); err != c.want {
+			t.Errorf("Delete(%d, %d): Expected %v. Recieved %v", c.id, c.userID, c.want, err)
+		}
+	}
+
+	if _, ok := db.galleries[gallery.ID]; !ok {
+		t.Fatal("Expected the gallery to be kept")
+	}
+
+	if err := gv.Delete(gallery.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.galleries[gallery.ID]; ok {
+		t.Error("Expected the owner to delete the gallery")
+	}
+}
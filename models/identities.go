@@ -0,0 +1,641 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"../../photofriends/hash"
+	"../../photofriends/oidc"
+	"../../photofriends/rand"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrProviderUnknown is returned when signing in
+	// with a provider that is not configured
+	ErrProviderUnknown = modelError("Signing in with this provider is not available")
+
+	// ErrIdentityFailed is returned when the provider
+	// did not sign the user in, or its answer did not verify
+	ErrIdentityFailed = modelError("Signing in with the provider failed. Please try again")
+
+	// ErrIdentityExpired is returned when the sign in took
+	// too long, or was finished already
+	ErrIdentityExpired = modelError("The sign in request has expired. Please try again")
+
+	// ErrIdentityTaken is returned when linking an
+	// account that is linked to another user
+	ErrIdentityTaken = modelError("This account is already linked to another user")
+
+	// ErrIdentityEmailTaken is returned when signing up with
+	// a provider for an email address that has an account.
+	// Accounts are never linked by email address alone, as
+	// not every provider checks it belongs to the user
+	ErrIdentityEmailTaken = modelError("An account with this email address already exists. Log in and link the provider from your account instead")
+
+	// ErrIdentityEmailMissing is returned when signing
+	// up with a provider that did not share an email address
+	ErrIdentityEmailMissing = modelError("The provider did not share your email address, which is needed to sign up")
+
+	// ErrIdentityLast is returned when unlinking the only
+	// way a user without a password has to log in
+	ErrIdentityLast = modelError("This is the only way you can log in. Set a password before unlinking it")
+)
+
+// IdentityFlowTimeout is how long users have
+// to sign in at the provider
+const IdentityFlowTimeout = 10 * time.Minute
+
+// Identity is an account at an OpenID Connect provider
+// linked to a user, who can log in with it. Subject is
+// the ID the provider knows the account by, which never
+// changes, unlike the email address
+type Identity struct {
+	ID       uint   `gorm:"primary_key"`
+	UserID   uint   `gorm:"not_null;index"`
+	Provider string `gorm:"not_null;unique_index:idx_identities_provider_subject"`
+	Subject  string `gorm:"not_null;unique_index:idx_identities_provider_subject"`
+
+	// Email is the address the provider had for the
+	// account when linked, shown to tell accounts apart
+	Email     string
+	CreatedAt time.Time
+}
+
+// IdentityProvider describes a provider users can sign in with
+type IdentityProvider struct {
+	Name        string
+	DisplayName string
+}
+
+// IdentityService signs users in with OpenID Connect
+// providers, and links their accounts there to users.
+// Begin returns a state identifying the flow, which the
+// provider passes back with the code to Complete
+type IdentityService interface {
+	// Providers returns the configured providers
+	Providers() []IdentityProvider
+
+	// Begin starts signing in with provider, returning the URL
+	// to send the user to. A user that is logged in already
+	// links the account, otherwise user is nil and the account
+	// logs in, or signs up if it is not linked to anyone yet
+	Begin(provider string, user *User) (state, authURL string, err error)
+
+	// Complete exchanges the code, verifies the ID token, and
+	// returns the user that logged in or linked the account.
+	// Logins are recorded with the user's login attempts
+	Complete(state, code string, client LoginClient) (user *User, linked bool, err error)
+
+	// Unlink removes an identity of the user
+	Unlink(user *User, identityID uint) error
+
+	IdentityDB
+}
+
+// IdentityDB is used to interact with the identities database
+type IdentityDB interface {
+	ByID(id uint) (*Identity, error)
+	BySubject(provider, subject string) (*Identity, error)
+
+	// ByUserID returns the identities of the user, oldest first
+	ByUserID(userID uint) ([]Identity, error)
+	Create(identity *Identity) error
+	Delete(id uint) error
+}
+
+// NewIdentityService creates an IdentityService for the
+// providers. Users signing up are created with users, and
+// flow states are HMACed with hmac
+func NewIdentityService(db *gorm.DB, users UserDB, providers []*oidc.Provider, hmac hash.HMAC) IdentityService {
+	return &identityService{
+		IdentityDB: &identityValidator{&identityGorm{db}},
+		flowDB:     newIdentityFlowValidator(&identityFlowGorm{db}, hmac),
+		users:      users,
+		attempts:   &loginAttemptGorm{db},
+		providers:  providers,
+	}
+}
+
+// ensure interface is matching
+var _ IdentityService = &identityService{}
+
+type identityService struct {
+	IdentityDB
+	flowDB    identityFlowDB
+	users     UserDB
+	attempts  loginAttemptDB
+	providers []*oidc.Provider
+}
+
+func (is *identityService) Providers() []IdentityProvider {
+	providers := make([]IdentityProvider, len(is.providers))
+	for i, p := range is.providers {
+		providers[i] = IdentityProvider{Name: p.Name(), DisplayName: p.DisplayName()}
+	}
+
+	return providers
+}
+
+func (is *identityService) provider(name string) (*oidc.Provider, error) {
+	for _, p := range is.providers {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+
+	return nil, ErrProviderUnknown
+}
+
+func (is *identityService) Begin(provider string, user *User) (string, string, error) {
+	p, err := is.provider(provider)
+	if err != nil {
+		return "", "", err
+	}
+
+	flow := identityFlow{Provider: p.Name()}
+	if user != nil {
+		flow.UserID = user.ID
+	}
+
+	if err := is.flowDB.Create(&flow); err != nil {
+		return "", "", err
+	}
+
+	authURL, err := p.AuthCodeURL(context.Background(), flow.Token, flow.Nonce, flow.Verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	return flow.Token, authURL, nil
+}
+
+// Complete never links accounts by their email address,
+// signing up for an address that has an account fails
+func (is *identityService) Complete(state, code string, client LoginClient) (*User, bool, error) {
+	flow, err := is.takeFlow(state)
+	if err != nil {
+		return nil, false, err
+	}
+
+	p, err := is.provider(flow.Provider)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx := context.Background()
+	rawIDToken, err := p.Exchange(ctx, code, flow.Verifier)
+	if err != nil {
+		return nil, false, ErrIdentityFailed
+	}
+
+	claims, err := p.Verify(ctx, rawIDToken, flow.Nonce)
+	if err != nil {
+		return nil, false, ErrIdentityFailed
+	}
+
+	identity, err := is.BySubject(p.Name(), claims.Subject)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		identity = nil
+	default:
+		return nil, false, err
+	}
+
+	if flow.UserID != 0 {
+		user, err := is.link(flow.UserID, identity, p.Name(), claims)
+		return user, true, err
+	}
+
+	var user *User
+	if identity != nil {
+		user, err = is.users.ByID(identity.UserID)
+	} else {
+		user, err = is.signUp(p.Name(), claims)
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	attempt := LoginAttempt{
+		UserID:    &user.ID,
+		Email:     user.Email,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Result:    LoginOIDC,
+	}
+	if err := is.attempts.Create(&attempt); err != nil {
+		return nil, false, err
+	}
+
+	return user, false, nil
+}
+
+// link adds the account to the user, unless it is
+// linked already. Linking it again is not an error
+func (is *identityService) link(userID uint, identity *Identity, provider string, claims *oidc.Claims) (*User, error) {
+	if identity != nil && identity.UserID != userID {
+		return nil, ErrIdentityTaken
+	}
+
+	user, err := is.users.ByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity != nil {
+		return user, nil
+	}
+
+	identity = &Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := is.Create(identity); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// signUp creates a user without a password for the
+// account. The email address counts as verified if the
+// provider says it checked it
+func (is *identityService) signUp(provider string, claims *oidc.Claims) (*User, error) {
+	if claims.Email == "" {
+		return nil, ErrIdentityEmailMissing
+	}
+
+	switch _, err := is.users.ByEmail(claims.Email); err {
+	case nil:
+		return nil, ErrIdentityEmailTaken
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	user := User{
+		Name:         claims.Name,
+		Email:        claims.Email,
+		Passwordless: true,
+	}
+	if claims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	if err := is.users.Create(&user); err != nil {
+		if fe, ok := err.(FieldErrors); ok && fe["email"] == ErrEmailTaken {
+			return nil, ErrIdentityEmailTaken
+		}
+		return nil, err
+	}
+
+	identity := Identity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+	if err := is.Create(&identity); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// Unlink keeps the last identity of users without a
+// password, as they could not log in anymore
+func (is *identityService) Unlink(user *User, identityID uint) error {
+	identity, err := is.ByID(identityID)
+	if err != nil {
+		return err
+	}
+
+	if identity.UserID != user.ID {
+		return ErrNotFound
+	}
+
+	if !user.HasPassword() {
+		identities, err := is.ByUserID(user.ID)
+		if err != nil {
+			return err
+		}
+
+		if len(identities) <= 1 {
+			return ErrIdentityLast
+		}
+	}
+
+	return is.Delete(identity.ID)
+}
+
+// takeFlow looks up the flow the state belongs to and
+// uses it up, so a code can only be completed once
+func (is *identityService) takeFlow(state string) (*identityFlow, error) {
+	flow, err := is.flowDB.ByToken(state)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrIdentityExpired
+		}
+		return nil, err
+	}
+
+	if err := is.flowDB.Delete(flow.ID); err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(flow.ExpiresAt) {
+		return nil, ErrIdentityExpired
+	}
+
+	return flow, nil
+}
+
+/******************* VALIDATORS **************************/
+
+type identityValidator struct {
+	IdentityDB
+}
+
+func (iv *identityValidator) BySubject(provider, subject string) (*Identity, error) {
+	if provider == "" || subject == "" {
+		return nil, ErrNotFound
+	}
+
+	return iv.IdentityDB.BySubject(provider, subject)
+}
+
+func (iv *identityValidator) Create(identity *Identity) error {
+	err := runIdentityValFuncs(identity,
+		iv.requireUserID,
+		iv.requireSubject,
+		iv.normalizeEmail)
+
+	if err != nil {
+		return err
+	}
+
+	return iv.IdentityDB.Create(identity)
+}
+
+func (iv *identityValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return iv.IdentityDB.Delete(id)
+}
+
+func (iv *identityValidator) requireUserID(identity *Identity) error {
+	if identity.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (iv *identityValidator) requireSubject(identity *Identity) error {
+	if identity.Provider == "" || identity.Subject == "" {
+		return ErrIdentityFailed
+	}
+
+	return nil
+}
+
+func (iv *identityValidator) normalizeEmail(identity *Identity) error {
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+	return nil
+}
+
+type identityValFunc func(*Identity) error
+
+func runIdentityValFuncs(identity *Identity, fns ...identityValFunc) error {
+	for _, fn := range fns {
+		if err := fn(identity); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/************************************************************/
+
+// ensure interface is matching
+var _ IdentityDB = &identityGorm{}
+
+type identityGorm struct {
+	db *gorm.DB
+}
+
+func (ig *identityGorm) ByID(id uint) (*Identity, error) {
+	var identity Identity
+	if err := first(ig.db.Where("id = ?", id), &identity); err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (ig *identityGorm) BySubject(provider, subject string) (*Identity, error) {
+	var identity Identity
+	db := ig.db.Where("provider = ? AND subject = ?", provider, subject)
+	if err := first(db, &identity); err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+func (ig *identityGorm) ByUserID(userID uint) ([]Identity, error) {
+	var identities []Identity
+	err := ig.db.
+		Where("user_id = ?", userID).
+		Order("created_at").
+		Find(&identities).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+func (ig *identityGorm) Create(identity *Identity) error {
+	return ig.db.Create(identity).Error
+}
+
+func (ig *identityGorm) Delete(id uint) error {
+	identity := Identity{ID: id}
+	return ig.db.Delete(&identity).Error
+}
+
+// identityFlow is a sign in at a provider in progress. The
+// token is the state passed through the provider, and kept
+// in a cookie to tie the flow to the browser that started
+// it. Only its HMAC is stored
+type identityFlow struct {
+	ID uint `gorm:"primary_key"`
+
+	// UserID is set when linking, and 0 for logins
+	UserID    uint   `gorm:"not_null;default:0"`
+	Provider  string `gorm:"not_null"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not_null;unique_index"`
+
+	// Nonce is echoed in the ID token, Verifier is
+	// the PKCE code verifier for the exchange
+	Nonce     string    `gorm:"not_null"`
+	Verifier  string    `gorm:"not_null"`
+	ExpiresAt time.Time `gorm:"not_null"`
+	CreatedAt time.Time
+}
+
+type identityFlowDB interface {
+	ByToken(token string) (*identityFlow, error)
+
+	// Create also deletes expired flows, as
+	// users may never come back from the provider
+	Create(flow *identityFlow) error
+	Delete(id uint) error
+}
+
+func newIdentityFlowValidator(db identityFlowDB, hmac hash.HMAC) *identityFlowValidator {
+	return &identityFlowValidator{
+		identityFlowDB: db,
+		hmac:           hmac,
+	}
+}
+
+type identityFlowValidator struct {
+	identityFlowDB
+	hmac hash.HMAC
+}
+
+// ByToken expects the raw token and will hash
+// it before looking up the flow
+func (ifv *identityFlowValidator) ByToken(token string) (*identityFlow, error) {
+	flow := identityFlow{Token: token}
+	if err := runIdentityFlowValFuncs(&flow, ifv.hmacToken); err != nil {
+		return nil, err
+	}
+
+	return ifv.identityFlowDB.ByToken(flow.TokenHash)
+}
+
+func (ifv *identityFlowValidator) Create(flow *identityFlow) error {
+	err := runIdentityFlowValFuncs(flow,
+		ifv.setTokenIfUnset,
+		ifv.hmacToken,
+		ifv.setSecrets,
+		ifv.setExpiry)
+
+	if err != nil {
+		return err
+	}
+
+	return ifv.identityFlowDB.Create(flow)
+}
+
+func (ifv *identityFlowValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return ifv.identityFlowDB.Delete(id)
+}
+
+func (ifv *identityFlowValidator) setTokenIfUnset(flow *identityFlow) error {
+	if flow.Token != "" {
+		return nil
+	}
+
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	flow.Token = token
+	return nil
+}
+
+func (ifv *identityFlowValidator) hmacToken(flow *identityFlow) error {
+	if flow.Token == "" {
+		return ErrNotFound
+	}
+
+	flow.TokenHash = ifv.hmac.Hash(flow.Token)
+	return nil
+}
+
+// setSecrets creates the nonce and
+// PKCE code verifier of the flow
+func (ifv *identityFlowValidator) setSecrets(flow *identityFlow) error {
+	nonce, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return err
+	}
+
+	flow.Nonce = nonce
+	flow.Verifier = verifier
+	return nil
+}
+
+func (ifv *identityFlowValidator) setExpiry(flow *identityFlow) error {
+	flow.ExpiresAt = time.Now().Add(IdentityFlowTimeout)
+	return nil
+}
+
+type identityFlowValFunc func(*identityFlow) error
+
+func runIdentityFlowValFuncs(flow *identityFlow, fns ...identityFlowValFunc) error {
+	for _, fn := range fns {
+		if err := fn(flow); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensure interface is matching
+var _ identityFlowDB = &identityFlowGorm{}
+
+type identityFlowGorm struct {
+	db *gorm.DB
+}
+
+// ByToken looks up a flow by the already hashed token
+func (ifg *identityFlowGorm) ByToken(tokenHash string) (*identityFlow, error) {
+	var flow identityFlow
+	if err := first(ifg.db.Where("token_hash = ?", tokenHash), &flow); err != nil {
+		return nil, err
+	}
+
+	return &flow, nil
+}
+
+func (ifg *identityFlowGorm) Create(flow *identityFlow) error {
+	err := ifg.db.Where("expires_at < ?", time.Now()).Delete(&identityFlow{}).Error
+	if err != nil {
+		return err
+	}
+
+	return ifg.db.Create(flow).Error
+}
+
+func (ifg *identityFlowGorm) Delete(id uint) error {
+	flow := identityFlow{ID: id}
+	return ifg.db.Delete(&flow).Error
+}
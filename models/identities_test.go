@@ -0,0 +1,253 @@
+package models
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"../../photofriends/hash"
+	"../../photofriends/oidc"
+	"../../photofriends/oidc/oidctest"
+)
+
+// memIdentityDB is an in-memory IdentityDB
+type memIdentityDB struct {
+	identities []Identity
+}
+
+func (m *memIdentityDB) ByID(id uint) (*Identity, error) {
+	for _, identity := range m.identities {
+		if identity.ID == id {
+			return &identity, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memIdentityDB) BySubject(provider, subject string) (*Identity, error) {
+	for _, identity := range m.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memIdentityDB) ByUserID(userID uint) ([]Identity, error) {
+	var all []Identity
+	for _, identity := range m.identities {
+		if identity.UserID == userID {
+			all = append(all, identity)
+		}
+	}
+
+	return all, nil
+}
+
+func (m *memIdentityDB) Create(identity *Identity) error {
+	identity.ID = uint(len(m.identities) + 1)
+	m.identities = append(m.identities, *identity)
+	return nil
+}
+
+func (m *memIdentityDB) Delete(id uint) error {
+	for i := range m.identities {
+		if m.identities[i].ID == id {
+			m.identities = append(m.identities[:i], m.identities[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// memIdentityFlowDB is an in-memory identityFlowDB
+type memIdentityFlowDB struct {
+	nextID uint
+	flows  map[uint]identityFlow
+}
+
+func (m *memIdentityFlowDB) ByToken(tokenHash string) (*identityFlow, error) {
+	for _, flow := range m.flows {
+		if flow.TokenHash == tokenHash {
+			return &flow, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memIdentityFlowDB) Create(flow *identityFlow) error {
+	m.nextID++
+	flow.ID = m.nextID
+	m.flows[flow.ID] = *flow
+	return nil
+}
+
+func (m *memIdentityFlowDB) Delete(id uint) error {
+	delete(m.flows, id)
+	return nil
+}
+
+func testingIdentityService(fake *oidctest.Provider) (*identityService, *memLoginAttemptDB) {
+	hmac := hash.NewHMAC("test-key")
+	cfg := fake.Config("test", "http://localhost:3000/auth/test/callback")
+	attempts := &memLoginAttemptDB{}
+	return &identityService{
+		IdentityDB: &identityValidator{&memIdentityDB{}},
+		flowDB: newIdentityFlowValidator(
+			&memIdentityFlowDB{flows: make(map[uint]identityFlow)}, hmac),
+		users: newUserValidator(&memUserDB{users: map[uint]User{
+			1: {Email: "jane@example.com", PasswordHash: "hash"},
+		}}, hmac, "test-pepper"),
+		attempts:  attempts,
+		providers: []*oidc.Provider{oidc.NewProvider(cfg, http.DefaultClient)},
+	}, attempts
+}
+
+// signInWith runs the flow at the fake provider and completes it
+func signInWith(t *testing.T, is *identityService, fake *oidctest.Provider, user *User) (*User, bool, error) {
+	_, authURL, err := is.Begin("test", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := fake.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return is.Complete(u.Query().Get("state"), u.Query().Get("code"), LoginClient{IP: "127.0.0.1"})
+}
+
+func TestIdentitySignUp(t *testing.T) {
+	fake := oidctest.New("client", "secret")
+	defer fake.Close()
+	is, attempts := testingIdentityService(fake)
+
+	if _, _, err := is.Begin("other", nil); err != ErrProviderUnknown {
+		t.Errorf("Expected ErrProviderUnknown. Recieved %v", err)
+	}
+
+	user, linked, err := signInWith(t, is, fake, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if linked || user.ID == 0 || user.Email != fake.Identity.Email || user.HasPassword() || !user.Verified() {
+		t.Errorf("Expected a verified user without a password. Recieved %+v", user)
+	}
+
+	// the next sign in finds the same user
+	again, _, err := signInWith(t, is, fake, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.ID != user.ID {
+		t.Errorf("Expected user %d to log in again. Recieved %d", user.ID, again.ID)
+	}
+
+	if len(attempts.attempts) != 2 || attempts.attempts[1].Result != LoginOIDC || !attempts.attempts[1].Succeeded() {
+		t.Errorf("Expected the logins to be recorded. Recieved %+v", attempts.attempts)
+	}
+
+	// the only way to log in is kept
+	identities, _ := is.ByUserID(user.ID)
+	if err := is.Unlink(user, identities[0].ID); err != ErrIdentityLast {
+		t.Errorf("Expected ErrIdentityLast. Recieved %v", err)
+	}
+
+	// accounts are not linked by email address
+	fake.Identity = oidctest.Identity{Subject: "other", Email: "jane@example.com", EmailVerified: true}
+	if _, _, err := signInWith(t, is, fake, nil); err != ErrIdentityEmailTaken {
+		t.Errorf("Expected ErrIdentityEmailTaken. Recieved %v", err)
+	}
+
+	fake.Identity = oidctest.Identity{Subject: "no-email"}
+	if _, _, err := signInWith(t, is, fake, nil); err != ErrIdentityEmailMissing {
+		t.Errorf("Expected ErrIdentityEmailMissing. Recieved %v", err)
+	}
+}
+
+func TestIdentityLink(t *testing.T) {
+	fake := oidctest.New("client", "secret")
+	defer fake.Close()
+	is, _ := testingIdentityService(fake)
+	jane, _ := is.users.ByID(1)
+
+	user, linked, err := signInWith(t, is, fake, jane)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !linked || user.ID != jane.ID {
+		t.Errorf("Expected the account to be linked to jane. Recieved %+v", user)
+	}
+
+	// the linked account logs jane in
+	user, linked, err = signInWith(t, is, fake, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if linked || user.ID != jane.ID {
+		t.Errorf("Expected jane to log in. Recieved %+v", user)
+	}
+
+	other := &User{Email: "jon@example.com"}
+	other.ID = 2
+	is.users.(*userValidator).UserDB.(*memUserDB).users[2] = *other
+	if _, _, err := signInWith(t, is, fake, other); err != ErrIdentityTaken {
+		t.Errorf("Expected ErrIdentityTaken. Recieved %v", err)
+	}
+
+	identities, _ := is.ByUserID(jane.ID)
+	if len(identities) != 1 || identities[0].Subject != fake.Identity.Subject {
+		t.Fatalf("Expected a single identity. Recieved %+v", identities)
+	}
+
+	if err := is.Unlink(other, identities[0].ID); err != ErrNotFound {
+		t.Errorf("Expected users not to unlink identities of others. Recieved %v", err)
+	}
+
+	// jane has a password, so she can unlink it
+	if err := is.Unlink(jane, identities[0].ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdentityFlow(t *testing.T) {
+	fake := oidctest.New("client", "secret")
+	defer fake.Close()
+	is, _ := testingIdentityService(fake)
+
+	state, authURL, err := is.Begin("test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback, _ := fake.Authorize(authURL)
+	u, _ := url.Parse(callback)
+	code := u.Query().Get("code")
+
+	if _, _, err := is.Complete("unknown", code, LoginClient{}); err != ErrIdentityExpired {
+		t.Errorf("Expected ErrIdentityExpired for an unknown state. Recieved %v", err)
+	}
+
+	if _, _, err := is.Complete(state, "wrong", LoginClient{}); err != ErrIdentityFailed {
+		t.Errorf("Expected ErrIdentityFailed for a wrong code. Recieved %v", err)
+	}
+
+	// the flow was used up by the failed attempt
+	if _, _, err := is.Complete(state, code, LoginClient{}); err != ErrIdentityExpired {
+		t.Errorf("Expected ErrIdentityExpired. Recieved %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"image"
+	"sort"
+	"time"
+
+	"../../photofriends/exif"
+	"../../photofriends/phash"
+	"../../photofriends/thumbnail"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// NearDuplicateDistance is how many bits the perceptual
+	// hashes of two images may differ in for them to count
+	// as near duplicates. At 0 they look the same
+	NearDuplicateDistance = 6
+
+	// hashSampleWidth is the width images are scaled down to
+	// before they are rotated upright and hashed
+	hashSampleWidth = 64
+)
+
+// ImageHash is the perceptual hash of an image, see package
+// phash. Hash is the phash.Hash stored as a signed bigint, and
+// Part0 to Part3 are its parts, indexed on their own to look
+// up the hashes near another, see phash.Hash.Parts
+type ImageHash struct {
+	ID        uint  `gorm:"primary_key"`
+	ImageID   uint  `gorm:"not_null;unique_index"`
+	Hash      int64 `gorm:"not_null"`
+	Part0     int   `gorm:"not_null;index"`
+	Part1     int   `gorm:"not_null;index"`
+	Part2     int   `gorm:"not_null;index"`
+	Part3     int   `gorm:"not_null;index"`
+	CreatedAt time.Time
+}
+
+// newImageHash returns the record of h for the image with imageID
+func newImageHash(imageID uint, h phash.Hash) *ImageHash {
+	parts := h.Parts()
+	return &ImageHash{
+		ImageID: imageID,
+		Hash:    int64(h),
+		Part0:   int(parts[0]),
+		Part1:   int(parts[1]),
+		Part2:   int(parts[2]),
+		Part3:   int(parts[3]),
+	}
+}
+
+// PHash returns the stored hash as a phash.Hash
+func (h *ImageHash) PHash() phash.Hash {
+	return phash.Hash(h.Hash)
+}
+
+// Duplicate is an image that looks like another one. Distance
+// is the number of bits their perceptual hashes differ in,
+// 0 for images that look the same
+type Duplicate struct {
+	Image
+	Distance int
+}
+
+// Exact reports whether the images look the same
+func (d *Duplicate) Exact() bool {
+	return d.Distance == 0
+}
+
+// hashImage hashes src once scaled down and rotated upright
+func hashImage(src image.Image, orientation int) phash.Hash {
+	return phash.DHash(exif.Orient(thumbnail.Resize(src, hashSampleWidth), orientation))
+}
+
+// groupSimilar groups the images of hashes that are within
+// maxDistance of each other, directly or through others of
+// the group. Images without any are left out. The groups
+// and their image IDs are sorted, oldest first
+func groupSimilar(hashes []ImageHash, maxDistance int) [][]uint {
+	var tree phash.Tree
+	for _, h := range hashes {
+		tree.Add(h.PHash(), h.ImageID)
+	}
+
+	// every image starts in a group of its own, and groups
+	// are merged as matches are found, see find
+	parent := make(map[uint]uint, len(hashes))
+	var find func(id uint) uint
+	find = func(id uint) uint {
+		p, ok := parent[id]
+		if !ok || p == id {
+			return id
+		}
+
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+
+	for _, h := range hashes {
+		for _, m := range tree.Search(h.PHash(), maxDistance) {
+			a, b := find(h.ImageID), find(m.ID)
+			if a == b {
+				continue
+			}
+			if a > b {
+				a, b = b, a
+			}
+			parent[b] = a
+		}
+	}
+
+	members := make(map[uint][]uint)
+	for _, h := range hashes {
+		root := find(h.ImageID)
+		members[root] = append(members[root], h.ImageID)
+	}
+
+	var groups [][]uint
+	for _, ids := range members {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		groups = append(groups, ids)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+
+	return groups
+}
+
+// ImageHashDB is used to interact with the image hashes database
+type ImageHashDB interface {
+	ByImageID(imageID uint) (*ImageHash, error)
+
+	// ByUserID returns the hashes of the images
+	// in every gallery of the user
+	ByUserID(userID uint) ([]ImageHash, error)
+
+	// Candidates returns the hashes of the images in every
+	// gallery of the owner of the gallery with galleryID that
+	// have a part among probes, see phash.Hash.Probes. Their
+	// distance still has to be checked
+	Candidates(galleryID uint, probes [phash.Parts][]uint16) ([]ImageHash, error)
+
+	// Unhashed returns up to limit images with an ID
+	// above afterID that were not hashed yet, by ID
+	Unhashed(afterID uint, limit int) ([]Image, error)
+	Create(hash *ImageHash) error
+	DeleteByImageID(imageID uint) error
+}
+
+// ensure interface is matching
+var _ ImageHashDB = &imageHashGorm{}
+
+type imageHashGorm struct {
+	db *gorm.DB
+}
+
+// owned joins the images and galleries of the hashes, leaving
+// out deleted ones, so they can be looked up by gallery owner
+func (hg *imageHashGorm) owned() *gorm.DB {
+	return hg.db.
+		Select("image_hashes.*").
+		Joins("JOIN images ON images.id = image_hashes.image_id AND images.deleted_at IS NULL").
+		Joins("JOIN galleries ON galleries.id = images.gallery_id AND galleries.deleted_at IS NULL")
+}
+
+func (hg *imageHashGorm) ByImageID(imageID uint) (*ImageHash, error) {
+	var hash ImageHash
+	if err := first(hg.db.Where("image_id = ?", imageID), &hash); err != nil {
+		return nil, err
+	}
+
+	return &hash, nil
+}
+
+func (hg *imageHashGorm) ByUserID(userID uint) ([]ImageHash, error) {
+	var hashes []ImageHash
+	err := hg.owned().
+		Where("galleries.user_id = ?", userID).
+		Find(&hashes).Error
+
+	return hashes, err
+}
+
+func (hg *imageHashGorm) Candidates(galleryID uint, probes [phash.Parts][]uint16) ([]ImageHash, error) {
+	var hashes []ImageHash
+	err := hg.owned().
+		Where("galleries.user_id = (SELECT user_id FROM galleries WHERE id = ?)", galleryID).
+		Where("image_hashes.part0 IN (?) OR image_hashes.part1 IN (?) OR image_hashes.part2 IN (?) OR image_hashes.part3 IN (?)",
+			probes[0], probes[1], probes[2], probes[3]).
+		Find(&hashes).Error
+
+	return hashes, err
+}
+
+func (hg *imageHashGorm) Unhashed(afterID uint, limit int) ([]Image, error) {
+	var images []Image
+	err := hg.db.
+		Select("images.*").
+		Joins("LEFT JOIN image_hashes ON image_hashes.image_id = images.id").
+		Where("image_hashes.id IS NULL AND images.id > ?", afterID).
+		Order("images.id asc").
+		Limit(limit).
+		Find(&images).Error
+
+	return images, err
+}
+
+func (hg *imageHashGorm) Create(hash *ImageHash) error {
+	return hg.db.Create(hash).Error
+}
+
+func (hg *imageHashGorm) DeleteByImageID(imageID uint) error {
+	return hg.db.Where("image_id = ?", imageID).Delete(&ImageHash{}).Error
+}
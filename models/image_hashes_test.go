@@ -0,0 +1,221 @@
+package models
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"../../photofriends/phash"
+	"../../photofriends/storage"
+	"../../photofriends/thumbnail"
+)
+
+// memImageDB is an in-memory ImageDB. The images are
+// updated from the thumbnail pool, hence the lock
+type memImageDB struct {
+	mu sync.Mutex
+	ImageDB
+	images map[uint]Image
+}
+
+func (m *memImageDB) ByIDs(ids []uint) ([]Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var images []Image
+	for _, id := range ids {
+		if image, ok := m.images[id]; ok {
+			images = append(images, image)
+		}
+	}
+
+	return images, nil
+}
+
+func (m *memImageDB) Create(image *Image) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	image.ID = uint(len(m.images) + 1)
+	m.images[image.ID] = *image
+	return nil
+}
+
+func (m *memImageDB) Update(image *Image) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.images[image.ID] = *image
+	return nil
+}
+
+// memImageHashDB is an in-memory ImageHashDB where
+// every image belongs to the same user
+type memImageHashDB struct {
+	mu     sync.Mutex
+	hashes []ImageHash
+}
+
+func (m *memImageHashDB) ByImageID(imageID uint) (*ImageHash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, h := range m.hashes {
+		if h.ImageID == imageID {
+			return &h, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memImageHashDB) ByUserID(userID uint) ([]ImageHash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.hashes, nil
+}
+
+func (m *memImageHashDB) Candidates(galleryID uint, probes [phash.Parts][]uint16) ([]ImageHash, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var candidates []ImageHash
+	for _, h := range m.hashes {
+		parts := h.PHash().Parts()
+		found := false
+		for i := range probes {
+			for _, probe := range probes[i] {
+				found = found || probe == parts[i]
+			}
+		}
+
+		if found {
+			candidates = append(candidates, h)
+		}
+	}
+
+	return candidates, nil
+}
+
+func (m *memImageHashDB) Unhashed(afterID uint, limit int) ([]Image, error) {
+	return nil, nil
+}
+
+func (m *memImageHashDB) Create(hash *ImageHash) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hashes = append(m.hashes, *hash)
+	return nil
+}
+
+func (m *memImageHashDB) DeleteByImageID(imageID uint) error {
+	return nil
+}
+
+// testingPicture is a PNG of a few stripes, width pixels wide.
+// Pictures of the same seed look alike at any width
+func testingPicture(t *testing.T, seed, width int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, width*3/4))
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < width; x++ {
+			stripe := uint8((x*(seed+2)/width)*60 + (y*(seed+1)*4/3/width)*40)
+			img.Set(x, y, color.RGBA{stripe, 255 - stripe, uint8(x * 255 / width), 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestImageDuplicates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photofriends-hashes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pool := thumbnail.NewPool(1, 10)
+	defer pool.Close()
+
+	is := &imageService{
+		ImageDB: &memImageDB{images: make(map[uint]Image)},
+		hashes:  &memImageHashDB{},
+		store:   storage.NewLocal(dir, "/images/", "secret"),
+		pool:    pool,
+	}
+
+	pictures := []struct {
+		filename   string
+		content    []byte
+		duplicates int
+	}{
+		{"beach.png", testingPicture(t, 1, 320), 0},
+		{"beach-small.png", testingPicture(t, 1, 160), 1},
+		{"beach-again.png", testingPicture(t, 1, 320), 2},
+		{"forest.png", testingPicture(t, 5, 320), 0},
+		{"notes.png", []byte("\x89PNG\r\n\x1a\nnot really"), 0},
+	}
+
+	for _, p := range pictures {
+		image := Image{GalleryID: 1, Filename: p.filename, ContentType: "image/png"}
+		if err := is.Create(&image, bytes.NewReader(p.content)); err != nil {
+			t.Fatal(err)
+		}
+
+		// waits for the image to be hashed on the pool
+		if err := is.DuplicatesOf(&image); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(image.Duplicates) != p.duplicates {
+			t.Errorf("%s: Expected %d duplicates. Recieved %+v", p.filename, p.duplicates, image.Duplicates)
+		}
+		if p.filename == "beach-again.png" && len(image.Duplicates) == 2 && !image.Duplicates[0].Exact() {
+			t.Errorf("Expected the exact duplicate first. Recieved %+v", image.Duplicates)
+		}
+	}
+
+	groups, err := is.Duplicates(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(groups) != 1 || len(groups[0]) != 3 || groups[0][0].Filename != "beach.png" || !groups[0][2].Exact() {
+		t.Errorf("Expected the beach pictures to be grouped. Recieved %+v", groups)
+	}
+}
+
+func TestGroupSimilar(t *testing.T) {
+	hashes := []ImageHash{
+		*newImageHash(1, 0x00ff),
+		*newImageHash(2, 0xff00),
+		*newImageHash(3, 0x00fe),
+		*newImageHash(4, 0xfe00),
+		*newImageHash(5, 0x01fe),
+		*newImageHash(6, 0x00ff),
+		*newImageHash(7, 0xf0f0f0f0f0f0f0f0),
+	}
+
+	groups := groupSimilar(hashes, 3)
+	want := [][]uint{{1, 3, 5, 6}, {2, 4}}
+	if len(groups) != len(want) {
+		t.Fatalf("Expected %v. Recieved %v", want, groups)
+	}
+
+	for i := range want {
+		if len(groups[i]) != len(want[i]) {
+			t.Errorf("Expected %v. Recieved %v", want, groups)
+			continue
+		}
+		for n := range want[i] {
+			if groups[i][n] != want[i][n] {
+				t.Errorf("Expected %v. Recieved %v", want, groups)
+				break
+			}
+		}
+	}
+}
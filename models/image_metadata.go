@@ -0,0 +1,120 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"../../photofriends/exif"
+	"github.com/jinzhu/gorm"
+)
+
+// ImageMetadata is the EXIF data read from an uploaded
+// image. It is kept in its own table so the image record
+// stays small, and so it survives stripping the file itself
+type ImageMetadata struct {
+	gorm.Model
+	ImageID      uint `gorm:"not_null;unique_index"`
+	CameraMake   string
+	CameraModel  string
+	LensModel    string
+	ExposureTime string
+	FNumber      float64
+	ISO          int
+	FocalLength  float64
+	CapturedAt   *time.Time
+	Orientation  int
+	Latitude     *float64
+	Longitude    *float64
+}
+
+// Camera returns the camera name, without repeating the make
+// when the model already includes it, eg: "Canon EOS 80D"
+func (m *ImageMetadata) Camera() string {
+	if strings.HasPrefix(strings.ToLower(m.CameraModel), strings.ToLower(m.CameraMake)) {
+		return m.CameraModel
+	}
+
+	return strings.TrimSpace(m.CameraMake + " " + m.CameraModel)
+}
+
+// HasLocation reports whether a GPS position was stored
+func (m *ImageMetadata) HasLocation() bool {
+	return m.Latitude != nil && m.Longitude != nil
+}
+
+// Coordinates formats the GPS position, eg: "59.91000, 10.75000"
+func (m *ImageMetadata) Coordinates() string {
+	if !m.HasLocation() {
+		return ""
+	}
+
+	return fmt.Sprintf("%.5f, %.5f", *m.Latitude, *m.Longitude)
+}
+
+// MapURL links to the GPS position on OpenStreetMap
+func (m *ImageMetadata) MapURL() string {
+	if !m.HasLocation() {
+		return ""
+	}
+
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%f&mlon=%f", *m.Latitude, *m.Longitude)
+}
+
+// newImageMetadata copies the fields we keep from x. When
+// stripPrivate is set the GPS position is left out, so it is
+// never shown to anyone even though the camera recorded it
+func newImageMetadata(x *exif.Exif, stripPrivate bool) *ImageMetadata {
+	meta := ImageMetadata{
+		CameraMake:   x.Make,
+		CameraModel:  x.Model,
+		LensModel:    strings.TrimSpace(x.LensMake + " " + x.LensModel),
+		ExposureTime: x.ExposureTime.String(),
+		FNumber:      x.FNumber,
+		ISO:          x.ISO,
+		FocalLength:  x.FocalLength,
+		Orientation:  x.Orientation,
+	}
+
+	if !x.CapturedAt.IsZero() {
+		meta.CapturedAt = &x.CapturedAt
+	}
+
+	if x.HasGPS && !stripPrivate {
+		meta.Latitude = &x.Latitude
+		meta.Longitude = &x.Longitude
+	}
+
+	return &meta
+}
+
+// ImageMetadataDB is used to interact with the image metadata database
+type ImageMetadataDB interface {
+	ByImageID(imageID uint) (*ImageMetadata, error)
+	Create(meta *ImageMetadata) error
+	DeleteByImageID(imageID uint) error
+}
+
+// ensure interface is matching
+var _ ImageMetadataDB = &imageMetadataGorm{}
+
+type imageMetadataGorm struct {
+	db *gorm.DB
+}
+
+func (mg *imageMetadataGorm) ByImageID(imageID uint) (*ImageMetadata, error) {
+	var meta ImageMetadata
+	if err := first(mg.db.Where("image_id = ?", imageID), &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func (mg *imageMetadataGorm) Create(meta *ImageMetadata) error {
+	return mg.db.Create(meta).Error
+}
+
+func (mg *imageMetadataGorm) DeleteByImageID(imageID uint) error {
+	return mg.db.Where("image_id = ?", imageID).Delete(&ImageMetadata{}).Error
+}
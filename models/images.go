@@ -0,0 +1,741 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"../../photofriends/exif"
+	"../../photofriends/phash"
+	"../../photofriends/storage"
+	"../../photofriends/thumbnail"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrGalleryIDRequired is returned when an image
+	// is created without a gallery to belong to
+	ErrGalleryIDRequired = errors.New("Gallery ID is required")
+
+	// ErrFilenameRequired is returned when an image
+	// is created without a filename
+	ErrFilenameRequired = modelError("Filename is required")
+
+	// ErrImageTypeInvalid is returned when an upload is
+	// not one of the image types listed in ImageContentTypes
+	ErrImageTypeInvalid = modelError("Only JPEG, PNG and GIF images can be uploaded")
+)
+
+// ImageContentTypes are the content types
+// users are allowed to upload to a gallery
+var ImageContentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+}
+
+// Image is a single uploaded file belonging to a
+// gallery. The file itself lives in a storage.Storage,
+// while this record keeps track of where to find it
+type Image struct {
+	gorm.Model
+	GalleryID   uint   `gorm:"not_null;index"`
+	Filename    string `gorm:"not_null"`
+	ContentType string `gorm:"not_null"`
+	Size        int64
+
+	// StripMetadata removes GPS and other private EXIF
+	// data from the copy of the image we serve
+	StripMetadata bool
+
+	// Width and Height of the original, these are set
+	// once the derived sizes have been generated
+	Width  int
+	Height int
+
+	URL      string         `gorm:"-"`
+	Variants []ImageVariant `gorm:"-"`
+	Metadata *ImageMetadata `gorm:"-"`
+
+	// Duplicates are the other images of the owner that
+	// look like this one, closest first. They are only
+	// looked up by ImageService.DuplicatesOf
+	Duplicates []Duplicate `gorm:"-"`
+
+	// processed is closed once the thumbnail pool is done
+	// with an image that was just created, see Create
+	processed chan struct{}
+}
+
+// ImageVariant is one of the thumbnail.Sizes generated
+// from an uploaded image
+type ImageVariant struct {
+	Name  string
+	Width int
+	URL   string
+}
+
+// Key is the storage key the image file is stored under
+func (i *Image) Key() string {
+	return fmt.Sprintf("galleries/%d/images/%d/%s", i.GalleryID, i.ID, i.Filename)
+}
+
+// VariantKey is the storage key of the derived size with
+// the given name, stored next to the original
+func (i *Image) VariantKey(size string) string {
+	return fmt.Sprintf("galleries/%d/images/%d/%s/%s", i.GalleryID, i.ID, size, i.Filename)
+}
+
+// SrcSet returns the image URLs in the format expected by
+// the srcset attribute, eg: "/a/thumb.jpg 200w, /a.jpg 1200w".
+// Until the derived sizes exist it is empty
+func (i *Image) SrcSet() string {
+	if i.Width == 0 {
+		return ""
+	}
+
+	candidates := make([]string, 0, len(i.Variants)+1)
+	for _, v := range i.Variants {
+		candidates = append(candidates, fmt.Sprintf("%s %dw", v.URL, v.Width))
+	}
+	candidates = append(candidates, fmt.Sprintf("%s %dw", i.URL, i.Width))
+
+	return strings.Join(candidates, ", ")
+}
+
+// Thumbnail returns the URL of the smallest derived
+// size, falling back to the original
+func (i *Image) Thumbnail() string {
+	if len(i.Variants) > 0 {
+		return i.Variants[0].URL
+	}
+
+	return i.URL
+}
+
+// ImageService is used to store image files and the
+// records that point to them. It does not care which
+// storage.Storage backend the files end up in
+type ImageService interface {
+	// Create stores the image record and the file read
+	// from r. If storing the file fails the record is
+	// removed again so we never point to missing files.
+	// EXIF data is read from JPEGs on the way in. The
+	// derived sizes and the perceptual hash are computed
+	// later on the thumbnail pool
+	Create(image *Image, r io.Reader) error
+
+	// ByID looks up a single image along with its metadata
+	ByID(id uint) (*Image, error)
+	ByGalleryID(galleryID uint) ([]Image, error)
+
+	// ByGalleryIDPage returns a page of the
+	// images in a gallery, oldest first
+	ByGalleryIDPage(galleryID uint, page Page) ([]Image, error)
+
+	// Duplicates groups the images in every gallery of the
+	// user that look alike, see NearDuplicateDistance. The
+	// Distance of each is to the first, oldest image of
+	// its group
+	Duplicates(userID uint) ([][]Duplicate, error)
+
+	// DuplicatesOf sets the Duplicates of image to the images
+	// of its owner that look like it. Images are hashed on the
+	// thumbnail pool after Create, so for an image that was
+	// just created this waits for its job to finish
+	DuplicatesOf(image *Image) error
+
+	// HashMissing hashes the images that were uploaded
+	// before perceptual hashes were kept. Images that
+	// can not be hashed are logged and skipped
+	HashMissing() error
+	Delete(image *Image) error
+}
+
+// ImageDB is used to interact with the images database
+type ImageDB interface {
+	ByID(id uint) (*Image, error)
+	ByGalleryID(galleryID uint) ([]Image, error)
+	ByGalleryIDPage(galleryID uint, page Page) ([]Image, error)
+
+	// ByIDs looks up the images with the given IDs,
+	// leaving out the ones that do not exist
+	ByIDs(ids []uint) ([]Image, error)
+	Create(image *Image) error
+	Update(image *Image) error
+	Delete(id uint) error
+}
+
+// NewImageService creates an ImageService storing files in
+// store. Derived sizes are generated in the background on pool
+func NewImageService(db *gorm.DB, store storage.Storage, pool *thumbnail.Pool) ImageService {
+	return &imageService{
+		ImageDB: newImageValidator(&imageGorm{db}),
+		meta:    &imageMetadataGorm{db},
+		hashes:  &imageHashGorm{db},
+		store:   store,
+		pool:    pool,
+	}
+}
+
+// ensure interface is matching
+var _ ImageService = &imageService{}
+
+type imageService struct {
+	ImageDB
+	meta   ImageMetadataDB
+	hashes ImageHashDB
+	store  storage.Storage
+	pool   *thumbnail.Pool
+}
+
+func (is *imageService) Create(image *Image, r io.Reader) error {
+	var meta *ImageMetadata
+	if image.ContentType == "image/jpeg" {
+		// exif.Decode only reads the start of the file, which
+		// we keep around so the whole file can still be stored
+		var head bytes.Buffer
+		x, err := exif.Decode(io.TeeReader(r, &head))
+		r = io.MultiReader(&head, r)
+		if err == nil {
+			meta = newImageMetadata(x, image.StripMetadata)
+		}
+
+		if image.StripMetadata {
+			stripped := exif.Stripped(r)
+			defer stripped.Close()
+			r = stripped
+		}
+	}
+
+	orientation := 1
+	if meta != nil {
+		orientation = meta.Orientation
+	}
+
+	if err := is.ImageDB.Create(image); err != nil {
+		return err
+	}
+
+	if err := is.store.Put(image.Key(), r, image.ContentType); err != nil {
+		is.ImageDB.Delete(image.ID)
+		return err
+	}
+
+	if meta != nil {
+		meta.ImageID = image.ID
+		if err := is.meta.Create(meta); err != nil {
+			return err
+		}
+
+		image.Metadata = meta
+	}
+
+	// the derived sizes and the hash are computed from the
+	// stored original, so the job only holds on to a copy
+	// of the record
+	queued := *image
+	processed := make(chan struct{})
+	err := is.pool.Submit(func() {
+		defer close(processed)
+		is.generateVariants(queued, orientation)
+	})
+	if err != nil {
+		return err
+	}
+	image.processed = processed
+
+	return is.setURL(image)
+}
+
+func (is *imageService) ByID(id uint) (*Image, error) {
+	image, err := is.ImageDB.ByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := is.meta.ByImageID(id)
+	switch err {
+	case nil:
+		image.Metadata = meta
+	case ErrNotFound:
+		// not every image has EXIF data
+	default:
+		return nil, err
+	}
+
+	return image, is.setURL(image)
+}
+
+func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
+	images, err := is.ImageDB.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return images, is.setURLs(images)
+}
+
+func (is *imageService) ByGalleryIDPage(galleryID uint, page Page) ([]Image, error) {
+	images, err := is.ImageDB.ByGalleryIDPage(galleryID, page)
+	if err != nil {
+		return nil, err
+	}
+
+	return images, is.setURLs(images)
+}
+
+func (is *imageService) DuplicatesOf(image *Image) error {
+	if image.processed != nil {
+		<-image.processed
+	}
+
+	hash, err := is.hashes.ByImageID(image.ID)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	h := hash.PHash()
+	candidates, err := is.hashes.Candidates(image.GalleryID, h.Probes(NearDuplicateDistance))
+	if err != nil {
+		return err
+	}
+
+	distances := make(map[uint]int)
+	ids := make([]uint, 0, len(candidates))
+	for _, c := range candidates {
+		d := phash.Distance(h, c.PHash())
+		if d <= NearDuplicateDistance && c.ImageID != image.ID {
+			distances[c.ImageID] = d
+			ids = append(ids, c.ImageID)
+		}
+	}
+
+	images, err := is.byIDs(ids)
+	if err != nil {
+		return err
+	}
+
+	image.Duplicates = make([]Duplicate, len(images))
+	for i := range images {
+		image.Duplicates[i] = Duplicate{images[i], distances[images[i].ID]}
+	}
+	sort.SliceStable(image.Duplicates, func(i, j int) bool {
+		return image.Duplicates[i].Distance < image.Duplicates[j].Distance
+	})
+
+	return nil
+}
+
+func (is *imageService) Duplicates(userID uint) ([][]Duplicate, error) {
+	hashes, err := is.hashes.ByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := groupSimilar(hashes, NearDuplicateDistance)
+	var ids []uint
+	for _, group := range groups {
+		ids = append(ids, group...)
+	}
+
+	images, err := is.byIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]Image, len(images))
+	for _, image := range images {
+		byID[image.ID] = image
+	}
+	hashByID := make(map[uint]phash.Hash, len(hashes))
+	for _, h := range hashes {
+		hashByID[h.ImageID] = h.PHash()
+	}
+
+	all := make([][]Duplicate, 0, len(groups))
+	for _, group := range groups {
+		var dups []Duplicate
+		for _, id := range group {
+			image, ok := byID[id]
+			if !ok {
+				continue
+			}
+			dups = append(dups, Duplicate{image, phash.Distance(hashByID[group[0]], hashByID[id])})
+		}
+
+		// images deleted since the hashes
+		// were looked up may break up a group
+		if len(dups) > 1 {
+			all = append(all, dups)
+		}
+	}
+
+	return all, nil
+}
+
+// byIDs looks up the images with their URLs, see ImageDB.ByIDs
+func (is *imageService) byIDs(ids []uint) ([]Image, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	images, err := is.ImageDB.ByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	return images, is.setURLs(images)
+}
+
+// hashBatchSize is how many images HashMissing looks up at once
+const hashBatchSize = 100
+
+func (is *imageService) HashMissing() error {
+	var afterID uint
+	for {
+		images, err := is.hashes.Unhashed(afterID, hashBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return nil
+		}
+
+		for i := range images {
+			afterID = images[i].ID
+			if err := is.hashStored(&images[i]); err != nil {
+				log.Printf("images: hashing image %d: %v", images[i].ID, err)
+			}
+		}
+	}
+}
+
+// hashStored hashes the stored original of img
+func (is *imageService) hashStored(img *Image) error {
+	orientation := 1
+	if img.Width == 0 {
+		// the original is only rotated upright along
+		// with generating the derived sizes
+		meta, err := is.meta.ByImageID(img.ID)
+		if err == nil {
+			orientation = meta.Orientation
+		}
+	}
+
+	rc, err := is.store.Get(img.Key())
+	if err != nil {
+		return err
+	}
+
+	src, _, err := image.Decode(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	return is.hashes.Create(newImageHash(img.ID, hashImage(src, orientation)))
+}
+
+// setURLs fills in the URLs of every image, see setURL
+func (is *imageService) setURLs(images []Image) error {
+	for i := range images {
+		if err := is.setURL(&images[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (is *imageService) Delete(image *Image) error {
+	for _, size := range thumbnail.Sizes {
+		if err := is.store.Delete(image.VariantKey(size.Name)); err != nil {
+			return err
+		}
+	}
+
+	if err := is.store.Delete(image.Key()); err != nil {
+		return err
+	}
+
+	if err := is.meta.DeleteByImageID(image.ID); err != nil {
+		return err
+	}
+
+	if err := is.hashes.DeleteByImageID(image.ID); err != nil {
+		return err
+	}
+
+	return is.ImageDB.Delete(image.ID)
+}
+
+// setURL fills in the URL of the original and of every
+// derived size that is smaller than the original
+func (is *imageService) setURL(image *Image) error {
+	url, err := is.store.URL(image.Key())
+	if err != nil {
+		return err
+	}
+
+	image.URL = url
+	image.Variants = nil
+	for _, size := range thumbnail.Sizes {
+		if size.Width >= image.Width {
+			break
+		}
+
+		url, err := is.store.URL(image.VariantKey(size.Name))
+		if err != nil {
+			return err
+		}
+
+		image.Variants = append(image.Variants, ImageVariant{
+			Name:  size.Name,
+			Width: size.Width,
+			URL:   url,
+		})
+	}
+
+	return nil
+}
+
+// generateVariants runs on the thumbnail pool. It decodes the
+// stored original, stores every derived size that is smaller
+// than it and then records the dimensions of the original.
+// Images that are not stored upright are rotated first. The
+// decoded original is also hashed, see DuplicatesOf
+func (is *imageService) generateVariants(img Image, orientation int) {
+	src, err := is.storeVariants(&img, orientation)
+	if err != nil {
+		log.Printf("images: generating sizes for image %d: %v", img.ID, err)
+		return
+	}
+
+	if err := is.ImageDB.Update(&img); err != nil {
+		log.Printf("images: updating image %d: %v", img.ID, err)
+	}
+
+	// the image is kept when this fails, it is
+	// only left out when looking for duplicates
+	if err := is.hashes.Create(newImageHash(img.ID, hashImage(src, 1))); err != nil {
+		log.Printf("images: hashing image %d: %v", img.ID, err)
+	}
+}
+
+// storeVariants returns the decoded original, rotated upright
+func (is *imageService) storeVariants(img *Image, orientation int) (image.Image, error) {
+	rc, err := is.store.Get(img.Key())
+	if err != nil {
+		return nil, err
+	}
+
+	src, format, err := image.Decode(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if orientation > 1 {
+		// replace the stored copy with the rotated pixels, this
+		// also drops the EXIF data so it is not rotated twice
+		src = exif.Orient(src, orientation)
+
+		var buf bytes.Buffer
+		if err := thumbnail.Encode(&buf, src, format); err != nil {
+			return nil, err
+		}
+
+		if err := is.store.Put(img.Key(), &buf, img.ContentType); err != nil {
+			return nil, err
+		}
+	}
+
+	bounds := src.Bounds()
+	for _, size := range thumbnail.Sizes {
+		if size.Width >= bounds.Dx() {
+			break
+		}
+
+		var buf bytes.Buffer
+		if err := thumbnail.Encode(&buf, thumbnail.Resize(src, size.Width), format); err != nil {
+			return nil, err
+		}
+
+		if err := is.store.Put(img.VariantKey(size.Name), &buf, img.ContentType); err != nil {
+			return nil, err
+		}
+	}
+
+	img.Width = bounds.Dx()
+	img.Height = bounds.Dy()
+	return src, nil
+}
+
+/******************* VALIDATORS **************************/
+
+func newImageValidator(idb ImageDB) *imageValidator {
+	return &imageValidator{
+		ImageDB: idb,
+
+		// unsafeFilenameChars matches everything we do
+		// not want to end up in a storage key
+		unsafeFilenameChars: regexp.MustCompile(`[^a-zA-Z0-9._-]+`),
+	}
+}
+
+type imageValidator struct {
+	ImageDB
+	unsafeFilenameChars *regexp.Regexp
+}
+
+func (iv *imageValidator) Create(image *Image) error {
+	err := runImageValFuncs(image,
+		iv.galleryIDRequired,
+		iv.normalizeFilename,
+		iv.filenameRequired,
+		iv.contentTypeAllowed)
+
+	if err != nil {
+		return err
+	}
+
+	return iv.ImageDB.Create(image)
+}
+
+func (iv *imageValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return iv.ImageDB.Delete(id)
+}
+
+func (iv *imageValidator) galleryIDRequired(image *Image) error {
+	if image.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+
+	return nil
+}
+
+// normalizeFilename drops any directories from the uploaded
+// filename and replaces characters that are not safe to use
+// in a storage key, eg: "C:\My Photos\cat 1.jpg" -> "cat_1.jpg"
+func (iv *imageValidator) normalizeFilename(image *Image) error {
+	name := image.Filename
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = iv.unsafeFilenameChars.ReplaceAllString(name, "_")
+	name = strings.TrimLeft(name, ".")
+	image.Filename = name
+	return nil
+}
+
+func (iv *imageValidator) filenameRequired(image *Image) error {
+	if image.Filename == "" {
+		return ErrFilenameRequired
+	}
+
+	return nil
+}
+
+func (iv *imageValidator) contentTypeAllowed(image *Image) error {
+	for _, ct := range ImageContentTypes {
+		if image.ContentType == ct {
+			return nil
+		}
+	}
+
+	return ErrImageTypeInvalid
+}
+
+type imageValFunc func(*Image) error
+
+func runImageValFuncs(image *Image, fns ...imageValFunc) error {
+	for _, fn := range fns {
+		if err := fn(image); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/************************************************************/
+
+// ensure interface is matching
+var _ ImageDB = &imageGorm{}
+
+type imageGorm struct {
+	db *gorm.DB
+}
+
+func (ig *imageGorm) ByID(id uint) (*Image, error) {
+	var image Image
+	if err := first(ig.db.Where("id = ?", id), &image); err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
+
+// ByGalleryID returns all images in a gallery, oldest first
+func (ig *imageGorm) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.
+		Where("gallery_id = ?", galleryID).
+		Order("created_at asc").
+		Find(&images).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+func (ig *imageGorm) ByGalleryIDPage(galleryID uint, page Page) ([]Image, error) {
+	var images []Image
+	db := page.apply(ig.db.Where("gallery_id = ?", galleryID), false)
+	if err := db.Find(&images).Error; err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+func (ig *imageGorm) ByIDs(ids []uint) ([]Image, error) {
+	var images []Image
+	if err := ig.db.Where("id IN (?)", ids).Find(&images).Error; err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+func (ig *imageGorm) Create(image *Image) error {
+	return ig.db.Create(image).Error
+}
+
+// Update will update the non zero fields of the provided
+// image. Unlike Save it never recreates a deleted image
+func (ig *imageGorm) Update(image *Image) error {
+	return ig.db.Model(image).Updates(image).Error
+}
+
+func (ig *imageGorm) Delete(id uint) error {
+	image := Image{Model: gorm.Model{ID: id}}
+	return ig.db.Delete(&image).Error
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"../../photofriends/ratelimit"
+	"github.com/jinzhu/gorm"
+)
+
+// Results of a login attempt
+const (
+	LoginSucceeded     = "succeeded"
+	LoginWrongPassword = "wrong_password"
+	LoginUnknownEmail  = "unknown_email"
+	LoginLimited       = "limited"
+	LoginPasskey       = "passkey"
+	LoginPasskeyFailed = "passkey_failed"
+	LoginOIDC          = "oidc"
+)
+
+// loginAttemptsShown is how many of their most
+// recent login attempts users can look at
+const loginAttemptsShown = 50
+
+// Rate limits for logging in. Failures are counted per IP
+// address and per email address. The IP limit is looser,
+// as many people can share an address behind a NAT
+var (
+	loginIPPolicy = ratelimit.Policy{
+		Window:      15 * time.Minute,
+		MaxFailures: 20,
+		Lockout:     5 * time.Minute,
+		MaxLockout:  time.Hour,
+	}
+
+	loginEmailPolicy = ratelimit.Policy{
+		Window:      15 * time.Minute,
+		MaxFailures: 5,
+		Lockout:     time.Minute,
+		MaxLockout:  time.Hour,
+	}
+)
+
+// LoginClient describes where a login attempt comes from
+type LoginClient struct {
+	IP        string
+	UserAgent string
+}
+
+// LoginAttempt records a single try to log in with a
+// password, passkey or identity provider, whether it succeeded or not
+type LoginAttempt struct {
+	ID uint `gorm:"primary_key"`
+
+	// UserID is nil when no user has the email address
+	UserID    *uint  `gorm:"index"`
+	Email     string `gorm:"not null"`
+	IP        string
+	UserAgent string
+	Result    string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// Succeeded reports whether the attempt logged the user in
+func (la *LoginAttempt) Succeeded() bool {
+	return la.Result == LoginSucceeded || la.Result == LoginPasskey || la.Result == LoginOIDC
+}
+
+type loginAttemptDB interface {
+	// ByUserID returns the latest attempts first
+	ByUserID(userID uint, limit int) ([]LoginAttempt, error)
+	Create(la *LoginAttempt) error
+}
+
+func loginIPKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+func loginEmailKey(email string) string {
+	return "login:email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ensure interface is matching
+var _ loginAttemptDB = &loginAttemptGorm{}
+
+type loginAttemptGorm struct {
+	db *gorm.DB
+}
+
+func (lag *loginAttemptGorm) ByUserID(userID uint, limit int) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := lag.db.Where("user_id = ?", userID).
+		Order("created_at desc").
+		Limit(limit).
+		Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+func (lag *loginAttemptGorm) Create(la *LoginAttempt) error {
+	return lag.db.Create(la).Error
+}
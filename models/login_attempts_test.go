@@ -0,0 +1,52 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"../../photofriends/ratelimit"
+)
+
+// memEmailUserDB is a UserDB without any users, which
+// like userGorm returns an empty user with ErrNotFound
+type memEmailUserDB struct {
+	UserDB
+}
+
+func (m *memEmailUserDB) ByEmail(email string) (*User, error) {
+	return &User{}, ErrNotFound
+}
+
+func TestAuthenticateUnknownEmail(t *testing.T) {
+	attempts := &memLoginAttemptDB{}
+	us := &userService{
+		UserDB:   &memEmailUserDB{},
+		attempts: attempts,
+		limiter:  ratelimit.New(ratelimit.NewMemory()),
+	}
+	client := LoginClient{IP: "203.0.113.7", UserAgent: "test"}
+
+	// another address every time, so only the IP
+	// address can be locked out
+	for i := 0; i < loginIPPolicy.MaxFailures-1; i++ {
+		email := fmt.Sprintf("nobody%d@example.com", i)
+		if _, err := us.Authenticate(email, "password", client); err != ErrNotFound {
+			t.Fatalf("Expected ErrNotFound. Recieved %v", err)
+		}
+	}
+
+	for _, a := range attempts.attempts {
+		if a.Result != LoginUnknownEmail || a.UserID != nil {
+			t.Fatalf("Expected an unknown_email attempt without user. Recieved %+v", a)
+		}
+	}
+
+	_, err := us.Authenticate("last@example.com", "password", client)
+	if _, ok := err.(*ratelimit.LimitedError); !ok {
+		t.Errorf("Expected the IP address to be locked out. Recieved %v", err)
+	}
+
+	if _, err := us.Authenticate("again@example.com", "password", client); err == ErrNotFound {
+		t.Error("Expected the locked out IP address to be refused")
+	}
+}
@@ -0,0 +1,34 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// Page selects part of a list for cursor based pagination.
+// Lists are ordered by ID, so After is the ID of the last
+// item seen, 0 to start at the beginning, and new items
+// never shift the pages that follow
+type Page struct {
+	After uint
+	Limit int
+}
+
+// apply adds the page to a query, ordering by ID
+// newest first when desc is set, oldest first otherwise
+func (p Page) apply(db *gorm.DB, desc bool) *gorm.DB {
+	if desc {
+		if p.After > 0 {
+			db = db.Where("id < ?", p.After)
+		}
+		db = db.Order("id desc")
+	} else {
+		if p.After > 0 {
+			db = db.Where("id > ?", p.After)
+		}
+		db = db.Order("id asc")
+	}
+
+	if p.Limit > 0 {
+		db = db.Limit(p.Limit)
+	}
+
+	return db
+}
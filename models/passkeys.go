@@ -0,0 +1,616 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"../../photofriends/hash"
+	"../../photofriends/rand"
+	"../../photofriends/webauthn"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrPasskeyInvalid is returned when a passkey
+	// is unknown or its response does not verify
+	ErrPasskeyInvalid = modelError("The passkey could not be verified. Please try again")
+
+	// ErrPasskeyUnverified is returned when the device
+	// did not check who is using the passkey
+	ErrPasskeyUnverified = modelError("Your device has to confirm it is you, with a PIN, fingerprint or face")
+
+	// ErrPasskeyCloned is returned when the sign counter of a
+	// passkey went backwards, a sign it was copied
+	ErrPasskeyCloned = modelError("This passkey may have been copied and can no longer be used. Please remove it and add a new one")
+
+	// ErrPasskeyRegistered is returned when adding
+	// a passkey that is already in use
+	ErrPasskeyRegistered = modelError("This passkey is already registered")
+
+	// ErrPasskeyExpired is returned when the ceremony
+	// took too long, or was finished already
+	ErrPasskeyExpired = modelError("The passkey request has expired. Please try again")
+
+	// ErrPasskeyNameTooLong is returned when a
+	// passkey is given a name that is too long
+	ErrPasskeyNameTooLong = modelError("Name must be at most 64 characters long")
+)
+
+const (
+	// passkeyNameMaxLength is the longest name a passkey can have
+	passkeyNameMaxLength = 64
+
+	// passkeyDefaultName is used when the user gave no name
+	passkeyDefaultName = "Passkey"
+)
+
+// Passkey is a WebAuthn credential the user can log in with,
+// instead of their password and second factor. Only the
+// public key is stored, the private one stays on the device
+type Passkey struct {
+	ID           uint   `gorm:"primary_key"`
+	UserID       uint   `gorm:"not_null;index"`
+	Name         string `gorm:"not_null"`
+	CredentialID []byte `gorm:"not_null;unique_index"`
+	PublicKey    []byte `gorm:"not_null"`
+
+	// SignCount is the counter of the last signature, it has
+	// to go up with every login unless the device has none
+	SignCount  int64 `gorm:"not_null;default:0"`
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// PasskeyService registers passkeys and logs users in
+// with them. Both take two steps: Begin creates a challenge
+// for the browser and returns a token identifying it, which
+// has to be passed back with the response to Finish
+type PasskeyService interface {
+	// BeginRegistration starts adding a passkey for the user.
+	// The options are passed to navigator.credentials.create
+	BeginRegistration(user *User) (token string, opts webauthn.CreationOptions, err error)
+
+	// FinishRegistration verifies the new credential and
+	// stores it as a passkey of the user, named name
+	FinishRegistration(user *User, token, name string, resp *webauthn.RegistrationResponse) (*Passkey, error)
+
+	// BeginLogin starts logging in without an email address.
+	// The options are passed to navigator.credentials.get
+	BeginLogin() (token string, opts webauthn.RequestOptions, err error)
+
+	// FinishLogin verifies the assertion and returns the user
+	// owning the passkey. The attempt is recorded with the
+	// user's login attempts
+	FinishLogin(token string, resp *webauthn.AssertionResponse, client LoginClient) (*User, error)
+
+	// Remove deletes a passkey of the user
+	Remove(userID, passkeyID uint) error
+
+	PasskeyDB
+}
+
+// PasskeyDB is used to interact with the passkeys database
+type PasskeyDB interface {
+	ByID(id uint) (*Passkey, error)
+	ByCredentialID(credentialID []byte) (*Passkey, error)
+
+	// ByUserID returns the passkeys of the user, oldest first
+	ByUserID(userID uint) ([]Passkey, error)
+	Create(passkey *Passkey) error
+	Update(passkey *Passkey) error
+	Delete(id uint) error
+}
+
+// NewPasskeyService creates a PasskeyService for the relying
+// party in cfg. Users logging in are looked up in users, and
+// challenge tokens are HMACed with hmac
+func NewPasskeyService(db *gorm.DB, users UserDB, cfg webauthn.Config, hmac hash.HMAC) PasskeyService {
+	return &passkeyService{
+		PasskeyDB:   &passkeyValidator{&passkeyGorm{db}},
+		challengeDB: newPasskeyChallengeValidator(&passkeyChallengeGorm{db}, hmac),
+		users:       users,
+		attempts:    &loginAttemptGorm{db},
+		cfg:         cfg,
+	}
+}
+
+// ensure interface is matching
+var _ PasskeyService = &passkeyService{}
+
+type passkeyService struct {
+	PasskeyDB
+	challengeDB passkeyChallengeDB
+	users       UserDB
+	attempts    loginAttemptDB
+	cfg         webauthn.Config
+}
+
+// userHandle is the WebAuthn user ID of a user, which
+// the authenticator returns when logging in
+func userHandle(userID uint) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// BeginRegistration excludes the passkeys the user already
+// has, so the browser refuses to add the same one twice
+func (ps *passkeyService) BeginRegistration(user *User) (string, webauthn.CreationOptions, error) {
+	passkeys, err := ps.ByUserID(user.ID)
+	if err != nil {
+		return "", webauthn.CreationOptions{}, err
+	}
+
+	exclude := make([][]byte, len(passkeys))
+	for i, pk := range passkeys {
+		exclude[i] = pk.CredentialID
+	}
+
+	pc := passkeyChallenge{UserID: user.ID}
+	if err := ps.challengeDB.Create(&pc); err != nil {
+		return "", webauthn.CreationOptions{}, err
+	}
+
+	displayName := user.Name
+	if displayName == "" {
+		displayName = user.Email
+	}
+
+	entity := webauthn.UserEntity{
+		ID:          userHandle(user.ID),
+		Name:        user.Email,
+		DisplayName: displayName,
+	}
+	return pc.Token, ps.cfg.CreationOptions(pc.Challenge, entity, exclude), nil
+}
+
+func (ps *passkeyService) FinishRegistration(user *User, token, name string, resp *webauthn.RegistrationResponse) (*Passkey, error) {
+	pc, err := ps.takeChallenge(token, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := ps.cfg.VerifyRegistration(pc.Challenge, resp)
+	if err != nil {
+		return nil, passkeyError(err)
+	}
+
+	switch _, err := ps.ByCredentialID(cred.ID); err {
+	case nil:
+		return nil, ErrPasskeyRegistered
+	case ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	passkey := Passkey{
+		UserID:       user.ID,
+		Name:         name,
+		CredentialID: cred.ID,
+		PublicKey:    cred.PublicKey,
+		SignCount:    int64(cred.SignCount),
+	}
+	if err := ps.Create(&passkey); err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+// BeginLogin does not ask for a specific passkey, the
+// browser offers the ones it has for the site
+func (ps *passkeyService) BeginLogin() (string, webauthn.RequestOptions, error) {
+	var pc passkeyChallenge
+	if err := ps.challengeDB.Create(&pc); err != nil {
+		return "", webauthn.RequestOptions{}, err
+	}
+
+	return pc.Token, ps.cfg.RequestOptions(pc.Challenge, nil), nil
+}
+
+// FinishLogin does not ask for the second factor of users
+// with two factor auth turned on, as the passkey already
+// is something they have, unlocked by a PIN or biometrics
+func (ps *passkeyService) FinishLogin(token string, resp *webauthn.AssertionResponse, client LoginClient) (*User, error) {
+	pc, err := ps.takeChallenge(token, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	passkey, err := ps.ByCredentialID(resp.RawID)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrPasskeyInvalid
+		}
+		return nil, err
+	}
+
+	user, err := ps.users.ByID(passkey.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := LoginAttempt{
+		UserID:    &user.ID,
+		Email:     user.Email,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Result:    LoginPasskeyFailed,
+	}
+
+	// authenticators may leave the user handle out when
+	// the credential was asked for by ID, but never send
+	// the one of another user
+	handle := resp.Response.UserHandle
+	if len(handle) != 0 && !bytes.Equal(handle, userHandle(user.ID)) {
+		ps.attempts.Create(&attempt)
+		return nil, ErrPasskeyInvalid
+	}
+
+	assertion, err := ps.cfg.VerifyAssertion(pc.Challenge, passkey.PublicKey, uint32(passkey.SignCount), resp)
+	if err != nil {
+		ps.attempts.Create(&attempt)
+		return nil, passkeyError(err)
+	}
+
+	now := time.Now()
+	passkey.SignCount = int64(assertion.SignCount)
+	passkey.LastUsedAt = &now
+	if err := ps.Update(passkey); err != nil {
+		return nil, err
+	}
+
+	attempt.Result = LoginPasskey
+	if err := ps.attempts.Create(&attempt); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (ps *passkeyService) Remove(userID, passkeyID uint) error {
+	passkey, err := ps.ByID(passkeyID)
+	if err != nil {
+		return err
+	}
+
+	if passkey.UserID != userID {
+		return ErrNotFound
+	}
+
+	return ps.Delete(passkey.ID)
+}
+
+// takeChallenge looks up the challenge of a ceremony and
+// uses it up, so a response can only be verified once.
+// Registrations have to be finished by the same user
+func (ps *passkeyService) takeChallenge(token string, userID uint) (*passkeyChallenge, error) {
+	pc, err := ps.challengeDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrPasskeyExpired
+		}
+		return nil, err
+	}
+
+	if err := ps.challengeDB.Delete(pc.ID); err != nil {
+		return nil, err
+	}
+
+	if pc.UserID != userID || time.Now().After(pc.ExpiresAt) {
+		return nil, ErrPasskeyExpired
+	}
+
+	return pc, nil
+}
+
+// passkeyError turns the reason a WebAuthn
+// response was refused into a message for users
+func passkeyError(err error) error {
+	switch err {
+	case webauthn.ErrUserVerification:
+		return ErrPasskeyUnverified
+	case webauthn.ErrSignCount:
+		return ErrPasskeyCloned
+	default:
+		return ErrPasskeyInvalid
+	}
+}
+
+/******************* VALIDATORS **************************/
+
+type passkeyValidator struct {
+	PasskeyDB
+}
+
+func (pv *passkeyValidator) ByCredentialID(credentialID []byte) (*Passkey, error) {
+	if len(credentialID) == 0 {
+		return nil, ErrNotFound
+	}
+
+	return pv.PasskeyDB.ByCredentialID(credentialID)
+}
+
+func (pv *passkeyValidator) Create(passkey *Passkey) error {
+	err := runPasskeyValFuncs(passkey,
+		pv.requireUserID,
+		pv.requireCredential,
+		pv.normalizeName)
+
+	if err != nil {
+		return err
+	}
+
+	return pv.PasskeyDB.Create(passkey)
+}
+
+func (pv *passkeyValidator) Update(passkey *Passkey) error {
+	err := runPasskeyValFuncs(passkey,
+		pv.requireUserID,
+		pv.requireCredential,
+		pv.normalizeName)
+
+	if err != nil {
+		return err
+	}
+
+	return pv.PasskeyDB.Update(passkey)
+}
+
+func (pv *passkeyValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return pv.PasskeyDB.Delete(id)
+}
+
+func (pv *passkeyValidator) requireUserID(pk *Passkey) error {
+	if pk.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (pv *passkeyValidator) requireCredential(pk *Passkey) error {
+	if len(pk.CredentialID) == 0 || len(pk.PublicKey) == 0 {
+		return ErrPasskeyInvalid
+	}
+
+	return nil
+}
+
+// normalizeName trims the name, and falls back to
+// passkeyDefaultName when it is empty
+func (pv *passkeyValidator) normalizeName(pk *Passkey) error {
+	pk.Name = strings.TrimSpace(pk.Name)
+	if pk.Name == "" {
+		pk.Name = passkeyDefaultName
+	}
+
+	if utf8.RuneCountInString(pk.Name) > passkeyNameMaxLength {
+		return FieldErrors{"name": ErrPasskeyNameTooLong}
+	}
+
+	return nil
+}
+
+type passkeyValFunc func(*Passkey) error
+
+func runPasskeyValFuncs(pk *Passkey, fns ...passkeyValFunc) error {
+	for _, fn := range fns {
+		if err := fn(pk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/************************************************************/
+
+// ensure interface is matching
+var _ PasskeyDB = &passkeyGorm{}
+
+type passkeyGorm struct {
+	db *gorm.DB
+}
+
+func (pg *passkeyGorm) ByID(id uint) (*Passkey, error) {
+	var passkey Passkey
+	if err := first(pg.db.Where("id = ?", id), &passkey); err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+func (pg *passkeyGorm) ByCredentialID(credentialID []byte) (*Passkey, error) {
+	var passkey Passkey
+	if err := first(pg.db.Where("credential_id = ?", credentialID), &passkey); err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+func (pg *passkeyGorm) ByUserID(userID uint) ([]Passkey, error) {
+	var passkeys []Passkey
+	err := pg.db.
+		Where("user_id = ?", userID).
+		Order("created_at").
+		Find(&passkeys).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+func (pg *passkeyGorm) Create(passkey *Passkey) error {
+	return pg.db.Create(passkey).Error
+}
+
+func (pg *passkeyGorm) Update(passkey *Passkey) error {
+	return pg.db.Save(passkey).Error
+}
+
+func (pg *passkeyGorm) Delete(id uint) error {
+	passkey := Passkey{ID: id}
+	return pg.db.Delete(&passkey).Error
+}
+
+// passkeyChallenge is a registration or login in progress.
+// The token is kept in a cookie while the browser talks to
+// the authenticator, only its HMAC is stored
+type passkeyChallenge struct {
+	ID uint `gorm:"primary_key"`
+
+	// UserID is 0 for logins, as the user is not known yet
+	UserID    uint      `gorm:"not_null;default:0"`
+	Token     string    `gorm:"-"`
+	TokenHash string    `gorm:"not_null;unique_index"`
+	Challenge []byte    `gorm:"not_null"`
+	ExpiresAt time.Time `gorm:"not_null"`
+	CreatedAt time.Time
+}
+
+type passkeyChallengeDB interface {
+	ByToken(token string) (*passkeyChallenge, error)
+
+	// Create also deletes expired challenges, as
+	// abandoned ceremonies are never finished
+	Create(pc *passkeyChallenge) error
+	Delete(id uint) error
+}
+
+func newPasskeyChallengeValidator(db passkeyChallengeDB, hmac hash.HMAC) *passkeyChallengeValidator {
+	return &passkeyChallengeValidator{
+		passkeyChallengeDB: db,
+		hmac:               hmac,
+	}
+}
+
+type passkeyChallengeValidator struct {
+	passkeyChallengeDB
+	hmac hash.HMAC
+}
+
+// ByToken expects the raw token and will hash
+// it before looking up the challenge
+func (pcv *passkeyChallengeValidator) ByToken(token string) (*passkeyChallenge, error) {
+	pc := passkeyChallenge{Token: token}
+	if err := runPasskeyChallengeValFuncs(&pc, pcv.hmacToken); err != nil {
+		return nil, err
+	}
+
+	return pcv.passkeyChallengeDB.ByToken(pc.TokenHash)
+}
+
+func (pcv *passkeyChallengeValidator) Create(pc *passkeyChallenge) error {
+	err := runPasskeyChallengeValFuncs(pc,
+		pcv.setTokenIfUnset,
+		pcv.hmacToken,
+		pcv.setChallenge,
+		pcv.setExpiry)
+
+	if err != nil {
+		return err
+	}
+
+	return pcv.passkeyChallengeDB.Create(pc)
+}
+
+func (pcv *passkeyChallengeValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return pcv.passkeyChallengeDB.Delete(id)
+}
+
+func (pcv *passkeyChallengeValidator) setTokenIfUnset(pc *passkeyChallenge) error {
+	if pc.Token != "" {
+		return nil
+	}
+
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	pc.Token = token
+	return nil
+}
+
+func (pcv *passkeyChallengeValidator) hmacToken(pc *passkeyChallenge) error {
+	if pc.Token == "" {
+		return ErrNotFound
+	}
+
+	pc.TokenHash = pcv.hmac.Hash(pc.Token)
+	return nil
+}
+
+func (pcv *passkeyChallengeValidator) setChallenge(pc *passkeyChallenge) error {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return err
+	}
+
+	pc.Challenge = challenge
+	return nil
+}
+
+func (pcv *passkeyChallengeValidator) setExpiry(pc *passkeyChallenge) error {
+	pc.ExpiresAt = time.Now().Add(webauthn.Timeout)
+	return nil
+}
+
+type passkeyChallengeValFunc func(*passkeyChallenge) error
+
+func runPasskeyChallengeValFuncs(pc *passkeyChallenge, fns ...passkeyChallengeValFunc) error {
+	for _, fn := range fns {
+		if err := fn(pc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensure interface is matching
+var _ passkeyChallengeDB = &passkeyChallengeGorm{}
+
+type passkeyChallengeGorm struct {
+	db *gorm.DB
+}
+
+// ByToken looks up a challenge by the already hashed token
+func (pcg *passkeyChallengeGorm) ByToken(tokenHash string) (*passkeyChallenge, error) {
+	var pc passkeyChallenge
+	if err := first(pcg.db.Where("token_hash = ?", tokenHash), &pc); err != nil {
+		return nil, err
+	}
+
+	return &pc, nil
+}
+
+func (pcg *passkeyChallengeGorm) Create(pc *passkeyChallenge) error {
+	err := pcg.db.Where("expires_at < ?", time.Now()).Delete(&passkeyChallenge{}).Error
+	if err != nil {
+		return err
+	}
+
+	return pcg.db.Create(pc).Error
+}
+
+func (pcg *passkeyChallengeGorm) Delete(id uint) error {
+	pc := passkeyChallenge{ID: id}
+	return pcg.db.Delete(&pc).Error
+}
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"testing"
+
+	"../../photofriends/hash"
+	"../../photofriends/webauthn"
+	"../../photofriends/webauthn/webauthntest"
+)
+
+// memPasskeyDB is an in-memory PasskeyDB
+type memPasskeyDB struct {
+	passkeys []Passkey
+}
+
+func (m *memPasskeyDB) ByID(id uint) (*Passkey, error) {
+	for _, pk := range m.passkeys {
+		if pk.ID == id {
+			return &pk, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memPasskeyDB) ByCredentialID(credentialID []byte) (*Passkey, error) {
+	for _, pk := range m.passkeys {
+		if string(pk.CredentialID) == string(credentialID) {
+			return &pk, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memPasskeyDB) ByUserID(userID uint) ([]Passkey, error) {
+	var all []Passkey
+	for _, pk := range m.passkeys {
+		if pk.UserID == userID {
+			all = append(all, pk)
+		}
+	}
+
+	return all, nil
+}
+
+func (m *memPasskeyDB) Create(pk *Passkey) error {
+	pk.ID = uint(len(m.passkeys) + 1)
+	m.passkeys = append(m.passkeys, *pk)
+	return nil
+}
+
+func (m *memPasskeyDB) Update(pk *Passkey) error {
+	for i := range m.passkeys {
+		if m.passkeys[i].ID == pk.ID {
+			m.passkeys[i] = *pk
+		}
+	}
+
+	return nil
+}
+
+func (m *memPasskeyDB) Delete(id uint) error {
+	for i := range m.passkeys {
+		if m.passkeys[i].ID == id {
+			m.passkeys = append(m.passkeys[:i], m.passkeys[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// memPasskeyChallengeDB is an in-memory passkeyChallengeDB
+type memPasskeyChallengeDB struct {
+	nextID     uint
+	challenges map[uint]passkeyChallenge
+}
+
+func (m *memPasskeyChallengeDB) ByToken(tokenHash string) (*passkeyChallenge, error) {
+	for _, pc := range m.challenges {
+		if pc.TokenHash == tokenHash {
+			return &pc, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memPasskeyChallengeDB) Create(pc *passkeyChallenge) error {
+	m.nextID++
+	pc.ID = m.nextID
+	m.challenges[pc.ID] = *pc
+	return nil
+}
+
+func (m *memPasskeyChallengeDB) Delete(id uint) error {
+	delete(m.challenges, id)
+	return nil
+}
+
+// memUserDB is an in-memory UserDB, without remember tokens
+type memUserDB struct {
+	users map[uint]User
+}
+
+func (m *memUserDB) ByID(id uint) (*User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	user.ID = id
+	return &user, nil
+}
+
+func (m *memUserDB) ByEmail(email string) (*User, error) {
+	for id, user := range m.users {
+		if user.Email == email {
+			user.ID = id
+			return &user, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memUserDB) Create(user *User) error {
+	user.ID = uint(len(m.users) + 1)
+	m.users[user.ID] = *user
+	return nil
+}
+
+func (m *memUserDB) Update(user *User) error {
+	m.users[user.ID] = *user
+	return nil
+}
+
+func (m *memUserDB) ByRemember(token string) (*User, error) { return nil, ErrNotFound }
+func (m *memUserDB) Delete(id uint) error                   { return nil }
+
+// memLoginAttemptDB is an in-memory loginAttemptDB
+type memLoginAttemptDB struct {
+	attempts []LoginAttempt
+}
+
+func (m *memLoginAttemptDB) ByUserID(userID uint, limit int) ([]LoginAttempt, error) {
+	return m.attempts, nil
+}
+
+func (m *memLoginAttemptDB) Create(la *LoginAttempt) error {
+	m.attempts = append(m.attempts, *la)
+	return nil
+}
+
+func testingPasskeyService() (*passkeyService, *memLoginAttemptDB) {
+	attempts := &memLoginAttemptDB{}
+	return &passkeyService{
+		PasskeyDB: &passkeyValidator{&memPasskeyDB{}},
+		challengeDB: newPasskeyChallengeValidator(
+			&memPasskeyChallengeDB{challenges: make(map[uint]passkeyChallenge)},
+			hash.NewHMAC("test-key")),
+		users: &memUserDB{users: map[uint]User{
+			1: {Email: "jon@example.com"},
+			2: {Email: "jane@example.com"},
+		}},
+		attempts: attempts,
+		cfg:      webauthn.DefaultConfig(),
+	}, attempts
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	ps, _ := testingPasskeyService()
+	a := webauthntest.New(ps.cfg.Origin)
+	user := &User{Email: "jon@example.com"}
+	user.ID = 1
+
+	token, opts, err := ps.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(opts.User.ID) != string(userHandle(1)) || opts.RP.ID != ps.cfg.RPID {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+
+	resp, err := a.Create(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := &User{}
+	other.ID = 2
+	if _, err := ps.FinishRegistration(other, token, "", resp); err != ErrPasskeyExpired {
+		t.Errorf("Expected another user not to finish the registration. Recieved %v", err)
+	}
+
+	// the challenge was used up by the failed attempt
+	if _, err := ps.FinishRegistration(user, token, "", resp); err != ErrPasskeyExpired {
+		t.Errorf("Expected ErrPasskeyExpired. Recieved %v", err)
+	}
+
+	token, opts, _ = ps.BeginRegistration(user)
+	resp, _ = a.Create(opts)
+	passkey, err := ps.FinishRegistration(user, token, "  ", resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if passkey.ID == 0 || passkey.UserID != 1 || passkey.Name != passkeyDefaultName {
+		t.Errorf("Unexpected passkey %+v", passkey)
+	}
+
+	// the passkey is excluded from the next registration
+	_, opts, _ = ps.BeginRegistration(user)
+	if len(opts.ExcludeCredentials) != 1 || string(opts.ExcludeCredentials[0].ID) != string(passkey.CredentialID) {
+		t.Errorf("Expected the passkey to be excluded. Recieved %+v", opts.ExcludeCredentials)
+	}
+
+	token, opts, _ = ps.BeginRegistration(user)
+	resp, _ = webauthntest.New("https://evil.example").Create(opts)
+	if _, err := ps.FinishRegistration(user, token, "", resp); err != ErrPasskeyInvalid {
+		t.Errorf("Expected ErrPasskeyInvalid for another origin. Recieved %v", err)
+	}
+
+	if err := ps.Remove(2, passkey.ID); err != ErrNotFound {
+		t.Errorf("Expected users not to remove passkeys of others. Recieved %v", err)
+	}
+
+	if err := ps.Remove(1, passkey.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	ps, attempts := testingPasskeyService()
+	a := webauthntest.New(ps.cfg.Origin)
+	user := &User{Email: "jon@example.com"}
+	user.ID = 1
+
+	token, opts, _ := ps.BeginRegistration(user)
+	resp, _ := a.Create(opts)
+	passkey, err := ps.FinishRegistration(user, token, "Laptop", resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := LoginClient{IP: "127.0.0.1", UserAgent: "test"}
+	token, reqOpts, err := ps.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion, err := a.Get(reqOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := ps.FinishLogin(token, assertion, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.Email != "jon@example.com" {
+		t.Errorf("Expected the owner of the passkey. Recieved %+v", found)
+	}
+
+	stored, _ := ps.ByID(passkey.ID)
+	if stored.SignCount != 1 || stored.LastUsedAt == nil {
+		t.Errorf("Expected the sign count and last use to be stored. Recieved %+v", stored)
+	}
+
+	if len(attempts.attempts) != 1 || attempts.attempts[0].Result != LoginPasskey || !attempts.attempts[0].Succeeded() {
+		t.Errorf("Expected a successful attempt to be recorded. Recieved %+v", attempts.attempts)
+	}
+
+	if _, err := ps.FinishLogin(token, assertion, client); err != ErrPasskeyExpired {
+		t.Errorf("Expected the challenge to be used up. Recieved %v", err)
+	}
+
+	// a clone that is behind the stored counter is refused
+	a.SetSignCount(passkey.CredentialID, 0)
+	token, reqOpts, _ = ps.BeginLogin()
+	assertion, _ = a.Get(reqOpts)
+	if _, err := ps.FinishLogin(token, assertion, client); err != ErrPasskeyCloned {
+		t.Errorf("Expected ErrPasskeyCloned. Recieved %v", err)
+	}
+
+	if last := attempts.attempts[len(attempts.attempts)-1]; last.Result != LoginPasskeyFailed {
+		t.Errorf("Expected the failure to be recorded. Recieved %+v", last)
+	}
+
+	// a user handle of someone else is refused
+	a.SetSignCount(passkey.CredentialID, 10)
+	token, reqOpts, _ = ps.BeginLogin()
+	assertion, _ = a.Get(reqOpts)
+	assertion.Response.UserHandle = userHandle(2)
+	if _, err := ps.FinishLogin(token, assertion, client); err != ErrPasskeyInvalid {
+		t.Errorf("Expected ErrPasskeyInvalid for another user handle. Recieved %v", err)
+	}
+
+	// passkeys that were removed can not log in
+	ps.Remove(1, passkey.ID)
+	token, reqOpts, _ = ps.BeginLogin()
+	assertion, _ = a.Get(reqOpts)
+	if _, err := ps.FinishLogin(token, assertion, client); err != ErrPasskeyInvalid {
+		t.Errorf("Expected ErrPasskeyInvalid for a removed passkey. Recieved %v", err)
+	}
+}
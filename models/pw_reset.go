@@ -0,0 +1,159 @@
+package models
+
+import (
+	"time"
+
+	"../../photofriends/hash"
+	"../../photofriends/rand"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrTokenInvalid is returned when a password reset
+	// token does not exist, was already used or expired
+	ErrTokenInvalid = modelError("This reset link is invalid or has expired")
+)
+
+// pwResetLifetime is how long a reset link can be used
+const pwResetLifetime = time.Hour
+
+// pwReset is a single use token that lets a user set a
+// new password. Only the HMAC of the token is stored
+type pwReset struct {
+	ID        uint      `gorm:"primary_key"`
+	UserID    uint      `gorm:"not_null;index"`
+	Token     string    `gorm:"-"`
+	TokenHash string    `gorm:"not_null;unique_index"`
+	ExpiresAt time.Time `gorm:"not_null"`
+	CreatedAt time.Time
+}
+
+type pwResetDB interface {
+	ByToken(token string) (*pwReset, error)
+	Create(pwr *pwReset) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+func newPwResetValidator(db pwResetDB, hmac hash.HMAC) *pwResetValidator {
+	return &pwResetValidator{
+		pwResetDB: db,
+		hmac:      hmac,
+	}
+}
+
+type pwResetValidator struct {
+	pwResetDB
+	hmac hash.HMAC
+}
+
+// ByToken expects the raw token and will hash
+// it before looking up the reset
+func (pwrv *pwResetValidator) ByToken(token string) (*pwReset, error) {
+	pwr := pwReset{Token: token}
+	if err := runPwResetValFuncs(&pwr, pwrv.hmacToken); err != nil {
+		return nil, err
+	}
+
+	return pwrv.pwResetDB.ByToken(pwr.TokenHash)
+}
+
+func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
+	err := runPwResetValFuncs(pwr,
+		pwrv.requireUserID,
+		pwrv.setTokenIfUnset,
+		pwrv.hmacToken,
+		pwrv.setExpiry)
+
+	if err != nil {
+		return err
+	}
+
+	return pwrv.pwResetDB.Create(pwr)
+}
+
+func (pwrv *pwResetValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return pwrv.pwResetDB.Delete(id)
+}
+
+func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
+	if pwr.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
+	if pwr.Token != "" {
+		return nil
+	}
+
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	pwr.Token = token
+	return nil
+}
+
+func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
+	if pwr.Token == "" {
+		return ErrTokenInvalid
+	}
+
+	pwr.TokenHash = pwrv.hmac.Hash(pwr.Token)
+	return nil
+}
+
+func (pwrv *pwResetValidator) setExpiry(pwr *pwReset) error {
+	pwr.ExpiresAt = time.Now().Add(pwResetLifetime)
+	return nil
+}
+
+type pwResetValFunc func(*pwReset) error
+
+func runPwResetValFuncs(pwr *pwReset, fns ...pwResetValFunc) error {
+	for _, fn := range fns {
+		if err := fn(pwr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensure interface is matching
+var _ pwResetDB = &pwResetGorm{}
+
+type pwResetGorm struct {
+	db *gorm.DB
+}
+
+// ByToken looks up a reset by the already hashed token
+func (pwrg *pwResetGorm) ByToken(tokenHash string) (*pwReset, error) {
+	var pwr pwReset
+	if err := first(pwrg.db.Where("token_hash = ?", tokenHash), &pwr); err != nil {
+		return nil, err
+	}
+
+	return &pwr, nil
+}
+
+func (pwrg *pwResetGorm) Create(pwr *pwReset) error {
+	return pwrg.db.Create(pwr).Error
+}
+
+func (pwrg *pwResetGorm) Delete(id uint) error {
+	pwr := pwReset{ID: id}
+	return pwrg.db.Delete(&pwr).Error
+}
+
+func (pwrg *pwResetGorm) DeleteByUserID(userID uint) error {
+	return pwrg.db.Where("user_id = ?", userID).Delete(&pwReset{}).Error
+}
@@ -1,44 +1,111 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"net/http"
+	"runtime"
+	"time"
 
-func NewServices(connectionInfo string) (*Services, error) {
-	db, err := gorm.Open("postgres", connectionInfo)
+	"../../photofriends/hash"
+	"../../photofriends/oidc"
+	"../../photofriends/ratelimit"
+	"../../photofriends/storage"
+	"../../photofriends/thumbnail"
+	"../../photofriends/webauthn"
+	"github.com/jinzhu/gorm"
+)
+
+// thumbnailQueueSize is how many uploaded images can wait
+// for their derived sizes before uploads start to block
+const thumbnailQueueSize = 256
+
+// oidcTimeout limits requests to identity providers,
+// which are made while the user waits
+const oidcTimeout = 10 * time.Second
+
+// ServicesConfig holds what the services need to
+// connect to the database and protect user secrets
+type ServicesConfig struct {
+	// ConnectionInfo is the postgres connection string
+	ConnectionInfo string
+
+	// LogSQL prints every query gorm runs
+	LogSQL bool
+
+	// Pepper is appended to passwords before they are hashed
+	Pepper string
+
+	// HMACKey is used to hash remember, session, API and email tokens
+	HMACKey string
+
+	// RateLimit picks where failed logins are counted
+	RateLimit ratelimit.Config
+
+	// WebAuthn describes the site passkeys are created for
+	WebAuthn webauthn.Config
+
+	// OIDC lists the providers users can sign in with
+	OIDC []oidc.Config
+}
+
+// NewServices opens the database connection and sets up
+// every service. Uploaded image files are kept in store
+func NewServices(cfg ServicesConfig, store storage.Storage) (*Services, error) {
+	db, err := gorm.Open("postgres", cfg.ConnectionInfo)
 	if err != nil {
 		return nil, err
 	}
 
-	db.LogMode(true)
+	db.LogMode(cfg.LogSQL)
+	limits, err := ratelimit.NewStore(cfg.RateLimit, db.DB())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	pool := thumbnail.NewPool(runtime.NumCPU(), thumbnailQueueSize)
+	fs := NewFriendService(db)
+	ss := NewSessionService(db, hash.NewHMAC(cfg.HMACKey))
+	us := NewUserService(db, ss, ratelimit.New(limits), cfg.Pepper, cfg.HMACKey)
+
+	is := NewImageService(db, store, pool)
+	client := &http.Client{Timeout: oidcTimeout}
+	providers := make([]*oidc.Provider, len(cfg.OIDC))
+	for i, pc := range cfg.OIDC {
+		providers[i] = oidc.NewProvider(pc, client)
+	}
+
 	return &Services{
-		User:    NewUserService(db),
-		Gallery: NewGalleryService(db),
-		db:      db,
+		User:     us,
+		Session:  ss,
+		APIToken: NewAPITokenService(db, hash.NewHMAC(cfg.HMACKey)),
+		Passkey:  NewPasskeyService(db, us, cfg.WebAuthn, hash.NewHMAC(cfg.HMACKey)),
+		Identity: NewIdentityService(db, us, providers, hash.NewHMAC(cfg.HMACKey)),
+		Gallery:  NewGalleryService(db, fs),
+		Friend:   fs,
+		Image:    is,
+		Upload:   NewUploadService(db, store, is),
+		db:       db,
+		pool:     pool,
 	}, nil
 }
 
 type Services struct {
-	Gallery GalleryService
-	User    UserService
-	db      *gorm.DB
+	APIToken APITokenService
+	Gallery  GalleryService
+	Friend   FriendService
+	Identity IdentityService
+	Image    ImageService
+	Passkey  PasskeyService
+	Session  SessionService
+	Upload   UploadService
+	User     UserService
+	db       *gorm.DB
+	pool     *thumbnail.Pool
 }
 
-// Close closes the  database connection
+// Close waits for queued thumbnails to finish
+// and then closes the database connection
 func (s *Services) Close() error {
+	s.pool.Close()
 	return s.db.Close()
 }
-
-// DestructiveReset drops all tables and rebuilds it
-func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Gallery{}).Error
-	if err != nil {
-		return err
-	}
-
-	return s.AutoMigrate()
-}
-
-// AutoMigrate will attempt to automatically migrate all tables
-func (s *Services) AutoMigrate() error {
-	err := s.db.AutoMigrate(&User{}, &Gallery{}).Error
-	return err
-}
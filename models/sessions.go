@@ -0,0 +1,356 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"../../photofriends/hash"
+	"../../photofriends/rand"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrSessionExpired is returned when looking up
+	// a session that is past its expiry time
+	ErrSessionExpired = modelError("Session has expired")
+
+	// ErrTokenRequired is returned when a session is
+	// created or looked up without a token
+	ErrTokenRequired = errors.New("Session token is required")
+)
+
+const (
+	// SessionLifetime is how long a session stays valid
+	// after logging in on a device
+	SessionLifetime = 30 * 24 * time.Hour
+
+	// PendingSessionLifetime is how long users have to enter
+	// their second factor after entering their password
+	PendingSessionLifetime = 10 * time.Minute
+
+	// sessionTouchInterval limits how often LastSeenAt is
+	// written, so not every request results in an update
+	sessionTouchInterval = time.Minute
+)
+
+// Session is a single logged in device. The raw token only
+// lives in the cookie on that device, we store its HMAC
+type Session struct {
+	gorm.Model
+	UserID     uint   `gorm:"not_null;index"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not_null;unique_index"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"not_null"`
+
+	// Pending sessions belong to users who entered their
+	// password, but not yet their second factor. They do
+	// not log the user in, and only reach the challenge
+	Pending bool `gorm:"not null;default:false"`
+}
+
+// SessionService is used to log users in and out
+// on individual devices
+type SessionService interface {
+	// Start creates a new session for the user. The raw
+	// token for the cookie is set on the returned session
+	Start(user *User, userAgent, ip string) (*Session, error)
+
+	// StartPending creates a short lived pending session for
+	// a user who still has to pass their second factor
+	StartPending(user *User, userAgent, ip string) (*Session, error)
+
+	// ByToken looks up the session for a raw cookie token.
+	// Expired sessions return ErrSessionExpired
+	ByToken(token string) (*Session, error)
+
+	// Touch records that the session was just used
+	Touch(session *Session) error
+
+	// Revoke logs out a single session of the user
+	Revoke(userID, sessionID uint) error
+
+	// RevokeAll logs the user out on every device
+	RevokeAll(userID uint) error
+
+	SessionDB
+}
+
+// SessionDB is used to interact with the sessions database
+type SessionDB interface {
+	ByID(id uint) (*Session, error)
+	ByTokenHash(tokenHash string) (*Session, error)
+	ByUserID(userID uint) ([]Session, error)
+	Create(session *Session) error
+	Update(session *Session) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+func NewSessionService(db *gorm.DB, hmac hash.HMAC) SessionService {
+	return &sessionService{
+		SessionDB: &sessionValidator{
+			SessionDB: &sessionGorm{db},
+			hmac:      hmac,
+		},
+	}
+}
+
+// ensure interface is matching
+var _ SessionService = &sessionService{}
+
+type sessionService struct {
+	SessionDB
+}
+
+func (ss *sessionService) Start(user *User, userAgent, ip string) (*Session, error) {
+	return ss.start(user, userAgent, ip, false)
+}
+
+func (ss *sessionService) StartPending(user *User, userAgent, ip string) (*Session, error) {
+	return ss.start(user, userAgent, ip, true)
+}
+
+func (ss *sessionService) start(user *User, userAgent, ip string, pending bool) (*Session, error) {
+	now := time.Now()
+	session := Session{
+		UserID:     user.ID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionLifetime),
+		Pending:    pending,
+	}
+
+	if pending {
+		session.ExpiresAt = now.Add(PendingSessionLifetime)
+	}
+
+	if err := ss.Create(&session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (ss *sessionService) ByToken(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrTokenRequired
+	}
+
+	session, err := ss.ByTokenHash(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		ss.Delete(session.ID)
+		return nil, ErrSessionExpired
+	}
+
+	return session, nil
+}
+
+func (ss *sessionService) Touch(session *Session) error {
+	if time.Since(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+
+	session.LastSeenAt = time.Now()
+	return ss.Update(session)
+}
+
+func (ss *sessionService) Revoke(userID, sessionID uint) error {
+	session, err := ss.ByID(sessionID)
+	if err != nil {
+		return err
+	}
+
+	if session.UserID != userID {
+		return ErrNotFound
+	}
+
+	return ss.Delete(session.ID)
+}
+
+func (ss *sessionService) RevokeAll(userID uint) error {
+	return ss.DeleteByUserID(userID)
+}
+
+/******************* VALIDATORS **************************/
+
+type sessionValidator struct {
+	SessionDB
+	hmac hash.HMAC
+}
+
+// ByTokenHash expects the raw token and will
+// hash it before looking up the session
+func (sv *sessionValidator) ByTokenHash(token string) (*Session, error) {
+	session := Session{Token: token}
+	if err := runSessionValFuncs(&session, sv.hmacToken); err != nil {
+		return nil, err
+	}
+
+	return sv.SessionDB.ByTokenHash(session.TokenHash)
+}
+
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValFuncs(session,
+		sv.userIDRequired,
+		sv.setTokenIfUnset,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired)
+
+	if err != nil {
+		return err
+	}
+
+	return sv.SessionDB.Create(session)
+}
+
+func (sv *sessionValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return sv.SessionDB.Delete(id)
+}
+
+func (sv *sessionValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrIDInvalid
+	}
+
+	return sv.SessionDB.DeleteByUserID(userID)
+}
+
+func (sv *sessionValidator) userIDRequired(s *Session) error {
+	if s.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (sv *sessionValidator) setTokenIfUnset(s *Session) error {
+	if s.Token != "" {
+		return nil
+	}
+
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+
+	s.Token = token
+	return nil
+}
+
+func (sv *sessionValidator) tokenMinBytes(s *Session) error {
+	n, err := rand.NBytes(s.Token)
+	if err != nil {
+		return err
+	}
+
+	if n < rand.RememberTokenBytes {
+		return ErrRememberTooShort
+	}
+
+	return nil
+}
+
+func (sv *sessionValidator) hmacToken(s *Session) error {
+	if s.Token == "" {
+		return ErrTokenRequired
+	}
+
+	s.TokenHash = sv.hmac.Hash(s.Token)
+	return nil
+}
+
+func (sv *sessionValidator) tokenHashRequired(s *Session) error {
+	if s.TokenHash == "" {
+		return ErrTokenRequired
+	}
+
+	return nil
+}
+
+type sessionValFunc func(*Session) error
+
+func runSessionValFuncs(session *Session, fns ...sessionValFunc) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/************************************************************/
+
+// ensure interface is matching
+var _ SessionDB = &sessionGorm{}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+func (sg *sessionGorm) ByID(id uint) (*Session, error) {
+	var session Session
+	if err := first(sg.db.Where("id = ?", id), &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// ByTokenHash looks up a session by the
+// already hashed token
+func (sg *sessionGorm) ByTokenHash(tokenHash string) (*Session, error) {
+	var session Session
+	if err := first(sg.db.Where("token_hash = ?", tokenHash), &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// ByUserID returns the sessions of a user, most recently used first
+func (sg *sessionGorm) ByUserID(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.
+		Where("user_id = ?", userID).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) Update(session *Session) error {
+	return sg.db.Save(session).Error
+}
+
+// Delete removes the session for good, revoked
+// sessions have no reason to stick around
+func (sg *sessionGorm) Delete(id uint) error {
+	session := Session{Model: gorm.Model{ID: id}}
+	return sg.db.Unscoped().Delete(&session).Error
+}
+
+func (sg *sessionGorm) DeleteByUserID(userID uint) error {
+	return sg.db.Unscoped().Where("user_id = ?", userID).Delete(&Session{}).Error
+}
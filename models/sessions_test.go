@@ -0,0 +1,167 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"../../photofriends/hash"
+)
+
+// memSessionDB is an in-memory SessionDB
+type memSessionDB struct {
+	nextID   uint
+	sessions map[uint]Session
+}
+
+func (m *memSessionDB) ByID(id uint) (*Session, error) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &s, nil
+}
+
+func (m *memSessionDB) ByTokenHash(tokenHash string) (*Session, error) {
+	for _, s := range m.sessions {
+		if s.TokenHash == tokenHash {
+			return &s, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memSessionDB) ByUserID(userID uint) ([]Session, error) {
+	var all []Session
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			all = append(all, s)
+		}
+	}
+
+	return all, nil
+}
+
+func (m *memSessionDB) Create(s *Session) error {
+	m.nextID++
+	s.ID = m.nextID
+	return m.Update(s)
+}
+
+func (m *memSessionDB) Update(s *Session) error {
+	stored := *s
+	stored.Token = ""
+	m.sessions[s.ID] = stored
+	return nil
+}
+
+func (m *memSessionDB) Delete(id uint) error {
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memSessionDB) DeleteByUserID(userID uint) error {
+	for id, s := range m.sessions {
+		if s.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+func testingSessionService() (SessionService, *memSessionDB) {
+	db := &memSessionDB{sessions: make(map[uint]Session)}
+	return &sessionService{
+		SessionDB: &sessionValidator{SessionDB: db, hmac: hash.NewHMAC("test-key")},
+	}, db
+}
+
+func TestSessionStartAndLookup(t *testing.T) {
+	ss, db := testingSessionService()
+	user := &User{}
+	user.ID = 1
+
+	first, err := ss.Start(user, "Firefox", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := ss.Start(user, "Safari", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Token == second.Token {
+		t.Error("Expected every device to get its own token")
+	}
+
+	if stored := db.sessions[first.ID]; stored.TokenHash == first.Token || stored.TokenHash == "" {
+		t.Errorf("Expected the token to be stored hashed. Recieved %q", stored.TokenHash)
+	}
+
+	found, err := ss.ByToken(second.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found.ID != second.ID || found.UserAgent != "Safari" {
+		t.Errorf("Expected session %d. Recieved %d", second.ID, found.ID)
+	}
+
+	if err := ss.Revoke(2, first.ID); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound revoking another users session. Recieved %v", err)
+	}
+
+	if err := ss.Revoke(1, first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ss.ByToken(first.Token); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a revoked session. Recieved %v", err)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	ss, db := testingSessionService()
+	user := &User{}
+	user.ID = 1
+
+	session, err := ss.Start(user, "Firefox", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := db.sessions[session.ID]
+	stored.ExpiresAt = time.Now().Add(-time.Minute)
+	db.sessions[session.ID] = stored
+
+	if _, err := ss.ByToken(session.Token); err != ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired. Recieved %v", err)
+	}
+}
+
+func TestSessionStartPending(t *testing.T) {
+	ss, _ := testingSessionService()
+	user := &User{}
+	user.ID = 1
+
+	session, err := ss.StartPending(user, "Firefox", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := ss.ByToken(session.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !found.Pending {
+		t.Error("Expected the session to be pending")
+	}
+
+	if time.Until(found.ExpiresAt) > PendingSessionLifetime {
+		t.Errorf("Expected a pending session to expire within %s. Recieved %s", PendingSessionLifetime, found.ExpiresAt)
+	}
+}
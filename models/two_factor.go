@@ -0,0 +1,248 @@
+package models
+
+import (
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"../../photofriends/hash"
+	"../../photofriends/rand"
+	"../../photofriends/ratelimit"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrTOTPCodeInvalid is returned when a two factor code
+	// or recovery code is wrong, or was already used
+	ErrTOTPCodeInvalid = modelError("The code is not valid. Check the clock of your device is right")
+
+	// ErrTOTPEnabled is returned when setting up two
+	// factor auth for a user who already has it on
+	ErrTOTPEnabled = modelError("Two factor authentication is already enabled")
+
+	// ErrTOTPNotEnabled is returned when checking or turning
+	// off two factor auth for a user who does not have it on
+	ErrTOTPNotEnabled = modelError("Two factor authentication is not enabled")
+)
+
+const (
+	// recoveryCodeCount is how many recovery codes users get
+	recoveryCodeCount = 10
+
+	// recoveryCodeBytes is the entropy of every recovery code,
+	// which encodes to 16 characters of base32
+	recoveryCodeBytes = 10
+
+	// totpSkew is how many 30 second steps the clock of the
+	// user's device may be off, either way
+	totpSkew = 1
+)
+
+// twoFactorPolicy limits guessing codes once the
+// password was found, per user
+var twoFactorPolicy = ratelimit.Policy{
+	Window:      15 * time.Minute,
+	MaxFailures: 5,
+	Lockout:     time.Minute,
+	MaxLockout:  time.Hour,
+}
+
+func twoFactorKey(userID uint) string {
+	return fmt.Sprintf("2fa:user:%d", userID)
+}
+
+// recoveryEncoding writes recovery codes in lower case
+// base32, which avoids look alike characters like 0 and O
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// recoveryCode is a single use code that replaces the
+// authenticator app, for when the device was lost. Only
+// the HMAC of the code is stored
+type recoveryCode struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not_null;index"`
+	Code      string `gorm:"-"`
+	CodeHash  string `gorm:"not_null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type recoveryCodeDB interface {
+	// ByCode looks up an unused code of the user
+	ByCode(userID uint, code string) (*recoveryCode, error)
+	CountUnused(userID uint) (int, error)
+	Create(rc *recoveryCode) error
+
+	// Use marks the code as used. It returns ErrNotFound
+	// if the code was used in the meantime
+	Use(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+func newRecoveryCodeValidator(db recoveryCodeDB, hmac hash.HMAC) *recoveryCodeValidator {
+	return &recoveryCodeValidator{
+		recoveryCodeDB: db,
+		hmac:           hmac,
+	}
+}
+
+type recoveryCodeValidator struct {
+	recoveryCodeDB
+	hmac hash.HMAC
+}
+
+// ByCode expects the code as typed by the user,
+// and will normalize and hash it before the lookup
+func (rcv *recoveryCodeValidator) ByCode(userID uint, code string) (*recoveryCode, error) {
+	rc := recoveryCode{UserID: userID, Code: code}
+	err := runRecoveryCodeValFuncs(&rc,
+		rcv.requireUserID,
+		rcv.normalizeCode,
+		rcv.hmacCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return rcv.recoveryCodeDB.ByCode(rc.UserID, rc.CodeHash)
+}
+
+func (rcv *recoveryCodeValidator) Create(rc *recoveryCode) error {
+	err := runRecoveryCodeValFuncs(rc,
+		rcv.requireUserID,
+		rcv.setCodeIfUnset,
+		rcv.hmacCode)
+
+	if err != nil {
+		return err
+	}
+
+	return rcv.recoveryCodeDB.Create(rc)
+}
+
+func (rcv *recoveryCodeValidator) Use(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return rcv.recoveryCodeDB.Use(id)
+}
+
+func (rcv *recoveryCodeValidator) requireUserID(rc *recoveryCode) error {
+	if rc.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+// setCodeIfUnset creates a code formatted in groups of four
+// characters, eg: "abcd-efgh-ijkl-mnop"
+func (rcv *recoveryCodeValidator) setCodeIfUnset(rc *recoveryCode) error {
+	if rc.Code != "" {
+		return nil
+	}
+
+	b, err := rand.Bytes(recoveryCodeBytes)
+	if err != nil {
+		return err
+	}
+
+	raw := recoveryEncoding.EncodeToString(b)
+	groups := make([]string, 0, len(raw)/4)
+	for i := 0; i < len(raw); i += 4 {
+		groups = append(groups, raw[i:i+4])
+	}
+
+	rc.Code = strings.Join(groups, "-")
+	return nil
+}
+
+// normalizeCode drops what people add or change when typing
+// a code, so "ABCD EFGH-ijkl mnop" matches "abcd-efgh-ijkl-mnop"
+func (rcv *recoveryCodeValidator) normalizeCode(rc *recoveryCode) error {
+	rc.Code = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(rc.Code))
+
+	if rc.Code == "" {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// hmacCode hashes the code without the dashes
+func (rcv *recoveryCodeValidator) hmacCode(rc *recoveryCode) error {
+	code := strings.Replace(rc.Code, "-", "", -1)
+	if code == "" {
+		return ErrNotFound
+	}
+
+	rc.CodeHash = rcv.hmac.Hash(code)
+	return nil
+}
+
+type recoveryCodeValFunc func(*recoveryCode) error
+
+func runRecoveryCodeValFuncs(rc *recoveryCode, fns ...recoveryCodeValFunc) error {
+	for _, fn := range fns {
+		if err := fn(rc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensure interface is matching
+var _ recoveryCodeDB = &recoveryCodeGorm{}
+
+type recoveryCodeGorm struct {
+	db *gorm.DB
+}
+
+// ByCode looks up an unused code by the already hashed code
+func (rcg *recoveryCodeGorm) ByCode(userID uint, codeHash string) (*recoveryCode, error) {
+	var rc recoveryCode
+	db := rcg.db.Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash)
+	if err := first(db, &rc); err != nil {
+		return nil, err
+	}
+
+	return &rc, nil
+}
+
+func (rcg *recoveryCodeGorm) CountUnused(userID uint) (int, error) {
+	var count int
+	err := rcg.db.Model(&recoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	return count, err
+}
+
+func (rcg *recoveryCodeGorm) Create(rc *recoveryCode) error {
+	return rcg.db.Create(rc).Error
+}
+
+func (rcg *recoveryCodeGorm) Use(id uint) error {
+	db := rcg.db.Model(&recoveryCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if db.RowsAffected != 1 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (rcg *recoveryCodeGorm) DeleteByUserID(userID uint) error {
+	return rcg.db.Where("user_id = ?", userID).Delete(&recoveryCode{}).Error
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"../../photofriends/hash"
+)
+
+// memRecoveryCodeDB is an in-memory recoveryCodeDB
+type memRecoveryCodeDB struct {
+	codes []recoveryCode
+}
+
+func (m *memRecoveryCodeDB) ByCode(userID uint, codeHash string) (*recoveryCode, error) {
+	for _, rc := range m.codes {
+		if rc.UserID == userID && rc.CodeHash == codeHash && rc.UsedAt == nil {
+			return &rc, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *memRecoveryCodeDB) CountUnused(userID uint) (int, error) {
+	count := 0
+	for _, rc := range m.codes {
+		if rc.UserID == userID && rc.UsedAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *memRecoveryCodeDB) Create(rc *recoveryCode) error {
+	rc.ID = uint(len(m.codes) + 1)
+	m.codes = append(m.codes, *rc)
+	return nil
+}
+
+func (m *memRecoveryCodeDB) Use(id uint) error {
+	for i := range m.codes {
+		if m.codes[i].ID == id && m.codes[i].UsedAt == nil {
+			now := time.Now()
+			m.codes[i].UsedAt = &now
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (m *memRecoveryCodeDB) DeleteByUserID(userID uint) error {
+	m.codes = nil
+	return nil
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	db := &memRecoveryCodeDB{}
+	rcv := newRecoveryCodeValidator(db, hash.NewHMAC("test-key"))
+
+	rc := recoveryCode{UserID: 1}
+	if err := rcv.Create(&rc); err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`^[a-z2-7]{4}(-[a-z2-7]{4}){3}$`).MatchString(rc.Code) {
+		t.Errorf("Expected a code like abcd-efgh-ijkl-mnop. Recieved %q", rc.Code)
+	}
+
+	if db.codes[0].CodeHash == "" || db.codes[0].CodeHash == rc.Code {
+		t.Errorf("Expected the code to be stored hashed. Recieved %q", db.codes[0].CodeHash)
+	}
+
+	plain := strings.Replace(rc.Code, "-", "", -1)
+	typed := []string{rc.Code, plain, " " + plain[:8] + " " + plain[8:], strings.ToUpper(rc.Code)}
+	for _, code := range typed {
+		if _, err := rcv.ByCode(1, code); err != nil {
+			t.Errorf("Expected %q to match %q. Recieved %v", code, rc.Code, err)
+		}
+	}
+
+	if _, err := rcv.ByCode(2, rc.Code); err != ErrNotFound {
+		t.Errorf("Expected codes of other users not to match. Recieved %v", err)
+	}
+
+	if _, err := rcv.ByCode(1, ""); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an empty code. Recieved %v", err)
+	}
+
+	if err := rcv.Use(db.codes[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rcv.ByCode(1, rc.Code); err != ErrNotFound {
+		t.Errorf("Expected a used code not to match. Recieved %v", err)
+	}
+}
@@ -0,0 +1,604 @@
+package models
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"../../photofriends/rand"
+	"../../photofriends/storage"
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// ErrUploadLengthInvalid is returned when an upload is
+	// created that is empty or larger than MaxUploadLength
+	ErrUploadLengthInvalid = modelError(fmt.Sprintf("Uploads must be between 1 byte and %d MB", MaxUploadLength>>20))
+
+	// ErrUploadOffsetMismatch is returned when a chunk does not
+	// start where the bytes received so far end. The client
+	// has to look up the offset again and resume from there
+	ErrUploadOffsetMismatch = modelError("The chunk does not start at the offset of the upload")
+
+	// ErrUploadTooLarge is returned when a chunk would
+	// go past the length the upload was created with
+	ErrUploadTooLarge = modelError("The chunk goes past the end of the upload")
+
+	// ErrChunkTooLarge is returned when a single chunk is
+	// larger than MaxChunkLength. The rest of the upload
+	// can still be sent in smaller chunks
+	ErrChunkTooLarge = modelError(fmt.Sprintf("Chunks must be at most %d MB", MaxChunkLength>>20))
+
+	// ErrUploadExpired is returned when an upload is
+	// looked up after it expired, see UploadLifetime
+	ErrUploadExpired = modelError("The upload has expired")
+
+	// errUploadFinished is returned by UploadDB.Finish when
+	// another request already recorded the image of the upload
+	errUploadFinished = errors.New("models: upload is already finished")
+)
+
+const (
+	// MaxUploadLength is the size of the largest image
+	// that can be uploaded in chunks
+	MaxUploadLength = 512 << 20 // 512 megabytes
+
+	// MaxChunkLength is the size of the largest chunk
+	// accepted at once, so a large upload has to be
+	// sent in a few requests
+	MaxChunkLength = 32 << 20 // 32 megabytes
+
+	// UploadLifetime is how long an upload is kept after it
+	// was created or last received a chunk. Partial uploads
+	// are deleted after that, see UploadService.DeleteExpired
+	UploadLifetime = 24 * time.Hour
+
+	// uploadSniffLength is how much of the first chunk
+	// is looked at to detect the content type
+	uploadSniffLength = 512
+
+	// partTokenBytes is the size of the random token in the
+	// name of every part, so chunks sent at the same offset
+	// at the same time never overwrite each other
+	partTokenBytes = 6
+)
+
+// Upload is an image that is uploaded in chunks, see package
+// api. The chunks are kept in the storage as parts of their
+// own until the last one arrives, when they are joined
+// into an Image of the gallery
+type Upload struct {
+	ID            uint   `gorm:"primary_key"`
+	UserID        uint   `gorm:"not_null;index"`
+	GalleryID     uint   `gorm:"not_null"`
+	Filename      string `gorm:"not_null"`
+	StripMetadata bool
+
+	// ContentType is detected from the first chunk
+	ContentType string
+
+	// Length is the size of the whole image, and Offset
+	// the number of bytes received so far
+	Length int64 `gorm:"not_null"`
+	Offset int64 `gorm:"not_null"`
+
+	// Parts are the names of the stored chunks, separated
+	// by spaces. Each is the offset the chunk starts at and
+	// a random token, see Upload.PartKeys
+	Parts string `gorm:"type:text"`
+
+	// ImageID is set once the upload is complete
+	ImageID   uint
+	ExpiresAt time.Time `gorm:"not_null;index"`
+	CreatedAt time.Time
+}
+
+// Complete reports whether every byte of the image arrived
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Expired reports whether the upload is past its expiry time
+func (u *Upload) Expired() bool {
+	return time.Now().After(u.ExpiresAt)
+}
+
+// PartKeys are the storage keys of the stored chunks, in order
+func (u *Upload) PartKeys() []string {
+	names := strings.Fields(u.Parts)
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = fmt.Sprintf("uploads/%d/%s", u.ID, name)
+	}
+
+	return keys
+}
+
+// UploadService is used to receive images in chunks
+// and add them to their gallery once complete
+type UploadService interface {
+	// ByID looks up an upload. Expired uploads
+	// that are not deleted yet return ErrUploadExpired
+	ByID(id uint) (*Upload, error)
+
+	// Write stores the chunk read from r, which has to start
+	// at offset. Once the last chunk is written the image
+	// is created with the ImageService, and ImageID set.
+	// Of chunks written at the same offset at the same time
+	// only one is kept, the others get ErrUploadOffsetMismatch
+	Write(upload *Upload, offset int64, r io.Reader) error
+
+	// Terminate deletes an upload and the chunks received
+	Terminate(upload *Upload) error
+
+	// DeleteExpired terminates every upload past its expiry
+	// time, complete or not. An upload that fails to be
+	// terminated is logged and left for the next run, and
+	// the others are still terminated
+	DeleteExpired() error
+
+	UploadDB
+}
+
+// UploadDB is used to interact with the uploads database
+type UploadDB interface {
+	ByID(id uint) (*Upload, error)
+
+	// ExpiredBefore returns the uploads that expired before t
+	ExpiredBefore(t time.Time) ([]Upload, error)
+	Create(upload *Upload) error
+	Update(upload *Upload) error
+
+	// Advance saves the progress of upload, only while the
+	// stored offset is still from and it has no image yet.
+	// Otherwise another request got there first, and
+	// ErrUploadOffsetMismatch is returned
+	Advance(upload *Upload, from int64) error
+
+	// Finish records the ImageID of upload and clears its
+	// parts, only when no image was recorded yet. Otherwise
+	// errUploadFinished is returned
+	Finish(upload *Upload) error
+	Delete(id uint) error
+}
+
+// NewUploadService creates an UploadService keeping the
+// chunks in store, and adding the images through is
+func NewUploadService(db *gorm.DB, store storage.Storage, is ImageService) UploadService {
+	return &uploadService{
+		UploadDB: &uploadValidator{&uploadGorm{db}},
+		store:    store,
+		is:       is,
+	}
+}
+
+// ensure interface is matching
+var _ UploadService = &uploadService{}
+
+type uploadService struct {
+	UploadDB
+	store storage.Storage
+	is    ImageService
+}
+
+func (us *uploadService) ByID(id uint) (*Upload, error) {
+	upload, err := us.UploadDB.ByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload.Expired() {
+		return nil, ErrUploadExpired
+	}
+
+	return upload, nil
+}
+
+func (us *uploadService) Write(upload *Upload, offset int64, r io.Reader) error {
+	if offset != upload.Offset {
+		return ErrUploadOffsetMismatch
+	}
+
+	// the type is detected early, so uploads of other files
+	// are refused before all of them has been sent
+	if offset == 0 {
+		br := bufio.NewReaderSize(r, uploadSniffLength)
+		head, err := br.Peek(uploadSniffLength)
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if len(head) > 0 {
+			upload.ContentType = http.DetectContentType(head)
+			if !uploadTypeAllowed(upload.ContentType) {
+				return ErrImageTypeInvalid
+			}
+		}
+		r = br
+	}
+
+	token, err := rand.String(partTokenBytes)
+	if err != nil {
+		return err
+	}
+
+	// one byte more than fits is read, to tell whether
+	// the chunk goes past the end of the upload or is
+	// larger than a chunk may be
+	max, tooLarge := upload.Length-upload.Offset, ErrUploadTooLarge
+	if max > MaxChunkLength {
+		max, tooLarge = MaxChunkLength, ErrChunkTooLarge
+	}
+	limited := &io.LimitedReader{R: r, N: max + 1}
+	counted := &countingReader{r: limited}
+	name := strconv.FormatInt(offset, 10) + "-" + token
+	key := fmt.Sprintf("uploads/%d/%s", upload.ID, name)
+	if err := us.store.Put(key, counted, "application/octet-stream"); err != nil {
+		us.store.Delete(key)
+		return err
+	}
+
+	if limited.N == 0 {
+		us.store.Delete(key)
+		return tooLarge
+	}
+
+	next := *upload
+	if counted.n > 0 {
+		next.Parts = strings.TrimSpace(next.Parts + " " + name)
+		next.Offset += counted.n
+	} else {
+		us.store.Delete(key)
+	}
+
+	// the chunk only counts when no other one was written at
+	// the same offset in the meantime, which is checked with
+	// the stored offset rather than the one read earlier
+	next.ExpiresAt = time.Now().Add(UploadLifetime)
+	if err := us.Advance(&next, offset); err != nil {
+		if counted.n > 0 {
+			us.store.Delete(key)
+			return err
+		}
+
+		// an empty chunk is a retry of the last one, which
+		// is done when another retry finished the upload
+		if err == ErrUploadOffsetMismatch && us.finishedElsewhere(upload) {
+			return nil
+		}
+		return err
+	}
+	*upload = next
+
+	// finishing again is how a failed last chunk is
+	// retried, with an empty chunk at the end
+	if upload.Complete() && upload.ImageID == 0 {
+		return us.finish(upload)
+	}
+
+	return nil
+}
+
+// finish joins the chunks of a complete upload into an
+// image of its gallery, and deletes them
+func (us *uploadService) finish(upload *Upload) error {
+	image := Image{
+		GalleryID:     upload.GalleryID,
+		Filename:      upload.Filename,
+		ContentType:   upload.ContentType,
+		Size:          upload.Length,
+		StripMetadata: upload.StripMetadata,
+	}
+
+	parts := &partsReader{store: us.store, keys: upload.PartKeys()}
+	err := us.is.Create(&image, parts)
+	parts.Close()
+	if err != nil {
+		// the parts are gone when another request
+		// finished the upload at the same time
+		if us.finishedElsewhere(upload) {
+			return nil
+		}
+		return err
+	}
+
+	finished := *upload
+	finished.ImageID = image.ID
+	finished.Parts = ""
+	err = us.Finish(&finished)
+	if err == errUploadFinished {
+		// the image of the other request is kept
+		if err := us.is.Delete(&image); err != nil {
+			return err
+		}
+
+		us.finishedElsewhere(upload)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	us.deleteParts(upload)
+	*upload = finished
+	return nil
+}
+
+// finishedElsewhere reports whether the stored upload already
+// has an image, and if so updates upload with it
+func (us *uploadService) finishedElsewhere(upload *Upload) bool {
+	stored, err := us.UploadDB.ByID(upload.ID)
+	if err != nil || stored.ImageID == 0 {
+		return false
+	}
+
+	*upload = *stored
+	return true
+}
+
+func (us *uploadService) Terminate(upload *Upload) error {
+	if err := us.deleteParts(upload); err != nil {
+		return err
+	}
+
+	return us.Delete(upload.ID)
+}
+
+func (us *uploadService) DeleteExpired() error {
+	uploads, err := us.ExpiredBefore(time.Now())
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for i := range uploads {
+		if err := us.Terminate(&uploads[i]); err != nil {
+			log.Printf("uploads: deleting upload %d: %v", uploads[i].ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("models: %d of %d expired uploads could not be deleted", failed, len(uploads))
+	}
+
+	return nil
+}
+
+func (us *uploadService) deleteParts(upload *Upload) error {
+	for _, key := range upload.PartKeys() {
+		if err := us.store.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uploadTypeAllowed reports whether contentType
+// is one of the ImageContentTypes
+func uploadTypeAllowed(contentType string) bool {
+	for _, ct := range ImageContentTypes {
+		if contentType == ct {
+			return true
+		}
+	}
+
+	return false
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// partsReader reads the objects stored under keys one
+// after the other, opening each only once it is reached
+type partsReader struct {
+	store storage.Storage
+	keys  []string
+	cur   io.ReadCloser
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.cur == nil {
+			if len(pr.keys) == 0 {
+				return 0, io.EOF
+			}
+
+			rc, err := pr.store.Get(pr.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			pr.cur, pr.keys = rc, pr.keys[1:]
+		}
+
+		n, err := pr.cur.Read(p)
+		if err == io.EOF {
+			pr.cur.Close()
+			pr.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (pr *partsReader) Close() error {
+	if pr.cur == nil {
+		return nil
+	}
+
+	return pr.cur.Close()
+}
+
+/******************* VALIDATORS **************************/
+
+type uploadValidator struct {
+	UploadDB
+}
+
+func (uv *uploadValidator) Create(upload *Upload) error {
+	err := runUploadValFuncs(upload,
+		uv.userIDRequired,
+		uv.galleryIDRequired,
+		uv.filenameRequired,
+		uv.lengthInRange,
+		uv.setExpiry)
+
+	if err != nil {
+		return err
+	}
+
+	return uv.UploadDB.Create(upload)
+}
+
+func (uv *uploadValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+
+	return uv.UploadDB.Delete(id)
+}
+
+func (uv *uploadValidator) userIDRequired(u *Upload) error {
+	if u.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+
+	return nil
+}
+
+func (uv *uploadValidator) galleryIDRequired(u *Upload) error {
+	if u.GalleryID <= 0 {
+		return ErrGalleryIDRequired
+	}
+
+	return nil
+}
+
+func (uv *uploadValidator) filenameRequired(u *Upload) error {
+	u.Filename = strings.TrimSpace(u.Filename)
+	if u.Filename == "" {
+		return ErrFilenameRequired
+	}
+
+	return nil
+}
+
+func (uv *uploadValidator) lengthInRange(u *Upload) error {
+	if u.Length <= 0 || u.Length > MaxUploadLength {
+		return ErrUploadLengthInvalid
+	}
+
+	return nil
+}
+
+func (uv *uploadValidator) setExpiry(u *Upload) error {
+	u.ExpiresAt = time.Now().Add(UploadLifetime)
+	return nil
+}
+
+type uploadValFunc func(*Upload) error
+
+func runUploadValFuncs(upload *Upload, fns ...uploadValFunc) error {
+	for _, fn := range fns {
+		if err := fn(upload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/************************************************************/
+
+// ensure interface is matching
+var _ UploadDB = &uploadGorm{}
+
+type uploadGorm struct {
+	db *gorm.DB
+}
+
+func (ug *uploadGorm) ByID(id uint) (*Upload, error) {
+	var upload Upload
+	if err := first(ug.db.Where("id = ?", id), &upload); err != nil {
+		return nil, err
+	}
+
+	return &upload, nil
+}
+
+func (ug *uploadGorm) ExpiredBefore(t time.Time) ([]Upload, error) {
+	var uploads []Upload
+	err := ug.db.Where("expires_at < ?", t).Find(&uploads).Error
+	return uploads, err
+}
+
+func (ug *uploadGorm) Create(upload *Upload) error {
+	return ug.db.Create(upload).Error
+}
+
+func (ug *uploadGorm) Update(upload *Upload) error {
+	return ug.db.Save(upload).Error
+}
+
+func (ug *uploadGorm) Advance(upload *Upload, from int64) error {
+	db := ug.db.Model(&Upload{}).
+		Where(`id = ? AND "offset" = ? AND (image_id IS NULL OR image_id = 0)`, upload.ID, from).
+		Updates(map[string]interface{}{
+			"content_type": upload.ContentType,
+			"offset":       upload.Offset,
+			"parts":        upload.Parts,
+			"expires_at":   upload.ExpiresAt,
+		})
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if db.RowsAffected == 0 {
+		return ErrUploadOffsetMismatch
+	}
+
+	return nil
+}
+
+func (ug *uploadGorm) Finish(upload *Upload) error {
+	db := ug.db.Model(&Upload{}).
+		Where("id = ? AND (image_id IS NULL OR image_id = 0)", upload.ID).
+		Updates(map[string]interface{}{
+			"image_id": upload.ImageID,
+			"parts":    upload.Parts,
+		})
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if db.RowsAffected == 0 {
+		return errUploadFinished
+	}
+
+	return nil
+}
+
+func (ug *uploadGorm) Delete(id uint) error {
+	upload := Upload{ID: id}
+	return ug.db.Delete(&upload).Error
+}
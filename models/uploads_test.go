@@ -0,0 +1,433 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"../../photofriends/storage"
+)
+
+// memUploadDB is an in-memory UploadDB, safe
+// to use from more than one goroutine
+type memUploadDB struct {
+	mu      sync.Mutex
+	nextID  uint
+	uploads map[uint]Upload
+}
+
+func (m *memUploadDB) ByID(id uint) (*Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &u, nil
+}
+
+func (m *memUploadDB) ExpiredBefore(t time.Time) ([]Upload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var expired []Upload
+	for _, u := range m.uploads {
+		if u.ExpiresAt.Before(t) {
+			expired = append(expired, u)
+		}
+	}
+
+	return expired, nil
+}
+
+func (m *memUploadDB) Create(u *Upload) error {
+	m.mu.Lock()
+	m.nextID++
+	u.ID = m.nextID
+	m.mu.Unlock()
+	return m.Update(u)
+}
+
+func (m *memUploadDB) Update(u *Upload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[u.ID] = *u
+	return nil
+}
+
+func (m *memUploadDB) Advance(u *Upload, from int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.uploads[u.ID]
+	if stored.Offset != from || stored.ImageID != 0 {
+		return ErrUploadOffsetMismatch
+	}
+
+	stored.ContentType, stored.Offset, stored.Parts, stored.ExpiresAt = u.ContentType, u.Offset, u.Parts, u.ExpiresAt
+	m.uploads[u.ID] = stored
+	return nil
+}
+
+func (m *memUploadDB) Finish(u *Upload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.uploads[u.ID]
+	if stored.ImageID != 0 {
+		return errUploadFinished
+	}
+
+	stored.ImageID, stored.Parts = u.ImageID, u.Parts
+	m.uploads[u.ID] = stored
+	return nil
+}
+
+func (m *memUploadDB) Delete(id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, id)
+	return nil
+}
+
+// memImages keeps the content of the images created,
+// and fails to create them while err is set
+type memImages struct {
+	ImageService
+	mu    sync.Mutex
+	files map[uint][]byte
+	err   error
+}
+
+func (m *memImages) Create(image *Image, r io.Reader) error {
+	if m.err != nil {
+		return m.err
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	image.ID = uint(len(m.files) + 1)
+	m.files[image.ID] = b
+	return nil
+}
+
+func (m *memImages) Delete(image *Image) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[image.ID] = nil
+	return nil
+}
+
+func testingUploadService(t *testing.T) (UploadService, *memUploadDB, *memImages, string) {
+	dir, err := ioutil.TempDir("", "photofriends-uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &memUploadDB{uploads: make(map[uint]Upload)}
+	images := &memImages{files: make(map[uint][]byte)}
+	return &uploadService{
+		UploadDB: &uploadValidator{db},
+		store:    storage.NewLocal(dir, "/images/", "secret"),
+		is:       images,
+	}, db, images, dir
+}
+
+// testingPNG is the start of a PNG file, followed by
+// enough bytes to be uploaded in a few chunks
+var testingPNG = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte("pixels"), 200)...)
+
+func TestUploadChunks(t *testing.T) {
+	us, db, images, dir := testingUploadService(t)
+	defer os.RemoveAll(dir)
+
+	upload := Upload{UserID: 1, GalleryID: 2, Filename: "beach.png", Length: int64(len(testingPNG))}
+	if err := us.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.Write(&upload, 0, bytes.NewReader(testingPNG[:700])); err != nil {
+		t.Fatal(err)
+	}
+	if upload.Offset != 700 || upload.ContentType != "image/png" {
+		t.Errorf("Expected 700 bytes of a PNG. Recieved %d of %s", upload.Offset, upload.ContentType)
+	}
+
+	if err := us.Write(&upload, 600, bytes.NewReader(testingPNG[600:])); err != ErrUploadOffsetMismatch {
+		t.Errorf("Expected ErrUploadOffsetMismatch. Recieved %v", err)
+	}
+
+	tooLong := append(testingPNG[700:len(testingPNG):len(testingPNG)], 'x')
+	if err := us.Write(&upload, 700, bytes.NewReader(tooLong)); err != ErrUploadTooLarge {
+		t.Errorf("Expected ErrUploadTooLarge. Recieved %v", err)
+	}
+	if upload.Offset != 700 || len(upload.PartKeys()) != 1 {
+		t.Errorf("Expected the chunk that was too large to be dropped. Recieved %+v", upload)
+	}
+
+	// the last chunk fails to become an image at first,
+	// and is retried with an empty chunk
+	images.err = errors.New("storage is down")
+	if err := us.Write(&upload, 700, bytes.NewReader(testingPNG[700:])); err != images.err {
+		t.Errorf("Expected the image to fail. Recieved %v", err)
+	}
+
+	images.err = nil
+	if err := us.Write(&upload, upload.Offset, bytes.NewReader(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if upload.ImageID == 0 || !bytes.Equal(images.files[upload.ImageID], testingPNG) {
+		t.Errorf("Expected the chunks to be joined into the image. Recieved %+v", upload)
+	}
+
+	stored := db.uploads[upload.ID]
+	if stored.Parts != "" || stored.ImageID != upload.ImageID {
+		t.Errorf("Expected the parts to be deleted once complete. Recieved %+v", stored)
+	}
+}
+
+func TestUploadTypeAndLength(t *testing.T) {
+	us, _, _, dir := testingUploadService(t)
+	defer os.RemoveAll(dir)
+
+	for _, length := range []int64{0, MaxUploadLength + 1} {
+		upload := Upload{UserID: 1, GalleryID: 2, Filename: "a.png", Length: length}
+		if err := us.Create(&upload); err != ErrUploadLengthInvalid {
+			t.Errorf("Length %d: Expected ErrUploadLengthInvalid. Recieved %v", length, err)
+		}
+	}
+
+	upload := Upload{UserID: 1, GalleryID: 2, Filename: "notes.txt", Length: 100}
+	if err := us.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.Write(&upload, 0, bytes.NewReader([]byte("just some text"))); err != ErrImageTypeInvalid {
+		t.Errorf("Expected ErrImageTypeInvalid for the first chunk. Recieved %v", err)
+	}
+}
+
+func TestUploadChunkTooLarge(t *testing.T) {
+	us, db, _, dir := testingUploadService(t)
+	defer os.RemoveAll(dir)
+
+	upload := Upload{UserID: 1, GalleryID: 2, Filename: "beach.png", Length: MaxChunkLength * 2}
+	if err := us.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := io.MultiReader(bytes.NewReader(testingPNG), io.LimitReader(zeros{}, MaxChunkLength))
+	if err := us.Write(&upload, 0, chunk); err != ErrChunkTooLarge {
+		t.Errorf("Expected ErrChunkTooLarge. Recieved %v", err)
+	}
+
+	if stored := db.uploads[upload.ID]; stored.Offset != 0 || stored.Parts != "" {
+		t.Errorf("Expected the chunk to be dropped. Recieved %+v", stored)
+	}
+
+	files, _ := ioutil.ReadDir(dir + "/uploads/1")
+	if len(files) != 0 {
+		t.Errorf("Expected the chunk to be deleted. Recieved %d files", len(files))
+	}
+}
+
+// zeros reads zero bytes forever
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestUploadDeleteExpired(t *testing.T) {
+	us, db, _, dir := testingUploadService(t)
+	defer os.RemoveAll(dir)
+
+	upload := Upload{UserID: 1, GalleryID: 2, Filename: "beach.png", Length: int64(len(testingPNG))}
+	if err := us.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Write(&upload, 0, bytes.NewReader(testingPNG[:100])); err != nil {
+		t.Fatal(err)
+	}
+
+	upload.ExpiresAt = time.Now().Add(-time.Minute)
+	db.Update(&upload)
+	if _, err := us.ByID(upload.ID); err != ErrUploadExpired {
+		t.Errorf("Expected ErrUploadExpired. Recieved %v", err)
+	}
+
+	if err := us.DeleteExpired(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.uploads) != 0 {
+		t.Errorf("Expected the upload to be deleted. Recieved %v", db.uploads)
+	}
+
+	files, _ := ioutil.ReadDir(dir + "/uploads/1")
+	if len(files) != 0 {
+		t.Errorf("Expected the parts to be deleted. Recieved %d files", len(files))
+	}
+}
+
+func TestUploadDeleteExpiredFailure(t *testing.T) {
+	us, db, _, dir := testingUploadService(t)
+	defer os.RemoveAll(dir)
+
+	var uploads [2]Upload
+	for i := range uploads {
+		uploads[i] = Upload{UserID: 1, GalleryID: 2, Filename: "beach.png", Length: int64(len(testingPNG))}
+		if err := us.Create(&uploads[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := us.Write(&uploads[i], 0, bytes.NewReader(testingPNG[:100])); err != nil {
+			t.Fatal(err)
+		}
+
+		uploads[i].ExpiresAt = time.Now().Add(-time.Minute)
+		db.Update(&uploads[i])
+	}
+
+	// a part of the first upload can't be deleted, as
+	// it was turned into a directory that isn't empty
+	part := dir + "/" + uploads[0].PartKeys()[0]
+	os.Remove(part)
+	if err := os.MkdirAll(part+"/stuck", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := us.DeleteExpired(); err == nil {
+		t.Error("Expected an error for the upload that could not be deleted")
+	}
+
+	if _, ok := db.uploads[uploads[0].ID]; !ok || len(db.uploads) != 1 {
+		t.Errorf("Expected only the other upload to be deleted. Recieved %v", db.uploads)
+	}
+
+	upload := Upload{UserID: 1, GalleryID: 2, Filename: "forest.png", Length: 10}
+	if err := us.Create(&upload); err != nil {
+		t.Errorf("Expected uploads to still be created. Recieved %v", err)
+	}
+}
+
+// barrierReader holds back its content until every reader
+// sharing wg has been read from, so chunks only arrive once
+// each Write has looked at the offset
+type barrierReader struct {
+	r    io.Reader
+	wg   *sync.WaitGroup
+	once sync.Once
+}
+
+func (br *barrierReader) Read(p []byte) (int, error) {
+	br.once.Do(func() {
+		br.wg.Done()
+		br.wg.Wait()
+	})
+
+	return br.r.Read(p)
+}
+
+func TestUploadConcurrentWrites(t *testing.T) {
+	us, db, images, dir := testingUploadService(t)
+	defer os.RemoveAll(dir)
+
+	upload := Upload{UserID: 1, GalleryID: 2, Filename: "beach.png", Length: int64(len(testingPNG))}
+	if err := us.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same upload is patched twice at once, once with
+	// the image and once with other bytes of the same length
+	other := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte("others"), 200)...)
+	var wg, started sync.WaitGroup
+	started.Add(2)
+	errs := make([]error, 2)
+	for i, content := range [][]byte{testingPNG, other} {
+		wg.Add(1)
+		go func(i int, content []byte) {
+			defer wg.Done()
+			u, err := us.ByID(upload.ID)
+			if err == nil {
+				err = us.Write(u, 0, &barrierReader{r: bytes.NewReader(content), wg: &started})
+			}
+			errs[i] = err
+		}(i, content)
+	}
+	wg.Wait()
+
+	if (errs[0] == nil) == (errs[1] == nil) || (errs[0] != ErrUploadOffsetMismatch && errs[1] != ErrUploadOffsetMismatch) {
+		t.Fatalf("Expected one chunk to be refused with ErrUploadOffsetMismatch. Recieved %v", errs)
+	}
+
+	winner := testingPNG
+	if errs[0] != nil {
+		winner = other
+	}
+
+	stored := db.uploads[upload.ID]
+	if stored.ImageID == 0 || len(images.files) != 1 || !bytes.Equal(images.files[stored.ImageID], winner) {
+		t.Errorf("Expected a single image of the accepted chunk. Recieved %+v and %d images", stored, len(images.files))
+	}
+
+	files, _ := ioutil.ReadDir(dir + "/uploads/1")
+	if len(files) != 0 {
+		t.Errorf("Expected the parts to be deleted. Recieved %d files", len(files))
+	}
+}
+
+func TestUploadConcurrentFinish(t *testing.T) {
+	us, db, images, dir := testingUploadService(t)
+	defer os.RemoveAll(dir)
+
+	upload := Upload{UserID: 1, GalleryID: 2, Filename: "beach.png", Length: int64(len(testingPNG))}
+	if err := us.Create(&upload); err != nil {
+		t.Fatal(err)
+	}
+
+	// the last chunk fails to become an image, and is
+	// then retried by two requests at once
+	images.err = errors.New("storage is down")
+	us.Write(&upload, 0, bytes.NewReader(testingPNG))
+	images.err = nil
+
+	var wg, started sync.WaitGroup
+	started.Add(2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := us.ByID(upload.ID)
+			if err == nil {
+				err = us.Write(u, u.Offset, &barrierReader{r: bytes.NewReader(nil), wg: &started})
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	kept := 0
+	for _, b := range images.files {
+		if b != nil {
+			kept++
+		}
+	}
+
+	if stored := db.uploads[upload.ID]; stored.ImageID == 0 || kept != 1 || images.files[stored.ImageID] == nil {
+		t.Errorf("Expected a single image to be kept. Recieved %+v and %d images", stored, kept)
+	}
+}
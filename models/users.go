@@ -3,11 +3,14 @@ package models
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"regexp"
 
 	"../../photofriends/hash"
 	"../../photofriends/rand"
+	"../../photofriends/ratelimit"
+	"../../photofriends/totp"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"golang.org/x/crypto/bcrypt"
@@ -16,7 +19,7 @@ import (
 var (
 	// ErrNotFound is returned when a resource
 	// cannot be found in the database
-	ErrNotFound = errors.New("Resource not found")
+	ErrNotFound = modelError("Resource not found")
 
 	// ErrIDInvalid is returned when an invalid ID is
 	// provided to a method like Delete
@@ -24,27 +27,32 @@ var (
 
 	// ErrPasswordIncorrect is returned when an invalid password
 	// is used when attempting to authenticate a user
-	ErrPasswordIncorrect = errors.New("Incorrect password provided")
+	ErrPasswordIncorrect = modelError("Incorrect password provided")
 
 	// ErrEmailRequired is returned when an email address
 	// is not provided when creating a user
-	ErrEmailRequired = errors.New("Email address is required")
+	ErrEmailRequired = modelError("Email address is required")
 
 	// ErrEmailInvalid is returned when an email address provided
 	// does not match any of our requirements
-	ErrEmailInvalid = errors.New("Email address is not valid")
+	ErrEmailInvalid = modelError("Email address is not valid")
 
 	// ErrEmailTaken is returned when an update or create
 	// is attempted with an email address that is already in use
-	ErrEmailTaken = errors.New("Email address is already taken")
+	ErrEmailTaken = modelError("Email address is already taken")
 
 	// ErrPasswordTooShort is returned when an update or create is
 	// attempted with a user passord that is less than 8 characters
-	ErrPasswordTooShort = errors.New("Password must be atleast 8 characters long")
+	ErrPasswordTooShort = modelError("Password must be atleast 8 characters long")
 
 	// ErrPasswordRequired is returned when a create is attempted
 	// wihtout a user password provided
-	ErrPasswordRequired = errors.New("Password is required")
+	ErrPasswordRequired = modelError("Password is required")
+
+	// ErrPasswordNotSet is returned when a user without a
+	// password, who signs in with another provider, attempts
+	// to log in with a password
+	ErrPasswordNotSet = modelError("This account has no password, sign in the way you signed up or reset your password")
 
 	// ErrRememberRequired is returned when a create or update
 	// is attempted wihtout a user remember token hash provided
@@ -55,11 +63,6 @@ var (
 	ErrRememberTooShort = errors.New("Remember token must be atleast 32 bytes")
 )
 
-const (
-	userPwPepper  = "secret-random-string"
-	hmacSecretKey = "secrey-hmac-key"
-)
-
 // User represent the user model stored in our database
 // This is used for user accounts, storing both an email
 // address and a password so users can log in and gain
@@ -72,6 +75,39 @@ type User struct {
 	PasswordHash string `gorm:"not null"`
 	Remember     string `gorm:"-"`
 	RememberHash string `gorm:"not null;unique_index"`
+
+	// EmailVerifiedAt is set once the user followed the link
+	// in the verification email, nil until then
+	EmailVerifiedAt *time.Time
+
+	// TOTPSecret is the base32 secret shared with the user's
+	// authenticator app. It is set when the setup starts, but
+	// only asked for once TOTPEnabledAt is set as well.
+	// TOTPLastStep is the time step of the last code used, so
+	// a code can not be used a second time
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	TOTPLastStep  int64 `gorm:"not null;default:0"`
+
+	// Passwordless creates the user without a password, for
+	// users signing up through an identity provider
+	Passwordless bool `gorm:"-"`
+}
+
+// HasPassword reports whether the user can log in with a password
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+// Verified reports whether the user has verified their email address
+func (u *User) Verified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// TOTPEnabled reports whether the user has to enter a code
+// from their authenticator app after their password
+func (u *User) TOTPEnabled() bool {
+	return u.TOTPEnabledAt != nil
 }
 
 // UserDB is used to interact with the users database
@@ -100,18 +136,76 @@ type UserService interface {
 	// Authenticate will verify the provided email and
 	// password are correct, if correct, the user corresponding
 	// to that email will be returned, if not the releated error
-	// for the reason the method failed
-	Authenticate(email, password string) (*User, error)
+	// for the reason the method failed. Every attempt is
+	// recorded, and too many failures from the client or for
+	// the email address return a *ratelimit.LimitedError
+	Authenticate(email, password string, client LoginClient) (*User, error)
+
+	// LoginAttempts returns the most recent
+	// attempts to log in as the user
+	LoginAttempts(userID uint) ([]LoginAttempt, error)
+
+	// InitiateReset creates a single use password reset
+	// token for the user with the provided email address.
+	// The raw token is returned so it can be emailed
+	InitiateReset(email string) (token string, user *User, err error)
+
+	// CompleteReset sets a new password for the user the
+	// token was issued to, and logs them out everywhere
+	CompleteReset(token, newPw string) (*User, error)
+
+	// InitiateVerification creates a token proving the user
+	// owns their email address. The raw token is returned so
+	// it can be emailed. Earlier tokens stop working
+	InitiateVerification(user *User) (token string, err error)
+
+	// CompleteVerification marks the email address of the
+	// user the token was issued to as verified
+	CompleteVerification(token string) (*User, error)
+
+	// SetupTOTP returns the secret for the user to add to
+	// their authenticator app. Two factor auth is only turned
+	// on by EnableTOTP, once the app is known to work
+	SetupTOTP(user *User) (secret string, err error)
+
+	// EnableTOTP turns on two factor auth if code is right for
+	// the secret from SetupTOTP. The raw recovery codes are
+	// returned, this is the only time they are available
+	EnableTOTP(user *User, code string) (recoveryCodes []string, err error)
+
+	// DisableTOTP turns off two factor auth, after
+	// checking code the same way VerifyTOTP does
+	DisableTOTP(user *User, code string) error
+
+	// VerifyTOTP checks a code from the authenticator app, or
+	// an unused recovery code, which is then used up. Too many
+	// wrong codes return a *ratelimit.LimitedError
+	VerifyTOTP(user *User, code string) error
+
+	// RecoveryCodesLeft returns how many unused recovery codes the user has
+	RecoveryCodesLeft(user *User) (int, error)
 	UserDB
 }
 
-func NewUserService(db *gorm.DB) UserService {
+// NewUserService creates a UserService. Passwords are
+// peppered with pepper before hashing, tokens are HMACed
+// with hmacKey, and sessions are revoked through ss
+// whenever a password is reset. Failed logins are
+// counted by limiter
+func NewUserService(db *gorm.DB, ss SessionService, limiter *ratelimit.Limiter, pepper, hmacKey string) UserService {
 	ug := &userGorm{db}
-	hmac := hash.NewHMAC(hmacSecretKey)
-	uv := newUserValidator(ug, hmac)
+	hmac := hash.NewHMAC(hmacKey)
+	uv := newUserValidator(ug, hmac, pepper)
 
 	return &userService{
-		UserDB: uv,
+		UserDB:     uv,
+		pepper:     pepper,
+		pwResetDB:  newPwResetValidator(&pwResetGorm{db}, hmac),
+		verifyDB:   newEmailVerificationValidator(&emailVerificationGorm{db}, hmac),
+		recoveryDB: newRecoveryCodeValidator(&recoveryCodeGorm{db}, hmac),
+		sessions:   ss,
+		attempts:   &loginAttemptGorm{db},
+		limiter:    limiter,
 	}
 }
 
@@ -121,37 +215,374 @@ var _ UserService = &userService{}
 // implementation of interface
 type userService struct {
 	UserDB
+	pepper     string
+	pwResetDB  pwResetDB
+	verifyDB   emailVerificationDB
+	recoveryDB recoveryCodeDB
+	sessions   SessionService
+	attempts   loginAttemptDB
+	limiter    *ratelimit.Limiter
 }
 
 // Authenticate can be used to authenticate a user with the provided
 // email address and password.
+//	If the client or the email address are locked out, this
+//		will return nil, *ratelimit.LimitedError
 //	If the email address
 // 		provided is invald, this will return nil, ErrNotFound
 // 	If the password provided is invalid, this will return
-// 		nil, ErrPasswordIncorrect
+// 		nil, ErrPasswordIncorrect, or *ratelimit.LimitedError
+//		when that was one failure too many
 // 	If the email and password are both valid, this will return
 //		user, nil
 // 	Otherwise if another error is encountered this will return
 // 		nil, error
-func (us *userService) Authenticate(email, password string) (*User, error) {
+func (us *userService) Authenticate(email, password string, client LoginClient) (*User, error) {
+	attempt := LoginAttempt{
+		Email:     email,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+	}
+
 	foundUser, err := us.ByEmail(email)
-	if err != nil {
+	switch err {
+	case nil:
+		attempt.UserID = &foundUser.ID
+	case ErrNotFound:
+		// ByEmail returns an empty user along with ErrNotFound
+		foundUser = nil
+	default:
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+userPwPepper))
+	if err := us.limiter.Check(loginIPPolicy, loginIPKey(client.IP)); err != nil {
+		return nil, us.loginFailed(&attempt, LoginLimited, err)
+	}
+
+	if err := us.limiter.Check(loginEmailPolicy, loginEmailKey(email)); err != nil {
+		return nil, us.loginFailed(&attempt, LoginLimited, err)
+	}
+
+	if foundUser == nil {
+		return nil, us.loginFailed(&attempt, LoginUnknownEmail, ErrNotFound)
+	}
+
+	if !foundUser.HasPassword() {
+		return nil, us.loginFailed(&attempt, LoginWrongPassword, ErrPasswordNotSet)
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+us.pepper))
 	if err != nil {
 		switch err {
 		case bcrypt.ErrMismatchedHashAndPassword:
-			return nil, ErrPasswordIncorrect
+			return nil, us.loginFailed(&attempt, LoginWrongPassword, ErrPasswordIncorrect)
 		default:
 			return nil, err
 		}
 	}
 
+	// only the email address is reset, otherwise a single
+	// account of their own would let anyone keep guessing
+	if err := us.limiter.Reset(loginEmailKey(email)); err != nil {
+		return nil, err
+	}
+
+	attempt.Result = LoginSucceeded
+	if err := us.attempts.Create(&attempt); err != nil {
+		return nil, err
+	}
+
 	return foundUser, nil
 }
 
+// loginFailed records the failed attempt and returns err.
+// Unless the attempt was limited already, it counts against
+// both the IP and the email address, and if that locks
+// either of them the lockout is returned instead
+func (us *userService) loginFailed(attempt *LoginAttempt, result string, err error) error {
+	attempt.Result = result
+	if err := us.attempts.Create(attempt); err != nil {
+		return err
+	}
+
+	if result == LoginLimited {
+		return err
+	}
+
+	if failErr := us.limiter.Fail(loginIPPolicy, loginIPKey(attempt.IP)); failErr != nil {
+		err = failErr
+	}
+
+	if failErr := us.limiter.Fail(loginEmailPolicy, loginEmailKey(attempt.Email)); failErr != nil {
+		err = failErr
+	}
+
+	return err
+}
+
+// LoginAttempts returns the latest login attempts of the user
+func (us *userService) LoginAttempts(userID uint) ([]LoginAttempt, error) {
+	return us.attempts.ByUserID(userID, loginAttemptsShown)
+}
+
+// InitiateReset looks up the user by email and creates
+// a reset token for them. Any earlier tokens stop working
+func (us *userService) InitiateReset(email string) (string, *User, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := us.pwResetDB.DeleteByUserID(user.ID); err != nil {
+		return "", nil, err
+	}
+
+	pwr := pwReset{UserID: user.ID}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return "", nil, err
+	}
+
+	return pwr.Token, user, nil
+}
+
+// CompleteReset checks the token, then updates the password
+// using the same rules as any other password change. The
+// token is used up, and every session and the remember token
+// of the user are replaced so old logins stop working
+func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+	pwr, err := us.pwResetDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+
+	if time.Now().After(pwr.ExpiresAt) {
+		us.pwResetDB.Delete(pwr.ID)
+		return nil, ErrTokenInvalid
+	}
+
+	if newPw == "" {
+		return nil, FieldErrors{"password": ErrPasswordRequired}
+	}
+
+	user, err := us.ByID(pwr.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	remember, err := rand.RememberToken()
+	if err != nil {
+		return nil, err
+	}
+
+	user.Password = newPw
+	user.Remember = remember
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+
+	if err := us.pwResetDB.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+
+	if err := us.sessions.RevokeAll(user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// InitiateVerification replaces any earlier verification
+// token of the user with a new one
+func (us *userService) InitiateVerification(user *User) (string, error) {
+	if user.Verified() {
+		return "", ErrEmailVerified
+	}
+
+	if err := us.verifyDB.DeleteByUserID(user.ID); err != nil {
+		return "", err
+	}
+
+	ev := emailVerification{UserID: user.ID}
+	if err := us.verifyDB.Create(&ev); err != nil {
+		return "", err
+	}
+
+	return ev.Token, nil
+}
+
+// CompleteVerification checks the token and sets the time
+// the email address of its user was verified
+func (us *userService) CompleteVerification(token string) (*User, error) {
+	ev, err := us.verifyDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound || err == ErrVerificationInvalid {
+			return nil, ErrVerificationInvalid
+		}
+		return nil, err
+	}
+
+	if time.Now().After(ev.ExpiresAt) {
+		us.verifyDB.Delete(ev.ID)
+		return nil, ErrVerificationInvalid
+	}
+
+	user, err := us.ByID(ev.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Verified() {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if err := us.Update(user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := us.verifyDB.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// SetupTOTP keeps the secret until the setup is confirmed,
+// so reloading the setup page shows the same QR code
+func (us *userService) SetupTOTP(user *User) (string, error) {
+	if user.TOTPEnabled() {
+		return "", ErrTOTPEnabled
+	}
+
+	if user.TOTPSecret != "" {
+		return user.TOTPSecret, nil
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return "", err
+	}
+
+	user.TOTPSecret = secret
+	if err := us.Update(user); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// EnableTOTP checks the first code from the app, turns two
+// factor auth on and replaces any earlier recovery codes
+func (us *userService) EnableTOTP(user *User, code string) ([]string, error) {
+	if user.TOTPEnabled() {
+		return nil, ErrTOTPEnabled
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew)
+	if !ok {
+		return nil, FieldErrors{"code": ErrTOTPCodeInvalid}
+	}
+
+	if err := us.recoveryDB.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		rc := recoveryCode{UserID: user.ID}
+		if err := us.recoveryDB.Create(&rc); err != nil {
+			return nil, err
+		}
+		codes[i] = rc.Code
+	}
+
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	user.TOTPLastStep = step
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP forgets the secret and the recovery codes
+func (us *userService) DisableTOTP(user *User, code string) error {
+	if err := us.VerifyTOTP(user, code); err != nil {
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	if err := us.Update(user); err != nil {
+		return err
+	}
+
+	return us.recoveryDB.DeleteByUserID(user.ID)
+}
+
+// VerifyTOTP tries code as a code from the app first, then
+// as a recovery code. Failures are limited per user, so
+// knowing the password does not allow guessing codes
+func (us *userService) VerifyTOTP(user *User, code string) error {
+	if !user.TOTPEnabled() {
+		return ErrTOTPNotEnabled
+	}
+
+	key := twoFactorKey(user.ID)
+	if err := us.limiter.Check(twoFactorPolicy, key); err != nil {
+		return err
+	}
+
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), totpSkew); ok {
+		if step <= user.TOTPLastStep {
+			return us.twoFactorFailed(key)
+		}
+
+		user.TOTPLastStep = step
+		if err := us.Update(user); err != nil {
+			return err
+		}
+
+		return us.limiter.Reset(key)
+	}
+
+	rc, err := us.recoveryDB.ByCode(user.ID, code)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		return us.twoFactorFailed(key)
+	default:
+		return err
+	}
+
+	if err := us.recoveryDB.Use(rc.ID); err != nil {
+		if err == ErrNotFound {
+			return us.twoFactorFailed(key)
+		}
+		return err
+	}
+
+	return us.limiter.Reset(key)
+}
+
+// twoFactorFailed counts a wrong code against key. The
+// lockout is returned if that was one too many
+func (us *userService) twoFactorFailed(key string) error {
+	if err := us.limiter.Fail(twoFactorPolicy, key); err != nil {
+		return err
+	}
+
+	return FieldErrors{"code": ErrTOTPCodeInvalid}
+}
+
+func (us *userService) RecoveryCodesLeft(user *User) (int, error) {
+	return us.recoveryDB.CountUnused(user.ID)
+}
+
 /******************* VALIDATORS **************************/
 
 // ensure interface is matching
@@ -169,10 +600,11 @@ func runUsersValFuncs(user *User, fns ...userValFunc) error {
 	return nil
 }
 
-func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
+func newUserValidator(udb UserDB, hmac hash.HMAC, pepper string) *userValidator {
 	return &userValidator{
 		UserDB: udb,
 		hmac:   hmac,
+		pepper: pepper,
 
 		// emailRegex is used to match email addresses.
 		// It is not perfect, but works well enough for now
@@ -183,6 +615,7 @@ func newUserValidator(udb UserDB, hmac hash.HMAC) *userValidator {
 type userValidator struct {
 	UserDB
 	hmac       hash.HMAC
+	pepper     string
 	emailRegex *regexp.Regexp
 }
 
@@ -202,7 +635,7 @@ func (uv *userValidator) ByEmail(email string) (*User, error) {
 		return nil, err
 	}
 
-	return uv.ByEmail(user.Email)
+	return uv.UserDB.ByEmail(user.Email)
 }
 
 func (uv *userValidator) ByRemember(token string) (*User, error) {
@@ -218,19 +651,27 @@ func (uv *userValidator) ByRemember(token string) (*User, error) {
 }
 
 func (uv *userValidator) Create(user *User) error {
+	fe := FieldErrors{}
+	fe.add("email", runUsersValFuncs(user,
+		uv.normalizeEmail,
+		uv.requireEmail,
+		uv.emailFormat,
+		uv.emailIsAvail))
+	fe.add("password", runUsersValFuncs(user,
+		uv.passwordRequiredUnlessPasswordless,
+		uv.passwordMinLength))
+
+	if err := fe.err(); err != nil {
+		return err
+	}
+
 	err := runUsersValFuncs(user,
-		uv.passwordRequired,
-		uv.passwordMinLength,
 		uv.bcryptPassword,
-		uv.passwordHashRequired,
+		uv.passwordHashRequiredUnlessPasswordless,
 		uv.setRmemberIfUnset,
 		uv.rememberMinBytes,
 		uv.hmacRemember,
-		uv.rememberHashRequired,
-		uv.normalizeEmail,
-		uv.emailFormat,
-		uv.requireEmail,
-		uv.emailFormat)
+		uv.rememberHashRequired)
 
 	if err != nil {
 		return err
@@ -241,16 +682,23 @@ func (uv *userValidator) Create(user *User) error {
 
 // Update will hash a remember token if it is provided
 func (uv *userValidator) Update(user *User) error {
+	fe := FieldErrors{}
+	fe.add("email", runUsersValFuncs(user,
+		uv.normalizeEmail,
+		uv.requireEmail,
+		uv.emailFormat,
+		uv.emailIsAvail))
+	fe.add("password", runUsersValFuncs(user, uv.passwordMinLength))
+
+	if err := fe.err(); err != nil {
+		return err
+	}
+
 	err := runUsersValFuncs(user,
-		uv.passwordMinLength,
 		uv.bcryptPassword,
 		uv.rememberMinBytes,
 		uv.hmacRemember,
-		uv.rememberHashRequired,
-		uv.normalizeEmail,
-		uv.emailFormat,
-		uv.requireEmail,
-		uv.emailIsAvail)
+		uv.rememberHashRequired)
 
 	if err != nil {
 		return err
@@ -272,15 +720,15 @@ func (uv *userValidator) Delete(id uint) error {
 	return uv.UserDB.Delete(id)
 }
 
-// bcryptPassword will hash a users password with a
-// predefined pepper(userPwPepper) and bcrypt if the
+// bcryptPassword will hash a users password with the
+// configured pepper and bcrypt if the
 // password field is not the empty string
 func (uv *userValidator) bcryptPassword(user *User) error {
 	if user.Password == "" {
 		return nil
 	}
 
-	pwBytes := []byte(user.Password + userPwPepper)
+	pwBytes := []byte(user.Password + uv.pepper)
 	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
 	if err != nil {
 		return err
@@ -421,6 +869,24 @@ func (uv *userValidator) passwordHashRequired(user *User) error {
 	return nil
 }
 
+// passwordRequiredUnlessPasswordless is passwordRequired, but
+// lets users created by an identity provider have none
+func (uv *userValidator) passwordRequiredUnlessPasswordless(user *User) error {
+	if user.Passwordless {
+		return nil
+	}
+
+	return uv.passwordRequired(user)
+}
+
+func (uv *userValidator) passwordHashRequiredUnlessPasswordless(user *User) error {
+	if user.Passwordless {
+		return nil
+	}
+
+	return uv.passwordHashRequired(user)
+}
+
 /************************************************************/
 
 // ensure interface is matching
@@ -4,31 +4,38 @@ import (
 	"fmt"
 	"time"
 	"testing"
+
+	"../../photofriends/hash"
+	"../../photofriends/ratelimit"
+	"github.com/jinzhu/gorm"
 )
 
- func testingUserService() (*UserService, error) {
+ func testingUserService() (UserService, error) {
 	const (
 		host 	 = "localhost"
 		port 	 = 5432
 		user	 = "postgres"
 		password = "postgres"
 		dbname 	 = "photofriends_test"
+		pepper 	 = "test-pepper"
+		hmacKey	 = "test-hmac-key"
 	)
 
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 	host, port, user, password, dbname)
 
-	us, err := NewUserService(psqlInfo)
+	db, err := gorm.Open("postgres", psqlInfo)
 
 	if err != nil {
 		return nil, err
 	}
 
-	us.db.LogMode(false)
+	db.LogMode(false)
 
 	// clear the users table between tests
-	us.DestructiveReset()
-	return us, nil
+	db.DropTableIfExists(&User{})
+	db.AutoMigrate(&User{})
+	return NewUserService(db, NewSessionService(db, hash.NewHMAC(hmacKey)), ratelimit.New(ratelimit.NewMemory()), pepper, hmacKey), nil
  }
 
  func TestCreateUser(t *testing.T) {
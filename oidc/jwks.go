@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// keyRefreshInterval limits how often the keys are fetched
+// again for an unknown key ID, so tokens with made up IDs
+// can not make us hammer the provider
+const keyRefreshInterval = time.Minute
+
+// minRSABits is the smallest RSA key accepted
+const minRSABits = 2048
+
+// jwk is a single JSON Web Key, see RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey parses the key, returning nil for
+// keys we do not use or can not parse
+func (k jwk) publicKey() crypto.PublicKey {
+	if k.Use != "" && k.Use != "sig" {
+		return nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			return nil
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.N.BitLen() < minRSABits || key.E < 3 {
+			return nil
+		}
+
+		return key
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil
+		}
+
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+			return nil
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil
+		}
+
+		return key
+	}
+
+	return nil
+}
+
+// keySet caches the signing keys of a provider. They are
+// fetched again when a token is signed with an unknown key,
+// as providers rotate their keys
+type keySet struct {
+	uri string
+	p   *Provider
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newKeySet(uri string, p *Provider) *keySet {
+	return &keySet{uri: uri, p: p}
+}
+
+// key returns the key with the ID kid
+func (ks *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+
+	if ks.keys != nil && ks.p.now().Sub(ks.fetchedAt) < keyRefreshInterval {
+		return nil, ErrKeyNotFound
+	}
+
+	if err := ks.fetch(ctx); err != nil {
+		return nil, err
+	}
+
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, ks.uri, nil)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	status, err := ks.p.do(req.WithContext(ctx), &set)
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return ErrDiscovery
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if key := k.publicKey(); key != nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	ks.keys = keys
+	ks.fetchedAt = ks.p.now()
+	return nil
+}
@@ -0,0 +1,187 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is how far the clocks of the
+// provider and ours may be apart
+const clockSkew = time.Minute
+
+var (
+	// ErrTokenInvalid is returned for ID tokens that are
+	// malformed, or not signed by a key of the provider
+	ErrTokenInvalid = errors.New("oidc: ID token is not valid")
+
+	// ErrKeyNotFound is returned when the ID token is signed
+	// with a key the provider does not publish
+	ErrKeyNotFound = errors.New("oidc: signing key not found")
+
+	// ErrTokenExpired is returned for expired ID tokens
+	ErrTokenExpired = errors.New("oidc: ID token has expired")
+
+	// ErrAudience is returned when the ID token
+	// was issued to another client, or by another issuer
+	ErrAudience = errors.New("oidc: ID token is not for us")
+
+	// ErrNonce is returned when the nonce of the ID token
+	// is not the one the flow was started with
+	ErrNonce = errors.New("oidc: nonce does not match")
+)
+
+// Claims are the claims of an ID token we use
+type Claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+
+	// AuthorizedParty is the client the token was issued
+	// to, when the audience lists more than one
+	AuthorizedParty string `json:"azp"`
+	Expiry          int64  `json:"exp"`
+	IssuedAt        int64  `json:"iat"`
+	Nonce           string `json:"nonce"`
+
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// audience is the aud claim, which is either
+// a single string or an array of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+
+	return false
+}
+
+// header is the JOSE header of a JWT
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature and claims of
+// the ID token, see section 3.1.3.7 of the spec
+func (p *Provider) verify(ctx context.Context, meta *metadata, rawIDToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+
+	var hdr header
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	key, err := p.keys.key(ctx, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if !verifySignature(hdr.Alg, key, signed, sig) {
+		return nil, ErrTokenInvalid
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	if claims.Issuer != meta.Issuer || !claims.Audience.contains(p.cfg.ClientID) {
+		return nil, ErrAudience
+	}
+
+	if claims.AuthorizedParty != "" && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, ErrAudience
+	}
+
+	now := p.now()
+	if claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(clockSkew)) {
+		return nil, ErrTokenExpired
+	}
+
+	if now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, ErrTokenInvalid
+	}
+
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonce
+	}
+
+	if claims.Subject == "" {
+		return nil, ErrTokenInvalid
+	}
+
+	return &claims, nil
+}
+
+// verifySignature checks sig with key. Only the asymmetric
+// algorithms are accepted, so a token can not pick "none"
+// or an HMAC keyed with the public key
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS uses the fixed size r || s encoding
+		if alg != "ES256" || len(sig) != 64 {
+			return false
+		}
+
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+
+	return false
+}
+
+func decodeSegment(seg string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dst)
+}
@@ -0,0 +1,271 @@
+// Package oidc implements the relying party side of OpenID
+// Connect: the authorization code flow with PKCE, and the
+// verification of ID tokens against the provider's JWKS.
+// Providers are found through their discovery document
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"../../photofriends/rand"
+)
+
+// verifierBytes is the entropy of PKCE code verifiers,
+// which encodes to 43 characters, the minimum allowed
+const verifierBytes = 32
+
+// maxResponseSize limits what is read from the provider
+const maxResponseSize = 1 << 20
+
+var (
+	// ErrDiscovery is returned when the discovery document
+	// of the provider can not be fetched or is not valid
+	ErrDiscovery = errors.New("oidc: provider discovery failed")
+
+	// ErrExchange is returned when the provider
+	// refuses to exchange the code for tokens
+	ErrExchange = errors.New("oidc: code exchange failed")
+)
+
+// Config describes a provider users can sign in with
+type Config struct {
+	// Name identifies the provider in URLs, eg: "google"
+	Name string `json:"name" toml:"name"`
+
+	// DisplayName is shown on the buttons, eg: "Google"
+	DisplayName string `json:"display_name" toml:"display_name"`
+
+	// Issuer is the URL the discovery document is found
+	// under, and which has to sign the ID tokens
+	Issuer string `json:"issuer" toml:"issuer"`
+
+	ClientID     string `json:"client_id" toml:"client_id"`
+	ClientSecret string `json:"client_secret" toml:"client_secret"`
+
+	// RedirectURL is where the provider sends users back to.
+	// It has to be registered with the provider as is
+	RedirectURL string `json:"redirect_url" toml:"redirect_url"`
+
+	// Scopes are asked for besides "openid", by
+	// default "email" and "profile"
+	Scopes []string `json:"scopes" toml:"scopes"`
+}
+
+// metadata is the part of the discovery document we use, see
+// https://openid.net/specs/openid-connect-discovery-1_0.html
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider signs users in with an OpenID Connect provider.
+// The discovery document is fetched on first use
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+// NewProvider returns a provider described by cfg. Requests
+// to the provider are made with client
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"email", "profile"}
+	}
+
+	return &Provider{
+		cfg:    cfg,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// Name returns the name used in URLs
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// DisplayName returns the name shown to users,
+// falling back to Name
+func (p *Provider) DisplayName() string {
+	if p.cfg.DisplayName == "" {
+		return p.cfg.Name
+	}
+
+	return p.cfg.DisplayName
+}
+
+// NewVerifier returns a random PKCE code verifier. It
+// has to be kept until the code is exchanged
+func NewVerifier() (string, error) {
+	b, err := rand.Bytes(verifierBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge of verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send users to, to sign in
+// with the provider. state and nonce are random values the
+// response and ID token have to echo, and verifier is the
+// PKCE code verifier later passed to Exchange
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", ErrDiscovery
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// tokenResponse is what the token endpoint returns, see
+// https://openid.net/specs/openid-connect-core-1_0.html#TokenResponse
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades the code the provider redirected back
+// with for an ID token, proving it started the flow with
+// verifier. The token still has to be checked with Verify
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	var tr tokenResponse
+	status, err := p.do(req, &tr)
+	if err != nil {
+		return "", err
+	}
+
+	if status != http.StatusOK || tr.IDToken == "" {
+		return "", fmt.Errorf("%v: %d %s %s", ErrExchange, status, tr.Error, tr.ErrorDescription)
+	}
+
+	return tr.IDToken, nil
+}
+
+// Verify checks the ID token was signed by the provider for
+// us, is not expired, and carries nonce. Its claims are
+// returned
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.verify(ctx, meta, rawIDToken, nonce)
+}
+
+// metadata returns the discovery document,
+// fetching it the first time
+func (p *Provider) metadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequest(http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta metadata
+	status, err := p.do(req.WithContext(ctx), &meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// the issuer has to match exactly, see section 4.3
+	if status != http.StatusOK || meta.Issuer != p.cfg.Issuer ||
+		meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, ErrDiscovery
+	}
+
+	p.meta = &meta
+	p.keys = newKeySet(meta.JWKSURI, p)
+	return p.meta, nil
+}
+
+// do sends req and decodes the JSON response into
+// dst, whatever the status, which is returned
+func (p *Provider) do(req *http.Request, dst interface{}) (int, error) {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := json.Unmarshal(body, dst); err != nil {
+		return res.StatusCode, fmt.Errorf("oidc: %s responded %d: %v", req.URL, res.StatusCode, err)
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,157 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"../../photofriends/oidc"
+	"../../photofriends/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:3000/auth/test/callback"
+
+// signIn runs the flow up to the callback,
+// returning the code
+func signIn(t *testing.T, fake *oidctest.Provider, p *oidc.Provider, state, nonce, verifier string) string {
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callback, err := fake.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := u.Scheme + "://" + u.Host + u.Path; got != redirectURL {
+		t.Errorf("Expected to be sent back to %s. Recieved %s", redirectURL, got)
+	}
+
+	if u.Query().Get("state") != state {
+		t.Errorf("Expected the state to be echoed. Recieved %q", u.Query().Get("state"))
+	}
+
+	return u.Query().Get("code")
+}
+
+func TestFlow(t *testing.T) {
+	fake := oidctest.New("client", "secret")
+	defer fake.Close()
+
+	p := oidc.NewProvider(fake.Config("test", redirectURL), http.DefaultClient)
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := signIn(t, fake, p, "state", "nonce", verifier)
+	raw, err := p.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := p.Verify(context.Background(), raw, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != fake.Identity.Subject || claims.Email != fake.Identity.Email || !claims.EmailVerified {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	if _, err := p.Verify(context.Background(), raw, "other"); err != oidc.ErrNonce {
+		t.Errorf("Expected ErrNonce. Recieved %v", err)
+	}
+
+	// codes can only be exchanged once
+	if _, err := p.Exchange(context.Background(), code, verifier); err == nil {
+		t.Error("Expected a code to be refused the second time")
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	fake := oidctest.New("client", "secret")
+	defer fake.Close()
+
+	p := oidc.NewProvider(fake.Config("test", redirectURL), http.DefaultClient)
+	verifier, _ := oidc.NewVerifier()
+	other, _ := oidc.NewVerifier()
+
+	code := signIn(t, fake, p, "state", "nonce", verifier)
+	if _, err := p.Exchange(context.Background(), code, other); err == nil {
+		t.Error("Expected the exchange to fail with another verifier")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	fake := oidctest.New("client", "secret")
+	defer fake.Close()
+
+	p := oidc.NewProvider(fake.Config("test", redirectURL), http.DefaultClient)
+	claims := func(change func(map[string]interface{})) string {
+		c := fake.Claims(fake.Identity, "nonce")
+		change(c)
+		return fake.IDToken(c)
+	}
+
+	cases := []struct {
+		name string
+		raw  string
+		err  error
+	}{
+		{"valid", claims(func(map[string]interface{}) {}), nil},
+		{"audience", claims(func(c map[string]interface{}) { c["aud"] = "other" }), oidc.ErrAudience},
+		{"audience list", claims(func(c map[string]interface{}) { c["aud"] = []string{"other", "client"} }), nil},
+		{"authorized party", claims(func(c map[string]interface{}) { c["azp"] = "other" }), oidc.ErrAudience},
+		{"issuer", claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example" }), oidc.ErrAudience},
+		{"expired", claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }), oidc.ErrTokenExpired},
+		{"issued later", claims(func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }), oidc.ErrTokenInvalid},
+		{"nonce", claims(func(c map[string]interface{}) { c["nonce"] = "other" }), oidc.ErrNonce},
+		{"subject", claims(func(c map[string]interface{}) { c["sub"] = "" }), oidc.ErrTokenInvalid},
+		{"malformed", "not.a-token", oidc.ErrTokenInvalid},
+		{"unsigned", "eyJhbGciOiJub25lIiwia2lkIjoiMSJ9.e30.", oidc.ErrTokenInvalid},
+	}
+
+	for _, tc := range cases {
+		if _, err := p.Verify(context.Background(), tc.raw, "nonce"); err != tc.err {
+			t.Errorf("%s: Expected %v. Recieved %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	fake := oidctest.New("client", "secret")
+	defer fake.Close()
+
+	p := oidc.NewProvider(fake.Config("test", redirectURL), http.DefaultClient)
+	old := fake.IDToken(fake.Claims(fake.Identity, "nonce"))
+	if _, err := p.Verify(context.Background(), old, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown keys are not fetched again right away
+	fake.RotateKey()
+	raw := fake.IDToken(fake.Claims(fake.Identity, "nonce"))
+	if _, err := p.Verify(context.Background(), raw, "nonce"); err != oidc.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound right after fetching. Recieved %v", err)
+	}
+
+	later := time.Now().Add(2 * time.Minute)
+	oidc.SetNow(p, func() time.Time { return later })
+	if _, err := p.Verify(context.Background(), raw, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the old key is no longer published
+	if _, err := p.Verify(context.Background(), old, "nonce"); err != oidc.ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound for a retired key. Recieved %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+// Package oidctest runs a fake OpenID Connect provider in
+// the test process, to test sign in flows without network
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"../../../photofriends/oidc"
+)
+
+// Identity is the user signing in at the provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is a provider that signs in Identity without
+// asking. Its keys are RSA, and the issuer is the URL of
+// the test server
+type Provider struct {
+	Server       *httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	// Identity is who the next codes are issued for
+	Identity Identity
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	kid   int
+	codes map[string]grant
+}
+
+// grant is an issued code and what it was issued for
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	identity    Identity
+}
+
+// New starts a provider for the client. Close it when done
+func New(clientID, clientSecret string) *Provider {
+	p := &Provider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Identity: Identity{
+			Subject:       "1234567890",
+			Email:         "jon@example.com",
+			EmailVerified: true,
+			Name:          "Jon Calhoun",
+		},
+		codes: make(map[string]grant),
+	}
+	p.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// Issuer returns the issuer URL of the provider
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// Config returns the config to use the provider with
+func (p *Provider) Config(name, redirectURL string) oidc.Config {
+	return oidc.Config{
+		Name:         name,
+		Issuer:       p.Issuer(),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+func (p *Provider) Close() {
+	p.Server.Close()
+}
+
+// RotateKey replaces the signing key, like
+// providers do from time to time
+func (p *Provider) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	p.mu.Lock()
+	p.key = key
+	p.kid++
+	p.mu.Unlock()
+}
+
+// Authorize acts like the user approving the sign in at
+// authURL. It returns the URL the provider redirects back to
+func (p *Provider) Authorize(authURL string) (string, error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		return "", fmt.Errorf("oidctest: authorize responded %d", res.StatusCode)
+	}
+
+	return res.Header.Get("Location"), nil
+}
+
+// IDToken signs claims with the current key, to
+// make tokens the real flow would never issue
+func (p *Provider) IDToken(claims map[string]interface{}) string {
+	p.mu.Lock()
+	key, kid := p.key, p.kid
+	p.mu.Unlock()
+
+	hdr, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": fmt.Sprint(kid)})
+	body, _ := json.Marshal(claims)
+	signed := encode(hdr) + "." + encode(body)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signed + "." + encode(sig)
+}
+
+// Claims returns the claims the provider puts in
+// the ID token of identity, issued now
+func (p *Provider) Claims(identity Identity, nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            p.Issuer(),
+		"sub":            identity.Subject,
+		"aud":            p.ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"name":           identity.Name,
+	}
+}
+
+func (p *Provider) discovery(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, map[string]string{
+		"issuer":                 p.Issuer(),
+		"authorization_endpoint": p.Issuer() + "/authorize",
+		"token_endpoint":         p.Issuer() + "/token",
+		"jwks_uri":               p.Issuer() + "/jwks",
+	})
+}
+
+func (p *Provider) authorize(res http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	if q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(res, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" {
+		http.Error(res, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := random()
+	p.mu.Lock()
+	p.codes[code] = grant{
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		identity:    p.Identity,
+	}
+	p.mu.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(res, req, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(res http.ResponseWriter, req *http.Request) {
+	id, secret, ok := req.BasicAuth()
+	if !ok || id != url.QueryEscape(p.ClientID) || secret != url.QueryEscape(p.ClientSecret) {
+		writeJSON(res, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if req.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(res, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	// codes can only be used once
+	p.mu.Lock()
+	code := req.PostFormValue("code")
+	g, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if !found || g.redirectURI != req.PostFormValue("redirect_uri") ||
+		oidc.Challenge(req.PostFormValue("code_verifier")) != g.challenge {
+		writeJSON(res, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(res, http.StatusOK, map[string]interface{}{
+		"access_token": random(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.IDToken(p.Claims(g.identity, g.nonce)),
+	})
+}
+
+func (p *Provider) jwks(res http.ResponseWriter, req *http.Request) {
+	p.mu.Lock()
+	key, kid := p.key, p.kid
+	p.mu.Unlock()
+
+	writeJSON(res, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": fmt.Sprint(kid),
+			"n":   encode(key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func random() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return encode(b)
+}
@@ -0,0 +1,101 @@
+// Package phash computes perceptual hashes of images, to tell
+// when two of them look the same or much alike, and looks
+// them up by their distance with a BK-tree or multi-index
+package phash
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+// Parts is the number of parts a Hash is split into to be
+// looked up in a multi-index, see Hash.Parts
+const Parts = 4
+
+// Hash is a perceptual hash of an image. Unlike a checksum,
+// images that look alike have hashes that differ in few
+// bits, even when they were resized or re-encoded
+type Hash uint64
+
+// DHash computes the difference hash of img. It is scaled
+// down to 9x8 pixels of gray, and every bit tells whether
+// a pixel is brighter than the one to its right. This
+// follows the gradients of the image, so it survives
+// changes in size, brightness and compression
+func DHash(img image.Image) Hash {
+	small := image.NewRGBA(image.Rect(0, 0, 9, 8))
+	draw.CatmullRom.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var h Hash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if luma(small, x, y) > luma(small, x+1, y) {
+				h |= 1
+			}
+		}
+	}
+
+	return h
+}
+
+// luma returns the brightness of the pixel at x, y
+func luma(img *image.RGBA, x, y int) uint32 {
+	c := img.RGBAAt(x, y)
+	return 299*uint32(c.R) + 587*uint32(c.G) + 114*uint32(c.B)
+}
+
+// Distance is the Hamming distance of a and b, the number
+// of bits they differ in. 0 means the images look the same
+func Distance(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// String formats h as 16 hex digits
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Parts splits h into Parts parts of 16 bits. When two hashes
+// are at most d bits apart, at least one of their parts is at
+// most d/Parts bits apart, so indexing the parts on their own
+// finds every hash near another without comparing all of them
+func (h Hash) Parts() [Parts]uint16 {
+	var parts [Parts]uint16
+	for i := range parts {
+		parts[i] = uint16(h >> (16 * uint(Parts-1-i)))
+	}
+
+	return parts
+}
+
+// Probes returns, for every part of h, the values within
+// maxDistance/Parts bits of it. A hash within maxDistance
+// of h has at least one part among the probes of that
+// part, see Hash.Parts
+func (h Hash) Probes(maxDistance int) [Parts][]uint16 {
+	var probes [Parts][]uint16
+	for i, part := range h.Parts() {
+		probes[i] = flips(part, 0, maxDistance/Parts)
+	}
+
+	return probes
+}
+
+// flips returns v along with every value that differs from
+// it in up to n of the bits from bit on
+func flips(v uint16, bit, n int) []uint16 {
+	values := []uint16{v}
+	if n == 0 {
+		return values
+	}
+
+	for b := bit; b < 16; b++ {
+		values = append(values, flips(v^1<<uint(b), b+1, n-1)...)
+	}
+
+	return values
+}
@@ -0,0 +1,145 @@
+package phash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// testingImage draws a few overlapping shapes, seeded
+// so every seed gives a picture of its own
+func testingImage(seed int64, width, height int) image.Image {
+	rnd := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), 128, 255})
+		}
+	}
+
+	for i := 0; i < 6; i++ {
+		x, y := rnd.Intn(width), rnd.Intn(height)
+		r := image.Rect(x, y, x+width/3, y+height/3)
+		c := color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255}
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	img := testingImage(1, 640, 480)
+	h := DHash(img)
+
+	small := image.NewRGBA(image.Rect(0, 0, 160, 120))
+	draw.CatmullRom.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, other := range map[string]image.Image{"resized": small, "compressed": compressed} {
+		if d := Distance(h, DHash(other)); d > 6 {
+			t.Errorf("%s: Expected a near duplicate. Recieved a distance of %d", name, d)
+		}
+	}
+
+	if d := Distance(h, DHash(testingImage(2, 640, 480))); d < 16 {
+		t.Errorf("Expected another image to be far away. Recieved a distance of %d", d)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		h := Hash(rnd.Uint64())
+		maxDistance := rnd.Intn(12)
+
+		// flip up to maxDistance random bits
+		other := h
+		for n := rnd.Intn(maxDistance + 1); n > 0; n-- {
+			other ^= 1 << uint(rnd.Intn(64))
+		}
+
+		found := false
+		probes := h.Probes(maxDistance)
+		for p, part := range other.Parts() {
+			for _, probe := range probes[p] {
+				found = found || probe == part
+			}
+		}
+
+		if !found {
+			t.Errorf("Expected %s to be probed within %d of %s", other, maxDistance, h)
+		}
+	}
+
+	if n := len(Hash(0).Probes(6)[0]); n != 17 {
+		t.Errorf("Expected a part and its 16 single bit flips. Recieved %d probes", n)
+	}
+}
+
+// TestTreeSearch compares searching a Tree
+// with comparing every hash
+func TestTreeSearch(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	hashes := make([]Hash, 2000)
+	var tree Tree
+	for i := range hashes {
+		if i > 0 && rnd.Intn(4) == 0 {
+			// exact and near duplicates of an earlier hash
+			hashes[i] = hashes[rnd.Intn(i)]
+			if rnd.Intn(2) == 0 {
+				hashes[i] ^= 1 << uint(rnd.Intn(64))
+			}
+		} else {
+			hashes[i] = Hash(rnd.Uint64())
+		}
+		tree.Add(hashes[i], uint(i))
+	}
+
+	if tree.Len() != len(hashes) {
+		t.Errorf("Expected %d hashes. Recieved %d", len(hashes), tree.Len())
+	}
+
+	for i := 0; i < 50; i++ {
+		h := hashes[rnd.Intn(len(hashes))] ^ Hash(rnd.Uint64())&Hash(rnd.Uint64())&Hash(rnd.Uint64())
+		maxDistance := rnd.Intn(24)
+
+		var want, got []int
+		for id, other := range hashes {
+			if Distance(h, other) <= maxDistance {
+				want = append(want, id)
+			}
+		}
+		for _, m := range tree.Search(h, maxDistance) {
+			if m.Distance != Distance(h, hashes[m.ID]) {
+				t.Errorf("Expected the distance of %d to be %d. Recieved %d", m.ID, Distance(h, hashes[m.ID]), m.Distance)
+			}
+			got = append(got, int(m.ID))
+		}
+		sort.Ints(got)
+
+		if len(want) != len(got) {
+			t.Errorf("Within %d of %s: Expected %v. Recieved %v", maxDistance, h, want, got)
+			continue
+		}
+		for n := range want {
+			if want[n] != got[n] {
+				t.Errorf("Within %d of %s: Expected %v. Recieved %v", maxDistance, h, want, got)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package phash
+
+// Tree is a BK-tree of hashes, used to find the hashes within
+// a distance of another without comparing all of them. Every
+// child of a node is keyed by its distance to the node, and
+// as Distance is a metric only children whose key is within
+// the distance searched for of the node can hold matches
+type Tree struct {
+	root *node
+	n    int
+}
+
+type node struct {
+	hash     Hash
+	ids      []uint
+	children map[int]*node
+}
+
+// Match is a hash found in a Tree, with the id it was added
+// with and its distance to the hash searched for
+type Match struct {
+	ID       uint
+	Hash     Hash
+	Distance int
+}
+
+// Add adds h to the tree, along with the id of
+// what it is the hash of. Ids may share a hash
+func (t *Tree) Add(h Hash, id uint) {
+	t.n++
+	if t.root == nil {
+		t.root = &node{hash: h, ids: []uint{id}}
+		return
+	}
+
+	n := t.root
+	for {
+		d := Distance(n.hash, h)
+		if d == 0 {
+			n.ids = append(n.ids, id)
+			return
+		}
+
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*node)
+			}
+			n.children[d] = &node{hash: h, ids: []uint{id}}
+			return
+		}
+		n = child
+	}
+}
+
+// Len is the number of ids added to the tree
+func (t *Tree) Len() int {
+	return t.n
+}
+
+// Search returns every id added with a hash at most
+// maxDistance bits from h, in no particular order
+func (t *Tree) Search(h Hash, maxDistance int) []Match {
+	if t.root == nil {
+		return nil
+	}
+
+	var matches []Match
+	stack := []*node{t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := Distance(n.hash, h)
+		if d <= maxDistance {
+			for _, id := range n.ids {
+				matches = append(matches, Match{ID: id, Hash: n.hash, Distance: d})
+			}
+		}
+
+		for key, child := range n.children {
+			if key >= d-maxDistance && key <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+
+	return matches
+}
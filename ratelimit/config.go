@@ -0,0 +1,31 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Config selects the Store backend
+type Config struct {
+	// Backend is either "memory" or "postgres". Use postgres
+	// when more than one instance of the app is running
+	Backend string `json:"backend" toml:"backend"`
+}
+
+// DefaultConfig keeps failures in memory
+func DefaultConfig() Config {
+	return Config{Backend: "memory"}
+}
+
+// NewStore creates the Store described by cfg. db
+// is only used by the postgres backend
+func NewStore(cfg Config, db *sql.DB) (Store, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemory(), nil
+	case "postgres":
+		return NewPostgres(db), nil
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryEntry is everything the memory store keeps for a key
+type memoryEntry struct {
+	failures    []time.Time
+	lockedUntil time.Time
+	lockouts    int
+}
+
+// Memory keeps failures in memory. It only limits
+// the instance it runs in, and forgets on restart
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+// ensure interface is matching
+var _ Store = &Memory{}
+
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]*memoryEntry)}
+}
+
+func (m *Memory) Fail(key string, now, since time.Time) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(now)
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = &memoryEntry{}
+		m.entries[key] = entry
+	}
+
+	entry.failures = append(entry.failures, now)
+	return entry.status(since), nil
+}
+
+func (m *Memory) Status(key string, since time.Time) (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return Status{}, nil
+	}
+
+	return entry.status(since), nil
+}
+
+func (m *Memory) Lock(key string, until time.Time, lockouts int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		entry = &memoryEntry{}
+		m.entries[key] = entry
+	}
+
+	entry.lockedUntil = until
+	entry.lockouts = lockouts
+	return nil
+}
+
+func (m *Memory) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// status drops the failures before since
+// and returns what is left of the entry
+func (e *memoryEntry) status(since time.Time) Status {
+	i := 0
+	for i < len(e.failures) && e.failures[i].Before(since) {
+		i++
+	}
+	e.failures = e.failures[i:]
+
+	return Status{
+		Failures:    len(e.failures),
+		LockedUntil: e.lockedUntil,
+		Lockouts:    e.lockouts,
+	}
+}
+
+// sweep forgets keys nothing happened to for maxAge, so
+// trying many different keys does not grow the map forever.
+// It runs at most once every maxAge
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < maxAge {
+		return
+	}
+	m.lastSweep = now
+
+	horizon := now.Add(-maxAge)
+	for key, entry := range m.entries {
+		last := entry.lockedUntil
+		if n := len(entry.failures); n > 0 && entry.failures[n-1].After(last) {
+			last = entry.failures[n-1]
+		}
+
+		if last.Before(horizon) {
+			delete(m.entries, key)
+		}
+	}
+}
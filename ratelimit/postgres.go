@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"time"
+)
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// ensure interface is matching
+var _ Store = &Postgres{}
+
+// Postgres keeps failures in the rate_limit_failures and
+// locks in the rate_limit_locks table, so every instance
+// of the app limits the same keys. The tables are created
+// by the migrations. Rows older than maxAge are deleted
+// whenever a failure is recorded
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) Fail(key string, now, since time.Time) (Status, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return Status{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO rate_limit_failures (key, failed_at) VALUES ($1, $2)", key, now)
+	if err != nil {
+		return Status{}, err
+	}
+
+	horizon := now.Add(-maxAge)
+	_, err = tx.Exec(`DELETE FROM rate_limit_failures
+		WHERE failed_at < $1 OR (key = $2 AND failed_at < $3)`, horizon, key, since)
+	if err != nil {
+		return Status{}, err
+	}
+
+	_, err = tx.Exec("DELETE FROM rate_limit_locks WHERE locked_until < $1", horizon)
+	if err != nil {
+		return Status{}, err
+	}
+
+	status, err := postgresStatus(tx, key, since)
+	if err != nil {
+		return Status{}, err
+	}
+
+	return status, tx.Commit()
+}
+
+func (p *Postgres) Status(key string, since time.Time) (Status, error) {
+	return postgresStatus(p.db, key, since)
+}
+
+func (p *Postgres) Lock(key string, until time.Time, lockouts int) error {
+	_, err := p.db.Exec(`INSERT INTO rate_limit_locks (key, locked_until, lockouts)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE
+		SET locked_until = EXCLUDED.locked_until, lockouts = EXCLUDED.lockouts`, key, until, lockouts)
+	return err
+}
+
+func (p *Postgres) Reset(key string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM rate_limit_failures WHERE key = $1", key); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM rate_limit_locks WHERE key = $1", key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func postgresStatus(q queryRower, key string, since time.Time) (Status, error) {
+	var status Status
+	err := q.QueryRow("SELECT count(*) FROM rate_limit_failures WHERE key = $1 AND failed_at >= $2",
+		key, since).Scan(&status.Failures)
+	if err != nil {
+		return Status{}, err
+	}
+
+	err = q.QueryRow("SELECT locked_until, lockouts FROM rate_limit_locks WHERE key = $1",
+		key).Scan(&status.LockedUntil, &status.Lockouts)
+	if err != nil && err != sql.ErrNoRows {
+		return Status{}, err
+	}
+
+	return status, nil
+}
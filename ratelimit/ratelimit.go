@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxAge is how long stores keep failures and locks around
+// at most. Policies with a longer Window do not work
+const maxAge = 24 * time.Hour
+
+// Status is what a Store knows about a key
+type Status struct {
+	// Failures is the number of failures inside the window
+	Failures int
+
+	// LockedUntil is when the last lockout ends or ended,
+	// zero if the key was never locked
+	LockedUntil time.Time
+
+	// Lockouts is how many times in a row the key was locked
+	Lockouts int
+}
+
+// Store keeps the recent failures and locks of every key.
+// The memory store is enough for a single instance, the
+// postgres store shares them between instances
+type Store interface {
+	// Fail records a failure of key at now, forgets the
+	// failures of key before since and returns its status
+	Fail(key string, now, since time.Time) (Status, error)
+
+	// Status returns the status of key, only
+	// counting failures after since
+	Status(key string, since time.Time) (Status, error)
+
+	// Lock locks key until until, and
+	// sets how many times it was locked
+	Lock(key string, until time.Time, lockouts int) error
+
+	// Reset forgets every failure and lock of key
+	Reset(key string) error
+}
+
+// Policy decides when a key gets locked, and for how long
+type Policy struct {
+	// Window is how far back failures are counted
+	Window time.Duration
+
+	// MaxFailures inside the Window lock the key
+	MaxFailures int
+
+	// Lockout is how long the first lockout lasts. Every
+	// lockout after that lasts twice as long as the one
+	// before, up to MaxLockout. Once a key stayed unlocked
+	// for a whole Window it starts over at Lockout
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+// lockout returns how long the key is locked
+// after it was already locked n times
+func (p Policy) lockout(n int) time.Duration {
+	d := p.Lockout
+	for i := 0; i < n && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+
+	if p.MaxLockout > 0 && d > p.MaxLockout {
+		d = p.MaxLockout
+	}
+
+	return d
+}
+
+// LimitedError is returned for keys that are locked
+type LimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("ratelimit: locked, retry after %s", e.RetryAfter)
+}
+
+// Public is the message shown to the
+// visitor who is being limited
+func (e *LimitedError) Public() string {
+	minutes := int((e.RetryAfter + time.Minute - 1) / time.Minute)
+	if minutes <= 1 {
+		return "Too many failed attempts. Please try again in a minute"
+	}
+
+	return fmt.Sprintf("Too many failed attempts. Please try again in %d minutes", minutes)
+}
+
+// Limiter applies policies to keys, like an IP address
+// or an email address, kept in a Store
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Check returns a *LimitedError if key is locked
+func (l *Limiter) Check(p Policy, key string) error {
+	now := l.now()
+	status, err := l.store.Status(key, now.Add(-p.Window))
+	if err != nil {
+		return err
+	}
+
+	if now.Before(status.LockedUntil) {
+		return &LimitedError{RetryAfter: status.LockedUntil.Sub(now)}
+	}
+
+	return nil
+}
+
+// Fail records a failure of key. When that makes too many
+// failures inside the window, the key is locked and a
+// *LimitedError is returned. Failures stay in the window
+// after the lock ends, so every further failure locks the
+// key again, for twice as long
+func (l *Limiter) Fail(p Policy, key string) error {
+	now := l.now()
+	status, err := l.store.Fail(key, now, now.Add(-p.Window))
+	if err != nil {
+		return err
+	}
+
+	if status.Failures < p.MaxFailures {
+		return nil
+	}
+
+	lockouts := status.Lockouts
+	if status.LockedUntil.Before(now.Add(-p.Window)) {
+		lockouts = 0
+	}
+
+	until := now.Add(p.lockout(lockouts))
+	if err := l.store.Lock(key, until, lockouts+1); err != nil {
+		return err
+	}
+
+	return &LimitedError{RetryAfter: until.Sub(now)}
+}
+
+// Reset forgets the failures and locks of key, eg: after
+// the owner of an account logged in successfully
+func (l *Limiter) Reset(key string) error {
+	return l.store.Reset(key)
+}
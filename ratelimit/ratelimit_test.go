@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var testingPolicy = Policy{
+	Window:      10 * time.Minute,
+	MaxFailures: 3,
+	Lockout:     time.Minute,
+	MaxLockout:  5 * time.Minute,
+}
+
+// testingLimiter returns a limiter using a memory
+// store and a clock that only moves when told to
+func testingLimiter() (*Limiter, *time.Time) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := New(NewMemory())
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func retryAfter(t *testing.T, err error) time.Duration {
+	limited, ok := err.(*LimitedError)
+	if !ok {
+		t.Fatalf("Expected a *LimitedError. Recieved %v", err)
+	}
+
+	return limited.RetryAfter
+}
+
+func TestLimiterLockout(t *testing.T) {
+	l, now := testingLimiter()
+	for i := 0; i < 2; i++ {
+		if err := l.Fail(testingPolicy, "key"); err != nil {
+			t.Fatalf("Expected failure %d to be allowed. Recieved %v", i+1, err)
+		}
+	}
+
+	if err := l.Check(testingPolicy, "key"); err != nil {
+		t.Fatalf("Expected key not to be locked yet. Recieved %v", err)
+	}
+
+	if d := retryAfter(t, l.Fail(testingPolicy, "key")); d != time.Minute {
+		t.Errorf("Expected the first lockout to last a minute. Recieved %s", d)
+	}
+
+	if d := retryAfter(t, l.Check(testingPolicy, "key")); d != time.Minute {
+		t.Errorf("Expected key to be locked for a minute. Recieved %s", d)
+	}
+
+	if err := l.Check(testingPolicy, "other"); err != nil {
+		t.Errorf("Expected other keys not to be locked. Recieved %v", err)
+	}
+
+	// the failures are still in the window, so every
+	// further failure locks again for twice as long
+	want := []time.Duration{2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for _, d := range want {
+		*now = now.Add(d)
+		if err := l.Check(testingPolicy, "key"); err != nil {
+			t.Fatalf("Expected the lockout to be over. Recieved %v", err)
+		}
+
+		if got := retryAfter(t, l.Fail(testingPolicy, "key")); got != d {
+			t.Errorf("Expected a lockout of %s. Recieved %s", d, got)
+		}
+	}
+}
+
+func TestLimiterSlidingWindow(t *testing.T) {
+	l, now := testingLimiter()
+	l.Fail(testingPolicy, "key")
+	*now = now.Add(6 * time.Minute)
+	l.Fail(testingPolicy, "key")
+	*now = now.Add(6 * time.Minute)
+
+	// the first failure slid out of the window
+	if err := l.Fail(testingPolicy, "key"); err != nil {
+		t.Fatalf("Expected only 2 failures in the window. Recieved %v", err)
+	}
+
+	retryAfter(t, l.Fail(testingPolicy, "key"))
+
+	// a whole window after the lockout, backoff starts over
+	*now = now.Add(time.Minute + testingPolicy.Window + time.Second)
+	for i := 0; i < 2; i++ {
+		l.Fail(testingPolicy, "key")
+	}
+
+	if d := retryAfter(t, l.Fail(testingPolicy, "key")); d != time.Minute {
+		t.Errorf("Expected the lockout to start over at a minute. Recieved %s", d)
+	}
+}
+
+func TestLimiterReset(t *testing.T) {
+	l, _ := testingLimiter()
+	for i := 0; i < 3; i++ {
+		l.Fail(testingPolicy, "key")
+	}
+
+	if err := l.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Check(testingPolicy, "key"); err != nil {
+		t.Errorf("Expected reset to unlock the key. Recieved %v", err)
+	}
+
+	if err := l.Fail(testingPolicy, "key"); err != nil {
+		t.Errorf("Expected reset to forget the failures. Recieved %v", err)
+	}
+}
+
+func TestMemorySweep(t *testing.T) {
+	m := NewMemory()
+	start := time.Now()
+	m.Fail("old", start, start.Add(-time.Minute))
+
+	later := start.Add(maxAge + time.Minute)
+	m.Fail("new", later, later.Add(-time.Minute))
+	if _, ok := m.entries["old"]; ok {
+		t.Error("Expected keys untouched for maxAge to be swept")
+	}
+
+	if _, ok := m.entries["new"]; !ok {
+		t.Error("Expected the key just failed to be kept")
+	}
+}
+
+func TestLimitedErrorPublic(t *testing.T) {
+	cases := map[time.Duration]string{
+		10 * time.Second:               "Too many failed attempts. Please try again in a minute",
+		time.Minute:                    "Too many failed attempts. Please try again in a minute",
+		4*time.Minute + 10*time.Second: "Too many failed attempts. Please try again in 5 minutes",
+	}
+	for d, want := range cases {
+		if got := (&LimitedError{RetryAfter: d}).Public(); got != want {
+			t.Errorf("%s: expected %q. Recieved %q", d, want, got)
+		}
+	}
+}
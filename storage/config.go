@@ -0,0 +1,40 @@
+package storage
+
+import "fmt"
+
+// Config selects and configures the storage backend
+type Config struct {
+	// Backend is either "local" or "s3"
+	Backend string `json:"backend" toml:"backend"`
+
+	// Dir, URLPrefix and Secret are used by the local
+	// backend. Secret signs the download URLs
+	Dir       string `json:"dir" toml:"dir"`
+	URLPrefix string `json:"url_prefix" toml:"url_prefix"`
+	Secret    string `json:"secret" toml:"secret"`
+
+	S3 S3Config `json:"s3" toml:"s3"`
+}
+
+// DefaultConfig stores images on local disk in ./images/,
+// served by the app itself under /images/
+func DefaultConfig() Config {
+	return Config{
+		Backend:   "local",
+		Dir:       "images",
+		URLPrefix: "/images/",
+		Secret:    "secret-image-url-key",
+	}
+}
+
+// New creates the Storage described by cfg
+func New(cfg Config) (Storage, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg.Dir, cfg.URLPrefix, cfg.Secret), nil
+	case "s3":
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("storage: unknown backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"../../photofriends/hash"
+)
+
+// localURLLifetime is how long a download URL handed out by
+// Local stays valid. URLs are stable within the same hour so
+// browsers can cache the images
+const localURLLifetime = time.Hour
+
+// NewLocal creates a Storage that keeps files on local disk
+// inside dir. urlPrefix is prepended to keys when building
+// download URLs, eg: "/images/" if Handler is served at
+// /images/. Download URLs are signed with secret
+func NewLocal(dir, urlPrefix, secret string) *Local {
+	return &Local{
+		dir:       dir,
+		urlPrefix: urlPrefix,
+		hmac:      hash.NewHMAC(secret),
+		now:       time.Now,
+	}
+}
+
+// ensure interface is matching
+var _ Storage = &Local{}
+
+// Local is a Storage backed by the local filesystem
+type Local struct {
+	dir       string
+	urlPrefix string
+	hmac      hash.HMAC
+	now       func() time.Time
+}
+
+// Put writes the contents of r to the file for key,
+// creating any missing directories along the way
+func (l *Local) Put(key string, r io.Reader, contentType string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(p)
+		return err
+	}
+
+	return f.Close()
+}
+
+// Get opens the file stored for key
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+// Delete removes the file stored for key
+func (l *Local) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// URL returns a signed path for key that Handler will serve
+// until it expires, much like a presigned S3 URL. This way
+// only visitors that were shown the URL can download a file
+func (l *Local) URL(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	expires := l.now().Truncate(localURLLifetime).Add(2 * localURLLifetime).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", l.sign(key, expires))
+
+	u := url.URL{Path: l.urlPrefix + key, RawQuery: q.Encode()}
+	return u.String(), nil
+}
+
+// Handler serves the files behind URLs returned by URL. It
+// must be mounted at the urlPrefix given to NewLocal
+func (l *Local) Handler() http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key, err := cleanKey(strings.TrimPrefix(req.URL.Path, l.urlPrefix))
+		if err != nil {
+			http.NotFound(res, req)
+			return
+		}
+
+		expires, err := strconv.ParseInt(req.URL.Query().Get("expires"), 10, 64)
+		if err != nil || l.now().Unix() > expires {
+			http.Error(res, "This link has expired", http.StatusForbidden)
+			return
+		}
+
+		sig := req.URL.Query().Get("sig")
+		if subtle.ConstantTimeCompare([]byte(sig), []byte(l.sign(key, expires))) != 1 {
+			http.Error(res, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		res.Header().Set("Cache-Control", "private, max-age=3600")
+		http.ServeFile(res, req, filepath.Join(l.dir, filepath.FromSlash(key)))
+	})
+}
+
+func (l *Local) sign(key string, expires int64) string {
+	return l.hmac.Hash(fmt.Sprintf("%s:%d", key, expires))
+}
+
+// path converts a key into a path on disk inside l.dir
+func (l *Local) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
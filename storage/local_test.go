@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalPutGetDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photofriends-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewLocal(dir, "/images/", "test-secret")
+	key := "galleries/1/images/2/my photo.jpg"
+
+	if err := store.Put(key, strings.NewReader("jpeg bytes"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "jpeg bytes" {
+		t.Errorf("Expected %q. Recieved %q", "jpeg bytes", b)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(key); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound. Recieved %v", err)
+	}
+}
+
+func TestLocalRejectsEscapingKeys(t *testing.T) {
+	store := NewLocal("images", "/images/", "test-secret")
+	for _, key := range []string{"", "/etc/passwd", "../secret", "a/../../b"} {
+		if err := store.Put(key, strings.NewReader(""), ""); err != ErrKeyInvalid {
+			t.Errorf("Put(%q): expected ErrKeyInvalid. Recieved %v", key, err)
+		}
+	}
+}
+
+func TestLocalHandlerChecksSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photofriends-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewLocal(dir, "/images/", "test-secret")
+	key := "galleries/1/images/2/my photo.jpg"
+	if err := store.Put(key, strings.NewReader("jpeg bytes"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := store.URL(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(url, "/images/galleries/1/images/2/my%20photo.jpg?") {
+		t.Errorf("Unexpected URL %q", url)
+	}
+
+	get := func(url string) int {
+		res := httptest.NewRecorder()
+		store.Handler().ServeHTTP(res, httptest.NewRequest("GET", url, nil))
+		return res.Code
+	}
+
+	if code := get(url); code != http.StatusOK {
+		t.Errorf("Expected 200 for a signed URL. Recieved %d", code)
+	}
+
+	if code := get("/images/galleries/1/images/2/my%20photo.jpg"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a signature. Recieved %d", code)
+	}
+
+	if code := get(strings.Replace(url, "images/2", "images/3", 1)); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another key. Recieved %d", code)
+	}
+
+	store.now = func() time.Time { return time.Now().Add(3 * time.Hour) }
+	if code := get(url); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for an expired URL. Recieved %d", code)
+	}
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3Config is used to configure an S3 compatible storage
+type S3Config struct {
+	// Endpoint is the base URL of the server, eg:
+	// https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Endpoint  string `json:"endpoint" toml:"endpoint"`
+	Region    string `json:"region" toml:"region"`
+	Bucket    string `json:"bucket" toml:"bucket"`
+	AccessKey string `json:"access_key" toml:"access_key"`
+	SecretKey string `json:"secret_key" toml:"secret_key"`
+
+	// URLExpiry is how long presigned download URLs stay valid
+	URLExpiry time.Duration `json:"url_expiry" toml:"url_expiry"`
+}
+
+// NewS3 creates a Storage that talks to an S3 compatible
+// server over its REST API. Objects are addressed path
+// style, eg: <endpoint>/<bucket>/<key>
+func NewS3(cfg S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: s3 endpoint %q must be an absolute URL", cfg.Endpoint)
+	}
+
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("storage: s3 bucket is required")
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if cfg.URLExpiry <= 0 {
+		cfg.URLExpiry = time.Hour
+	}
+
+	return &S3{
+		endpoint: endpoint,
+		bucket:   cfg.Bucket,
+		expiry:   cfg.URLExpiry,
+		signer: sigV4{
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			region:    cfg.Region,
+			service:   "s3",
+		},
+		client: http.DefaultClient,
+		now:    time.Now,
+	}, nil
+}
+
+// ensure interface is matching
+var _ Storage = &S3{}
+
+// S3 is a Storage backed by an S3 compatible object store
+type S3 struct {
+	endpoint *url.URL
+	bucket   string
+	expiry   time.Duration
+	signer   sigV4
+	client   *http.Client
+	now      func() time.Time
+}
+
+// Put uploads the contents of r to key. As the signature
+// covers the hash and length of the body, r is first copied
+// to a temporary file rather than held in memory
+func (s *S3) Put(key string, r io.Reader, contentType string) error {
+	body, err := ioutil.TempFile("", "photofriends-s3")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(body.Name())
+	defer body.Close()
+
+	hash := sha256.New()
+	length, err := io.Copy(io.MultiWriter(body, hash), r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// an empty body is sent as such, as a file
+	// would be sent chunked for lack of a length
+	var payload io.Reader = body
+	if length == 0 {
+		payload = http.NoBody
+	}
+
+	req, err := s.newRequest("PUT", key, payload)
+	if err != nil {
+		return err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.ContentLength = length
+
+	res, err := s.do(req, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// Get downloads the object stored under key
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	req, err := s.newRequest("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// Delete removes the object stored under key
+func (s *S3) Delete(key string) error {
+	req, err := s.newRequest("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req, emptyPayloadHash)
+	if err == ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// URL returns a presigned GET URL for key, so visitors can
+// download private objects until the URL expires
+func (s *S3) URL(key string) (string, error) {
+	req, err := s.newRequest("GET", key, nil)
+	if err != nil {
+		return "", err
+	}
+
+	s.signer.presign(req, s.expiry, s.now())
+	return req.URL.String(), nil
+}
+
+func (s *S3) newRequest(method, key string, body io.Reader) (*http.Request, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *s.endpoint
+	u.Path = u.Path + "/" + s.bucket + "/" + key
+	return http.NewRequest(method, u.String(), body)
+}
+
+// do signs and sends req, turning any non 2xx response
+// into an error. The caller must close the response body
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.signer.sign(req, payloadHash, s.now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return nil, fmt.Errorf("storage: s3 %s %s: %s: %s", req.Method, req.URL.Path, res.Status, bytes.TrimSpace(msg))
+}
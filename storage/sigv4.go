@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	sigV4DateFormat = "20060102"
+
+	// unsignedPayload is used in place of the payload
+	// hash for presigned URLs, as the body is unknown
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// emptyPayloadHash is the hex encoded SHA256 of an empty body
+var emptyPayloadHash = hashHex(nil)
+
+// sigV4 signs requests using AWS Signature Version 4, which
+// is what S3 and every S3 compatible server expects
+//
+// See: https://docs.aws.amazon.com/general/latest/gr/signature-version-4.html
+type sigV4 struct {
+	accessKey string
+	secretKey string
+	region    string
+	service   string
+}
+
+// sign adds the X-Amz-Date, X-Amz-Content-Sha256 and
+// Authorization headers to req. payloadHash is the hex
+// encoded SHA256 of the request body
+func (s sigV4) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := signedHeaders(req.Header)
+	cr := canonicalRequest(req, signed, payloadHash)
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.accessKey, s.scope(t), strings.Join(signed, ";"), s.signature(t, cr)))
+}
+
+// presign adds the query parameters that turn req into a
+// presigned URL which is valid for the expires duration
+func (s sigV4) presign(req *http.Request, expires time.Duration, t time.Time) {
+	t = t.UTC()
+	q := req.URL.Query()
+	q.Set("X-Amz-Algorithm", sigV4Algorithm)
+	q.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(t))
+	q.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	q.Set("X-Amz-Expires", fmt.Sprintf("%d", int64(expires/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	req.URL.RawQuery = canonicalQuery(q)
+
+	cr := canonicalRequest(req, []string{"host"}, unsignedPayload)
+	req.URL.RawQuery += "&X-Amz-Signature=" + s.signature(t, cr)
+}
+
+// scope is the credential scope, eg: 20130524/us-east-1/s3/aws4_request
+func (s sigV4) scope(t time.Time) string {
+	return strings.Join([]string{t.Format(sigV4DateFormat), s.region, s.service, "aws4_request"}, "/")
+}
+
+// signature derives the signing key for the day of t
+// and uses it to sign the canonical request
+func (s sigV4) signature(t time.Time, canonicalRequest string) string {
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.Format(sigV4TimeFormat),
+		s.scope(t),
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), t.Format(sigV4DateFormat))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalRequest builds the canonical form of req, only
+// including the headers listed in signed
+func canonicalRequest(req *http.Request, signed []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.Host
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// signedHeaders lists the lower cased names of the headers
+// we sign: host, content headers, range and every x-amz-*
+func signedHeaders(h http.Header) []string {
+	signed := []string{"host"}
+	for name := range h {
+		name = strings.ToLower(name)
+		switch {
+		case name == "content-type", name == "content-md5", name == "range",
+			strings.HasPrefix(name, "x-amz-"):
+			signed = append(signed, name)
+		}
+	}
+
+	sort.Strings(signed)
+	return signed
+}
+
+// canonicalQuery sorts and encodes the query parameters,
+// leaving out the signature of presigned URLs
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		if k != "X-Amz-Signature" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), q[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent encodes every byte except the unreserved
+// characters. Slashes are kept as is unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
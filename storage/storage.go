@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when no object
+	// is stored under the requested key
+	ErrNotFound = errors.New("storage: object not found")
+
+	// ErrKeyInvalid is returned when a key is empty or
+	// tries to escape the storage root, eg: "../secret"
+	ErrKeyInvalid = errors.New("storage: invalid key")
+)
+
+// Storage is used to store and retrieve files such as
+// uploaded images. Keys are slash separated paths like
+// "galleries/1/images/2/photo.jpg", and every backend
+// is expected to treat them the same way
+type Storage interface {
+	// Put stores everything read from r under key,
+	// replacing whatever was stored there before
+	Put(key string, r io.Reader, contentType string) error
+
+	// Get opens the object stored under key. It is up
+	// to the caller to close the returned reader
+	Get(key string) (io.ReadCloser, error)
+
+	// Delete removes the object stored under key. Deleting
+	// a key that does not exist is not an error
+	Delete(key string) error
+
+	// URL returns the URL visitors can use to download
+	// the object stored under key
+	URL(key string) (string, error)
+}
+
+// cleanKey makes sure a key is a relative, slash separated
+// path that stays inside the storage root
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", ErrKeyInvalid
+	}
+
+	cleaned := path.Clean(key)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrKeyInvalid
+	}
+
+	return cleaned, nil
+}
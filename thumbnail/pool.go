@@ -0,0 +1,75 @@
+package thumbnail
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned when submitting a job
+// to a pool that has already been closed
+var ErrPoolClosed = errors.New("thumbnail: pool is closed")
+
+// NewPool starts a pool of workers goroutines sharing a
+// queue of up to queueSize pending jobs. The fixed number
+// of workers keeps the number of images decoded in memory
+// at the same time bounded, no matter how many are uploaded
+func NewPool(workers, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &Pool{
+		jobs: make(chan func(), queueSize),
+	}
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Pool runs jobs in the background on a fixed set of workers
+type Pool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Submit queues job to be run by one of the workers. It only
+// blocks when the queue is full, which applies back pressure
+// to uploads rather than letting the queue grow without bound
+func (p *Pool) Submit(job func()) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	p.jobs <- job
+	return nil
+}
+
+// Close stops accepting new jobs and waits for
+// every queued job to finish
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		job()
+	}
+}
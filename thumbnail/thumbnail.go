@@ -0,0 +1,65 @@
+package thumbnail
+
+import (
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrFormatUnsupported is returned when encoding to
+// a format other than jpeg, png or gif
+var ErrFormatUnsupported = errors.New("thumbnail: unsupported image format")
+
+// Size is a derived size generated for every uploaded
+// image. Images are scaled down to Width pixels wide
+// and the height follows from the aspect ratio
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes are the derived sizes we generate, smallest first
+var Sizes = []Size{
+	{Name: "thumb", Width: 200},
+	{Name: "medium", Width: 800},
+	{Name: "large", Width: 1600},
+}
+
+// Resize scales img down to width pixels wide while keeping
+// the aspect ratio. Images that are already narrow enough
+// are returned as is, we never scale up
+func Resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if width <= 0 || b.Dx() <= width {
+		return img
+	}
+
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// Encode writes img to w in the given format, which is
+// one of the names returned by image.Decode
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return ErrFormatUnsupported
+	}
+}
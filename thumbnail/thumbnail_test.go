@@ -0,0 +1,56 @@
+package thumbnail
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResizeKeepsAspectRatio(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1200, 900))
+
+	got := Resize(img, 200).Bounds()
+	if got.Dx() != 200 || got.Dy() != 150 {
+		t.Errorf("Expected 200x150. Recieved %dx%d", got.Dx(), got.Dy())
+	}
+}
+
+func TestResizeNeverScalesUp(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+
+	if got := Resize(img, 800); got != image.Image(img) {
+		t.Errorf("Expected the original image back. Recieved %v", got.Bounds())
+	}
+}
+
+func TestPoolBoundsConcurrency(t *testing.T) {
+	const workers = 3
+	pool := NewPool(workers, 1)
+
+	var running, peak int32
+	var mu sync.Mutex
+	for i := 0; i < 20; i++ {
+		err := pool.Submit(func() {
+			n := atomic.AddInt32(&running, 1)
+			mu.Lock()
+			if n > peak {
+				peak = n
+			}
+			mu.Unlock()
+			atomic.AddInt32(&running, -1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.Close()
+
+	if peak > workers {
+		t.Errorf("Expected at most %d jobs at once. Recieved %d", workers, peak)
+	}
+
+	if err := pool.Submit(func() {}); err != ErrPoolClosed {
+		t.Errorf("Expected ErrPoolClosed. Recieved %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package totp implements time based one time passwords as
+// described in RFC 6238, the codes shown by authenticator
+// apps. Every function takes the time to use, rather than
+// reading the clock, so codes can be tested with a fixed one
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"../../photofriends/rand"
+)
+
+const (
+	// Digits is the length of a code
+	Digits = 6
+
+	// Period is how long a code is valid for
+	Period = 30 * time.Second
+
+	// SecretBytes is the length of new secrets. RFC 4226
+	// recommends 160 bits, the size of a SHA1 hash
+	SecretBytes = 20
+)
+
+// ErrSecretInvalid is returned for secrets
+// that are not valid base32
+var ErrSecretInvalid = errors.New("totp: secret is not valid base32")
+
+// encoding is the unpadded base32 authenticator apps expect
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random base32 encoded secret
+func NewSecret() (string, error) {
+	b, err := rand.Bytes(SecretBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the time step t falls in. Codes
+// are the HOTP of the step, which is the counter
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code for secret at t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, uint64(Step(t)), Digits), nil
+}
+
+// Validate checks code against the codes of the steps around
+// t, allowing for skew steps of clock drift either way. The
+// step that matched is returned, so callers can refuse codes
+// from steps that were already used
+func Validate(secret, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for i := -skew; i <= skew; i++ {
+		step := now + int64(i)
+		if step < 0 {
+			continue
+		}
+
+		want := hotp(key, uint64(step), Digits)
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// URL returns the otpauth:// URL authenticator apps read from
+// QR codes. issuer is the name of the site, account is shown
+// next to it, usually the email address
+func URL(issuer, account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period / time.Second))},
+	}
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// decodeSecret accepts secrets with or without padding,
+// in any case and with spaces, as people type them
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := encoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrSecretInvalid
+	}
+
+	return key, nil
+}
+
+// hotp is the HMAC based one time password of RFC 4226
+func hotp(key []byte, counter uint64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, the low 4 bits of the last byte
+	// pick where the 31 bit number is read from
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, n%mod)
+}
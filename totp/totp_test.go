@@ -0,0 +1,124 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcKey is the SHA1 key used by the test
+// vectors of both RFC 4226 and RFC 6238
+var rfcKey = []byte("12345678901234567890")
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+	for counter, code := range want {
+		if got := hotp(rfcKey, uint64(counter), 6); got != code {
+			t.Errorf("Counter %d: expected %s. Recieved %s", counter, code, got)
+		}
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA1 rows
+	cases := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, code := range cases {
+		step := Step(time.Unix(unix, 0))
+		if got := hotp(rfcKey, uint64(step), 8); got != code {
+			t.Errorf("Time %d: expected %s. Recieved %s", unix, code, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := encoding.EncodeToString(rfcKey)
+	now := time.Unix(1111111111, 0)
+
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code != "050471" {
+		t.Errorf("Expected the last 6 digits of the RFC vector. Recieved %s", code)
+	}
+
+	step, ok := Validate(secret, code, now, 1)
+	if !ok || step != Step(now) {
+		t.Errorf("Expected the code to match the current step. Recieved %d, %v", step, ok)
+	}
+
+	// a code from the previous step is accepted within the skew
+	if step, ok := Validate(secret, code, now.Add(Period), 1); !ok || step != Step(now) {
+		t.Errorf("Expected a code one step old to be accepted. Recieved %d, %v", step, ok)
+	}
+
+	if _, ok := Validate(secret, code, now.Add(2*Period), 1); ok {
+		t.Error("Expected a code two steps old to be refused")
+	}
+
+	if _, ok := Validate(secret, code, now.Add(Period), 0); ok {
+		t.Error("Expected no skew to refuse the previous code")
+	}
+
+	// people type secrets and codes with spaces
+	spaced := "gezd gnbv gy3t qojq gezd gnbv gy3t qojq"
+	if _, ok := Validate(spaced, "050 471", now, 0); !ok {
+		t.Error("Expected spaces and lower case to be accepted")
+	}
+
+	for _, bad := range []string{"", "05047", "0504710", "abcdef"} {
+		if _, ok := Validate(secret, bad, now, 1); ok {
+			t.Errorf("Expected %q to be refused", bad)
+		}
+	}
+
+	if _, err := Code("not base32!", now); err != ErrSecretInvalid {
+		t.Errorf("Expected ErrSecretInvalid. Recieved %v", err)
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := NewSecret()
+	if a == b {
+		t.Error("Expected secrets to be random")
+	}
+
+	key, err := decodeSecret(a)
+	if err != nil || len(key) != SecretBytes {
+		t.Errorf("Expected %d bytes of secret. Recieved %d, %v", SecretBytes, len(key), err)
+	}
+}
+
+func TestURL(t *testing.T) {
+	raw := URL("photofriends", "jon@example.com", "GEZDGNBV")
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/photofriends:jon@example.com" {
+		t.Errorf("Unexpected URL %s", raw)
+	}
+
+	q := u.Query()
+	if q.Get("secret") != "GEZDGNBV" || q.Get("issuer") != "photofriends" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("Unexpected parameters %v", q)
+	}
+}
@@ -0,0 +1,26 @@
+package views
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"../context"
+)
+
+// CSRFFieldName is the name of the hidden form
+// field csrfField renders the CSRF token in
+const CSRFFieldName = "csrf_token"
+
+// csrfField returns the hidden input every form that posts
+// back to the app has to include, as {{csrfField}}. It is
+// empty when the CSRF middleware did not run for req
+func csrfField(req *http.Request) template.HTML {
+	token := context.CSRFToken(req.Context())
+	if token == "" {
+		return ""
+	}
+
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		CSRFFieldName, template.HTMLEscapeString(token)))
+}
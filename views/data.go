@@ -0,0 +1,86 @@
+package views
+
+import (
+	"log"
+
+	"../../photofriends/models"
+)
+
+const (
+	// Alert levels, they match the Bulma notification colors
+	AlertLvlError   = "danger"
+	AlertLvlWarning = "warning"
+	AlertLvlInfo    = "info"
+	AlertLvlSuccess = "success"
+
+	// AlertMsgGeneric is shown for every error
+	// that is not meant to be seen by users
+	AlertMsgGeneric = "Something went wrong. Please try again, and contact us if the problem persists."
+)
+
+// PublicError is an error with a message that is safe
+// to show to users. Every other error is logged and
+// shown as AlertMsgGeneric
+type PublicError interface {
+	error
+	Public() string
+}
+
+// publicError is a PublicError created by controllers
+type publicError string
+
+func (e publicError) Error() string {
+	return string(e)
+}
+
+func (e publicError) Public() string {
+	return string(e)
+}
+
+// NewPublicError returns an error whose message is shown as is
+func NewPublicError(msg string) error {
+	return publicError(msg)
+}
+
+// Alert is a message shown at the top of the page
+type Alert struct {
+	Level   string
+	Message string
+}
+
+// ErrorAlert returns the alert shown for err
+func ErrorAlert(err error) Alert {
+	if pErr, ok := err.(PublicError); ok {
+		return Alert{Level: AlertLvlError, Message: pErr.Public()}
+	}
+
+	log.Println(err)
+	return Alert{Level: AlertLvlError, Message: AlertMsgGeneric}
+}
+
+// Data is what the layout is rendered with. Yield holds
+// the data of the page template itself
+type Data struct {
+	Alert *Alert
+	User  *models.User
+	Yield interface{}
+}
+
+// SetAlert shows err, see ErrorAlert. When err is a
+// FieldError and the Yield a *Form, the message of each
+// field is also set on the form
+func (d *Data) SetAlert(err error) {
+	if fErr, ok := err.(FieldError); ok {
+		if form, ok := d.Yield.(*Form); ok {
+			form.Errors = fErr.Fields()
+		}
+	}
+
+	alert := ErrorAlert(err)
+	d.Alert = &alert
+}
+
+// AlertError shows msg as an error
+func (d *Data) AlertError(msg string) {
+	d.Alert = &Alert{Level: AlertLvlError, Message: msg}
+}
@@ -0,0 +1,87 @@
+package views
+
+import (
+	"encoding/base64"
+	"net/http"
+	"time"
+)
+
+const (
+	alertLevelCookie   = "alert_level"
+	alertMessageCookie = "alert_message"
+
+	// alertLifetime is how long a flash alert waits for
+	// the next page to be rendered before it is dropped
+	alertLifetime = 5 * time.Minute
+)
+
+// RedirectAlert redirects like http.Redirect, and stores the
+// alert in cookies so it is shown on the page redirected to
+func RedirectAlert(res http.ResponseWriter, req *http.Request, url string, code int, alert Alert) {
+	persistAlert(res, alert)
+	http.Redirect(res, req, url, code)
+}
+
+// RedirectError redirects with the alert for err, see ErrorAlert
+func RedirectError(res http.ResponseWriter, req *http.Request, url string, err error) {
+	RedirectAlert(res, req, url, http.StatusFound, ErrorAlert(err))
+}
+
+func persistAlert(res http.ResponseWriter, alert Alert) {
+	expires := time.Now().Add(alertLifetime)
+	http.SetCookie(res, &http.Cookie{
+		Name:     alertLevelCookie,
+		Value:    alert.Level,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+	})
+
+	// the message may hold characters that are not allowed in cookies
+	http.SetCookie(res, &http.Cookie{
+		Name:     alertMessageCookie,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(alert.Message)),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+	})
+}
+
+func clearAlert(res http.ResponseWriter) {
+	for _, name := range []string{alertLevelCookie, alertMessageCookie} {
+		http.SetCookie(res, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			Expires:  time.Unix(0, 0),
+			MaxAge:   -1,
+			HttpOnly: true,
+		})
+	}
+}
+
+// getAlert returns the flash alert of the request, if any
+func getAlert(req *http.Request) *Alert {
+	level, err := req.Cookie(alertLevelCookie)
+	if err != nil {
+		return nil
+	}
+
+	message, err := req.Cookie(alertMessageCookie)
+	if err != nil {
+		return nil
+	}
+
+	msg, err := base64.RawURLEncoding.DecodeString(message.Value)
+	if err != nil {
+		return nil
+	}
+
+	switch level.Value {
+	case AlertLvlError, AlertLvlWarning, AlertLvlInfo, AlertLvlSuccess:
+	default:
+		return nil
+	}
+
+	return &Alert{Level: level.Value, Message: string(msg)}
+}
@@ -0,0 +1,57 @@
+package views
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAlertCookies(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/galleries/1/delete", nil)
+	alert := Alert{Level: AlertLvlSuccess, Message: `Gallery "Summer; 2019" deleted`}
+	RedirectAlert(rec, req, "/galleries", http.StatusFound, alert)
+
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/galleries" {
+		t.Fatalf("Expected a redirect to /galleries. Recieved %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	next := httptest.NewRequest("GET", "/galleries", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+
+	got := getAlert(next)
+	if got == nil || *got != alert {
+		t.Errorf("Expected %+v to survive the redirect. Recieved %+v", alert, got)
+	}
+
+	cleared := httptest.NewRecorder()
+	clearAlert(cleared)
+	for _, cookie := range cleared.Result().Cookies() {
+		if cookie.MaxAge >= 0 {
+			t.Errorf("Expected cookie %s to be deleted", cookie.Name)
+		}
+	}
+}
+
+func TestAlertCookiesInvalidLevel(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: alertLevelCookie, Value: "is-danger onclick"})
+	req.AddCookie(&http.Cookie{Name: alertMessageCookie, Value: "aGk"})
+
+	if alert := getAlert(req); alert != nil {
+		t.Errorf("Expected an unknown level to be ignored. Recieved %+v", alert)
+	}
+}
+
+func TestErrorAlert(t *testing.T) {
+	if alert := ErrorAlert(NewPublicError("Gallery not found")); alert.Message != "Gallery not found" {
+		t.Errorf("Expected public errors to be shown as is. Recieved %q", alert.Message)
+	}
+
+	if alert := ErrorAlert(errors.New("pq: relation does not exist")); alert.Message != AlertMsgGeneric {
+		t.Errorf("Expected other errors to be hidden. Recieved %q", alert.Message)
+	}
+}
@@ -0,0 +1,25 @@
+package views
+
+// FieldError is a PublicError about specific form
+// fields. Fields maps field names to their message
+type FieldError interface {
+	PublicError
+	Fields() map[string]string
+}
+
+// Form is the Yield of pages with a form. Values holds
+// what was submitted, so the form can be filled in again,
+// and Errors the message for every invalid field
+type Form struct {
+	Values interface{}
+	Errors map[string]string
+}
+
+// Error returns the message for the field, "" if it is valid
+func (f *Form) Error(field string) string {
+	if f == nil {
+		return ""
+	}
+
+	return f.Errors[field]
+}
@@ -1,9 +1,16 @@
 package views
 
 import (
+	"bytes"
+	"errors"
 	"html/template"
+	"io"
+	"log"
 	"path/filepath"
 	"net/http"
+	"sync"
+
+	"../context"
 )
 
 var (
@@ -21,8 +28,14 @@ func NewView(layout string, files ...string) *View {
 	addTemplateExt(files)
 	files = append(files, layoutFiles()...)
 
-	t, err := template.ParseFiles(files...) // spread strings from slice
-	if err != nil { panic(nil) }
+	// csrfField is replaced for every request in RenderStatus,
+	// but has to exist while parsing
+	t, err := template.New("").Funcs(template.FuncMap{
+		"csrfField": func() (template.HTML, error) {
+			return "", errors.New("csrfField is not implemented")
+		},
+	}).ParseFiles(files...) // spread strings from slice
+	if err != nil { panic(err) }
 
 	return &View {
 		Layout: layout,
@@ -32,22 +45,87 @@ func NewView(layout string, files ...string) *View {
 
 // View structure to initialize "n" amount
 // of passed inn template paths aswell as
-// a layout string resulting in a yield to render
+// a layout string resulting in a yield to render.
+// Template itself is never executed, every render
+// works on a clone with the request's csrfField
 type View struct {
 	Layout string
 	Template *template.Template
 }
 
 func (v *View) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	if err := v.Render(res, nil); err != nil {
-		panic(nil)
+	v.Render(res, req, nil)
+}
+
+// Render is used to render the view with the predefined layout.
+// data is used as the Yield unless it already is a Data. The
+// current user and any flash alert are filled in from req
+func (v *View) Render(res http.ResponseWriter, req *http.Request, data interface{}) {
+	v.RenderStatus(res, req, http.StatusOK, data)
+}
+
+// RenderStatus works like Render, but responds with status
+func (v *View) RenderStatus(res http.ResponseWriter, req *http.Request, status int, data interface{}) {
+	var vd Data
+	switch d := data.(type) {
+	case Data:
+		vd = d
+	case *Data:
+		vd = *d
+	default:
+		vd = Data{Yield: data}
+	}
+
+	if alert := getAlert(req); alert != nil {
+		clearAlert(res)
+		if vd.Alert == nil {
+			vd.Alert = alert
+		}
+	}
+	vd.User = context.User(req.Context())
+
+	tpl, err := v.Template.Clone()
+	if err != nil {
+		log.Println(err)
+		http.Error(res, AlertMsgGeneric, http.StatusInternalServerError)
+		return
 	}
+
+	tpl.Funcs(template.FuncMap{
+		"csrfField": func() template.HTML {
+			return csrfField(req)
+		},
+	})
+
+	// render into a buffer first, so a failing template
+	// does not leave half a page behind
+	var buf bytes.Buffer
+	if err := tpl.ExecuteTemplate(&buf, v.Layout, vd); err != nil {
+		log.Println(err)
+		http.Error(res, AlertMsgGeneric, http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(status)
+	io.Copy(res, &buf)
 }
 
-// Render is used to render the view with the predefined layout
-func (v *View) Render(res http.ResponseWriter, data interface{}) error {
-	res.Header().Set("Content-Type", "text/html")
-	return v.Template.ExecuteTemplate(res, v.Layout, data)
+var (
+	errorView     *View
+	errorViewOnce sync.Once
+)
+
+// Error renders the alert for err on an otherwise
+// empty page in the layout, see ErrorAlert
+func Error(res http.ResponseWriter, req *http.Request, status int, err error) {
+	errorViewOnce.Do(func() {
+		errorView = NewView("layout", "static/error")
+	})
+
+	var vd Data
+	vd.SetAlert(err)
+	errorView.RenderStatus(res, req, status, vd)
 }
 
 // layout files return a slice of strings
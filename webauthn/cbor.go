@@ -0,0 +1,181 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// maxCBORDepth limits how deeply nested the
+// CBOR sent by authenticators may be
+const maxCBORDepth = 16
+
+var errCBOR = errors.New("webauthn: malformed CBOR")
+
+// cborDecode decodes the first CBOR item in data, and returns
+// it with the number of bytes it took. It covers what
+// authenticators send: integers, byte and text strings,
+// arrays, maps, booleans, null and floats, all of definite
+// length. Integers decode to int64 so map keys can be looked
+// up as such, maps decode to map[interface{}]interface{}
+func cborDecode(data []byte) (interface{}, int, error) {
+	d := cborDecoder{data: data}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return v, d.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errCBOR
+	}
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		return d.bytes(arg)
+	case 3:
+		b, err := d.bytes(arg)
+		return string(b), err
+	case 4:
+		// every item takes at least a byte, which
+		// stops huge lengths from allocating
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+
+		items := make([]interface{}, arg)
+		for i := range items {
+			if items[i], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos)/2 {
+			return nil, errCBOR
+		}
+
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errCBOR
+			}
+
+			if m[key], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case 6:
+		// tags carry no meaning for us, decode what they tag
+		return d.decode(depth + 1)
+	default:
+		return d.simple(arg)
+	}
+}
+
+// head reads the initial byte and the argument that follows it
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errCBOR
+	}
+
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		// reserved values and indefinite lengths
+		return 0, 0, errCBOR
+	}
+
+	if len(d.data)-d.pos < size {
+		return 0, 0, errCBOR
+	}
+
+	var buf [8]byte
+	copy(buf[8-size:], d.data[d.pos:d.pos+size])
+	d.pos += size
+
+	// floats keep the argument size in the major type 7 value
+	if major == 7 {
+		return major, uint64(size)<<56 | binary.BigEndian.Uint64(buf[:]), nil
+	}
+
+	return major, binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBOR
+	}
+
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// simple decodes major type 7, where head put the size of a
+// following argument in the top byte of arg
+func (d *cborDecoder) simple(arg uint64) (interface{}, error) {
+	size, value := arg>>56, arg&(1<<56-1)
+	switch size {
+	case 0:
+		switch value {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+	case 4:
+		return float64(math.Float32frombits(uint32(value))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(d.data[d.pos-8 : d.pos])), nil
+	}
+
+	return nil, errCBOR
+}
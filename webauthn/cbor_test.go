@@ -0,0 +1,50 @@
+package webauthn
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCBORDecode(t *testing.T) {
+	cases := []struct {
+		in   []byte
+		want interface{}
+	}{
+		{[]byte{0x17}, int64(23)},
+		{[]byte{0x18, 0x64}, int64(100)},
+		{[]byte{0x39, 0x01, 0x00}, int64(-257)},
+		{[]byte{0x43, 1, 2, 3}, []byte{1, 2, 3}},
+		{[]byte{0x63, 'f', 'm', 't'}, "fmt"},
+		{[]byte{0x82, 0x01, 0x20}, []interface{}{int64(1), int64(-1)}},
+		{[]byte{0xa1, 0x01, 0xf5}, map[interface{}]interface{}{int64(1): true}},
+		{[]byte{0xf6}, nil},
+		{[]byte{0xfa, 0x3f, 0xc0, 0x00, 0x00}, 1.5},
+	}
+	for _, c := range cases {
+		got, n, err := cborDecode(c.in)
+		if err != nil || n != len(c.in) || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Decoding % x: expected %#v. Recieved %#v, %d, %v", c.in, c.want, got, n, err)
+		}
+	}
+
+	// the length tells where the next item starts
+	if _, n, err := cborDecode([]byte{0x01, 0x02}); n != 1 || err != nil {
+		t.Errorf("Expected 1 byte to be used. Recieved %d, %v", n, err)
+	}
+
+	bad := map[string][]byte{
+		"empty":              {},
+		"truncated string":   {0x45, 1, 2},
+		"truncated argument": {0x19, 0x01},
+		"indefinite length":  {0x5f, 0x41, 0x01, 0xff},
+		"huge array":         {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"array map key":      {0xa1, 0x80, 0x01},
+		"too deep":           bytes.Repeat([]byte{0x81}, maxCBORDepth+2),
+	}
+	for name, in := range bad {
+		if _, _, err := cborDecode(in); err != errCBOR {
+			t.Errorf("%s: expected errCBOR. Recieved %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers, as registered with IANA
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// minRSABits is the smallest RSA key accepted
+const minRSABits = 2048
+
+var (
+	// ErrKeyUnsupported is returned for public keys of
+	// an algorithm or curve we do not verify
+	ErrKeyUnsupported = errors.New("webauthn: unsupported public key")
+
+	errKeyInvalid = errors.New("webauthn: malformed public key")
+)
+
+// COSE key parameters, see RFC 8152 section 7
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// publicKey is a credential public key ready to verify with
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey parses a COSE_Key as found in the attested
+// credential data. It returns the number of bytes it took,
+// since the key is followed by extensions
+func parsePublicKey(data []byte) (*publicKey, int, error) {
+	v, n, err := cborDecode(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errKeyInvalid
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 {
+			return nil, 0, ErrKeyUnsupported
+		}
+
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, errKeyInvalid
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, errKeyInvalid
+		}
+
+		return &publicKey{alg: alg, key: key}, n, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 {
+			return nil, 0, ErrKeyUnsupported
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, errKeyInvalid
+		}
+
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, n, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		nb, _ := m[int64(coseN)].([]byte)
+		eb, _ := m[int64(coseE)].([]byte)
+		if len(eb) == 0 || len(eb) > 4 {
+			return nil, 0, errKeyInvalid
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nb),
+			E: int(new(big.Int).SetBytes(eb).Int64()),
+		}
+		if key.N.BitLen() < minRSABits || key.E < 3 {
+			return nil, 0, ErrKeyUnsupported
+		}
+
+		return &publicKey{alg: alg, key: key}, n, nil
+	}
+
+	return nil, 0, ErrKeyUnsupported
+}
+
+// verify checks sig is the signature of signed by the key
+func (pk *publicKey) verify(signed, sig []byte) bool {
+	switch key := pk.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, signed, sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+
+	return false
+}
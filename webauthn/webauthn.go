@@ -0,0 +1,437 @@
+// Package webauthn implements the relying party side of
+// WebAuthn, which is what passkeys are built on. It creates
+// the options passed to navigator.credentials in the browser,
+// and verifies what the authenticator answered. Attestation
+// is not checked: any authenticator is trusted to hold the
+// key, as we only want to know it is the same one next time
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"../../photofriends/rand"
+)
+
+const (
+	// ChallengeBytes is the length of new challenges
+	ChallengeBytes = 32
+
+	// Timeout is how long the browser and
+	// the server wait for the authenticator
+	Timeout = 5 * time.Minute
+)
+
+// authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+var (
+	// ErrChallenge is returned when the response was
+	// made for another challenge than the one expected
+	ErrChallenge = errors.New("webauthn: challenge does not match")
+
+	// ErrOrigin is returned when the response was made on
+	// another site, or for another relying party ID
+	ErrOrigin = errors.New("webauthn: origin does not match")
+
+	// ErrUserVerification is returned when the authenticator
+	// did not verify the user, by PIN or biometrics
+	ErrUserVerification = errors.New("webauthn: user was not verified")
+
+	// ErrSignature is returned when the assertion is
+	// not signed by the credential's key
+	ErrSignature = errors.New("webauthn: signature is not valid")
+
+	// ErrSignCount is returned when the sign counter did not
+	// go up, which means the credential might have been cloned
+	ErrSignCount = errors.New("webauthn: sign count did not increase")
+
+	// ErrMalformed is returned when the response
+	// can not be parsed
+	ErrMalformed = errors.New("webauthn: malformed response")
+)
+
+// Config describes the relying party, which is us
+type Config struct {
+	// RPID is the domain passkeys are bound to. It has to be
+	// the host of Origin or a domain that host is part of
+	RPID string `json:"rp_id" toml:"rp_id"`
+
+	// RPName is shown by the browser when creating a passkey
+	RPName string `json:"rp_name" toml:"rp_name"`
+
+	// Origin is where the app is served from, eg:
+	// "https://photofriends.example"
+	Origin string `json:"origin" toml:"origin"`
+}
+
+// DefaultConfig works for the development server
+func DefaultConfig() Config {
+	return Config{
+		RPID:   "localhost",
+		RPName: "photofriends",
+		Origin: "http://localhost:3000",
+	}
+}
+
+// NewChallenge returns a random challenge. It must
+// be kept by the server until the response comes in
+func NewChallenge() ([]byte, error) {
+	return rand.Bytes(ChallengeBytes)
+}
+
+// URLBytes are bytes encoded in JSON as unpadded
+// base64url, the encoding WebAuthn uses
+type URLBytes []byte
+
+func (b URLBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return ErrMalformed
+	}
+
+	*b = decoded
+	return nil
+}
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity is the account a passkey is created for. ID
+// is returned as the user handle when logging in with it
+type UserEntity struct {
+	ID          URLBytes `json:"id"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string   `json:"type"`
+	ID   URLBytes `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are passed as publicKey to
+// navigator.credentials.create, once the binary
+// fields are decoded
+type CreationOptions struct {
+	Challenge              URLBytes               `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are passed as publicKey to
+// navigator.credentials.get
+type RequestOptions struct {
+	Challenge        URLBytes               `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions asks for a discoverable credential, so
+// it can be used without typing an email. exclude are the
+// IDs of the user's credentials, which stops registering
+// the same authenticator twice
+func (c Config) CreationOptions(challenge []byte, user UserEntity, exclude [][]byte) CreationOptions {
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: c.RPID, Name: c.RPName},
+		User:      user,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            int64(Timeout / time.Millisecond),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions leaves allow empty for the browser
+// to offer every passkey it has for the site
+func (c Config) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             c.RPID,
+		Timeout:          int64(Timeout / time.Millisecond),
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return list
+}
+
+// RegistrationResponse is the PublicKeyCredential returned
+// by navigator.credentials.create, with the binary
+// fields encoded as base64url
+type RegistrationResponse struct {
+	RawID    URLBytes `json:"rawId"`
+	Type     string   `json:"type"`
+	Response struct {
+		ClientDataJSON    URLBytes `json:"clientDataJSON"`
+		AttestationObject URLBytes `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the PublicKeyCredential
+// returned by navigator.credentials.get
+type AssertionResponse struct {
+	RawID    URLBytes `json:"rawId"`
+	Type     string   `json:"type"`
+	Response struct {
+		ClientDataJSON    URLBytes `json:"clientDataJSON"`
+		AuthenticatorData URLBytes `json:"authenticatorData"`
+		Signature         URLBytes `json:"signature"`
+		UserHandle        URLBytes `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Credential is a newly registered credential,
+// which has to be stored with the user
+type Credential struct {
+	ID []byte
+
+	// PublicKey is the COSE encoded key, as
+	// VerifyAssertion expects it
+	PublicKey []byte
+	SignCount uint32
+}
+
+// Assertion is a verified login with a credential
+type Assertion struct {
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+}
+
+// VerifyRegistration checks the response to
+// CreationOptions made with challenge
+func (c Config) VerifyRegistration(challenge []byte, resp *RegistrationResponse) (*Credential, error) {
+	if resp.Type != "public-key" {
+		return nil, ErrMalformed
+	}
+
+	if err := c.verifyClientData(resp.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, n, err := cborDecode(resp.Response.AttestationObject)
+	if err != nil || n != len(resp.Response.AttestationObject) {
+		return nil, ErrMalformed
+	}
+
+	att, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrMalformed
+	}
+
+	raw, ok := att["authData"].([]byte)
+	if !ok {
+		return nil, ErrMalformed
+	}
+
+	ad, err := c.parseAuthData(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if ad.flags&flagAttested == 0 || !bytes.Equal(ad.credentialID, resp.RawID) {
+		return nil, ErrMalformed
+	}
+
+	return &Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.publicKey,
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response to RequestOptions made
+// with challenge, using the public key and sign count stored
+// for the credential with the ID of resp.RawID. The new sign
+// count of the assertion has to be stored for the next one
+func (c Config) VerifyAssertion(challenge, publicKeyCOSE []byte, signCount uint32, resp *AssertionResponse) (*Assertion, error) {
+	if resp.Type != "public-key" {
+		return nil, ErrMalformed
+	}
+
+	clientData := resp.Response.ClientDataJSON
+	if err := c.verifyClientData(clientData, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	ad, err := c.parseAuthData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, _, err := parsePublicKey(publicKeyCOSE)
+	if err != nil {
+		return nil, err
+	}
+
+	clientHash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, resp.Response.AuthenticatorData...), clientHash[:]...)
+	if !pk.verify(signed, resp.Response.Signature) {
+		return nil, ErrSignature
+	}
+
+	// authenticators without a counter always send 0
+	if (ad.signCount != 0 || signCount != 0) && ad.signCount <= signCount {
+		return nil, ErrSignCount
+	}
+
+	return &Assertion{
+		CredentialID: resp.RawID,
+		UserHandle:   resp.Response.UserHandle,
+		SignCount:    ad.signCount,
+	}, nil
+}
+
+// clientData is what the browser signs over, see
+// https://www.w3.org/TR/webauthn-2/#dictionary-client-data
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (c Config) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ErrMalformed
+	}
+
+	if cd.Type != typ {
+		return ErrMalformed
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallenge
+	}
+
+	if cd.Origin != c.Origin || cd.CrossOrigin {
+		return ErrOrigin
+	}
+
+	return nil
+}
+
+// authData is the parsed authenticator data, see
+// https://www.w3.org/TR/webauthn-2/#sctn-authenticator-data
+type authData struct {
+	flags     byte
+	signCount uint32
+
+	// only set when the flagAttested is
+	credentialID []byte
+	publicKey    []byte
+}
+
+func (c Config) parseAuthData(raw []byte) (*authData, error) {
+	if len(raw) < 37 {
+		return nil, ErrMalformed
+	}
+
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if subtle.ConstantTimeCompare(raw[:32], rpIDHash[:]) != 1 {
+		return nil, ErrOrigin
+	}
+
+	ad := authData{
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+	if ad.flags&flagUserPresent == 0 || ad.flags&flagUserVerified == 0 {
+		return nil, ErrUserVerification
+	}
+
+	rest := raw[37:]
+	if ad.flags&flagAttested != 0 {
+		// AAGUID, then the length of the credential ID
+		if len(rest) < 18 {
+			return nil, ErrMalformed
+		}
+
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, ErrMalformed
+		}
+
+		ad.credentialID, rest = rest[:idLen], rest[idLen:]
+		_, n, err := parsePublicKey(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		ad.publicKey, rest = rest[:n], rest[n:]
+	}
+
+	if ad.flags&flagExtensions != 0 {
+		_, n, err := cborDecode(rest)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrMalformed
+	}
+
+	return &ad, nil
+}
@@ -0,0 +1,164 @@
+package webauthn_test
+
+import (
+	"testing"
+
+	"../../photofriends/webauthn"
+	"../../photofriends/webauthn/webauthntest"
+)
+
+var cfg = webauthn.DefaultConfig()
+
+// register creates a credential on a with a new challenge
+func register(t *testing.T, a *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := webauthn.UserEntity{ID: []byte{0, 0, 0, 0, 0, 0, 0, 1}, Name: "jon@example.com"}
+	resp, err := a.Create(cfg.CreationOptions(challenge, user, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := cfg.VerifyRegistration(challenge, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cred
+}
+
+func TestRegistration(t *testing.T) {
+	a := webauthntest.New(cfg.Origin)
+	cred := register(t, a)
+	if len(cred.ID) == 0 || len(cred.PublicKey) == 0 || cred.SignCount != 0 {
+		t.Errorf("Unexpected credential %+v", cred)
+	}
+
+	challenge, _ := webauthn.NewChallenge()
+	user := webauthn.UserEntity{ID: []byte{1}, Name: "jon@example.com"}
+	opts := cfg.CreationOptions(challenge, user, nil)
+
+	resp, _ := a.Create(opts)
+	other, _ := webauthn.NewChallenge()
+	if _, err := cfg.VerifyRegistration(other, resp); err != webauthn.ErrChallenge {
+		t.Errorf("Expected ErrChallenge. Recieved %v", err)
+	}
+
+	a.Origin = "https://evil.example"
+	resp, _ = a.Create(opts)
+	if _, err := cfg.VerifyRegistration(challenge, resp); err != webauthn.ErrOrigin {
+		t.Errorf("Expected ErrOrigin. Recieved %v", err)
+	}
+
+	a.Origin = cfg.Origin
+	opts.RP.ID = "evil.example"
+	resp, _ = a.Create(opts)
+	if _, err := cfg.VerifyRegistration(challenge, resp); err != webauthn.ErrOrigin {
+		t.Errorf("Expected ErrOrigin for another RP ID. Recieved %v", err)
+	}
+
+	opts.RP.ID = cfg.RPID
+	a.SkipUserVerification = true
+	resp, _ = a.Create(opts)
+	if _, err := cfg.VerifyRegistration(challenge, resp); err != webauthn.ErrUserVerification {
+		t.Errorf("Expected ErrUserVerification. Recieved %v", err)
+	}
+
+	a.SkipUserVerification = false
+	resp, _ = a.Create(opts)
+	resp.Response.AttestationObject = resp.Response.AttestationObject[:40]
+	if _, err := cfg.VerifyRegistration(challenge, resp); err != webauthn.ErrMalformed {
+		t.Errorf("Expected ErrMalformed for a cut off response. Recieved %v", err)
+	}
+
+	opts.ExcludeCredentials = []webauthn.CredentialDescriptor{{Type: "public-key", ID: cred.ID}}
+	if _, err := a.Create(opts); err == nil {
+		t.Error("Expected the authenticator to refuse excluded credentials")
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	a := webauthntest.New(cfg.Origin)
+	cred := register(t, a)
+	count := cred.SignCount
+
+	for i := 0; i < 2; i++ {
+		challenge, _ := webauthn.NewChallenge()
+		resp, err := a.Get(cfg.RequestOptions(challenge, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertion, err := cfg.VerifyAssertion(challenge, cred.PublicKey, count, resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(assertion.CredentialID) != string(cred.ID) || len(assertion.UserHandle) != 8 {
+			t.Errorf("Unexpected assertion %+v", assertion)
+		}
+
+		if assertion.SignCount <= count {
+			t.Errorf("Expected the sign count to go up from %d. Recieved %d", count, assertion.SignCount)
+		}
+		count = assertion.SignCount
+	}
+
+	challenge, _ := webauthn.NewChallenge()
+	opts := cfg.RequestOptions(challenge, [][]byte{cred.ID})
+
+	resp, _ := a.Get(opts)
+	other, _ := webauthn.NewChallenge()
+	if _, err := cfg.VerifyAssertion(other, cred.PublicKey, count, resp); err != webauthn.ErrChallenge {
+		t.Errorf("Expected ErrChallenge. Recieved %v", err)
+	}
+
+	resp, _ = a.Get(opts)
+	resp.Response.Signature[len(resp.Response.Signature)-1] ^= 1
+	if _, err := cfg.VerifyAssertion(challenge, cred.PublicKey, count, resp); err != webauthn.ErrSignature {
+		t.Errorf("Expected ErrSignature. Recieved %v", err)
+	}
+
+	// the key of another credential does not verify
+	otherCred := register(t, webauthntest.New(cfg.Origin))
+	resp, _ = a.Get(opts)
+	if _, err := cfg.VerifyAssertion(challenge, otherCred.PublicKey, count, resp); err != webauthn.ErrSignature {
+		t.Errorf("Expected ErrSignature for another key. Recieved %v", err)
+	}
+
+	// a clone of the authenticator lags behind the stored count
+	a.SetSignCount(cred.ID, count-1)
+	resp, _ = a.Get(opts)
+	if _, err := cfg.VerifyAssertion(challenge, cred.PublicKey, count, resp); err != webauthn.ErrSignCount {
+		t.Errorf("Expected ErrSignCount. Recieved %v", err)
+	}
+
+	a.SkipUserVerification = true
+	resp, _ = a.Get(opts)
+	if _, err := cfg.VerifyAssertion(challenge, cred.PublicKey, 0, resp); err != webauthn.ErrUserVerification {
+		t.Errorf("Expected ErrUserVerification. Recieved %v", err)
+	}
+
+	if _, err := a.Get(cfg.RequestOptions(challenge, [][]byte{[]byte("unknown")})); err != webauthntest.ErrNoCredential {
+		t.Errorf("Expected ErrNoCredential. Recieved %v", err)
+	}
+}
+
+func TestSignCountZero(t *testing.T) {
+	a := webauthntest.New(cfg.Origin)
+	a.NoSignCount = true
+	cred := register(t, a)
+
+	// authenticators without a counter always send 0
+	for i := 0; i < 2; i++ {
+		challenge, _ := webauthn.NewChallenge()
+		resp, _ := a.Get(cfg.RequestOptions(challenge, nil))
+		if _, err := cfg.VerifyAssertion(challenge, cred.PublicKey, 0, resp); err != nil {
+			t.Errorf("Expected a zero count to be accepted. Recieved %v", err)
+		}
+	}
+}
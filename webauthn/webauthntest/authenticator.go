@@ -0,0 +1,198 @@
+// Package webauthntest provides a software authenticator,
+// to test WebAuthn ceremonies without a browser or hardware
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"../../../photofriends/webauthn"
+)
+
+// ErrNoCredential is returned by Get when the
+// authenticator has no credential to use
+var ErrNoCredential = errors.New("webauthntest: no matching credential")
+
+// Authenticator is a platform authenticator holding ES256
+// keys in memory. It verifies the user and counts
+// signatures per credential, unless told otherwise
+type Authenticator struct {
+	// Origin is what the browser would put in the client
+	// data. Change it to act like a phishing site
+	Origin string
+
+	// SkipUserVerification leaves the user
+	// verified flag out of the responses
+	SkipUserVerification bool
+
+	// NoSignCount always sends 0 as the sign count,
+	// like synced passkeys do
+	NoSignCount bool
+
+	credentials []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// New returns an authenticator used from origin
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+// Create makes a new credential, like
+// navigator.credentials.create does
+func (a *Authenticator) Create(opts webauthn.CreationOptions) (*webauthn.RegistrationResponse, error) {
+	for _, ex := range opts.ExcludeCredentials {
+		if a.find(opts.RP.ID, ex.ID) != nil {
+			return nil, errors.New("webauthntest: credential already registered")
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	cred := &credential{
+		id:         id,
+		rpID:       opts.RP.ID,
+		userHandle: append([]byte{}, opts.User.ID...),
+		key:        key,
+	}
+	a.credentials = append(a.credentials, cred)
+
+	// attested credential data: AAGUID, ID length, ID, key
+	attested := make([]byte, 16, 16+2+len(id))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(attested, id...)
+	attested = append(attested, PublicKey(&key.PublicKey)...)
+
+	authData := a.authData(cred, 0x40)
+	authData = append(authData, attested...)
+
+	var resp webauthn.RegistrationResponse
+	resp.RawID = id
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = a.clientData("webauthn.create", opts.Challenge)
+	resp.Response.AttestationObject = encodeMap(
+		"fmt", "none",
+		"attStmt", pairs{},
+		"authData", authData)
+	return &resp, nil
+}
+
+// Get signs the challenge with a credential for the relying
+// party, like navigator.credentials.get does. With no allowed
+// credentials it uses the one created last
+func (a *Authenticator) Get(opts webauthn.RequestOptions) (*webauthn.AssertionResponse, error) {
+	var cred *credential
+	if len(opts.AllowCredentials) == 0 {
+		for _, c := range a.credentials {
+			if c.rpID == opts.RPID {
+				cred = c
+			}
+		}
+	}
+
+	for _, allowed := range opts.AllowCredentials {
+		if cred = a.find(opts.RPID, allowed.ID); cred != nil {
+			break
+		}
+	}
+
+	if cred == nil {
+		return nil, ErrNoCredential
+	}
+
+	if !a.NoSignCount {
+		cred.signCount++
+	}
+	authData := a.authData(cred, 0)
+	clientData := a.clientData("webauthn.get", opts.Challenge)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var resp webauthn.AssertionResponse
+	resp.RawID = cred.id
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = clientData
+	resp.Response.AuthenticatorData = authData
+	resp.Response.Signature = sig
+	resp.Response.UserHandle = cred.userHandle
+	return &resp, nil
+}
+
+// SetSignCount sets the counter of a credential, to act
+// like a clone of the authenticator that fell behind
+func (a *Authenticator) SetSignCount(id []byte, count uint32) {
+	for _, c := range a.credentials {
+		if string(c.id) == string(id) {
+			c.signCount = count
+		}
+	}
+}
+
+func (a *Authenticator) find(rpID string, id []byte) *credential {
+	for _, c := range a.credentials {
+		if c.rpID == rpID && string(c.id) == string(id) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (a *Authenticator) authData(cred *credential, flags byte) []byte {
+	flags |= 0x01
+	if !a.SkipUserVerification {
+		flags |= 0x04
+	}
+
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, cred.signCount)
+}
+
+func (a *Authenticator) clientData(typ string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+// PublicKey encodes key as a COSE_Key
+func PublicKey(key *ecdsa.PublicKey) []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	key.X.FillBytes(x)
+	key.Y.FillBytes(y)
+	return encodeMap(
+		int64(1), int64(2),
+		int64(3), int64(webauthn.AlgES256),
+		int64(-1), int64(1),
+		int64(-2), x,
+		int64(-3), y)
+}
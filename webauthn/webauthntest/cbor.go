@@ -0,0 +1,55 @@
+package webauthntest
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// pairs are the keys and values of a CBOR map,
+// kept in order so the encoding is the same each time
+type pairs []interface{}
+
+// encodeMap encodes a map of the key and value pairs in kv
+func encodeMap(kv ...interface{}) []byte {
+	return encode(nil, pairs(kv))
+}
+
+// encode appends the CBOR encoding of v to buf. It
+// only covers what authenticators send
+func encode(buf []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return head(buf, 1, uint64(-1-v))
+		}
+		return head(buf, 0, uint64(v))
+	case []byte:
+		return append(head(buf, 2, uint64(len(v))), v...)
+	case string:
+		return append(head(buf, 3, uint64(len(v))), v...)
+	case pairs:
+		buf = head(buf, 5, uint64(len(v)/2))
+		for _, item := range v {
+			buf = encode(buf, item)
+		}
+		return buf
+	default:
+		panic(fmt.Sprintf("webauthntest: can not encode %T", v))
+	}
+}
+
+func head(buf []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(buf, major|byte(arg))
+	case arg <= 0xff:
+		return append(buf, major|24, byte(arg))
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, major|27), arg)
+	}
+}